@@ -75,6 +75,8 @@ var (
 		"COPYING",
 		executablePath("abigen"),
 		executablePath("bootnode"),
+		executablePath("checkpoint-admin"),
+		executablePath("devp2p"),
 		executablePath("evm"),
 		executablePath("seth"),
 		executablePath("puppeth"),
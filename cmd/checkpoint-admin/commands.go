@@ -0,0 +1,234 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/severeum/go-severeum/cmd/utils"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/common/hexutil"
+	"github.com/severeum/go-severeum/contracts/checkpointoracle"
+	"github.com/severeum/go-severeum/contracts/checkpointoracle/contract"
+	"github.com/severeum/go-severeum/ethclient"
+	"github.com/severeum/go-severeum/params"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var commandStatus = cli.Command{
+	Name:  "status",
+	Usage: "Fetches the admins and the latest checkpoint of the oracle",
+	Flags: []cli.Flag{
+		oracleFlag,
+		nodeURLFlag,
+	},
+	Action: status,
+}
+
+var commandDeploy = cli.Command{
+	Name:  "deploy",
+	Usage: "Deploys a new checkpoint oracle contract",
+	Description: `
+Deploys a checkpoint oracle administrated by the given signers, requiring the
+given number of their signatures to approve a checkpoint.`,
+	Flags: []cli.Flag{
+		nodeURLFlag,
+		keystoreFlag,
+		signerFlag,
+		passwordFlag,
+		signersFlag,
+		thresholdFlag,
+	},
+	Action: deploy,
+}
+
+var commandSign = cli.Command{
+	Name:  "sign",
+	Usage: "Signs a checkpoint with the signer account",
+	Description: `
+Retrieves the checkpoint of a section from a light server node and signs it for
+the oracle contract. The signature should be handed over to the admin publishing
+the checkpoint.`,
+	Flags: []cli.Flag{
+		oracleFlag,
+		nodeURLFlag,
+		indexFlag,
+		keystoreFlag,
+		signerFlag,
+		passwordFlag,
+	},
+	Action: sign,
+}
+
+var commandPublish = cli.Command{
+	Name:  "publish",
+	Usage: "Publishes a checkpoint with the collected signatures",
+	Description: `
+Retrieves the checkpoint of a section from a light server node and registers it
+in the oracle contract along with the given admin signatures.`,
+	Flags: []cli.Flag{
+		oracleFlag,
+		nodeURLFlag,
+		indexFlag,
+		keystoreFlag,
+		signerFlag,
+		passwordFlag,
+		signaturesFlag,
+	},
+	Action: publish,
+}
+
+// status fetches the admins list and the latest checkpoint of the oracle.
+func status(ctx *cli.Context) error {
+	client := newRPCClient(ctx)
+	addr, oracle := newContract(ctx, client)
+	fmt.Printf("Oracle => %s\n", addr.Hex())
+	fmt.Println()
+
+	admins, err := oracle.Contract().GetAllAdmin(nil)
+	if err != nil {
+		return err
+	}
+	for i, admin := range admins {
+		fmt.Printf("Admin %d => %s\n", i+1, admin.Hex())
+	}
+	fmt.Println()
+
+	index, head, cht, bloom, height, err := oracle.Contract().GetLatestCheckpoint(nil)
+	if err != nil {
+		return err
+	}
+	if height.Sign() == 0 {
+		fmt.Println("No checkpoint published yet")
+		return nil
+	}
+	cp := &params.TrustedCheckpoint{SectionIndex: index, SectionHead: head, CHTRoot: cht, BloomRoot: bloom}
+	fmt.Printf("Checkpoint (published at #%d) %d => %s\n", height, cp.SectionIndex, cp.Hash().Hex())
+	return nil
+}
+
+// deploy deploys a new checkpoint oracle contract.
+func deploy(ctx *cli.Context) error {
+	var (
+		admins    []common.Address
+		threshold = ctx.Uint64(thresholdFlag.Name)
+	)
+	for _, admin := range strings.Split(ctx.String(signersFlag.Name), ",") {
+		if admin = strings.TrimSpace(admin); !common.IsHexAddress(admin) {
+			utils.Fatalf("Invalid signer address %q", admin)
+		}
+		admins = append(admins, common.HexToAddress(admin))
+	}
+	if threshold == 0 || threshold > uint64(len(admins)) {
+		utils.Fatalf("Invalid signature threshold %d for %d signers", threshold, len(admins))
+	}
+	client := newRPCClient(ctx)
+	opts := newSigner(ctx).transactOpts(getChainID(client))
+
+	addr, tx, _, err := contract.DeployCheckpointOracle(opts, ethclient.NewClient(client), admins, big.NewInt(params.CHTFrequencyClient), big.NewInt(params.HelperTrieProcessConfirmations), new(big.Int).SetUint64(threshold))
+	if err != nil {
+		utils.Fatalf("Failed to deploy checkpoint oracle: %v", err)
+	}
+	fmt.Printf("Sent deploy transaction %s\n", tx.Hash().Hex())
+	fmt.Printf("Oracle => %s\n", addr.Hex())
+	return nil
+}
+
+// sign signs a checkpoint with the signer account.
+func sign(ctx *cli.Context) error {
+	client := newRPCClient(ctx)
+	addr := getContractAddr(ctx)
+	cp := getCheckpoint(ctx, client)
+
+	sig, err := checkpointoracle.SignCheckpoint(addr, cp, newSigner(ctx).signHash)
+	if err != nil {
+		utils.Fatalf("Failed to sign checkpoint: %v", err)
+	}
+	fmt.Printf("Oracle     => %s\n", addr.Hex())
+	fmt.Printf("Index      => %d\n", cp.SectionIndex)
+	fmt.Printf("Head       => %s\n", cp.SectionHead.Hex())
+	fmt.Printf("CHTRoot    => %s\n", cp.CHTRoot.Hex())
+	fmt.Printf("BloomRoot  => %s\n", cp.BloomRoot.Hex())
+	fmt.Printf("Hash       => %s\n", cp.Hash().Hex())
+	fmt.Printf("Signature  => %s\n", hexutil.Encode(sig))
+	return nil
+}
+
+// publish registers a checkpoint in the oracle with the collected signatures,
+// sorted by the signer addresses as required by the contract.
+func publish(ctx *cli.Context) error {
+	client := newRPCClient(ctx)
+	addr, oracle := newContract(ctx, client)
+	cp := getCheckpoint(ctx, client)
+
+	admins, err := oracle.Contract().GetAllAdmin(nil)
+	if err != nil {
+		return err
+	}
+	isAdmin := make(map[common.Address]bool)
+	for _, admin := range admins {
+		isAdmin[admin] = true
+	}
+	type vote struct {
+		signer common.Address
+		sig    []byte
+	}
+	var votes []vote
+	for _, hex := range strings.Split(ctx.String(signaturesFlag.Name), ",") {
+		sig, err := hexutil.Decode(strings.TrimSpace(hex))
+		if err != nil {
+			utils.Fatalf("Invalid signature %q: %v", hex, err)
+		}
+		signer, err := checkpointoracle.RecoverSigner(addr, cp.Hash(), sig)
+		if err != nil {
+			utils.Fatalf("Failed to recover signer of %q: %v", hex, err)
+		}
+		if !isAdmin[signer] {
+			utils.Fatalf("Signature %q is signed by non-admin %s", hex, signer.Hex())
+		}
+		votes = append(votes, vote{signer, sig})
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		return bytes.Compare(votes[i].signer[:], votes[j].signer[:]) < 0
+	})
+	var sigs [][]byte
+	for i, v := range votes {
+		if i > 0 && v.signer == votes[i-1].signer {
+			utils.Fatalf("Duplicate signature from %s", v.signer.Hex())
+		}
+		sigs = append(sigs, v.sig)
+	}
+	// Pin the transaction to the current chain head, so it's rejected on any
+	// other fork
+	head, err := ethclient.NewClient(client).HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		utils.Fatalf("Failed to retrieve chain head: %v", err)
+	}
+	opts := newSigner(ctx).transactOpts(getChainID(client))
+	tx, err := oracle.RegisterCheckpoint(opts, cp, head.Number, head.Hash(), sigs)
+	if err != nil {
+		utils.Fatalf("Failed to publish checkpoint: %v", err)
+	}
+	fmt.Printf("Sent publish transaction %s\n", tx.Hash().Hex())
+	fmt.Printf("Checkpoint %d => %s\n", cp.SectionIndex, cp.Hash().Hex())
+	return nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/severeum/go-severeum/accounts"
+	"github.com/severeum/go-severeum/accounts/abi/bind"
+	"github.com/severeum/go-severeum/accounts/keystore"
+	"github.com/severeum/go-severeum/cmd/utils"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/common/hexutil"
+	"github.com/severeum/go-severeum/console"
+	"github.com/severeum/go-severeum/contracts/checkpointoracle"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/ethclient"
+	"github.com/severeum/go-severeum/params"
+	"github.com/severeum/go-severeum/rpc"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// newRPCClient creates an rpc client connected to the node specified by the
+// --rpc flag.
+func newRPCClient(ctx *cli.Context) *rpc.Client {
+	client, err := rpc.Dial(ctx.String(nodeURLFlag.Name))
+	if err != nil {
+		utils.Fatalf("Failed to connect to Severeum node: %v", err)
+	}
+	return client
+}
+
+// getChainID retrieves the chain id of the connected node, used to sign
+// replay protected transactions.
+func getChainID(client *rpc.Client) *big.Int {
+	var id hexutil.Uint64
+	if err := client.CallContext(context.Background(), &id, "eth_chainId"); err != nil {
+		utils.Fatalf("Failed to retrieve chain id: %v", err)
+	}
+	return new(big.Int).SetUint64(uint64(id))
+}
+
+// getContractAddr retrieves the oracle contract address specified by the
+// --oracle flag.
+func getContractAddr(ctx *cli.Context) common.Address {
+	addr := ctx.String(oracleFlag.Name)
+	if !common.IsHexAddress(addr) {
+		utils.Fatalf("Invalid oracle contract address %q", addr)
+	}
+	return common.HexToAddress(addr)
+}
+
+// newContract binds the oracle contract specified by the --oracle flag.
+func newContract(ctx *cli.Context, client *rpc.Client) (common.Address, *checkpointoracle.CheckpointOracle) {
+	addr := getContractAddr(ctx)
+	oracle, err := checkpointoracle.NewCheckpointOracle(addr, ethclient.NewClient(client))
+	if err != nil {
+		utils.Fatalf("Failed to bind oracle contract: %v", err)
+	}
+	return addr, oracle
+}
+
+// getCheckpoint retrieves the checkpoint specified by the --index flag from the
+// connected node, or its latest checkpoint if no index is given. The node must
+// be running as a light server.
+func getCheckpoint(ctx *cli.Context, client *rpc.Client) *params.TrustedCheckpoint {
+	var (
+		cp  params.TrustedCheckpoint
+		err error
+	)
+	if index := ctx.Int64(indexFlag.Name); index >= 0 {
+		err = client.CallContext(context.Background(), &cp, "les_getCheckpoint", index)
+	} else {
+		err = client.CallContext(context.Background(), &cp, "les_latestCheckpoint")
+	}
+	if err != nil {
+		utils.Fatalf("Failed to retrieve checkpoint: %v", err)
+	}
+	if cp.Empty() {
+		utils.Fatalf("Incomplete checkpoint %d retrieved", cp.SectionIndex)
+	}
+	return &cp
+}
+
+// signer is a keystore account unlocked to sign checkpoints and transactions.
+type signer struct {
+	ks      *keystore.KeyStore
+	account accounts.Account
+}
+
+// newSigner unlocks the account specified by the --signer flag from the keystore
+// specified by the --keystore flag.
+func newSigner(ctx *cli.Context) *signer {
+	dir := ctx.String(keystoreFlag.Name)
+	if dir == "" {
+		utils.Fatalf("Keystore directory not specified")
+	}
+	addr := ctx.String(signerFlag.Name)
+	if !common.IsHexAddress(addr) {
+		utils.Fatalf("Invalid signer address %q", addr)
+	}
+	ks := keystore.NewKeyStore(dir, keystore.StandardScryptN, keystore.StandardScryptP)
+	account := accounts.Account{Address: common.HexToAddress(addr)}
+	if !ks.HasAddress(account.Address) {
+		utils.Fatalf("Signer account %s not found in keystore", addr)
+	}
+	if err := ks.Unlock(account, getPassword(ctx)); err != nil {
+		utils.Fatalf("Failed to unlock signer account: %v", err)
+	}
+	return &signer{ks: ks, account: account}
+}
+
+// getPassword obtains the password of the signer account from the file given
+// by the --passwordfile flag, or prompts the user for it.
+func getPassword(ctx *cli.Context) string {
+	if file := ctx.String(passwordFlag.Name); file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			utils.Fatalf("Failed to read password file '%s': %v", file, err)
+		}
+		return strings.TrimRight(string(content), "\r\n")
+	}
+	password, err := console.Stdin.PromptPassword("Password: ")
+	if err != nil {
+		utils.Fatalf("Failed to read password: %v", err)
+	}
+	return password
+}
+
+// signHash signs a hash with the signer account.
+func (s *signer) signHash(hash []byte) ([]byte, error) {
+	return s.ks.SignHash(s.account, hash)
+}
+
+// transactOpts returns the options to send transactions from the signer account.
+func (s *signer) transactOpts(chainID *big.Int) *bind.TransactOpts {
+	return &bind.TransactOpts{
+		From: s.account.Address,
+		Signer: func(_ types.Signer, addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			return s.ks.SignTx(accounts.Account{Address: addr}, tx, chainID)
+		},
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/severeum/go-severeum/cmd/devp2p/internal/ethtest"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/forkid"
+	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/p2p/discover"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+const (
+	crawlWorkers        = 16               // Number of nodes checked concurrently
+	crawlStatusInterval = 8 * time.Second  // Interval of the progress log message
+	rlpxTimeout         = 10 * time.Second // Timeout of the RLPx handshakes
+)
+
+// crawler maintains a node set by checking its nodes and adding new ones found
+// through random lookups in the discovery DHT.
+type crawler struct {
+	input  nodeSet
+	output nodeSet
+	disc   *discover.Table
+	key    *ecdsa.PrivateKey
+	rlpx   bool // whether to perform RLPx handshakes with live nodes
+
+	added, updated, unresponsive, ignored int
+}
+
+// crawlResult is the outcome of checking a single node.
+type crawlResult struct {
+	node   *enode.Node // latest known record of the node
+	live   bool        // whether the node answered the ping
+	client *clientInfo // handshake result, nil if not performed or failed
+	time   time.Time
+}
+
+// ethStatus is the status message of the eth protocol. The fork ID is the first
+// trailing element since eth/64.
+type ethStatus struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+	Rest            []rlp.RawValue `rlp:"tail"`
+}
+
+func newCrawler(input nodeSet, disc *discover.Table, key *ecdsa.PrivateKey, rlpx bool) *crawler {
+	c := &crawler{input: input, output: make(nodeSet, len(input)), disc: disc, key: key, rlpx: rlpx}
+	for id, n := range input {
+		c.output[id] = n
+	}
+	return c
+}
+
+// run checks the input nodes and crawls the DHT until the timeout expires. It
+// returns the updated node set.
+func (c *crawler) run(timeout time.Duration) nodeSet {
+	var (
+		deadline   = time.NewTimer(timeout)
+		status     = time.NewTicker(crawlStatusInterval)
+		done       = make(chan struct{})
+		candidates = make(chan *enode.Node)
+		results    = make(chan crawlResult)
+		wg         sync.WaitGroup
+	)
+	defer deadline.Stop()
+	defer status.Stop()
+
+	go c.feed(candidates, done)
+	for i := 0; i < crawlWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range candidates {
+				results <- c.check(n)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				log.Info("Crawl finished", "added", c.added, "updated", c.updated, "unresponsive", c.unresponsive, "ignored", c.ignored)
+				return c.output
+			}
+			c.update(res)
+		case <-status.C:
+			log.Info("Crawling in progress", "added", c.added, "updated", c.updated, "unresponsive", c.unresponsive, "ignored", c.ignored)
+		case <-deadline.C:
+			// Stop feeding new candidates, the loop ends once all running
+			// checks are done.
+			close(done)
+			deadline.C = nil
+		}
+	}
+}
+
+// feed sends the input nodes, followed by the results of random lookups, to the
+// candidates channel. Every node is sent at most once.
+func (c *crawler) feed(candidates chan<- *enode.Node, done <-chan struct{}) {
+	defer close(candidates)
+
+	// Lookups may return the local node, don't add it to the set.
+	sent := map[enode.ID]bool{enode.PubkeyToIDV4(&c.key.PublicKey): true}
+	send := func(n *enode.Node) bool {
+		if sent[n.ID()] {
+			return true
+		}
+		sent[n.ID()] = true
+		select {
+		case candidates <- n:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	for _, n := range c.input.nodes() {
+		if !send(n) {
+			return
+		}
+	}
+	for {
+		found := c.disc.LookupRandom()
+		for _, n := range found {
+			if !send(n) {
+				return
+			}
+		}
+		if len(found) == 0 {
+			// Avoid spinning when the table is empty, e.g. because
+			// the bootstrap nodes are unreachable.
+			select {
+			case <-time.After(time.Second):
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// check pings n and fetches its latest record. If enabled, it also performs
+// the RLPx handshake with live nodes.
+func (c *crawler) check(n *enode.Node) crawlResult {
+	res := crawlResult{node: n, time: time.Now()}
+	seq, err := c.disc.Ping(n)
+	if err != nil {
+		log.Debug("Node unresponsive", "id", n.ID(), "err", err)
+		return res
+	}
+	res.live = true
+	if seq > n.Seq() {
+		if nn, err := c.disc.RequestENR(n); err != nil {
+			log.Debug("Node record request failed", "id", n.ID(), "err", err)
+		} else {
+			res.node = nn
+		}
+	}
+	if c.rlpx && res.node.TCP() != 0 {
+		res.client = c.handshake(res.node)
+	}
+	return res
+}
+
+// update applies the result of a node check to the output set.
+func (c *crawler) update(res crawlResult) {
+	id := res.node.ID()
+	n, known := c.output[id]
+	if !res.live {
+		if !known {
+			c.ignored++
+			return
+		}
+		c.unresponsive++
+		n.LastCheck = res.time
+		c.output[id] = n
+		return
+	}
+	if known {
+		c.updated++
+	} else {
+		c.added++
+		n.FirstSeen = res.time
+	}
+	n.LastSeen, n.LastCheck = res.time, res.time
+	if res.client != nil {
+		n.Client = res.client
+	}
+	c.output[id] = n
+	c.output.setRecord(res.node)
+}
+
+// handshake performs the RLPx handshake with n and reads the eth status message
+// if the node supports eth.
+func (c *crawler) handshake(n *enode.Node) *clientInfo {
+	// Only eth is offered, so the eth messages of the remote node start at code zero.
+	conn, err := p2p.DialRLPX(n, c.key, "devp2p-crawler", ethtest.EthCaps, rlpxTimeout)
+	if err != nil {
+		log.Debug("RLPx handshake failed", "id", n.ID(), "err", err)
+		return nil
+	}
+	defer conn.Close(p2p.DiscRequested)
+
+	info := &clientInfo{Name: conn.Name}
+	var eth bool
+	for _, cap := range conn.Caps {
+		info.Caps = append(info.Caps, cap.String())
+		eth = eth || cap.Name == "eth"
+	}
+	if !eth {
+		return info
+	}
+	msg, err := conn.ReadMsg()
+	if err != nil || msg.Code != 0 {
+		log.Debug("Can't read eth status", "id", n.ID(), "code", msg.Code, "err", err)
+		return info
+	}
+	var status ethStatus
+	if err := msg.Decode(&status); err != nil {
+		log.Debug("Invalid eth status", "id", n.ID(), "err", err)
+		return info
+	}
+	info.NetworkID, info.Genesis = status.NetworkID, &status.Genesis
+	var id forkid.ID
+	if len(status.Rest) > 0 && rlp.DecodeBytes(status.Rest[0], &id) == nil {
+		info.ForkHash, info.ForkNext = id.Hash[:], id.Next
+	}
+	return info
+}
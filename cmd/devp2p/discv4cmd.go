@@ -0,0 +1,134 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/p2p/discover"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/params"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	discv4Command = cli.Command{
+		Name:  "discv4",
+		Usage: "Node Discovery v4 tools",
+		Subcommands: []cli.Command{
+			discv4CrawlCommand,
+		},
+	}
+	discv4CrawlCommand = cli.Command{
+		Name:      "crawl",
+		Usage:     "Update a nodes.json file with the nodes found in the DHT",
+		ArgsUsage: "<nodes.json>",
+		Action:    discv4Crawl,
+		Flags:     []cli.Flag{bootnodesFlag, listenAddrFlag, crawlTimeoutFlag, crawlRLPXFlag},
+	}
+)
+
+var (
+	bootnodesFlag = cli.StringFlag{
+		Name:  "bootnodes",
+		Usage: "Comma separated nodes used for bootstrapping (defaults to the main network bootnodes)",
+	}
+	listenAddrFlag = cli.StringFlag{
+		Name:  "addr",
+		Usage: "UDP listening address of the discovery endpoint",
+		Value: "0.0.0.0:0",
+	}
+	crawlTimeoutFlag = cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Time limit for the crawl",
+		Value: 30 * time.Minute,
+	}
+	crawlRLPXFlag = cli.BoolFlag{
+		Name:  "rlpx",
+		Usage: "Perform RLPx and eth handshakes with live nodes to record client information",
+	}
+)
+
+// discv4Crawl performs discv4CrawlCommand.
+func discv4Crawl(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need nodes file as argument")
+	}
+	nodesFile := ctx.Args().First()
+	var input nodeSet
+	if common.FileExist(nodesFile) {
+		input = loadNodesJSON(nodesFile)
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	disc := startV4(ctx, key)
+	defer disc.Close()
+
+	c := newCrawler(input, disc, key, ctx.Bool(crawlRLPXFlag.Name))
+	output := c.run(ctx.Duration(crawlTimeoutFlag.Name))
+	writeNodesJSON(nodesFile, output)
+	return nil
+}
+
+// startV4 starts an ephemeral discovery v4 node.
+func startV4(ctx *cli.Context, key *ecdsa.PrivateKey) *discover.Table {
+	socket, ln := listen(key, ctx.String(listenAddrFlag.Name))
+	cfg := discover.Config{PrivateKey: key, Bootnodes: parseBootnodes(ctx)}
+	disc, err := discover.ListenUDP(socket, ln, cfg)
+	if err != nil {
+		exit(err)
+	}
+	return disc
+}
+
+func listen(key *ecdsa.PrivateKey, addr string) (*net.UDPConn, *enode.LocalNode) {
+	socket, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		exit(err)
+	}
+	usocket := socket.(*net.UDPConn)
+	db, _ := enode.OpenDB("")
+	ln := enode.NewLocalNode(db, key)
+	ln.SetFallbackIP(net.IP{127, 0, 0, 1})
+	ln.SetFallbackUDP(usocket.LocalAddr().(*net.UDPAddr).Port)
+	return usocket, ln
+}
+
+func parseBootnodes(ctx *cli.Context) []*enode.Node {
+	s := params.MainnetBootnodes
+	if ctx.IsSet(bootnodesFlag.Name) {
+		s = strings.Split(ctx.String(bootnodesFlag.Name), ",")
+	}
+	nodes := make([]*enode.Node, len(s))
+	for i, record := range s {
+		n, err := enode.Parse(enode.ValidSchemes, strings.TrimSpace(record))
+		if err != nil {
+			exit(fmt.Errorf("invalid bootstrap node: %v", err))
+		}
+		nodes[i] = n
+	}
+	return nodes
+}
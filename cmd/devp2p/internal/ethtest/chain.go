@@ -0,0 +1,213 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package ethtest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core"
+	"github.com/severeum/go-severeum/core/forkid"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/params"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+// Chain is the canonical chain the test suite expects the remote node to have,
+// starting at the genesis block.
+type Chain struct {
+	blocks      []*types.Block
+	chainConfig *params.ChainConfig
+}
+
+// NewChain creates a chain from the given genesis specification and blocks.
+// The blocks must follow the genesis block without gaps.
+func NewChain(genesis *core.Genesis, blocks []*types.Block) (*Chain, error) {
+	gblock := genesis.ToBlock(nil)
+	for i, block := range blocks {
+		parent := gblock
+		if i > 0 {
+			parent = blocks[i-1]
+		}
+		if block.ParentHash() != parent.Hash() || block.NumberU64() != parent.NumberU64()+1 {
+			return nil, fmt.Errorf("block %d (%x) does not extend the chain", block.NumberU64(), block.Hash())
+		}
+	}
+	config := genesis.Config
+	if config == nil {
+		config = params.AllSevashProtocolChanges
+	}
+	return &Chain{
+		blocks:      append([]*types.Block{gblock}, blocks...),
+		chainConfig: config,
+	}, nil
+}
+
+// LoadChain reads the genesis specification from genesisfile and the blocks
+// from chainfile, which holds RLP encoded blocks as written by 'seth export'.
+// Gzipped chain files are supported if their name ends in ".gz".
+func LoadChain(chainfile, genesisfile string) (*Chain, error) {
+	gen, err := loadGenesis(genesisfile)
+	if err != nil {
+		return nil, err
+	}
+	fh, err := os.Open(chainfile)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = fh
+	if strings.HasSuffix(chainfile, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return nil, err
+		}
+	}
+	stream := rlp.NewStream(reader, 0)
+	var blocks []*types.Block
+	for i := 0; ; i++ {
+		var b types.Block
+		if err := stream.Decode(&b); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("at block %d: %v", i, err)
+		}
+		blocks = append(blocks, &b)
+	}
+	// Exports may include the genesis block, drop it.
+	if len(blocks) > 0 && blocks[0].NumberU64() == 0 {
+		blocks = blocks[1:]
+	}
+	return NewChain(gen, blocks)
+}
+
+func loadGenesis(file string) (*core.Genesis, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var gen core.Genesis
+	if err := json.Unmarshal(data, &gen); err != nil {
+		return nil, fmt.Errorf("invalid genesis file: %v", err)
+	}
+	return &gen, nil
+}
+
+// Len returns the number of blocks in the chain, including the genesis block.
+func (c *Chain) Len() int {
+	return len(c.blocks)
+}
+
+// Config returns the chain configuration.
+func (c *Chain) Config() *params.ChainConfig {
+	return c.chainConfig
+}
+
+// Genesis returns the genesis block.
+func (c *Chain) Genesis() *types.Block {
+	return c.blocks[0]
+}
+
+// Head returns the last block of the chain.
+func (c *Chain) Head() *types.Block {
+	return c.blocks[len(c.blocks)-1]
+}
+
+// Block returns the block at the given height, or nil if the chain is shorter.
+func (c *Chain) Block(number uint64) *types.Block {
+	if number >= uint64(len(c.blocks)) {
+		return nil
+	}
+	return c.blocks[number]
+}
+
+// BlockByHash returns the block with the given hash, or nil if it isn't part of
+// the chain.
+func (c *Chain) BlockByHash(hash common.Hash) *types.Block {
+	for _, b := range c.blocks {
+		if b.Hash() == hash {
+			return b
+		}
+	}
+	return nil
+}
+
+// TD calculates the total difficulty of the chain up to and including the block
+// at the given height.
+func (c *Chain) TD(number uint64) *big.Int {
+	sum := new(big.Int)
+	for _, b := range c.blocks[:number+1] {
+		sum.Add(sum, b.Difficulty())
+	}
+	return sum
+}
+
+// ForkID computes the fork ID of the chain when the given block is the head.
+func (c *Chain) ForkID(number uint64) forkid.ID {
+	return forkid.NewID(chainAt{c, number})
+}
+
+// Shorten returns a copy of the chain ending at the given height.
+func (c *Chain) Shorten(number uint64) *Chain {
+	blocks := make([]*types.Block, number+1)
+	copy(blocks, c.blocks)
+	return &Chain{blocks: blocks, chainConfig: c.chainConfig}
+}
+
+// GetHeaders returns the headers selected by the given query, mirroring the way
+// the eth protocol handler answers GetBlockHeaders.
+func (c *Chain) GetHeaders(req GetBlockHeaders) []*types.Header {
+	var origin *types.Block
+	if req.Origin.Hash != (common.Hash{}) {
+		origin = c.BlockByHash(req.Origin.Hash)
+	} else {
+		origin = c.Block(req.Origin.Number)
+	}
+	if origin == nil {
+		return nil
+	}
+	var (
+		headers []*types.Header
+		number  = int64(origin.NumberU64())
+		step    = int64(req.Skip) + 1
+	)
+	if req.Reverse {
+		step = -step
+	}
+	for uint64(len(headers)) < req.Amount && number >= 0 && number < int64(len(c.blocks)) {
+		headers = append(headers, c.blocks[number].Header())
+		number += step
+	}
+	return headers
+}
+
+// chainAt is a view of a chain with the given head, used to calculate fork IDs.
+type chainAt struct {
+	*Chain
+	head uint64
+}
+
+func (c chainAt) CurrentHeader() *types.Header {
+	return c.blocks[c.head].Header()
+}
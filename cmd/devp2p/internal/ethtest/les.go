@@ -0,0 +1,289 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package ethtest
+
+import (
+	"net"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/internal/utesting"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+// TestLesStatus checks that the remote node accepts a matching les status
+// message.
+func (s *Suite) TestLesStatus(t *utesting.T) {
+	conn := s.connectLes(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	t.Logf("remote is %q on les/%d, head %d", conn.Name, conn.version, conn.head.NumberU64())
+	var headers LesBlockHeaders
+	query := GetBlockHeaders{Origin: HashOrNumber{Hash: conn.head.Hash()}, Amount: 1}
+	conn.request(t, LesGetBlockHeadersMsg, &query, LesBlockHeadersMsg, &headers)
+	if len(headers.Headers) != 1 || headers.Headers[0].Hash() != conn.head.Hash() {
+		t.Fatalf("wrong response to head query: %v", headers.Headers)
+	}
+}
+
+// TestLesStatusMismatch checks that the remote node disconnects if the genesis
+// block of the les status message doesn't match its own.
+func (s *Suite) TestLesStatusMismatch(t *utesting.T) {
+	conn := s.dialLes(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	conn.readStatus(t)
+	conn.sendStatus(t, common.Hash{1})
+	conn.expectDisconnect(t)
+}
+
+// TestLesGetBlockHeaders checks the les responses to several kinds of header
+// queries.
+func (s *Suite) TestLesGetBlockHeaders(t *utesting.T) {
+	conn := s.connectLes(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	head := conn.head.NumberU64()
+	queries := []GetBlockHeaders{
+		{Origin: HashOrNumber{Number: 1}, Amount: maxQuery},
+		{Origin: HashOrNumber{Hash: conn.head.Hash()}, Amount: maxQuery},
+		{Origin: HashOrNumber{Number: 0}, Amount: maxQuery, Skip: 1},
+		{Origin: HashOrNumber{Number: head}, Amount: maxQuery, Reverse: true},
+	}
+	for _, query := range queries {
+		var headers LesBlockHeaders
+		conn.request(t, LesGetBlockHeadersMsg, &query, LesBlockHeadersMsg, &headers)
+
+		want := conn.chain.GetHeaders(query)
+		if len(headers.Headers) != len(want) {
+			t.Errorf("query %+v: got %d headers, want %d", query, len(headers.Headers), len(want))
+			continue
+		}
+		for i, header := range headers.Headers {
+			if header.Hash() != want[i].Hash() {
+				t.Errorf("query %+v: header %d mismatch: got %x, want %x", query, i, header.Hash(), want[i].Hash())
+			}
+		}
+	}
+}
+
+// TestLesGetBlockBodies checks that the remote node returns the bodies of the
+// most recent blocks over les.
+func (s *Suite) TestLesGetBlockBodies(t *utesting.T) {
+	conn := s.connectLes(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	var blocks []*types.Block
+	for n := conn.head.NumberU64(); n > 0 && len(blocks) < maxQuery; n-- {
+		blocks = append([]*types.Block{conn.chain.Block(n)}, blocks...)
+	}
+	var hashes []common.Hash
+	for _, b := range blocks {
+		hashes = append(hashes, b.Hash())
+	}
+	var bodies LesBlockBodies
+	conn.request(t, LesGetBlockBodiesMsg, hashes, LesBlockBodiesMsg, &bodies)
+
+	if len(bodies.Bodies) != len(blocks) {
+		t.Fatalf("got %d bodies, want %d", len(bodies.Bodies), len(blocks))
+	}
+	for i, body := range bodies.Bodies {
+		header := blocks[i].Header()
+		if hash := types.DeriveSha(types.Transactions(body.Transactions)); hash != header.TxHash {
+			t.Errorf("body %d: transaction root mismatch: got %x, want %x", header.Number, hash, header.TxHash)
+		}
+		if hash := types.CalcUncleHash(body.Uncles); hash != header.UncleHash {
+			t.Errorf("body %d: uncle hash mismatch: got %x, want %x", header.Number, hash, header.UncleHash)
+		}
+	}
+}
+
+// LesConn is a les connection to the remote node.
+type LesConn struct {
+	*p2p.RLPXConn
+	chain   *Chain       // Test chain, shortened to the remote head after the handshake
+	version uint64       // Negotiated les protocol version
+	network uint64       // Network ID announced by the remote node
+	head    *types.Block // Head block announced by the remote node
+	reqID   uint64       // ID of the last request sent
+}
+
+// dialLes connects to the remote node, without performing the status handshake.
+// The test is skipped if the remote node doesn't serve les.
+func (s *Suite) dialLes(t *utesting.T) *LesConn {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	rlpx, err := p2p.DialRLPX(s.Dest, key, "devp2p-eth-test", LesCaps, dialTimeout)
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	for _, cap := range rlpx.Caps {
+		for _, our := range LesCaps {
+			if cap == our {
+				return &LesConn{RLPXConn: rlpx, chain: s.chain, version: uint64(cap.Version)}
+			}
+		}
+	}
+	rlpx.Close(p2p.DiscUselessPeer)
+	t.Skip("remote node does not serve les")
+	return nil
+}
+
+// connectLes connects to the remote node and performs the les status handshake.
+func (s *Suite) connectLes(t *utesting.T) *LesConn {
+	conn := s.dialLes(t)
+	conn.readStatus(t)
+	conn.sendStatus(t, conn.chain.Genesis().Hash())
+	return conn
+}
+
+// readStatus reads the les status message of the remote node and checks it
+// against the test chain.
+func (c *LesConn) readStatus(t *utesting.T) {
+	c.SetDeadline(time.Now().Add(respTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	msg, err := c.ReadMsg()
+	if err != nil {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("could not read status: %v", err)
+	}
+	if msg.Code != LesStatusMsg {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("first message has code %#x, want status", msg.Code)
+	}
+	var status LesStatus
+	if err := msg.Decode(&status); err != nil {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("invalid status: %v", err)
+	}
+	var (
+		version       uint64
+		genesis, head common.Hash
+	)
+	for _, err := range []error{
+		status.Get("protocolVersion", &version),
+		status.Get("networkId", &c.network),
+		status.Get("headHash", &head),
+		status.Get("genesisHash", &genesis),
+	} {
+		if err != nil {
+			c.Close(p2p.DiscRequested)
+			t.Fatalf("invalid status: %v", err)
+		}
+	}
+	if version != c.version {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("remote announced les/%d in status, negotiated les/%d", version, c.version)
+	}
+	if genesis != c.chain.Genesis().Hash() {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("remote genesis %x does not match the test chain (%x)", genesis, c.chain.Genesis().Hash())
+	}
+	if c.head = c.chain.BlockByHash(head); c.head == nil {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("remote head %x is not part of the test chain", head)
+	}
+	c.chain = c.chain.Shorten(c.head.NumberU64())
+}
+
+// sendStatus sends a client status announcing the remote head and the given
+// genesis block.
+func (c *LesConn) sendStatus(t *utesting.T, genesis common.Hash) {
+	var status LesStatus
+	status = status.Add("protocolVersion", c.version)
+	status = status.Add("networkId", c.network)
+	status = status.Add("headTd", c.chain.TD(c.head.NumberU64()))
+	status = status.Add("headHash", c.head.Hash())
+	status = status.Add("headNum", c.head.NumberU64())
+	status = status.Add("genesisHash", genesis)
+	status = status.Add("announceType", uint64(1))
+	if err := p2p.Send(c, LesStatusMsg, status); err != nil {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("could not send status: %v", err)
+	}
+}
+
+// request sends a request with a fresh request ID and decodes the response into
+// result, which must be a les response with a matching ID.
+func (c *LesConn) request(t *utesting.T, code uint64, query interface{}, respCode uint64, result interface{}) {
+	c.reqID++
+	if err := p2p.Send(c, code, []interface{}{c.reqID, query}); err != nil {
+		t.Fatalf("could not send message %#x: %v", code, err)
+	}
+	var (
+		msg  = c.expect(t, respCode)
+		raw  rlp.RawValue
+		resp struct {
+			ReqID uint64
+			Rest  []rlp.RawValue `rlp:"tail"`
+		}
+	)
+	if err := msg.Decode(&raw); err != nil {
+		t.Fatalf("invalid response %#x: %v", respCode, err)
+	}
+	if err := rlp.DecodeBytes(raw, &resp); err != nil {
+		t.Fatalf("invalid response %#x: %v", respCode, err)
+	}
+	if resp.ReqID != c.reqID {
+		t.Fatalf("response %#x has request ID %d, want %d", respCode, resp.ReqID, c.reqID)
+	}
+	if err := rlp.DecodeBytes(raw, result); err != nil {
+		t.Fatalf("invalid response %#x: %v", respCode, err)
+	}
+}
+
+// expect reads messages until one with the given code arrives. Announcements
+// and other messages are discarded.
+func (c *LesConn) expect(t *utesting.T, code uint64) p2p.Msg {
+	c.SetDeadline(time.Now().Add(respTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	for {
+		msg, err := c.ReadMsg()
+		if err != nil {
+			t.Fatalf("error while waiting for message %#x: %v", code, err)
+		}
+		if msg.Code == code {
+			return msg
+		}
+		msg.Discard()
+	}
+}
+
+// expectDisconnect reads messages until the remote node disconnects.
+func (c *LesConn) expectDisconnect(t *utesting.T) {
+	c.SetDeadline(time.Now().Add(respTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	for {
+		msg, err := c.ReadMsg()
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			t.Fatalf("remote node did not disconnect")
+		}
+		if err != nil {
+			t.Logf("remote node disconnected: %v", err)
+			return
+		}
+		msg.Discard()
+	}
+}
@@ -0,0 +1,459 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ethtest implements a conformance test suite for the eth and les
+// protocols. The suite connects to a running node over RLPx and checks its
+// responses against a chain which the node is expected to have imported.
+package ethtest
+
+import (
+	"net"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/internal/utesting"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/p2p/enode"
+)
+
+const (
+	dialTimeout = 10 * time.Second // Time allowance for the RLPx handshakes
+	respTimeout = 10 * time.Second // Time allowance for the remote node to respond
+	maxQuery    = 4                // Number of blocks queried by the retrieval tests
+)
+
+// Suite represents a set of conformance tests against a single node.
+type Suite struct {
+	Dest  *enode.Node
+	chain *Chain
+}
+
+// NewSuite creates a test suite for dest, which must have imported the chain
+// contained in chainfile (see LoadChain).
+func NewSuite(dest *enode.Node, chainfile, genesisfile string) (*Suite, error) {
+	chain, err := LoadChain(chainfile, genesisfile)
+	if err != nil {
+		return nil, err
+	}
+	return NewSuiteWithChain(dest, chain), nil
+}
+
+// NewSuiteWithChain creates a test suite for dest using an already loaded chain.
+func NewSuiteWithChain(dest *enode.Node, chain *Chain) *Suite {
+	return &Suite{Dest: dest, chain: chain}
+}
+
+// AllTests returns all test cases of the suite. The block propagation test
+// runs before the transaction tests because nodes only process transactions
+// once they consider themselves synced, which the import of a propagated block
+// signals. The les tests are skipped if the remote node doesn't serve les.
+func (s *Suite) AllTests() []utesting.Test {
+	return []utesting.Test{
+		{Name: "Status", Fn: s.TestStatus},
+		{Name: "StatusMismatch", Fn: s.TestStatusMismatch},
+		{Name: "GetBlockHeaders", Fn: s.TestGetBlockHeaders},
+		{Name: "GetBlockBodies", Fn: s.TestGetBlockBodies},
+		{Name: "GetNodeData", Fn: s.TestGetNodeData},
+		{Name: "GetReceipts", Fn: s.TestGetReceipts},
+		{Name: "NewBlock", Fn: s.TestNewBlock},
+		{Name: "Transactions", Fn: s.TestTransactions},
+		{Name: "MalformedGetBlockHeaders", Fn: s.TestMalformedGetBlockHeaders},
+		{Name: "MalformedTransactions", Fn: s.TestMalformedTransactions},
+		{Name: "MalformedNewBlock", Fn: s.TestMalformedNewBlock},
+		{Name: "LesStatus", Fn: s.TestLesStatus},
+		{Name: "LesStatusMismatch", Fn: s.TestLesStatusMismatch},
+		{Name: "LesGetBlockHeaders", Fn: s.TestLesGetBlockHeaders},
+		{Name: "LesGetBlockBodies", Fn: s.TestLesGetBlockBodies},
+	}
+}
+
+// TestStatus checks that the remote node accepts a matching status message.
+func (s *Suite) TestStatus(t *utesting.T) {
+	conn := s.connect(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	t.Logf("remote is %q on eth/%d, head %d", conn.Name, conn.version, conn.head.NumberU64())
+	conn.checkAlive(t)
+}
+
+// TestStatusMismatch checks that the remote node disconnects if the genesis
+// block of the status message doesn't match its own.
+func (s *Suite) TestStatusMismatch(t *utesting.T) {
+	conn := s.dial(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	status := conn.readStatus(t)
+	status.Genesis = common.Hash{1}
+	conn.sendStatus(t, status)
+	conn.expectDisconnect(t)
+}
+
+// TestGetBlockHeaders checks the responses to several kinds of header queries.
+func (s *Suite) TestGetBlockHeaders(t *utesting.T) {
+	conn := s.connect(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	head := conn.head.NumberU64()
+	queries := []GetBlockHeaders{
+		{Origin: HashOrNumber{Number: 1}, Amount: maxQuery},
+		{Origin: HashOrNumber{Hash: conn.head.Hash()}, Amount: maxQuery},
+		{Origin: HashOrNumber{Number: 0}, Amount: maxQuery, Skip: 1},
+		{Origin: HashOrNumber{Number: head}, Amount: maxQuery, Reverse: true},
+		{Origin: HashOrNumber{Hash: conn.chain.Genesis().Hash()}, Amount: maxQuery, Skip: 2},
+	}
+	for _, query := range queries {
+		var headers []*types.Header
+		conn.request(t, GetBlockHeadersMsg, &query, BlockHeadersMsg, &headers)
+
+		want := conn.chain.GetHeaders(query)
+		if len(headers) != len(want) {
+			t.Errorf("query %+v: got %d headers, want %d", query, len(headers), len(want))
+			continue
+		}
+		for i := range headers {
+			if headers[i].Hash() != want[i].Hash() {
+				t.Errorf("query %+v: header %d mismatch: got %x, want %x", query, i, headers[i].Hash(), want[i].Hash())
+			}
+		}
+	}
+}
+
+// TestGetBlockBodies checks that the remote node returns the bodies of the most
+// recent blocks.
+func (s *Suite) TestGetBlockBodies(t *utesting.T) {
+	conn := s.connect(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	blocks := conn.recentBlocks()
+	var hashes []common.Hash
+	for _, b := range blocks {
+		hashes = append(hashes, b.Hash())
+	}
+	var bodies []*BlockBody
+	conn.request(t, GetBlockBodiesMsg, hashes, BlockBodiesMsg, &bodies)
+
+	if len(bodies) != len(blocks) {
+		t.Fatalf("got %d bodies, want %d", len(bodies), len(blocks))
+	}
+	for i, body := range bodies {
+		header := blocks[i].Header()
+		if hash := types.DeriveSha(types.Transactions(body.Transactions)); hash != header.TxHash {
+			t.Errorf("body %d: transaction root mismatch: got %x, want %x", header.Number, hash, header.TxHash)
+		}
+		if hash := types.CalcUncleHash(body.Uncles); hash != header.UncleHash {
+			t.Errorf("body %d: uncle hash mismatch: got %x, want %x", header.Number, hash, header.UncleHash)
+		}
+	}
+}
+
+// TestGetNodeData checks that the remote node returns the state root node of
+// its head block.
+func (s *Suite) TestGetNodeData(t *utesting.T) {
+	conn := s.connect(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	root := conn.head.Root()
+	var data [][]byte
+	conn.request(t, GetNodeDataMsg, []common.Hash{root}, NodeDataMsg, &data)
+
+	if len(data) != 1 {
+		t.Fatalf("got %d state entries, want 1", len(data))
+	}
+	if hash := crypto.Keccak256Hash(data[0]); hash != root {
+		t.Fatalf("state entry hash mismatch: got %x, want %x", hash, root)
+	}
+}
+
+// TestGetReceipts checks that the remote node returns the receipts of the most
+// recent blocks.
+func (s *Suite) TestGetReceipts(t *utesting.T) {
+	conn := s.connect(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	blocks := conn.recentBlocks()
+	var hashes []common.Hash
+	for _, b := range blocks {
+		hashes = append(hashes, b.Hash())
+	}
+	var receipts [][]*types.Receipt
+	conn.request(t, GetReceiptsMsg, hashes, ReceiptsMsg, &receipts)
+
+	if len(receipts) != len(blocks) {
+		t.Fatalf("got %d receipt lists, want %d", len(receipts), len(blocks))
+	}
+	for i, list := range receipts {
+		header := blocks[i].Header()
+		if hash := types.DeriveSha(types.Receipts(list)); hash != header.ReceiptHash {
+			t.Errorf("receipts %d: root mismatch: got %x, want %x", header.Number, hash, header.ReceiptHash)
+		}
+	}
+}
+
+// TestNewBlock propagates the block following the remote head on one connection
+// and checks that the remote node imports and announces it on another one.
+func (s *Suite) TestNewBlock(t *utesting.T) {
+	sender := s.connect(t)
+	defer sender.Close(p2p.DiscRequested)
+	receiver := s.connect(t)
+	defer receiver.Close(p2p.DiscRequested)
+
+	next := s.chain.Block(sender.head.NumberU64() + 1)
+	if next == nil {
+		t.Skip("remote node is at the head of the test chain, no block left to propagate")
+	}
+	announce := NewBlock{Block: next, TD: s.chain.TD(next.NumberU64())}
+	if err := p2p.Send(sender, NewBlockMsg, announce); err != nil {
+		t.Fatalf("could not send block: %v", err)
+	}
+	msg := receiver.expect(t, NewBlockMsg, NewBlockHashesMsg)
+	switch msg.Code {
+	case NewBlockMsg:
+		var block NewBlock
+		if err := msg.Decode(&block); err != nil {
+			t.Fatalf("invalid NewBlock message: %v", err)
+		}
+		if block.Block.Hash() != next.Hash() {
+			t.Fatalf("wrong block propagated: got %x, want %x", block.Block.Hash(), next.Hash())
+		}
+	case NewBlockHashesMsg:
+		var hashes NewBlockHashes
+		if err := msg.Decode(&hashes); err != nil {
+			t.Fatalf("invalid NewBlockHashes message: %v", err)
+		}
+		if len(hashes) == 0 || hashes[0].Hash != next.Hash() {
+			t.Fatalf("wrong block announced: got %v, want %x", hashes, next.Hash())
+		}
+	}
+}
+
+// TestTransactions sends the transactions of the test chain and checks that the
+// remote node keeps the connection.
+func (s *Suite) TestTransactions(t *utesting.T) {
+	conn := s.connect(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	var txs []*types.Transaction
+	for _, b := range conn.recentBlocks() {
+		txs = append(txs, b.Transactions()...)
+	}
+	if err := p2p.Send(conn, TransactionsMsg, txs); err != nil {
+		t.Fatalf("could not send transactions: %v", err)
+	}
+	conn.checkAlive(t)
+}
+
+// TestMalformedGetBlockHeaders checks that the remote node disconnects when it
+// receives a header query with an invalid origin.
+func (s *Suite) TestMalformedGetBlockHeaders(t *utesting.T) {
+	conn := s.connect(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	query := []interface{}{make([]byte, 40), uint64(1), uint64(0), false}
+	if err := p2p.Send(conn, GetBlockHeadersMsg, query); err != nil {
+		t.Fatalf("could not send query: %v", err)
+	}
+	conn.expectDisconnect(t)
+}
+
+// TestMalformedTransactions checks that the remote node disconnects when it
+// receives an undecodable transaction. Note that nodes ignore transactions
+// while they are syncing.
+func (s *Suite) TestMalformedTransactions(t *utesting.T) {
+	conn := s.connect(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	txs := []interface{}{[]interface{}{uint64(1), uint64(2)}}
+	if err := p2p.Send(conn, TransactionsMsg, txs); err != nil {
+		t.Fatalf("could not send transactions: %v", err)
+	}
+	conn.expectDisconnect(t)
+}
+
+// TestMalformedNewBlock checks that the remote node disconnects when it receives
+// an undecodable block.
+func (s *Suite) TestMalformedNewBlock(t *utesting.T) {
+	conn := s.connect(t)
+	defer conn.Close(p2p.DiscRequested)
+
+	announce := []interface{}{[]interface{}{"junk"}, uint64(1)}
+	if err := p2p.Send(conn, NewBlockMsg, announce); err != nil {
+		t.Fatalf("could not send block: %v", err)
+	}
+	conn.expectDisconnect(t)
+}
+
+// Conn is an eth connection to the remote node.
+type Conn struct {
+	*p2p.RLPXConn
+	chain   *Chain       // Test chain, shortened to the remote head after the handshake
+	version uint32       // Negotiated eth protocol version
+	head    *types.Block // Head block announced by the remote node
+}
+
+// dial connects to the remote node, without performing the status handshake.
+func (s *Suite) dial(t *utesting.T) *Conn {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	rlpx, err := p2p.DialRLPX(s.Dest, key, "devp2p-eth-test", EthCaps, dialTimeout)
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	return &Conn{RLPXConn: rlpx, chain: s.chain}
+}
+
+// connect connects to the remote node and performs the status handshake.
+func (s *Suite) connect(t *utesting.T) *Conn {
+	conn := s.dial(t)
+	status := conn.readStatus(t)
+	conn.sendStatus(t, status)
+	return conn
+}
+
+// readStatus reads the status message of the remote node and checks it against
+// the test chain. The returned status announces the same chain as the remote
+// node, so the remote node won't try to sync from the test connection.
+func (c *Conn) readStatus(t *utesting.T) *statusMsg {
+	c.SetDeadline(time.Now().Add(respTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	msg, err := c.ReadMsg()
+	if err != nil {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("could not read status: %v", err)
+	}
+	if msg.Code != StatusMsg {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("first message has code %#x, want status", msg.Code)
+	}
+	var status statusMsg
+	if err := msg.Decode(&status); err != nil {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("invalid status: %v", err)
+	}
+	if status.Genesis != c.chain.Genesis().Hash() {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("remote genesis %x does not match the test chain (%x)", status.Genesis, c.chain.Genesis().Hash())
+	}
+	head := c.chain.BlockByHash(status.Head)
+	if head == nil {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("remote head %x is not part of the test chain", status.Head)
+	}
+	c.version, c.head = status.ProtocolVersion, head
+	c.chain = c.chain.Shorten(head.NumberU64())
+	return &status
+}
+
+// sendStatus sends the given status, adding the fork ID since eth/64.
+func (c *Conn) sendStatus(t *utesting.T, status *statusMsg) {
+	var msg interface{} = &Status{status.ProtocolVersion, status.NetworkID, status.TD, status.Head, status.Genesis}
+	if status.ProtocolVersion >= 64 {
+		forkID := c.chain.ForkID(c.head.NumberU64())
+		msg = &Status64{status.ProtocolVersion, status.NetworkID, status.TD, status.Head, status.Genesis, forkID}
+	}
+	if err := p2p.Send(c, StatusMsg, msg); err != nil {
+		c.Close(p2p.DiscRequested)
+		t.Fatalf("could not send status: %v", err)
+	}
+}
+
+// recentBlocks returns up to maxQuery blocks ending at the remote head.
+func (c *Conn) recentBlocks() []*types.Block {
+	var blocks []*types.Block
+	for n := c.head.NumberU64(); n > 0 && len(blocks) < maxQuery; n-- {
+		blocks = append([]*types.Block{c.chain.Block(n)}, blocks...)
+	}
+	return blocks
+}
+
+// request sends a request and decodes the response into result.
+func (c *Conn) request(t *utesting.T, code uint64, data interface{}, respCode uint64, result interface{}) {
+	if err := p2p.Send(c, code, data); err != nil {
+		t.Fatalf("could not send message %#x: %v", code, err)
+	}
+	msg := c.expect(t, respCode)
+	if err := msg.Decode(result); err != nil {
+		t.Fatalf("invalid response %#x: %v", respCode, err)
+	}
+}
+
+// checkAlive checks that the remote node still answers header queries.
+func (c *Conn) checkAlive(t *utesting.T) {
+	var headers []*types.Header
+	query := GetBlockHeaders{Origin: HashOrNumber{Hash: c.head.Hash()}, Amount: 1}
+	c.request(t, GetBlockHeadersMsg, &query, BlockHeadersMsg, &headers)
+	if len(headers) != 1 || headers[0].Hash() != c.head.Hash() {
+		t.Fatalf("wrong response to head query: %v", headers)
+	}
+}
+
+// expect reads messages until one with any of the given codes arrives. Header
+// queries of the remote node are answered, other messages are discarded.
+func (c *Conn) expect(t *utesting.T, codes ...uint64) p2p.Msg {
+	c.SetDeadline(time.Now().Add(respTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	for {
+		msg, err := c.ReadMsg()
+		if err != nil {
+			t.Fatalf("error while waiting for message %#x: %v", codes, err)
+		}
+		for _, code := range codes {
+			if msg.Code == code {
+				return msg
+			}
+		}
+		if err := c.serve(msg); err != nil {
+			t.Fatalf("could not handle message %#x: %v", msg.Code, err)
+		}
+	}
+}
+
+// expectDisconnect reads messages until the remote node disconnects.
+func (c *Conn) expectDisconnect(t *utesting.T) {
+	c.SetDeadline(time.Now().Add(respTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	for {
+		msg, err := c.ReadMsg()
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			t.Fatalf("remote node did not disconnect")
+		}
+		if err != nil {
+			t.Logf("remote node disconnected: %v", err)
+			return
+		}
+		c.serve(msg)
+	}
+}
+
+// serve answers the header queries of the remote node, which it may use to check
+// the chain announced in the status message, and discards all other messages.
+func (c *Conn) serve(msg p2p.Msg) error {
+	defer msg.Discard()
+	if msg.Code != GetBlockHeadersMsg {
+		return nil
+	}
+	var query GetBlockHeaders
+	if err := msg.Decode(&query); err != nil {
+		return err
+	}
+	return p2p.Send(c, BlockHeadersMsg, c.chain.GetHeaders(query))
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package ethtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/consensus/ethash"
+	"github.com/severeum/go-severeum/core"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/eth"
+	"github.com/severeum/go-severeum/eth/downloader"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/internal/utesting"
+	"github.com/severeum/go-severeum/les"
+	"github.com/severeum/go-severeum/node"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/params"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddress = crypto.PubkeyToAddress(testKey.PublicKey)
+)
+
+func TestEthSuite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ethtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create a test chain and write it to disk, like 'seth export' would.
+	genesis, blocks := makeChain(t, 20)
+	chainfile, genesisfile := filepath.Join(dir, "chain.rlp"), filepath.Join(dir, "genesis.json")
+	writeChain(t, chainfile, genesisfile, genesis, blocks)
+
+	// Start a node which has all but the last block.
+	stack := runNode(t, filepath.Join(dir, "node"), genesis, blocks[:len(blocks)-1])
+	defer stack.Stop()
+
+	suite, err := NewSuite(stack.Server().Self(), chainfile, genesisfile)
+	if err != nil {
+		t.Fatalf("could not create suite: %v", err)
+	}
+	for _, test := range suite.AllTests() {
+		t.Run(test.Name, func(t *testing.T) {
+			failed, skipped, output := utesting.Run(test)
+			if output != "" {
+				t.Log(output)
+			}
+			if failed {
+				t.Fatal("test failed")
+			}
+			if skipped {
+				t.Skip("test skipped")
+			}
+		})
+	}
+}
+
+// makeChain creates a chain of n blocks, each containing a value transfer.
+func makeChain(t *testing.T, n int) (*core.Genesis, []*types.Block) {
+	genesis := &core.Genesis{
+		Config:     params.TestChainConfig,
+		Difficulty: params.MinimumDifficulty,
+		Alloc:      core.GenesisAlloc{testAddress: {Balance: big.NewInt(params.Sever)}},
+	}
+	db := ethdb.NewMemDatabase()
+	gblock := genesis.MustCommit(db)
+	signer := types.NewEIP155Signer(genesis.Config.ChainID)
+	blocks, _ := core.GenerateChain(genesis.Config, gblock, ethash.NewFaker(), db, n, func(i int, b *core.BlockGen) {
+		tx := types.NewTransaction(b.TxNonce(testAddress), common.Address{1}, big.NewInt(1000), params.TxGas, big.NewInt(1), nil)
+		tx, err := types.SignTx(tx, signer, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.AddTx(tx)
+	})
+	return genesis, blocks
+}
+
+func writeChain(t *testing.T, chainfile, genesisfile string, genesis *core.Genesis, blocks []*types.Block) {
+	gjson, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(genesisfile, gjson, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fh, err := os.Create(chainfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	for _, b := range blocks {
+		if err := rlp.Encode(fh, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// runNode starts an in-process node serving eth and les, which has imported the
+// given blocks.
+func runNode(t *testing.T, datadir string, genesis *core.Genesis, blocks []*types.Block) *node.Node {
+	stack, err := node.New(&node.Config{
+		DataDir: datadir,
+		P2P: p2p.Config{
+			ListenAddr:  "127.0.0.1:0",
+			NoDiscovery: true,
+			MaxPeers:    10,
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create node: %v", err)
+	}
+	config := eth.DefaultConfig
+	config.Genesis = genesis
+	config.NetworkId = genesis.Config.ChainID.Uint64()
+	config.SyncMode = downloader.FullSync
+	config.Sevash.PowMode = ethash.ModeFake
+	config.LightServ, config.LightPeers = 50, 5
+	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		severeum, err := eth.New(ctx, &config)
+		if err != nil {
+			return nil, err
+		}
+		server, err := les.NewLesServer(severeum, &config)
+		if err != nil {
+			return nil, err
+		}
+		severeum.AddLesServer(server)
+		return severeum, nil
+	})
+	if err != nil {
+		t.Fatalf("could not register eth service: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("could not start node: %v", err)
+	}
+	var severeum *eth.Severeum
+	stack.Service(&severeum)
+	if _, err := severeum.BlockChain().InsertChain(blocks); err != nil {
+		stack.Stop()
+		t.Fatalf("could not import blocks: %v", err)
+	}
+	return stack
+}
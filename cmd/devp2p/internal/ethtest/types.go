@@ -0,0 +1,211 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package ethtest
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/forkid"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+// This file mirrors the message types of the eth and les protocols. They are
+// duplicated here because the suite is meant to test the wire format, so it must
+// not share the encoding logic with the implementation under test.
+
+// EthCaps are the eth versions supported by the suite.
+var EthCaps = []p2p.Cap{{Name: "eth", Version: 62}, {Name: "eth", Version: 63}, {Name: "eth", Version: 64}}
+
+// LesCaps are the les versions supported by the suite.
+var LesCaps = []p2p.Cap{{Name: "les", Version: 2}}
+
+// Message codes of the eth protocol.
+const (
+	StatusMsg          = 0x00
+	NewBlockHashesMsg  = 0x01
+	TransactionsMsg    = 0x02
+	GetBlockHeadersMsg = 0x03
+	BlockHeadersMsg    = 0x04
+	GetBlockBodiesMsg  = 0x05
+	BlockBodiesMsg     = 0x06
+	NewBlockMsg        = 0x07
+	GetNodeDataMsg     = 0x0d
+	NodeDataMsg        = 0x0e
+	GetReceiptsMsg     = 0x0f
+	ReceiptsMsg        = 0x10
+)
+
+// Status is the status message of eth/62 and eth/63.
+type Status struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+}
+
+// Status64 is the status message since eth/64, which adds the fork ID.
+type Status64 struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+	ForkID          forkid.ID
+}
+
+// statusMsg decodes either kind of status message.
+type statusMsg struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Genesis         common.Hash
+	Rest            []rlp.RawValue `rlp:"tail"`
+}
+
+// NewBlockHashes is the network packet for block announcements.
+type NewBlockHashes []struct {
+	Hash   common.Hash
+	Number uint64
+}
+
+// GetBlockHeaders represents a block header query.
+type GetBlockHeaders struct {
+	Origin  HashOrNumber
+	Amount  uint64
+	Skip    uint64
+	Reverse bool
+}
+
+// HashOrNumber is a combined field for specifying an origin block.
+type HashOrNumber struct {
+	Hash   common.Hash
+	Number uint64
+}
+
+// EncodeRLP encodes only one of the two contained union fields.
+func (hn *HashOrNumber) EncodeRLP(w io.Writer) error {
+	if hn.Hash == (common.Hash{}) {
+		return rlp.Encode(w, hn.Number)
+	}
+	if hn.Number != 0 {
+		return fmt.Errorf("both origin hash (%x) and number (%d) provided", hn.Hash, hn.Number)
+	}
+	return rlp.Encode(w, hn.Hash)
+}
+
+// DecodeRLP decodes the contents into either a block hash or a block number.
+func (hn *HashOrNumber) DecodeRLP(s *rlp.Stream) error {
+	_, size, _ := s.Kind()
+	origin, err := s.Raw()
+	if err == nil {
+		switch {
+		case size == 32:
+			err = rlp.DecodeBytes(origin, &hn.Hash)
+		case size <= 8:
+			err = rlp.DecodeBytes(origin, &hn.Number)
+		default:
+			err = fmt.Errorf("invalid input size %d for origin", size)
+		}
+	}
+	return err
+}
+
+// NewBlock is the network packet for block propagation.
+type NewBlock struct {
+	Block *types.Block
+	TD    *big.Int
+}
+
+// BlockBody represents the data content of a single block.
+type BlockBody struct {
+	Transactions []*types.Transaction
+	Uncles       []*types.Header
+}
+
+// Message codes of the les protocol.
+const (
+	LesStatusMsg          = 0x00
+	LesAnnounceMsg        = 0x01
+	LesGetBlockHeadersMsg = 0x02
+	LesBlockHeadersMsg    = 0x03
+	LesGetBlockBodiesMsg  = 0x04
+	LesBlockBodiesMsg     = 0x05
+)
+
+// LesStatus is the status message of les, a list of key/value pairs whose
+// values are RLP encoded.
+type LesStatus []LesStatusEntry
+
+// LesStatusEntry is a single entry of the les status message.
+type LesStatusEntry struct {
+	Key   string
+	Value rlp.RawValue
+}
+
+// Add returns the status with the given entry appended. Flags without value are
+// encoded as zero.
+func (s LesStatus) Add(key string, val interface{}) LesStatus {
+	if val == nil {
+		val = uint64(0)
+	}
+	enc, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		panic(err)
+	}
+	return append(s, LesStatusEntry{Key: key, Value: enc})
+}
+
+// Get decodes the value of the given entry into val.
+func (s LesStatus) Get(key string, val interface{}) error {
+	for _, entry := range s {
+		if entry.Key == key {
+			return rlp.DecodeBytes(entry.Value, val)
+		}
+	}
+	return fmt.Errorf("missing status entry %q", key)
+}
+
+// LesGetBlockHeaders is a les header query.
+type LesGetBlockHeaders struct {
+	ReqID uint64
+	Query GetBlockHeaders
+}
+
+// LesBlockHeaders is the les response to a header query.
+type LesBlockHeaders struct {
+	ReqID, BV uint64
+	Headers   []*types.Header
+}
+
+// LesGetBlockBodies is a les block body query.
+type LesGetBlockBodies struct {
+	ReqID  uint64
+	Hashes []common.Hash
+}
+
+// LesBlockBodies is the les response to a block body query.
+type LesBlockBodies struct {
+	ReqID, BV uint64
+	Bodies    []*BlockBody
+}
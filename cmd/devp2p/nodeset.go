@@ -0,0 +1,132 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/common/hexutil"
+	"github.com/severeum/go-severeum/p2p/enode"
+)
+
+const jsonIndent = "    "
+
+// nodeSet is the nodes.json file format. It holds a set of node records
+// as a JSON object, keyed by node ID.
+type nodeSet map[enode.ID]nodeJSON
+
+type nodeJSON struct {
+	Seq    uint64      `json:"seq"`
+	Record string      `json:"record"` // Text form of the node record ("enr:..." or "enode://...")
+	N      *enode.Node `json:"-"`
+
+	// These fields are maintained by the crawler.
+	FirstSeen time.Time   `json:"firstSeen,omitempty"`
+	LastSeen  time.Time   `json:"lastSeen,omitempty"`
+	LastCheck time.Time   `json:"lastCheck,omitempty"`
+	Client    *clientInfo `json:"client,omitempty"` // Result of the last successful RLPx handshake
+}
+
+// clientInfo holds what a node announced in the RLPx and eth handshakes.
+type clientInfo struct {
+	Name      string        `json:"name"`
+	Caps      []string      `json:"caps"`
+	NetworkID uint64        `json:"networkId,omitempty"`
+	Genesis   *common.Hash  `json:"genesis,omitempty"`
+	ForkHash  hexutil.Bytes `json:"forkHash,omitempty"` // Only announced since eth/64
+	ForkNext  uint64        `json:"forkNext,omitempty"`
+}
+
+func loadNodesJSON(file string) nodeSet {
+	var nodes nodeSet
+	if err := loadJSON(file, &nodes); err != nil {
+		exit(err)
+	}
+	for id, n := range nodes {
+		node, err := enode.Parse(enode.ValidSchemes, n.Record)
+		if err != nil {
+			exit(fmt.Errorf("invalid record of node %v in %s: %v", id, file, err))
+		}
+		if node.ID() != id {
+			exit(fmt.Errorf("record of node %v in %s has mismatching ID %v", id, file, node.ID()))
+		}
+		n.N = node
+		nodes[id] = n
+	}
+	return nodes
+}
+
+func writeNodesJSON(file string, nodes nodeSet) {
+	nodesJSON, err := json.MarshalIndent(nodes, "", jsonIndent)
+	if err != nil {
+		exit(err)
+	}
+	if file == "-" {
+		os.Stdout.Write(nodesJSON)
+		return
+	}
+	if err := ioutil.WriteFile(file, nodesJSON, 0644); err != nil {
+		exit(err)
+	}
+}
+
+// nodes returns the nodes of the set, sorted by ID.
+func (ns nodeSet) nodes() []*enode.Node {
+	result := make([]*enode.Node, 0, len(ns))
+	for _, n := range ns {
+		result = append(result, n.N)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].ID().Bytes(), result[j].ID().Bytes()) < 0
+	})
+	return result
+}
+
+// add inserts the given nodes into the set, replacing older records.
+func (ns nodeSet) add(nodes ...*enode.Node) {
+	for _, n := range nodes {
+		ns.setRecord(n)
+	}
+}
+
+// setRecord updates the record of a node in the set, keeping the crawler
+// metadata. Records older than the one in the set are ignored.
+func (ns nodeSet) setRecord(n *enode.Node) {
+	v, ok := ns[n.ID()]
+	if ok && v.Seq > n.Seq() {
+		return
+	}
+	v.Seq, v.Record, v.N = n.Seq(), nodeText(n), n
+	ns[n.ID()] = v
+}
+
+// nodeText returns the text form of n. Nodes found through discovery v4 may
+// only be known by their endpoint, without a signed record. The enode URL is
+// used for them.
+func nodeText(n *enode.Node) string {
+	if n.Record().VerifySignature(enode.ValidSchemes) != nil {
+		return n.String()
+	}
+	return n.RecordText()
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/severeum/go-severeum/common/hexutil"
+	"github.com/severeum/go-severeum/core/forkid"
+	"github.com/severeum/go-severeum/rlp"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	nodesetCommand = cli.Command{
+		Name:  "nodeset",
+		Usage: "Node set tools",
+		Subcommands: []cli.Command{
+			nodesetInfoCommand,
+			nodesetFilterCommand,
+		},
+	}
+	nodesetInfoCommand = cli.Command{
+		Name:      "info",
+		Usage:     "Show statistics about a node set",
+		ArgsUsage: "<nodes.json>",
+		Action:    nodesetInfo,
+	}
+	nodesetFilterCommand = cli.Command{
+		Name:      "filter",
+		Usage:     "Filter a node set, writing the result to stdout",
+		ArgsUsage: "<nodes.json>",
+		Action:    nodesetFilter,
+		Flags:     []cli.Flag{filterNetworkIDFlag, filterForkHashFlag, filterSeenWithinFlag},
+	}
+)
+
+var (
+	filterNetworkIDFlag = cli.Uint64Flag{
+		Name:  "network-id",
+		Usage: "Keep nodes which announced this network ID in the eth handshake",
+	}
+	filterForkHashFlag = cli.StringFlag{
+		Name:  "fork-hash",
+		Usage: "Keep nodes announcing this fork hash (hex) in their record or eth handshake",
+	}
+	filterSeenWithinFlag = cli.DurationFlag{
+		Name:  "seen-within",
+		Usage: "Keep nodes which were live during the given duration",
+	}
+)
+
+// ethEntry is the "eth" entry of node records.
+type ethEntry struct {
+	ForkID forkid.ID
+	Rest   []rlp.RawValue `rlp:"tail"`
+}
+
+func (ethEntry) ENRKey() string { return "eth" }
+
+// nodesetInfo performs nodesetInfoCommand.
+func nodesetInfo(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need nodes file as argument")
+	}
+	var (
+		ns       = loadNodesJSON(ctx.Args().First())
+		live     int
+		shaken   int
+		clients  = make(map[string]int)
+		networks = make(map[string]int)
+	)
+	for _, n := range ns {
+		if time.Since(n.LastSeen) < 24*time.Hour {
+			live++
+		}
+		if n.Client == nil {
+			continue
+		}
+		shaken++
+		clients[clientVersion(n.Client.Name)]++
+		if n.Client.NetworkID != 0 {
+			networks[fmt.Sprint(n.Client.NetworkID)]++
+		}
+	}
+	fmt.Printf("Number of nodes:        %d\n", len(ns))
+	fmt.Printf("Live in the last 24h:   %d\n", live)
+	fmt.Printf("With client info:       %d\n", shaken)
+	if len(clients) > 0 {
+		fmt.Println("\nClients:")
+		printCounts(clients)
+	}
+	if len(networks) > 0 {
+		fmt.Println("\nNetwork IDs:")
+		printCounts(networks)
+	}
+	return nil
+}
+
+// clientVersion strips the platform information from a client name,
+// e.g. "Seth/v1.8.23-stable/linux-amd64/go1.11" becomes "Seth/v1.8.23-stable".
+func clientVersion(name string) string {
+	parts := strings.Split(name, "/")
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	return strings.Join(parts, "/")
+}
+
+// printCounts prints a table of counts, largest first.
+func printCounts(counts map[string]int) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s\t%d\n", k, counts[k])
+	}
+	w.Flush()
+}
+
+// nodesetFilter performs nodesetFilterCommand.
+func nodesetFilter(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need nodes file as argument")
+	}
+	var filters []func(nodeJSON) bool
+	if ctx.IsSet(filterNetworkIDFlag.Name) {
+		id := ctx.Uint64(filterNetworkIDFlag.Name)
+		filters = append(filters, func(n nodeJSON) bool {
+			return n.Client != nil && n.Client.NetworkID == id
+		})
+	}
+	if ctx.IsSet(filterForkHashFlag.Name) {
+		hash, err := hexutil.Decode(ctx.String(filterForkHashFlag.Name))
+		if err != nil || len(hash) != 4 {
+			return fmt.Errorf("invalid fork hash %q", ctx.String(filterForkHashFlag.Name))
+		}
+		filters = append(filters, func(n nodeJSON) bool {
+			return hasForkHash(n, hash)
+		})
+	}
+	if ctx.IsSet(filterSeenWithinFlag.Name) {
+		d := ctx.Duration(filterSeenWithinFlag.Name)
+		filters = append(filters, func(n nodeJSON) bool {
+			return time.Since(n.LastSeen) <= d
+		})
+	}
+
+	ns := loadNodesJSON(ctx.Args().First())
+	result := make(nodeSet)
+	for id, n := range ns {
+		keep := true
+		for _, f := range filters {
+			keep = keep && f(n)
+		}
+		if keep {
+			result[id] = n
+		}
+	}
+	writeNodesJSON("-", result)
+	return nil
+}
+
+// hasForkHash reports whether the node announces the given fork hash, either
+// in its node record or in the eth handshake.
+func hasForkHash(n nodeJSON, hash []byte) bool {
+	if n.Client != nil && bytes.Equal(n.Client.ForkHash, hash) {
+		return true
+	}
+	var entry ethEntry
+	return n.N.Load(&entry) == nil && bytes.Equal(entry.ForkID.Hash[:], hash)
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/severeum/go-severeum/cmd/devp2p/internal/ethtest"
+	"github.com/severeum/go-severeum/internal/utesting"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	rlpxCommand = cli.Command{
+		Name:  "rlpx",
+		Usage: "RLPx Commands",
+		Subcommands: []cli.Command{
+			rlpxEthTestCommand,
+		},
+	}
+	rlpxEthTestCommand = cli.Command{
+		Name:      "eth-test",
+		Usage:     "Runs tests against a node",
+		ArgsUsage: "<node> <chain.rlp> <genesis.json>",
+		Action:    rlpxEthTest,
+	}
+)
+
+// rlpxEthTest performs rlpxEthTestCommand.
+func rlpxEthTest(ctx *cli.Context) error {
+	if ctx.NArg() < 3 {
+		exit("need node, chain file and genesis file as arguments")
+	}
+	n, err := enode.Parse(enode.ValidSchemes, ctx.Args()[0])
+	if err != nil {
+		exit(fmt.Errorf("invalid node: %v", err))
+	}
+	suite, err := ethtest.NewSuite(n, ctx.Args()[1], ctx.Args()[2])
+	if err != nil {
+		exit(err)
+	}
+	results := utesting.RunTests(suite.AllTests(), os.Stdout)
+	if fails := utesting.CountFailures(results); fails > 0 {
+		return fmt.Errorf("%v of %v tests passed", len(results)-fails, len(results))
+	}
+	fmt.Printf("all tests passed\n")
+	return nil
+}
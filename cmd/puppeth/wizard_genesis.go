@@ -30,6 +30,7 @@ import (
 	"time"
 
 	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/consensus/ibft"
 	"github.com/severeum/go-severeum/core"
 	"github.com/severeum/go-severeum/log"
 	"github.com/severeum/go-severeum/params"
@@ -57,6 +58,7 @@ func (w *wizard) makeGenesis() {
 	fmt.Println("Which consensus engine to use? (default = clique)")
 	fmt.Println(" 1. Sevash - proof-of-work")
 	fmt.Println(" 2. Clique - proof-of-authority")
+	fmt.Println(" 3. IBFT   - byzantine fault tolerant proof-of-authority")
 
 	choice := w.read()
 	switch {
@@ -103,6 +105,38 @@ func (w *wizard) makeGenesis() {
 			copy(genesis.ExtraData[32+i*common.AddressLength:], signer[:])
 		}
 
+	case choice == "3":
+		// In the case of IBFT, configure the consensus parameters
+		genesis.Difficulty = big.NewInt(1)
+		genesis.Config.IBFT = &params.IBFTConfig{
+			Period:         5,
+			Epoch:          30000,
+			RequestTimeout: 10000,
+		}
+		fmt.Println()
+		fmt.Println("How many seconds should blocks take? (default = 5)")
+		genesis.Config.IBFT.Period = uint64(w.readDefaultInt(5))
+
+		fmt.Println()
+		fmt.Println("How many milliseconds should validators wait for a round to commit? (default = 10000)")
+		genesis.Config.IBFT.RequestTimeout = uint64(w.readDefaultInt(10000))
+
+		// We also need the initial list of validators
+		fmt.Println()
+		fmt.Println("Which accounts are allowed to validate? (mandatory at least one, tolerates (n-1)/3 faulty)")
+
+		var validators []common.Address
+		for {
+			if address := w.readAddress(); address != nil {
+				validators = append(validators, *address)
+				continue
+			}
+			if len(validators) > 0 {
+				break
+			}
+		}
+		genesis.ExtraData = ibft.GenesisExtra(validators)
+
 	default:
 		log.Crit("Invalid consensus engine choice", "choice", choice)
 	}
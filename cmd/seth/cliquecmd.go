@@ -0,0 +1,230 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of go-severeum.
+//
+// go-severeum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-severeum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-severeum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/severeum/go-severeum/cmd/utils"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/consensus/clique"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/rpc"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	cliqueWindowFlag = cli.Uint64Flag{
+		Name:  "window",
+		Usage: "Number of blocks to inspect for signer activity (0 = default)",
+	}
+
+	cliqueCommand = cli.Command{
+		Name:     "clique",
+		Usage:    "Inspect the proof-of-authority signer voting",
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The clique commands inspect the signer voting of a Clique proof-of-authority
+chain directly from the local database, without running a node. Block
+arguments are interpreted as block numbers or hashes and default to the
+current head.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "signers",
+				Usage:     "List the authorized signers at a block",
+				ArgsUsage: "[<blockHash> | <blockNum>]",
+				Action:    utils.MigrateFlags(cliqueSigners),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.SyncModeFlag,
+				},
+				Description: `
+    seth clique signers [<blockHash> | <blockNum>]
+
+Prints the list of signers authorized to seal the block following the
+specified one.`,
+			},
+			{
+				Name:      "proposals",
+				Usage:     "List the pending proposals of the local signer",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(cliqueProposals),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.SyncModeFlag,
+				},
+				Description: `
+    seth clique proposals
+
+Prints the authorization proposals the local signer is pushing through, as
+persisted by clique.propose and clique.discard.`,
+			},
+			{
+				Name:      "votes",
+				Usage:     "List the votes cast and their tally at a block",
+				ArgsUsage: "[<blockHash> | <blockNum>]",
+				Action:    utils.MigrateFlags(cliqueVotes),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.SyncModeFlag,
+				},
+				Description: `
+    seth clique votes [<blockHash> | <blockNum>]
+
+Prints the votes cast since the last epoch checkpoint and the current tally
+of every account being voted on.`,
+			},
+			{
+				Name:      "activity",
+				Usage:     "Report the sealing activity of the signers",
+				ArgsUsage: "[<blockHash> | <blockNum>]",
+				Action:    utils.MigrateFlags(cliqueActivity),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.SyncModeFlag,
+					cliqueWindowFlag,
+				},
+				Description: `
+    seth clique activity [--window <blocks>] [<blockHash> | <blockNum>]
+
+Prints, for every signer, the number of blocks sealed in-turn and out-of-turn,
+the last block sealed and the number of in-turn slots missed over a window of
+blocks ending at the specified one.`,
+			},
+		},
+	}
+)
+
+// makeCliqueAPI opens the local chain and returns the clique API operating on
+// it, along with the header specified by the first command argument (or the
+// current head if none was given). The returned function stops the chain and
+// closes its database once the caller is done.
+func makeCliqueAPI(ctx *cli.Context) (*clique.API, *types.Header, func()) {
+	// Validate the block argument before opening the database
+	var (
+		arg  = ctx.Args().First()
+		hash common.Hash
+		num  uint64
+		err  error
+	)
+	if arg != "" {
+		if hashish(arg) {
+			err = hash.UnmarshalText([]byte(arg))
+		} else {
+			num, err = strconv.ParseUint(arg, 10, 64)
+		}
+		if err != nil {
+			utils.Fatalf("Invalid block hash or number %q: %v", arg, err)
+		}
+	}
+	stack := makeFullNode(ctx)
+	chain, chainDb := utils.MakeChain(ctx, stack)
+	closer := func() {
+		chain.Stop()
+		chainDb.Close()
+	}
+	engine, ok := chain.Engine().(*clique.Clique)
+	if !ok {
+		closer()
+		utils.Fatalf("Chain is not using clique consensus")
+	}
+	header := chain.CurrentHeader()
+	switch {
+	case arg == "":
+	case hashish(arg):
+		header = chain.GetHeaderByHash(hash)
+	default:
+		header = chain.GetHeaderByNumber(num)
+	}
+	if header == nil {
+		closer()
+		utils.Fatalf("Block not found")
+	}
+	return engine.APIs(chain)[0].Service.(*clique.API), header, closer
+}
+
+// printJSON dumps an arbitrary clique report to the standard output.
+func printJSON(v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		utils.Fatalf("Failed to encode report: %v", err)
+	}
+	fmt.Println(string(out))
+}
+
+// cliqueSigners prints the authorized signers at a specific block.
+func cliqueSigners(ctx *cli.Context) error {
+	api, header, closer := makeCliqueAPI(ctx)
+	defer closer()
+
+	signers, err := api.GetSignersAtHash(header.Hash())
+	if err != nil {
+		utils.Fatalf("Failed to retrieve signers: %v", err)
+	}
+	printJSON(signers)
+	return nil
+}
+
+// cliqueProposals prints the proposals persisted by the local signer.
+func cliqueProposals(ctx *cli.Context) error {
+	api, _, closer := makeCliqueAPI(ctx)
+	defer closer()
+
+	printJSON(api.Proposals())
+	return nil
+}
+
+// cliqueVotes prints the votes and tallies at a specific block.
+func cliqueVotes(ctx *cli.Context) error {
+	api, header, closer := makeCliqueAPI(ctx)
+	defer closer()
+
+	snap, err := api.GetSnapshotAtHash(header.Hash())
+	if err != nil {
+		utils.Fatalf("Failed to retrieve voting snapshot: %v", err)
+	}
+	printJSON(map[string]interface{}{
+		"number": snap.Number,
+		"hash":   snap.Hash,
+		"votes":  snap.Votes,
+		"tally":  snap.Tally,
+	})
+	return nil
+}
+
+// cliqueActivity prints the sealing activity of the signers up to a specific block.
+func cliqueActivity(ctx *cli.Context) error {
+	api, header, closer := makeCliqueAPI(ctx)
+	defer closer()
+
+	var (
+		number = rpc.BlockNumber(header.Number.Int64())
+		window = ctx.Uint64(cliqueWindowFlag.Name)
+	)
+	activity, err := api.GetActivity(&number, &window)
+	if err != nil {
+		utils.Fatalf("Failed to retrieve signer activity: %v", err)
+	}
+	printJSON(activity)
+	return nil
+}
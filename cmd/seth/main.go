@@ -35,6 +35,7 @@ import (
 	"github.com/severeum/go-severeum/eth"
 	"github.com/severeum/go-severeum/ethclient"
 	"github.com/severeum/go-severeum/internal/debug"
+	"github.com/severeum/go-severeum/les"
 	"github.com/severeum/go-severeum/log"
 	"github.com/severeum/go-severeum/metrics"
 	"github.com/severeum/go-severeum/node"
@@ -71,6 +72,7 @@ var (
 		utils.SevashDatasetDirFlag,
 		utils.SevashDatasetsInMemoryFlag,
 		utils.SevashDatasetsOnDiskFlag,
+		utils.CliqueMissedTurnsFlag,
 		utils.TxPoolLocalsFlag,
 		utils.TxPoolNoLocalsFlag,
 		utils.TxPoolJournalFlag,
@@ -84,10 +86,13 @@ var (
 		utils.TxPoolLifetimeFlag,
 		utils.SyncModeFlag,
 		utils.GCModeFlag,
+		utils.HistoryRetentionFlag,
+		utils.TxLookupLimitFlag,
 		utils.LightServFlag,
 		utils.LightPeersFlag,
 		utils.LightKDFFlag,
 		utils.WhitelistFlag,
+		utils.CheckpointFlag,
 		utils.CacheFlag,
 		utils.CacheDatabaseFlag,
 		utils.CacheTrieFlag,
@@ -114,11 +119,16 @@ var (
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
 		utils.DiscoveryV5Flag,
+		utils.NoiseFlag,
+		utils.MuxFlag,
+		utils.DNSDiscoveryFlag,
 		utils.NetrestrictFlag,
 		utils.NodeKeyFileFlag,
 		utils.NodeKeyHexFlag,
 		utils.DeveloperFlag,
 		utils.DeveloperPeriodFlag,
+		utils.DeveloperPoWFlag,
+		utils.DeveloperDifficultyFlag,
 		utils.TestnetFlag,
 		utils.RinkebyFlag,
 		utils.VMEnableDebugFlag,
@@ -183,6 +193,8 @@ func init() {
 		copydbCommand,
 		removedbCommand,
 		dumpCommand,
+		// See cliquecmd.go:
+		cliqueCommand,
 		// See monitorcmd.go:
 		monitorCommand,
 		// See accountcmd.go:
@@ -289,6 +301,15 @@ func startNode(ctx *cli.Context, stack *node.Node) {
 			unlockAccount(ctx, ks, trimmed, i, passwords)
 		}
 	}
+	// Set the contract backend of the checkpoint oracle if running as a light client
+	var lesService *les.LightSevereum
+	if err := stack.Service(&lesService); err == nil {
+		rpcClient, err := stack.Attach()
+		if err != nil {
+			utils.Fatalf("Failed to attach to self: %v", err)
+		}
+		lesService.SetContractBackend(ethclient.NewClient(rpcClient))
+	}
 	// Register wallet event handlers to open and auto-derive wallets
 	events := make(chan accounts.WalletEvent, 16)
 	stack.AccountManager().Subscribe(events)
@@ -76,12 +76,15 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.RinkebyFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
+			utils.HistoryRetentionFlag,
+			utils.TxLookupLimitFlag,
 			utils.SevStatsURLFlag,
 			utils.IdentityFlag,
 			utils.LightServFlag,
 			utils.LightPeersFlag,
 			utils.LightKDFFlag,
 			utils.WhitelistFlag,
+			utils.CheckpointFlag,
 		},
 	},
 	{
@@ -89,6 +92,8 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			utils.DeveloperFlag,
 			utils.DeveloperPeriodFlag,
+			utils.DeveloperPoWFlag,
+			utils.DeveloperDifficultyFlag,
 		},
 	},
 	{
@@ -102,6 +107,12 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.SevashDatasetsOnDiskFlag,
 		},
 	},
+	{
+		Name: "CLIQUE",
+		Flags: []cli.Flag{
+			utils.CliqueMissedTurnsFlag,
+		},
+	},
 	//{
 	//	Name: "DASHBOARD",
 	//	Flags: []cli.Flag{
@@ -178,6 +189,9 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.NATFlag,
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
+			utils.NoiseFlag,
+			utils.MuxFlag,
+			utils.DNSDiscoveryFlag,
 			utils.NetrestrictFlag,
 			utils.NodeKeyFileFlag,
 			utils.NodeKeyHexFlag,
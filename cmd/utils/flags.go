@@ -35,6 +35,7 @@ import (
 	"github.com/severeum/go-severeum/consensus"
 	"github.com/severeum/go-severeum/consensus/clique"
 	"github.com/severeum/go-severeum/consensus/ethash"
+	"github.com/severeum/go-severeum/consensus/ibft"
 	"github.com/severeum/go-severeum/core"
 	"github.com/severeum/go-severeum/core/state"
 	"github.com/severeum/go-severeum/core/vm"
@@ -152,6 +153,15 @@ var (
 		Name:  "dev.period",
 		Usage: "Block period to use in developer mode (0 = mine only if transaction pending)",
 	}
+	DeveloperPoWFlag = cli.BoolFlag{
+		Name:  "dev.pow",
+		Usage: "Use an instantly sealing proof-of-work engine in developer mode instead of proof-of-authority",
+	}
+	DeveloperDifficultyFlag = cli.Uint64Flag{
+		Name:  "dev.difficulty",
+		Usage: "Fixed fake block difficulty to use in proof-of-work developer mode",
+		Value: 1,
+	}
 	IdentityFlag = cli.StringFlag{
 		Name:  "identity",
 		Usage: "Custom node name",
@@ -164,7 +174,7 @@ var (
 	defaultSyncMode = eth.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
-		Usage: `Blockchain sync mode ("fast", "full", or "light")`,
+		Usage: `Blockchain sync mode ("fast", "full", "light" or "snap")`,
 		Value: &defaultSyncMode,
 	}
 	GCModeFlag = cli.StringFlag{
@@ -172,6 +182,14 @@ var (
 		Usage: `Blockchain garbage collection mode ("full", "archive")`,
 		Value: "full",
 	}
+	HistoryRetentionFlag = cli.Uint64Flag{
+		Name:  "history.retention",
+		Usage: "Number of recent blocks to keep bodies and receipts for (0 = entire chain)",
+	}
+	TxLookupLimitFlag = cli.Uint64Flag{
+		Name:  "txlookuplimit",
+		Usage: "Number of recent blocks to maintain the transaction index for (0 = entire chain)",
+	}
 	LightServFlag = cli.IntFlag{
 		Name:  "lightserv",
 		Usage: "Maximum percentage of time allowed for serving LES requests (0-90)",
@@ -190,6 +208,10 @@ var (
 		Name:  "whitelist",
 		Usage: "Comma separated block number-to-hash mappings to enforce (<number>=<hash>)",
 	}
+	CheckpointFlag = cli.StringFlag{
+		Name:  "checkpoint",
+		Usage: "Trusted block to start fast sync from, enforced on peers in all modes (<number>=<hash>:<td>)",
+	}
 	// Dashboard settings
 	DashboardEnabledFlag = cli.BoolFlag{
 		Name:  metrics.DashboardEnabledFlag,
@@ -240,6 +262,12 @@ var (
 		Usage: "Number of recent ethash mining DAGs to keep on disk (1+GB each)",
 		Value: eth.DefaultConfig.Sevash.DatasetsOnDisk,
 	}
+	// Clique settings
+	CliqueMissedTurnsFlag = cli.Uint64Flag{
+		Name:  "clique.missedturns",
+		Usage: "Number of consecutive turns a signer may miss before warning about it (0 = disabled)",
+		Value: eth.DefaultConfig.CliqueMissedTurns,
+	}
 	// Transaction pool settings
 	TxPoolLocalsFlag = cli.StringFlag{
 		Name:  "txpool.locals",
@@ -532,7 +560,7 @@ var (
 	}
 	NATFlag = cli.StringFlag{
 		Name:  "nat",
-		Usage: "NAT port mapping mechanism (any|none|upnp|pmp|extip:<IP>)",
+		Usage: "NAT port mapping mechanism (any|none|upnp|pmp|pcp|extip:<IP>)",
 		Value: "any",
 	}
 	NoDiscoverFlag = cli.BoolFlag{
@@ -543,6 +571,18 @@ var (
 		Name:  "v5disc",
 		Usage: "Enables the experimental RLPx V5 (Topic Discovery) mechanism",
 	}
+	DNSDiscoveryFlag = cli.StringFlag{
+		Name:  "discovery.dns",
+		Usage: "Comma separated enrtree:// URLs of DNS node lists used as additional peer sources",
+	}
+	NoiseFlag = cli.BoolFlag{
+		Name:  "noise",
+		Usage: "Enables the experimental Noise transport for peers which advertise it (RLPx remains the default)",
+	}
+	MuxFlag = cli.BoolFlag{
+		Name:  "mux",
+		Usage: "Enables multiplexed streams for peers which support them (one stream per protocol)",
+	}
 	NetrestrictFlag = cli.StringFlag{
 		Name:  "netrestrict",
 		Usage: "Restricts network communication to the given IP networks (CIDR masks)",
@@ -956,6 +996,16 @@ func SetP2PConfig(ctx *cli.Context, cfg *p2p.Config) {
 		cfg.DiscoveryV5 = true
 	}
 
+	if urls := ctx.GlobalString(DNSDiscoveryFlag.Name); urls != "" {
+		cfg.DiscoveryDNS = splitAndTrim(urls)
+	}
+	if ctx.GlobalIsSet(NoiseFlag.Name) {
+		cfg.EnableNoise = ctx.GlobalBool(NoiseFlag.Name)
+	}
+	if ctx.GlobalIsSet(MuxFlag.Name) {
+		cfg.EnableMux = ctx.GlobalBool(MuxFlag.Name)
+	}
+
 	if netrestrict := ctx.GlobalString(NetrestrictFlag.Name); netrestrict != "" {
 		list, err := netutil.ParseNetlist(netrestrict)
 		if err != nil {
@@ -1103,6 +1153,34 @@ func setWhitelist(ctx *cli.Context, cfg *eth.Config) {
 	}
 }
 
+func setCheckpoint(ctx *cli.Context, cfg *eth.Config) {
+	checkpoint := ctx.GlobalString(CheckpointFlag.Name)
+	if checkpoint == "" {
+		return
+	}
+	parts := strings.Split(checkpoint, "=")
+	if len(parts) != 2 {
+		Fatalf("Invalid checkpoint: %s", checkpoint)
+	}
+	number, err := strconv.ParseUint(parts[0], 0, 64)
+	if err != nil {
+		Fatalf("Invalid checkpoint block number %s: %v", parts[0], err)
+	}
+	parts = strings.Split(parts[1], ":")
+	if len(parts) != 2 {
+		Fatalf("Invalid checkpoint: %s", checkpoint)
+	}
+	var hash common.Hash
+	if err = hash.UnmarshalText([]byte(parts[0])); err != nil {
+		Fatalf("Invalid checkpoint hash %s: %v", parts[0], err)
+	}
+	td, ok := new(big.Int).SetString(parts[1], 0)
+	if !ok || td.Sign() <= 0 {
+		Fatalf("Invalid checkpoint total difficulty %s", parts[1])
+	}
+	cfg.Checkpoint = &downloader.Checkpoint{Number: number, Hash: hash, Td: td}
+}
+
 // checkExclusive verifies that only a single instance of the provided flags was
 // set by the user. Each flag might optionally be followed by a string type to
 // specialize it further.
@@ -1169,6 +1247,11 @@ func SetSevConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	setTxPool(ctx, &cfg.TxPool)
 	setSevash(ctx, cfg)
 	setWhitelist(ctx, cfg)
+	setCheckpoint(ctx, cfg)
+
+	if ctx.GlobalIsSet(CliqueMissedTurnsFlag.Name) {
+		cfg.CliqueMissedTurns = ctx.GlobalUint64(CliqueMissedTurnsFlag.Name)
+	}
 
 	if ctx.GlobalIsSet(SyncModeFlag.Name) {
 		cfg.SyncMode = *GlobalTextMarshaler(ctx, SyncModeFlag.Name).(*downloader.SyncMode)
@@ -1192,6 +1275,13 @@ func SetSevConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	}
 	cfg.NoPruning = ctx.GlobalString(GCModeFlag.Name) == "archive"
 
+	if ctx.GlobalIsSet(HistoryRetentionFlag.Name) {
+		cfg.HistoryRetention = ctx.GlobalUint64(HistoryRetentionFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxLookupLimitFlag.Name) {
+		cfg.TxLookupLimit = ctx.GlobalUint64(TxLookupLimitFlag.Name)
+	}
+
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
 	}
@@ -1278,7 +1368,18 @@ func SetSevConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 		}
 		log.Info("Using developer account", "address", developer.Address)
 
-		cfg.Genesis = core.DeveloperGenesisBlock(uint64(ctx.GlobalInt(DeveloperPeriodFlag.Name)), developer.Address)
+		cfg.Developer = true
+		period := uint64(ctx.GlobalInt(DeveloperPeriodFlag.Name))
+		if ctx.GlobalBool(DeveloperPoWFlag.Name) {
+			difficulty := ctx.GlobalUint64(DeveloperDifficultyFlag.Name)
+
+			cfg.Genesis = core.DeveloperSevashGenesisBlock(difficulty, developer.Address)
+			cfg.Sevash.PowMode = ethash.ModeDev
+			cfg.Sevash.DevPeriod = period
+			cfg.Sevash.DevDifficulty = difficulty
+		} else {
+			cfg.Genesis = core.DeveloperGenesisBlock(period, developer.Address)
+		}
 		if !ctx.GlobalIsSet(MinerGasPriceFlag.Name) && !ctx.GlobalIsSet(MinerLegacyGasPriceFlag.Name) {
 			cfg.MinerGasPrice = big.NewInt(1)
 		}
@@ -1413,6 +1514,8 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 	var engine consensus.Engine
 	if config.Clique != nil {
 		engine = clique.New(config.Clique, chainDb)
+	} else if config.IBFT != nil {
+		engine = ibft.New(config.IBFT, chainDb)
 	} else {
 		engine = ethash.NewFaker()
 		if !ctx.GlobalBool(FakePoWFlag.Name) {
@@ -0,0 +1,141 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/consensus"
+	"github.com/severeum/go-severeum/core/types"
+)
+
+const (
+	defaultActivityWindow = 128  // Number of blocks to inspect if no explicit window is requested
+	maxActivityWindow     = 1024 // Maximum number of blocks an activity report may span
+)
+
+// SignerActivity is the sealing record of a single authorized signer over a
+// range of recent blocks.
+type SignerActivity struct {
+	LastSealed  uint64 `json:"lastSealed"`  // Number of the last block sealed by the signer (0 if none in range)
+	Sealed      int    `json:"sealed"`      // Number of blocks sealed by the signer in the range
+	InTurn      int    `json:"inturn"`      // Number of blocks sealed while being the in-turn signer
+	OutOfTurn   int    `json:"noturn"`      // Number of blocks sealed while not being the in-turn signer
+	Missed      int    `json:"missed"`      // Number of in-turn slots that were sealed by someone else
+	MissedInRow int    `json:"missedInRow"` // Number of in-turn slots missed since the last in-turn seal
+}
+
+// Activity is the sealing record of all the authorized signers over a range of
+// recent blocks.
+type Activity struct {
+	From    uint64                             `json:"from"`    // First block included in the report
+	To      uint64                             `json:"to"`      // Last block included in the report
+	Signers map[common.Address]*SignerActivity `json:"signers"` // Sealing record of every signer
+}
+
+// turn is the record of who sealed a single block and whose turn it was to seal.
+type turn struct {
+	number uint64         // Number of the sealed block
+	sealer common.Address // Signer who sealed the block
+	inturn common.Address // Signer whose turn it was to seal the block
+}
+
+// summarize aggregates a list of consecutive turns (ascending order) into an
+// activity report. All the given signers are included, even if idle.
+func summarize(turns []turn, signers []common.Address) *Activity {
+	report := &Activity{
+		Signers: make(map[common.Address]*SignerActivity),
+	}
+	if len(turns) > 0 {
+		report.From, report.To = turns[0].number, turns[len(turns)-1].number
+	}
+	record := func(signer common.Address) *SignerActivity {
+		if _, ok := report.Signers[signer]; !ok {
+			report.Signers[signer] = new(SignerActivity)
+		}
+		return report.Signers[signer]
+	}
+	for _, signer := range signers {
+		record(signer)
+	}
+	for _, turn := range turns {
+		// Account the sealed block to the signer who created it
+		sealer := record(turn.sealer)
+		sealer.LastSealed = turn.number
+		sealer.Sealed++
+
+		// Account the in-turn slot to the signer who should have sealed it
+		if turn.sealer == turn.inturn {
+			sealer.InTurn++
+			sealer.MissedInRow = 0
+		} else {
+			sealer.OutOfTurn++
+
+			inturn := record(turn.inturn)
+			inturn.Missed++
+			inturn.MissedInRow++
+		}
+	}
+	return report
+}
+
+// activity gathers the sealing record of all the signers over the window of
+// blocks ending with the given header (inclusive).
+func (c *Clique) activity(chain consensus.ChainReader, header *types.Header, window uint64) (*Activity, error) {
+	// Sanitize the requested window and gather the headers to inspect
+	if window == 0 {
+		window = defaultActivityWindow
+	}
+	if window > maxActivityWindow {
+		window = maxActivityWindow
+	}
+	number := header.Number.Uint64()
+	if window > number {
+		window = number
+	}
+	headers := make([]*types.Header, window)
+	for i := len(headers) - 1; i >= 0; i-- {
+		headers[i] = header
+		if header = chain.GetHeader(header.ParentHash, header.Number.Uint64()-1); header == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+	}
+	// Retrieve the snapshot preceding the window and replay the headers on top
+	snap, err := c.snapshot(chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	turns := make([]turn, 0, len(headers))
+	for _, header := range headers {
+		signer, err := ecrecover(header, c.signatures)
+		if err != nil {
+			return nil, err
+		}
+		turns = append(turns, turn{
+			number: header.Number.Uint64(),
+			sealer: signer,
+			inturn: snap.inturnSigner(header.Number.Uint64()),
+		})
+		if snap, err = snap.apply([]*types.Header{header}); err != nil {
+			return nil, err
+		}
+	}
+	report := summarize(turns, snap.signers())
+	if len(turns) == 0 {
+		report.From, report.To = number, number
+	}
+	return report, nil
+}
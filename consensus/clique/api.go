@@ -101,19 +101,48 @@ func (api *API) Proposals() map[common.Address]bool {
 }
 
 // Propose injects a new authorization proposal that the signer will attempt to
-// push through.
-func (api *API) Propose(address common.Address, auth bool) {
+// push through. The proposal is persisted to survive restarts.
+func (api *API) Propose(address common.Address, auth bool) error {
 	api.clique.lock.Lock()
 	defer api.clique.lock.Unlock()
 
 	api.clique.proposals[address] = auth
+	return storeProposals(api.clique.db, api.clique.proposals)
 }
 
 // Discard drops a currently running proposal, stopping the signer from casting
 // further votes (either for or against).
-func (api *API) Discard(address common.Address) {
+func (api *API) Discard(address common.Address) error {
 	api.clique.lock.Lock()
 	defer api.clique.lock.Unlock()
 
 	delete(api.clique.proposals, address)
+	return storeProposals(api.clique.db, api.clique.proposals)
+}
+
+// GetActivity retrieves the sealing record of all the authorized signers over a
+// window of blocks ending at the specified block (or current if none requested).
+func (api *API) GetActivity(number *rpc.BlockNumber, window *uint64) (*Activity, error) {
+	// Retrieve the requested block number (or current if none requested)
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	// Ensure we have an actually valid block and gather the activity up to it
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	var blocks uint64
+	if window != nil {
+		blocks = *window
+	}
+	return api.clique.activity(api.chain, header, blocks)
+}
+
+// Status retrieves the sealing record of all the authorized signers over the
+// window of recently verified and sealed blocks tracked by the local node.
+func (api *API) Status() *Activity {
+	return api.clique.monitor.status()
 }
@@ -19,6 +19,7 @@ package clique
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"math/big"
 	"math/rand"
@@ -50,6 +51,10 @@ const (
 	wiggleTime = 500 * time.Millisecond // Random delay (per signer) to allow concurrent signers
 )
 
+// proposalsKey is the database key under which the local vote proposals are
+// persisted across restarts.
+var proposalsKey = []byte("clique-proposals")
+
 // Clique proof-of-authority protocol constants.
 var (
 	epochLength = uint64(30000) // Default number of blocks after which to checkpoint and reset the pending votes
@@ -134,8 +139,21 @@ var (
 	// errRecentlySigned is returned if a header is signed by an authorized entity
 	// that already signed a header recently, thus is temporarily not allowed to.
 	errRecentlySigned = errors.New("recently signed")
+
+	// errNoPendingSeal is returned if a block is requested to be sealed on demand,
+	// but there is no matching sealing task pending.
+	errNoPendingSeal = errors.New("no pending block to seal")
 )
 
+// sealTask is a block sealing request retained by the engine to allow sealing it
+// on demand, without waiting for its scheduled slot.
+type sealTask struct {
+	chain   consensus.ChainReader
+	block   *types.Block
+	results chan<- *types.Block
+	stop    <-chan struct{}
+}
+
 // SignerFn is a signer callback function to request a hash to be signed by a
 // backing account.
 type SignerFn func(accounts.Account, []byte) ([]byte, error)
@@ -207,6 +225,11 @@ type Clique struct {
 
 	proposals map[common.Address]bool // Current list of proposals we are pushing
 
+	monitor *monitor // Signer activity tracker to detect inactive signers
+
+	pending *sealTask     // Latest sealing task, retained to be sealed on demand
+	offset  time.Duration // Clock offset to timestamp and schedule blocks with (developer chains)
+
 	signer common.Address // Severeum address of the signing key
 	signFn SignerFn       // Signer function to authorize hashes with
 	lock   sync.RWMutex   // Protects the signer fields
@@ -227,15 +250,58 @@ func New(config *params.CliqueConfig, db ethdb.Database) *Clique {
 	recents, _ := lru.NewARC(inmemorySnapshots)
 	signatures, _ := lru.NewARC(inmemorySignatures)
 
+	// Reload any proposals the signer was pushing before a restart
+	proposals, err := loadProposals(db)
+	if err != nil {
+		log.Warn("Failed to load clique proposals", "err", err)
+		proposals = make(map[common.Address]bool)
+	}
+	if len(proposals) > 0 {
+		log.Info("Loaded clique proposals from disk", "count", len(proposals))
+	}
 	return &Clique{
 		config:     &conf,
 		db:         db,
 		recents:    recents,
 		signatures: signatures,
-		proposals:  make(map[common.Address]bool),
+		proposals:  proposals,
+		monitor:    newMonitor(),
 	}
 }
 
+// loadProposals retrieves the set of vote proposals persisted into the database
+// by a previous run. A missing entry is not an error, it simply means there was
+// nothing being voted on.
+func loadProposals(db ethdb.Database) (map[common.Address]bool, error) {
+	proposals := make(map[common.Address]bool)
+	if db == nil {
+		return proposals, nil
+	}
+	if has, err := db.Has(proposalsKey); err != nil || !has {
+		return proposals, err
+	}
+	blob, err := db.Get(proposalsKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(blob, &proposals); err != nil {
+		return nil, err
+	}
+	return proposals, nil
+}
+
+// storeProposals persists the current set of vote proposals into the database.
+func storeProposals(db ethdb.Database, proposals map[common.Address]bool) error {
+	if db == nil {
+		return nil
+	}
+	blob, err := json.Marshal(proposals)
+	if err != nil {
+		return err
+	}
+	return db.Put(proposalsKey, blob)
+}
+
 // Author implements consensus.Engine, returning the Severeum address recovered
 // from the signature in the header's extra-data section.
 func (c *Clique) Author(header *types.Header) (common.Address, error) {
@@ -279,7 +345,7 @@ func (c *Clique) verifyHeader(chain consensus.ChainReader, header *types.Header,
 	number := header.Number.Uint64()
 
 	// Don't waste time checking blocks from the future
-	if header.Time.Cmp(big.NewInt(time.Now().Unix())) > 0 {
+	if header.Time.Cmp(big.NewInt(c.now().Unix())) > 0 {
 		return consensus.ErrFutureBlock
 	}
 	// Checkpoint blocks need to enforce zero beneficiary
@@ -509,6 +575,7 @@ func (c *Clique) verifySeal(chain consensus.ChainReader, header *types.Header, p
 			return errWrongDifficulty
 		}
 	}
+	c.monitor.record(header, signer, snap)
 	return nil
 }
 
@@ -571,8 +638,8 @@ func (c *Clique) Prepare(chain consensus.ChainReader, header *types.Header) erro
 		return consensus.ErrUnknownAncestor
 	}
 	header.Time = new(big.Int).Add(parent.Time, new(big.Int).SetUint64(c.config.Period))
-	if header.Time.Int64() < time.Now().Unix() {
-		header.Time = big.NewInt(time.Now().Unix())
+	if now := c.now().Unix(); header.Time.Int64() < now {
+		header.Time = big.NewInt(now)
 	}
 	return nil
 }
@@ -598,20 +665,76 @@ func (c *Clique) Authorize(signer common.Address, signFn SignerFn) {
 	c.signFn = signFn
 }
 
+// SetMissedTurnsLimit sets the number of consecutive in-turn slots a signer may
+// miss before a warning is logged about it being inactive (0 = never warn).
+func (c *Clique) SetMissedTurnsLimit(limit uint64) {
+	c.monitor.setLimit(limit)
+}
+
+// SetTimeOffset shifts the clock used to timestamp and schedule new blocks by
+// the given offset. It is meant to allow time travel on developer chains.
+func (c *Clique) SetTimeOffset(offset time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.offset = offset
+}
+
+// now returns the current time, shifted by any configured clock offset.
+func (c *Clique) now() time.Time {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return time.Now().Add(c.offset)
+}
+
 // Seal implements consensus.Engine, attempting to create a sealed block using
 // the local signing credentials.
 func (c *Clique) Seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	// Retain the task in case someone wants to seal it on demand
+	c.lock.Lock()
+	c.pending = &sealTask{chain: chain, block: block, results: results, stop: stop}
+	c.lock.Unlock()
+
+	_, err := c.seal(chain, block, results, stop, false)
+	return err
+}
+
+// SealPending forcefully seals the latest sealing task, even if it contains no
+// transactions or its scheduled slot did not arrive yet. The task must build on
+// top of the given parent and must not be timestamped before the given time.
+// The hash of the sealed block is returned.
+func (c *Clique) SealPending(parent common.Hash, timestamp uint64) (common.Hash, error) {
+	c.lock.RLock()
+	task := c.pending
+	c.lock.RUnlock()
+
+	if task == nil || task.block.ParentHash() != parent || task.block.Time().Uint64() < timestamp {
+		return common.Hash{}, errNoPendingSeal
+	}
+	select {
+	case <-task.stop:
+		return common.Hash{}, errNoPendingSeal
+	default:
+	}
+	return c.seal(task.chain, task.block, task.results, task.stop, true)
+}
+
+// seal attempts to create a sealed block using the local signing credentials,
+// delivering it asynchronously when the signer's slot arrives. If force is set,
+// empty blocks are sealed too and the block is delivered without delay.
+func (c *Clique) seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}, force bool) (common.Hash, error) {
 	header := block.Header()
 
 	// Sealing the genesis block is not supported
 	number := header.Number.Uint64()
 	if number == 0 {
-		return errUnknownBlock
+		return common.Hash{}, errUnknownBlock
 	}
 	// For 0-period chains, refuse to seal empty blocks (no reward but would spin sealing)
-	if c.config.Period == 0 && len(block.Transactions()) == 0 {
+	if !force && c.config.Period == 0 && len(block.Transactions()) == 0 {
 		log.Info("Sealing paused, waiting for transactions")
-		return nil
+		return common.Hash{}, nil
 	}
 	// Don't hold the signer fields for the entire sealing procedure
 	c.lock.RLock()
@@ -621,10 +744,10 @@ func (c *Clique) Seal(chain consensus.ChainReader, block *types.Block, results c
 	// Bail out if we're unauthorized to sign a block
 	snap, err := c.snapshot(chain, number-1, header.ParentHash, nil)
 	if err != nil {
-		return err
+		return common.Hash{}, err
 	}
 	if _, authorized := snap.Signers[signer]; !authorized {
-		return errUnauthorizedSigner
+		return common.Hash{}, errUnauthorizedSigner
 	}
 	// If we're amongst the recent signers, wait for the next block
 	for seen, recent := range snap.Recents {
@@ -632,13 +755,15 @@ func (c *Clique) Seal(chain consensus.ChainReader, block *types.Block, results c
 			// Signer is among recents, only wait if the current block doesn't shift it out
 			if limit := uint64(len(snap.Signers)/2 + 1); number < limit || seen > number-limit {
 				log.Info("Signed recently, must wait for others")
-				return nil
+				return common.Hash{}, nil
 			}
 		}
 	}
 	// Sweet, the protocol permits us to sign the block, wait for our time
-	delay := time.Unix(header.Time.Int64(), 0).Sub(time.Now()) // nolint: gosimple
-	if header.Difficulty.Cmp(diffNoTurn) == 0 {
+	delay := time.Unix(header.Time.Int64(), 0).Sub(c.now()) // nolint: gosimple
+	if force {
+		delay = 0
+	} else if header.Difficulty.Cmp(diffNoTurn) == 0 {
 		// It's not our turn explicitly to sign, delay it a bit
 		wiggle := time.Duration(len(snap.Signers)/2+1) * wiggleTime
 		delay += time.Duration(rand.Int63n(int64(wiggle)))
@@ -648,9 +773,11 @@ func (c *Clique) Seal(chain consensus.ChainReader, block *types.Block, results c
 	// Sign all the things!
 	sighash, err := signFn(accounts.Account{Address: signer}, sigHash(header).Bytes())
 	if err != nil {
-		return err
+		return common.Hash{}, err
 	}
 	copy(header.Extra[len(header.Extra)-extraSeal:], sighash)
+	sealed := block.WithSeal(header)
+
 	// Wait until sealing is terminated or delay timeout.
 	log.Trace("Waiting for slot to sign and propagate", "delay", common.PrettyDuration(delay))
 	go func() {
@@ -661,13 +788,14 @@ func (c *Clique) Seal(chain consensus.ChainReader, block *types.Block, results c
 		}
 
 		select {
-		case results <- block.WithSeal(header):
+		case results <- sealed:
+			c.monitor.record(header, signer, snap)
 		default:
 			log.Warn("Sealing result is not read by miner", "sealhash", c.SealHash(header))
 		}
 	}()
 
-	return nil
+	return sealed.Hash(), nil
 }
 
 // CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
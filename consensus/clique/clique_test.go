@@ -0,0 +1,281 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"math/big"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/accounts"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/core/vm"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/metrics"
+	"github.com/severeum/go-severeum/params"
+)
+
+// Tests that the proposals pushed by the local signer survive an engine restart.
+func TestProposalPersistence(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	config := &params.CliqueConfig{Period: 1, Epoch: 30000}
+
+	api := &API{clique: New(config, db)}
+	if err := api.Propose(common.Address{0x01}, true); err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	if err := api.Propose(common.Address{0x02}, false); err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	if err := api.Propose(common.Address{0x03}, true); err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	if err := api.Discard(common.Address{0x03}); err != nil {
+		t.Fatalf("failed to discard: %v", err)
+	}
+	// Recreate the engine on top of the same database and check the proposals
+	api = &API{clique: New(config, db)}
+
+	want := map[common.Address]bool{{0x01}: true, {0x02}: false}
+	if have := api.Proposals(); !reflect.DeepEqual(have, want) {
+		t.Errorf("proposals mismatch: have %v, want %v", have, want)
+	}
+}
+
+// Tests that the signer activity report correctly accounts in-turn, out-of-turn
+// and missed slots.
+func TestSignerActivity(t *testing.T) {
+	// Create three signers and order them the same way clique does
+	accounts := newTesterAccountPool()
+
+	names := []string{"A", "B", "C"}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := accounts.address(names[i]), accounts.address(names[j])
+		return signersAscending{a, b}.Less(0, 1)
+	})
+	genesis := &core.Genesis{
+		ExtraData: make([]byte, extraVanity+common.AddressLength*len(names)+extraSeal),
+	}
+	accounts.checkpoint(&types.Header{Extra: genesis.ExtraData}, names)
+
+	db := ethdb.NewMemDatabase()
+	genesis.Commit(db)
+
+	config := *params.TestChainConfig
+	config.Clique = &params.CliqueConfig{Period: 1, Epoch: 30000}
+	engine := New(config.Clique, db)
+	engine.fakeDiff = true
+
+	// Seal the blocks: #1 in-turn, #2 and #3 out-of-turn, #4 in-turn again
+	sealers := []string{names[1], names[0], names[2], names[1]}
+
+	blocks, _ := core.GenerateChain(&config, genesis.ToBlock(db), engine, db, len(sealers), nil)
+	for i, block := range blocks {
+		header := block.Header()
+		if i > 0 {
+			header.ParentHash = blocks[i-1].Hash()
+		}
+		header.Extra = make([]byte, extraVanity+extraSeal)
+		header.Difficulty = diffInTurn
+
+		accounts.sign(header, sealers[i])
+		blocks[i] = block.WithSeal(header)
+	}
+	chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create test chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	report, err := engine.activity(chain, chain.CurrentHeader(), 0)
+	if err != nil {
+		t.Fatalf("failed to gather activity: %v", err)
+	}
+	if report.From != 1 || report.To != 4 {
+		t.Errorf("range mismatch: have [%d, %d], want [1, 4]", report.From, report.To)
+	}
+	want := map[string]SignerActivity{
+		names[0]: {LastSealed: 2, Sealed: 1, OutOfTurn: 1, Missed: 1, MissedInRow: 1},
+		names[1]: {LastSealed: 4, Sealed: 2, InTurn: 2},
+		names[2]: {LastSealed: 3, Sealed: 1, OutOfTurn: 1, Missed: 1, MissedInRow: 1},
+	}
+	for name, record := range want {
+		have := report.Signers[accounts.address(name)]
+		if have == nil {
+			t.Errorf("signer %s: missing from report", name)
+			continue
+		}
+		if *have != record {
+			t.Errorf("signer %s: activity mismatch: have %+v, want %+v", name, *have, record)
+		}
+	}
+}
+
+// Tests that the signer monitor tracks consecutive missed turns and slides its
+// window over old blocks.
+func TestSignerMonitor(t *testing.T) {
+	signers := []common.Address{{0x01}, {0x02}, {0x03}}
+	snap := newSnapshot(&params.CliqueConfig{Epoch: 30000}, nil, 0, common.Hash{}, signers)
+
+	// Signer 0x01 is in-turn for every third block, but 0x02 always seals them
+	m := newMonitor()
+	for number := uint64(1); number <= monitorWindow+9; number++ {
+		sealer := snap.inturnSigner(number)
+		if sealer == signers[0] {
+			sealer = signers[1]
+		}
+		header := &types.Header{Number: new(big.Int).SetUint64(number), Time: big.NewInt(time.Now().Unix())}
+		m.record(header, sealer, snap)
+	}
+	status := m.status()
+	if status.From != 10 || status.To != monitorWindow+9 {
+		t.Errorf("window mismatch: have [%d, %d], want [%d, %d]", status.From, status.To, 10, monitorWindow+9)
+	}
+	if have := status.Signers[signers[0]]; have.Sealed != 0 || have.MissedInRow != have.Missed || have.Missed == 0 {
+		t.Errorf("silent signer activity mismatch: %+v", *have)
+	}
+	if have := status.Signers[signers[2]]; have.Missed != 0 || have.InTurn != have.Sealed {
+		t.Errorf("healthy signer activity mismatch: %+v", *have)
+	}
+}
+
+// Tests that the signer monitor warns only once per streak of missed turns and
+// drops the activity gauges of signers voted out.
+func TestSignerMonitorStreaks(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+
+	signers := []common.Address{{0x11}, {0x12}, {0x13}}
+	snap := newSnapshot(&params.CliqueConfig{Epoch: 30000}, nil, 0, common.Hash{}, signers)
+
+	m := newMonitor()
+	m.setLimit(2)
+
+	record := func(number uint64, snap *Snapshot, silent common.Address) {
+		sealer := snap.inturnSigner(number)
+		if sealer == silent {
+			for _, signer := range snap.signers() {
+				if signer != silent {
+					sealer = signer
+					break
+				}
+			}
+		}
+		header := &types.Header{Number: new(big.Int).SetUint64(number), Time: big.NewInt(time.Now().Unix())}
+		m.record(header, sealer, snap)
+	}
+	// Let the first signer miss enough turns to be warned about, then recover
+	number := uint64(1)
+	for ; number <= 12; number++ {
+		record(number, snap, signers[0])
+	}
+	if !m.warned[signers[0]] {
+		t.Fatalf("silent signer not warned about")
+	}
+	for ; number <= 15; number++ {
+		record(number, snap, common.Address{})
+	}
+	if m.warned[signers[0]] {
+		t.Fatalf("recovered signer still flagged as warned")
+	}
+	lastsealed := signerMetricsPrefix(signers[0]) + "lastsealed"
+	if metrics.DefaultRegistry.Get(lastsealed) == nil {
+		t.Fatalf("signer gauge %s not registered", lastsealed)
+	}
+	// Vote out the first signer and ensure its gauges are dropped
+	snap = newSnapshot(&params.CliqueConfig{Epoch: 30000}, nil, number-1, common.Hash{}, signers[1:])
+	record(number, snap, common.Address{})
+
+	if metrics.DefaultRegistry.Get(lastsealed) != nil {
+		t.Errorf("gauge %s of voted out signer still registered", lastsealed)
+	}
+	if metrics.DefaultRegistry.Get(signerMetricsPrefix(signers[1])+"lastsealed") == nil {
+		t.Errorf("gauge of authorized signer missing")
+	}
+}
+
+// Tests that empty blocks on 0-period chains are only sealed when forced to, and
+// that forced sealing only accepts the pending task it was requested for.
+func TestSealPending(t *testing.T) {
+	// Create a single signer chain without a block period
+	accs := newTesterAccountPool()
+	genesis := &core.Genesis{
+		ExtraData: make([]byte, extraVanity+common.AddressLength+extraSeal),
+	}
+	accs.checkpoint(&types.Header{Extra: genesis.ExtraData}, []string{"A"})
+
+	db := ethdb.NewMemDatabase()
+	genesisBlock := genesis.MustCommit(db)
+
+	config := *params.TestChainConfig
+	config.Clique = &params.CliqueConfig{Period: 0, Epoch: 30000}
+	engine := New(config.Clique, db)
+	engine.Authorize(accs.address("A"), func(account accounts.Account, hash []byte) ([]byte, error) {
+		return crypto.Sign(hash, accs.accounts["A"])
+	})
+	chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create test chain: %v", err)
+	}
+	defer chain.Stop()
+
+	header := &types.Header{ParentHash: genesisBlock.Hash(), Number: big.NewInt(1), GasLimit: genesisBlock.GasLimit()}
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	block := types.NewBlockWithHeader(header)
+
+	// Empty blocks must not be sealed until forced to
+	results := make(chan *types.Block, 1)
+	if err := engine.Seal(chain, block, results, make(chan struct{})); err != nil {
+		t.Fatalf("failed to seal empty block: %v", err)
+	}
+	select {
+	case sealed := <-results:
+		t.Fatalf("empty block sealed without being forced: %x", sealed.Hash())
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := engine.SealPending(common.Hash{0x01}, 0); err != errNoPendingSeal {
+		t.Errorf("mismatching parent sealing error mismatch: have %v, want %v", err, errNoPendingSeal)
+	}
+	if _, err := engine.SealPending(genesisBlock.Hash(), header.Time.Uint64()+1); err != errNoPendingSeal {
+		t.Errorf("early timestamp sealing error mismatch: have %v, want %v", err, errNoPendingSeal)
+	}
+	hash, err := engine.SealPending(genesisBlock.Hash(), header.Time.Uint64())
+	if err != nil {
+		t.Fatalf("failed to force seal: %v", err)
+	}
+	select {
+	case sealed := <-results:
+		if sealed.Hash() != hash {
+			t.Errorf("sealed block mismatch: have %x, want %x", sealed.Hash(), hash)
+		}
+		if err := engine.VerifySeal(chain, sealed.Header()); err != nil {
+			t.Errorf("forcefully sealed block invalid: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("forced seal timed out")
+	}
+}
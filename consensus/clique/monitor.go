@@ -0,0 +1,181 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/metrics"
+)
+
+const (
+	monitorWindow  = 1024             // Number of recent blocks to track signer activity over
+	monitorRecency = 10 * time.Minute // Blocks older than this are tracked, but never warned about (chain sync)
+)
+
+// monitor tracks the sealing activity of the authorized signers over a sliding
+// window of recently verified or sealed blocks, reporting it via metrics and
+// warning about signers that went silent.
+type monitor struct {
+	turns   map[uint64]turn  // Turns of the blocks within the tracked window
+	head    uint64           // Highest block number tracked
+	signers []common.Address // Authorized signers as of the highest tracked block
+	limit   uint64           // Number of consecutive missed turns to warn after (0 = disabled)
+
+	warned map[common.Address]bool // Signers already warned about in their current streak of missed turns
+	gauged map[common.Address]bool // Signers with registered activity gauges
+
+	lock sync.Mutex
+}
+
+// newMonitor creates a signer activity monitor.
+func newMonitor() *monitor {
+	return &monitor{
+		turns:  make(map[uint64]turn),
+		warned: make(map[common.Address]bool),
+		gauged: make(map[common.Address]bool),
+	}
+}
+
+// setLimit sets the number of consecutive missed turns after which to warn about
+// an inactive signer.
+func (m *monitor) setLimit(limit uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.limit = limit
+}
+
+// record inserts the turn of a freshly verified or sealed block into the sliding
+// window. The snapshot is the one the block was verified against (i.e. the one
+// of its parent). Blocks on competing forks overwrite each other.
+func (m *monitor) record(header *types.Header, sealer common.Address, snap *Snapshot) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	number := header.Number.Uint64()
+	if number+monitorWindow <= m.head {
+		return
+	}
+	// Slide the window forward if the block is a new head
+	if number >= m.head {
+		if number-m.head < monitorWindow {
+			for n := m.head + 1; n <= number; n++ {
+				if n >= monitorWindow {
+					delete(m.turns, n-monitorWindow)
+				}
+			}
+		} else {
+			m.turns = make(map[uint64]turn)
+		}
+		m.head, m.signers = number, snap.signers()
+	}
+	current := turn{
+		number: number,
+		sealer: sealer,
+		inturn: snap.inturnSigner(number),
+	}
+	m.turns[number] = current
+
+	// Skip reporting while importing historical blocks
+	if time.Since(time.Unix(header.Time.Int64(), 0)) > monitorRecency {
+		return
+	}
+	report := m.summarize()
+	m.report(report)
+
+	// If the in-turn signer went silent for too long, warn the user once per streak
+	for signer := range m.warned {
+		if activity := report.Signers[signer]; activity == nil || uint64(activity.MissedInRow) <= m.limit {
+			delete(m.warned, signer)
+		}
+	}
+	if m.limit == 0 || current.sealer == current.inturn || m.warned[current.inturn] {
+		return
+	}
+	if activity := report.Signers[current.inturn]; activity != nil && uint64(activity.MissedInRow) > m.limit {
+		log.Warn("Clique signer missing its turns", "signer", current.inturn, "missed", activity.MissedInRow, "last", activity.LastSealed)
+		m.warned[current.inturn] = true
+	}
+}
+
+// summarize aggregates the turns in the tracked window into an activity report.
+// The caller must hold the monitor lock.
+func (m *monitor) summarize() *Activity {
+	numbers := make([]uint64, 0, len(m.turns))
+	for number := range m.turns {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	turns := make([]turn, len(numbers))
+	for i, number := range numbers {
+		turns[i] = m.turns[number]
+	}
+	return summarize(turns, m.signers)
+}
+
+// report updates the per-signer activity gauges of the authorized signers and
+// drops the gauges of those voted out. The caller must hold the monitor lock.
+func (m *monitor) report(report *Activity) {
+	if !metrics.Enabled {
+		return
+	}
+	authorized := make(map[common.Address]bool, len(m.signers))
+	for _, signer := range m.signers {
+		authorized[signer] = true
+
+		activity := report.Signers[signer]
+		prefix := signerMetricsPrefix(signer)
+
+		metrics.GetOrRegisterGauge(prefix+"lastsealed", nil).Update(int64(activity.LastSealed))
+		metrics.GetOrRegisterGauge(prefix+"inturn", nil).Update(int64(activity.InTurn))
+		metrics.GetOrRegisterGauge(prefix+"noturn", nil).Update(int64(activity.OutOfTurn))
+		metrics.GetOrRegisterGauge(prefix+"missed", nil).Update(int64(activity.Missed))
+		metrics.GetOrRegisterGauge(prefix+"missedinrow", nil).Update(int64(activity.MissedInRow))
+		m.gauged[signer] = true
+	}
+	for signer := range m.gauged {
+		if authorized[signer] {
+			continue
+		}
+		prefix := signerMetricsPrefix(signer)
+		for _, name := range []string{"lastsealed", "inturn", "noturn", "missed", "missedinrow"} {
+			metrics.Unregister(prefix + name)
+		}
+		delete(m.gauged, signer)
+	}
+}
+
+// signerMetricsPrefix returns the metrics namespace of a signer's gauges.
+func signerMetricsPrefix(signer common.Address) string {
+	return fmt.Sprintf("clique/signers/%x/", signer)
+}
+
+// status returns the activity report of the tracked window.
+func (m *monitor) status() *Activity {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.summarize()
+}
@@ -310,3 +310,10 @@ func (s *Snapshot) inturn(number uint64, signer common.Address) bool {
 	}
 	return (number % uint64(len(signers))) == uint64(offset)
 }
+
+// inturnSigner returns the signer whose turn it is to seal the block at the
+// given height.
+func (s *Snapshot) inturnSigner(number uint64) common.Address {
+	signers := s.signers()
+	return signers[number%uint64(len(signers))]
+}
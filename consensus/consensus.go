@@ -23,6 +23,7 @@ import (
 	"github.com/severeum/go-severeum/common"
 	"github.com/severeum/go-severeum/core/state"
 	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/p2p"
 	"github.com/severeum/go-severeum/params"
 	"github.com/severeum/go-severeum/rpc"
 )
@@ -114,3 +115,20 @@ type PoW interface {
 	// Hashrate returns the current mining hashrate of a PoW consensus engine.
 	Hashrate() float64
 }
+
+// Handler is a consensus engine that needs to exchange messages with the other
+// sealers of the network to agree on new blocks.
+type Handler interface {
+	Engine
+
+	// Protocols returns the p2p sub-protocols the consensus engine communicates over.
+	Protocols() []p2p.Protocol
+
+	// Start begins participating in the consensus on top of the given chain, using
+	// the given callback to import the blocks agreed upon.
+	Start(chain ChainReader, insert func(*types.Block) error) error
+
+	// NewChainHead notifies the consensus engine that a new block was imported on
+	// top of the local chain.
+	NewChainHead(head *types.Header)
+}
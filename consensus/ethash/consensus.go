@@ -247,7 +247,11 @@ func (ethash *Sevash) verifyHeader(chain consensus.ChainReader, header, parent *
 			return errLargeBlockTime
 		}
 	} else {
-		if header.Time.Cmp(big.NewInt(time.Now().Add(allowedFutureBlockTime).Unix())) > 0 {
+		now := time.Now()
+		if ethash.config.PowMode == ModeDev {
+			now = ethash.now()
+		}
+		if header.Time.Cmp(big.NewInt(now.Add(allowedFutureBlockTime).Unix())) > 0 {
 			return consensus.ErrFutureBlock
 		}
 	}
@@ -304,6 +308,13 @@ func (ethash *Sevash) verifyHeader(chain consensus.ChainReader, header, parent *
 // the difficulty that a new block should have when created at time
 // given the parent block's time and difficulty.
 func (ethash *Sevash) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	// Developer chains use a fixed fake difficulty
+	if ethash.config.PowMode == ModeDev {
+		if ethash.config.DevDifficulty == 0 {
+			return big.NewInt(1)
+		}
+		return new(big.Int).SetUint64(ethash.config.DevDifficulty)
+	}
 	return CalcDifficulty(chain.Config(), time, parent)
 }
 
@@ -490,7 +501,7 @@ func (ethash *Sevash) VerifySeal(chain consensus.ChainReader, header *types.Head
 // to make remote mining fast.
 func (ethash *Sevash) verifySeal(chain consensus.ChainReader, header *types.Header, fulldag bool) error {
 	// If we're running a fake PoW, accept any seal as valid
-	if ethash.config.PowMode == ModeFake || ethash.config.PowMode == ModeFullFake {
+	if ethash.config.PowMode == ModeFake || ethash.config.PowMode == ModeFullFake || ethash.config.PowMode == ModeDev {
 		time.Sleep(ethash.fakeDelay)
 		if ethash.fakeFail == header.Number.Uint64() {
 			return errInvalidPoW
@@ -50,7 +50,7 @@ var (
 	two256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
 
 	// sharedSevash is a full instance that can be shared between multiple users.
-	sharedSevash = New(Config{"", 3, 0, "", 1, 0, ModeNormal}, nil, false)
+	sharedSevash = New(Config{"", 3, 0, "", 1, 0, ModeNormal, 0, 0}, nil, false)
 
 	// algorithmRevision is the data structure version used for file naming.
 	algorithmRevision = 23
@@ -392,6 +392,7 @@ const (
 	ModeTest
 	ModeFake
 	ModeFullFake
+	ModeDev
 )
 
 // Config are the configuration parameters of the ethash.
@@ -403,6 +404,10 @@ type Config struct {
 	DatasetsInMem  int
 	DatasetsOnDisk int
 	PowMode        Mode
+
+	// Developer mode options
+	DevPeriod     uint64 `toml:",omitempty"` // Block period to seal in (0 = seal only if transactions pending)
+	DevDifficulty uint64 `toml:",omitempty"` // Fixed difficulty of all the sealed blocks (0 = 1)
 }
 
 // sealTask wraps a seal block with relative result channel for remote sealer thread.
@@ -461,6 +466,10 @@ type Sevash struct {
 	fakeFail  uint64        // Block number which fails PoW check even in fake mode
 	fakeDelay time.Duration // Time delay to sleep for before returning from verify
 
+	// The fields below are used by the developer mode
+	devTask   *devTask      // Latest sealing task, retained to be sealed on demand
+	devOffset time.Duration // Clock offset to schedule blocks with in developer mode
+
 	lock      sync.Mutex      // Ensures thread safety for the in-memory caches and mining fields
 	closeOnce sync.Once       // Ensures exit channel will not be closed twice.
 	exitCh    chan chan error // Notification channel to exiting backend threads
@@ -562,6 +571,21 @@ func NewFullFaker() *Sevash {
 	}
 }
 
+// NewDeveloper creates an ethash consensus engine for local development chains.
+// It accepts all blocks' seal as valid and seals new blocks instantly, either as
+// soon as transactions are available or at fixed intervals, using a fixed fake
+// difficulty. Blocks can also be sealed on demand via the dev RPC API.
+func NewDeveloper(period uint64, difficulty uint64) *Sevash {
+	return &Sevash{
+		config: Config{
+			PowMode:       ModeDev,
+			DevPeriod:     period,
+			DevDifficulty: difficulty,
+		},
+		hashrate: metrics.NewMeterForced(),
+	}
+}
+
 // NewShared creates a full sized ethash PoW shared between all requesters running
 // in the same process.
 func NewShared() *Sevash {
@@ -694,7 +718,7 @@ func (ethash *Sevash) Hashrate() float64 {
 func (ethash *Sevash) APIs(chain consensus.ChainReader) []rpc.API {
 	// In order to ensure backward compatibility, we exposes ethash RPC APIs
 	// to both eth and ethash namespaces.
-	return []rpc.API{
+	apis := []rpc.API{
 		{
 			Namespace: "eth",
 			Version:   "1.0",
@@ -708,6 +732,7 @@ func (ethash *Sevash) APIs(chain consensus.ChainReader) []rpc.API {
 			Public:    true,
 		},
 	}
+	return apis
 }
 
 // SeedHash is the seed to use for generating a verification cache and the mining
@@ -60,6 +60,10 @@ func (ethash *Sevash) Seal(chain consensus.ChainReader, block *types.Block, resu
 		}
 		return nil
 	}
+	// If we're running a developer chain, seal instantly or on schedule
+	if ethash.config.PowMode == ModeDev {
+		return ethash.sealDev(chain, block, results, stop)
+	}
 	// If we're running a shared PoW, delegate sealing to it
 	if ethash.shared != nil {
 		return ethash.shared.Seal(chain, block, results, stop)
@@ -369,3 +373,112 @@ func (ethash *Sevash) remote(notify []string, noverify bool) {
 		}
 	}
 }
+
+// devTask is a sealing task retained by the developer mode engine, waiting for
+// its scheduled time or to be sealed on demand.
+type devTask struct {
+	block   *types.Block        // Sealed block to deliver
+	results chan<- *types.Block // Channel to deliver the sealed block on
+	stop    <-chan struct{}     // Notification channel if the task was superseded
+	once    sync.Once           // Ensures the block is delivered only once
+}
+
+// deliver pushes the sealed block to the miner, unless already done.
+func (task *devTask) deliver() {
+	task.once.Do(func() {
+		select {
+		case task.results <- task.block:
+		default:
+			log.Warn("Sealing result is not read by miner", "mode", "dev", "hash", task.block.Hash())
+		}
+	})
+}
+
+// sealDev seals a block in developer mode with a zero nonce. If a block period
+// is configured, the block is delivered once its parent is old enough, otherwise
+// it is delivered immediately if it contains transactions. The task is retained
+// either way so that it can be forcefully sealed via SealPending.
+func (ethash *Sevash) sealDev(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	header := block.Header()
+	header.Nonce, header.MixDigest = types.BlockNonce{}, common.Hash{}
+
+	task := &devTask{block: block.WithSeal(header), results: results, stop: stop}
+
+	ethash.lock.Lock()
+	ethash.devTask = task
+	ethash.lock.Unlock()
+
+	// For 0-period chains, refuse to seal empty blocks until forced to
+	period := ethash.config.DevPeriod
+	if period == 0 {
+		if len(block.Transactions()) == 0 {
+			log.Info("Sealing paused, waiting for transactions")
+			return nil
+		}
+		task.deliver()
+		return nil
+	}
+	// Otherwise wait until the block period elapses since the parent
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	delay := time.Unix(int64(parent.Time.Uint64()+period), 0).Sub(ethash.now())
+
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		task.deliver()
+	}()
+	return nil
+}
+
+// SealPending forcefully seals the latest sealing task in developer mode, even
+// if it contains no transactions or its scheduled time did not arrive yet. The
+// task must build on top of the given parent and must not be timestamped before
+// the given time. The hash of the sealed block is returned.
+func (ethash *Sevash) SealPending(parent common.Hash, timestamp uint64) (common.Hash, error) {
+	if ethash.config.PowMode != ModeDev {
+		return common.Hash{}, errors.New("not supported")
+	}
+	ethash.lock.Lock()
+	task := ethash.devTask
+	ethash.lock.Unlock()
+
+	if task == nil || task.block.ParentHash() != parent || task.block.Time().Uint64() < timestamp {
+		return common.Hash{}, errNoMiningWork
+	}
+	select {
+	case <-task.stop:
+		return common.Hash{}, errNoMiningWork
+	default:
+	}
+	task.deliver()
+	return task.block.Hash(), nil
+}
+
+// SetTimeOffset shifts the clock used to schedule developer mode blocks by the
+// given offset. It is meant to allow time travel on developer chains.
+func (ethash *Sevash) SetTimeOffset(offset time.Duration) {
+	ethash.lock.Lock()
+	defer ethash.lock.Unlock()
+
+	ethash.devOffset = offset
+}
+
+// now returns the current time, shifted by any configured clock offset.
+func (ethash *Sevash) now() time.Time {
+	ethash.lock.Lock()
+	defer ethash.lock.Unlock()
+
+	return time.Now().Add(ethash.devOffset)
+}
+
+// InstantSeal returns whether the engine only seals blocks on demand, in which
+// case miners need to push new work as soon as transactions arrive.
+func (ethash *Sevash) InstantSeal() bool {
+	return ethash.config.PowMode == ModeDev && ethash.config.DevPeriod == 0
+}
@@ -209,3 +209,62 @@ func TestStaleSubmission(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the developer mode seals blocks with transactions instantly, but
+// holds back empty ones until explicitly forced to seal them.
+func TestDevSeal(t *testing.T) {
+	ethash := NewDeveloper(0, 1)
+	defer ethash.Close()
+
+	results := make(chan *types.Block, 1)
+
+	// Empty blocks must not be sealed until forced to
+	empty := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)})
+	if err := ethash.Seal(nil, empty, results, make(chan struct{})); err != nil {
+		t.Fatalf("failed to seal empty block: %v", err)
+	}
+	select {
+	case block := <-results:
+		t.Fatalf("empty block sealed without being forced: %x", block.Hash())
+	case <-time.After(100 * time.Millisecond):
+	}
+	// Forced sealing must only accept the matching parent and timestamp
+	if _, err := ethash.SealPending(common.Hash{0x01}, 0); err != errNoMiningWork {
+		t.Errorf("mismatching parent sealing error mismatch: have %v, want %v", err, errNoMiningWork)
+	}
+	if _, err := ethash.SealPending(common.Hash{}, 1); err != errNoMiningWork {
+		t.Errorf("early timestamp sealing error mismatch: have %v, want %v", err, errNoMiningWork)
+	}
+	hash, err := ethash.SealPending(common.Hash{}, 0)
+	if err != nil {
+		t.Fatalf("failed to force seal: %v", err)
+	}
+	select {
+	case block := <-results:
+		if block.Hash() != hash {
+			t.Errorf("sealed block mismatch: have %x, want %x", block.Hash(), hash)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("forced seal timed out")
+	}
+	// Blocks with transactions must be sealed instantly
+	tx := types.NewTransaction(0, common.Address{}, big.NewInt(0), 0, big.NewInt(0), nil)
+	full := types.NewBlock(&types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}, []*types.Transaction{tx}, nil, nil)
+	if err := ethash.Seal(nil, full, results, make(chan struct{})); err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	select {
+	case <-results:
+	case <-time.After(time.Second):
+		t.Fatalf("instant seal timed out")
+	}
+	// Superseded tasks must not be forcefully sealed
+	stop := make(chan struct{})
+	if err := ethash.Seal(nil, empty, results, stop); err != nil {
+		t.Fatalf("failed to seal empty block: %v", err)
+	}
+	close(stop)
+	if _, err := ethash.SealPending(common.Hash{}, 0); err != errNoMiningWork {
+		t.Errorf("stale task sealing error mismatch: have %v, want %v", err, errNoMiningWork)
+	}
+}
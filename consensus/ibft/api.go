@@ -0,0 +1,129 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/consensus"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/rpc"
+)
+
+// API is a user facing RPC API to allow controlling the validator voting of the
+// byzantine fault tolerant proof-of-authority scheme.
+type API struct {
+	chain consensus.ChainReader
+	ibft  *IBFT
+}
+
+// Status is the state of the consensus rounds the local validator takes part in.
+type Status struct {
+	Validator common.Address `json:"validator"` // Address of the local validator
+	Active    bool           `json:"active"`    // Whether the local node validates the current height
+	Height    uint64         `json:"height"`    // Number of the block being agreed on
+	Round     uint64         `json:"round"`     // Current consensus round at this height
+	Proposer  common.Address `json:"proposer"`  // Validator proposing in the current round
+	Locked    *common.Hash   `json:"locked"`    // Proposal the local validator is locked on
+	Peers     int            `json:"peers"`     // Number of peers speaking the consensus protocol
+}
+
+// GetSnapshot retrieves the state snapshot at a given block.
+func (api *API) GetSnapshot(number *rpc.BlockNumber) (*Snapshot, error) {
+	header := api.header(number)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return api.ibft.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+}
+
+// GetSnapshotAtHash retrieves the state snapshot at a given block.
+func (api *API) GetSnapshotAtHash(hash common.Hash) (*Snapshot, error) {
+	header := api.chain.GetHeaderByHash(hash)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return api.ibft.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+}
+
+// GetValidators retrieves the list of validators at the specified block.
+func (api *API) GetValidators(number *rpc.BlockNumber) ([]common.Address, error) {
+	header := api.header(number)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	snap, err := api.ibft.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return snap.validators(), nil
+}
+
+// GetValidatorsAtHash retrieves the list of validators at the specified block.
+func (api *API) GetValidatorsAtHash(hash common.Hash) ([]common.Address, error) {
+	header := api.chain.GetHeaderByHash(hash)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	snap, err := api.ibft.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return snap.validators(), nil
+}
+
+// Proposals returns the current proposals the node tries to uphold and vote on.
+func (api *API) Proposals() map[common.Address]bool {
+	api.ibft.lock.RLock()
+	defer api.ibft.lock.RUnlock()
+
+	proposals := make(map[common.Address]bool)
+	for address, auth := range api.ibft.proposals {
+		proposals[address] = auth
+	}
+	return proposals
+}
+
+// Propose injects a new authorization proposal that the validator will attempt
+// to push through.
+func (api *API) Propose(address common.Address, auth bool) {
+	api.ibft.lock.Lock()
+	defer api.ibft.lock.Unlock()
+
+	api.ibft.proposals[address] = auth
+}
+
+// Discard drops a currently running proposal, stopping the validator from
+// casting further votes (either for or against).
+func (api *API) Discard(address common.Address) {
+	api.ibft.lock.Lock()
+	defer api.ibft.lock.Unlock()
+
+	delete(api.ibft.proposals, address)
+}
+
+// Status retrieves the state of the consensus rounds at the current height.
+func (api *API) Status() *Status {
+	return api.ibft.status()
+}
+
+// header retrieves the requested header (or current if none requested).
+func (api *API) header(number *rpc.BlockNumber) *types.Header {
+	if number == nil || *number == rpc.LatestBlockNumber {
+		return api.chain.CurrentHeader()
+	}
+	return api.chain.GetHeaderByNumber(uint64(number.Int64()))
+}
@@ -0,0 +1,849 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ibft implements the byzantine fault tolerant proof-of-authority
+// consensus engine, sealing blocks in rounds of pre-prepare, prepare and commit
+// messages exchanged between the validators.
+package ibft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/severeum/go-severeum/accounts"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/common/hexutil"
+	"github.com/severeum/go-severeum/consensus"
+	"github.com/severeum/go-severeum/consensus/misc"
+	"github.com/severeum/go-severeum/core/state"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/params"
+	"github.com/severeum/go-severeum/rlp"
+	"github.com/severeum/go-severeum/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	checkpointInterval = 1024 // Number of blocks after which to save the vote snapshot to the database
+	inmemorySnapshots  = 128  // Number of recent vote snapshots to keep in memory
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+)
+
+// IBFT protocol constants.
+var (
+	epochLength    = uint64(30000) // Default number of blocks after which to checkpoint and reset the pending votes
+	requestTimeout = uint64(10000) // Default milliseconds to wait for a round to commit before changing it
+
+	extraVanity = 32 // Fixed number of extra-data prefix bytes reserved for validator vanity
+
+	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // Magic nonce number to vote on adding a new validator
+	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // Magic nonce number to vote on removing a validator.
+
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	defaultDifficulty = big.NewInt(1) // Block difficulty, all blocks are equal as they are final
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of validators is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidCheckpointBeneficiary is returned if a checkpoint/epoch transition
+	// block has a beneficiary set to non-zeroes.
+	errInvalidCheckpointBeneficiary = errors.New("beneficiary in checkpoint block non-zero")
+
+	// errInvalidVote is returned if a nonce value is something else that the two
+	// allowed constants of 0x00..0 or 0xff..f.
+	errInvalidVote = errors.New("vote nonce not 0x00..0 or 0xff..f")
+
+	// errInvalidCheckpointVote is returned if a checkpoint/epoch transition block
+	// has a vote nonce set to non-zeroes.
+	errInvalidCheckpointVote = errors.New("vote nonce in checkpoint block non-zero")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the validator vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errInvalidExtra is returned if a block's extra-data section following the
+	// vanity cannot be decoded into the validator set and seals.
+	errInvalidExtra = errors.New("invalid extra-data validator section")
+
+	// errMismatchingValidators is returned if a block contains a list of validators
+	// different than the one the local node calculated.
+	errMismatchingValidators = errors.New("mismatching validator list")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errInvalidVotingChain is returned if an authorization list is attempted to
+	// be modified via out-of-range or non-contiguous headers.
+	errInvalidVotingChain = errors.New("invalid voting chain")
+
+	// errUnauthorizedProposer is returned if a header is proposed by a non-validator.
+	errUnauthorizedProposer = errors.New("unauthorized proposer")
+
+	// errUnauthorizedSigner is returned if the local signer is not a validator.
+	errUnauthorizedSigner = errors.New("unauthorized signer")
+
+	// errInvalidCommittedSeals is returned if a committed seal was not signed by a
+	// validator, or a validator committed more than once.
+	errInvalidCommittedSeals = errors.New("invalid committed seals")
+
+	// errInsufficientCommittedSeals is returned if a block was committed by less
+	// than a quorum of the validators.
+	errInsufficientCommittedSeals = errors.New("insufficient committed seals")
+
+	// errConflictingBlock is returned if a block conflicts with an already final
+	// block at the same height.
+	errConflictingBlock = errors.New("block conflicts with final block")
+)
+
+// extra is the IBFT specific section of a header's extra-data, following the
+// 32 byte vanity prefix.
+type extra struct {
+	Validators     []common.Address // Validators required to commit the block (ascending order)
+	Seal           []byte           // Signature of the proposer over the block
+	CommittedSeals [][]byte         // Signatures of the validators committing the block
+	Round          uint64           // Consensus round the committed seals were created in
+}
+
+// decodeExtra extracts the IBFT specific section from a header's extra-data.
+func decodeExtra(header *types.Header) (*extra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, errMissingVanity
+	}
+	ext := new(extra)
+	if err := rlp.DecodeBytes(header.Extra[extraVanity:], ext); err != nil {
+		return nil, errInvalidExtra
+	}
+	return ext, nil
+}
+
+// encodeExtra assembles a header's extra-data from the given vanity and IBFT
+// specific section.
+func encodeExtra(vanity []byte, ext *extra) ([]byte, error) {
+	blob, err := rlp.EncodeToBytes(ext)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, extraVanity, extraVanity+len(blob))
+	copy(buf, vanity)
+
+	return append(buf, blob...), nil
+}
+
+// GenesisExtra assembles the extra-data of a genesis block, authorizing the
+// given initial validators.
+func GenesisExtra(validators []common.Address) []byte {
+	sorted := make([]common.Address, len(validators))
+	copy(sorted, validators)
+	sortAddresses(sorted)
+
+	blob, _ := encodeExtra(nil, &extra{Validators: sorted})
+	return blob
+}
+
+// filteredHash returns the hash of a header with its seals optionally removed
+// from the extra-data. Headers with malformed extra-data hash as is.
+func filteredHash(header *types.Header, keepSeal bool) common.Hash {
+	ext, err := decodeExtra(header)
+	if err != nil {
+		return header.Hash()
+	}
+	if !keepSeal {
+		ext.Seal = nil
+	}
+	ext.CommittedSeals, ext.Round = nil, 0
+
+	cpy := types.CopyHeader(header)
+	if cpy.Extra, err = encodeExtra(header.Extra[:extraVanity], ext); err != nil {
+		return header.Hash()
+	}
+	return cpy.Hash()
+}
+
+// sigHash returns the hash the proposer signs to seal a block. It is the hash of
+// the entire header apart from the proposer seal and the committed seals.
+func sigHash(header *types.Header) common.Hash {
+	return filteredHash(header, false)
+}
+
+// proposalHash returns the hash the validators agree on while committing a block.
+// It is the hash of the entire header apart from the committed seals and round.
+func proposalHash(header *types.Header) common.Hash {
+	return filteredHash(header, true)
+}
+
+// prepareHash returns the hash the validators sign to prepare a proposal in a
+// round.
+func prepareHash(proposal common.Hash, round uint64) []byte {
+	return sealHash(proposal, round, msgPrepare)
+}
+
+// commitHash returns the hash the validators sign to commit a proposal in a round.
+func commitHash(proposal common.Hash, round uint64) []byte {
+	return sealHash(proposal, round, msgCommit)
+}
+
+// sealHash returns the hash of a proposal bound to the round and the consensus
+// step it was sealed in, so seals cannot be replayed in other rounds.
+func sealHash(proposal common.Hash, round uint64, code uint64) []byte {
+	var enc [8]byte
+	binary.BigEndian.PutUint64(enc[:], round)
+	return crypto.Keccak256(proposal[:], enc[:], []byte{byte(code)})
+}
+
+// recoverAddress extracts the Severeum account address that signed a hash.
+func recoverAddress(hash []byte, signature []byte) (common.Address, error) {
+	pubkey, err := crypto.SigToPub(hash, signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
+
+// ecrecover extracts the Severeum account address of the proposer of a header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	ext, err := decodeExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	proposer, err := recoverAddress(sigHash(header).Bytes(), ext.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	sigcache.Add(hash, proposer)
+	return proposer, nil
+}
+
+// quorum returns the number of validators needed to agree on a block, being
+// two thirds of the validator set, rounded up.
+func quorum(validators int) int {
+	return (2*validators + 2) / 3
+}
+
+// faulty returns the maximum number of faulty validators the consensus tolerates.
+func faulty(validators int) int {
+	return (validators - 1) / 3
+}
+
+// SignerFn is a signer callback function to request a hash to be signed by a
+// backing account.
+type SignerFn func(accounts.Account, []byte) ([]byte, error)
+
+// IBFT is the byzantine fault tolerant proof-of-authority consensus engine. The
+// validators agree on every block in rounds of messages, making blocks final as
+// soon as they are committed.
+type IBFT struct {
+	config *params.IBFTConfig // Consensus engine configuration parameters
+	db     ethdb.Database     // Database to store and retrieve snapshot checkpoints
+
+	recents    *lru.ARCCache // Snapshots for recent block to speed up reorgs
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+
+	proposals map[common.Address]bool // Current list of proposals we are pushing
+
+	rounds  *rounds  // Round based state machine agreeing on blocks
+	handler *handler // Network layer exchanging consensus messages
+
+	chain  consensus.ChainReader    // Local chain to verify proposals against
+	insert func(*types.Block) error // Callback to import agreed upon blocks
+	signer common.Address           // Severeum address of the signing key
+	signFn SignerFn                 // Signer function to authorize hashes with
+	lock   sync.RWMutex             // Protects the signer and chain fields
+}
+
+// New creates an IBFT consensus engine with the initial validators set to the
+// ones in the genesis block.
+func New(config *params.IBFTConfig, db ethdb.Database) *IBFT {
+	// Set any missing consensus parameters to their defaults
+	conf := *config
+	if conf.Epoch == 0 {
+		conf.Epoch = epochLength
+	}
+	if conf.RequestTimeout == 0 {
+		conf.RequestTimeout = requestTimeout
+	}
+	// Allocate the snapshot caches and create the engine
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+
+	engine := &IBFT{
+		config:     &conf,
+		db:         db,
+		recents:    recents,
+		signatures: signatures,
+		proposals:  make(map[common.Address]bool),
+	}
+	engine.rounds = newRounds(engine, time.Duration(conf.Period)*time.Second, time.Duration(conf.RequestTimeout)*time.Millisecond)
+	engine.handler = newHandler(engine.rounds.handleMessage)
+	return engine
+}
+
+// Author implements consensus.Engine, returning the Severeum address recovered
+// from the proposer seal in the header's extra-data section.
+func (c *IBFT) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, c.signatures)
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (c *IBFT) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return c.verifyHeader(chain, header, nil, true)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (c *IBFT) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	go func() {
+		for i, header := range headers {
+			err := c.verifyHeader(chain, header, headers[:i], true)
+
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database. Proposals are verified without requiring
+// them to be committed yet.
+func (c *IBFT) verifyHeader(chain consensus.ChainReader, header *types.Header, parents []*types.Header, committed bool) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time.Cmp(big.NewInt(time.Now().Unix())) > 0 {
+		return consensus.ErrFutureBlock
+	}
+	// Checkpoint blocks need to enforce zero beneficiary
+	checkpoint := (number % c.config.Epoch) == 0
+	if checkpoint && header.Coinbase != (common.Address{}) {
+		return errInvalidCheckpointBeneficiary
+	}
+	// Nonces must be 0x00..0 or 0xff..f, zeroes enforced on checkpoints
+	if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidVote
+	}
+	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidCheckpointVote
+	}
+	// Check that the extra-data contains the vanity and the validator section
+	if _, err := decodeExtra(header); err != nil {
+		return err
+	}
+	// Ensure that the mix digest is zero as we don't have fork protection currently
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	// Ensure that the block doesn't contain any uncles which are meaningless in PoA
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	// Ensure that the block's difficulty is constant, all final blocks are equal
+	if number > 0 && (header.Difficulty == nil || header.Difficulty.Cmp(defaultDifficulty) != 0) {
+		return errInvalidDifficulty
+	}
+	// If all checks passed, validate any special fields for hard forks
+	if err := misc.VerifyForkHashes(chain.Config(), header, false); err != nil {
+		return err
+	}
+	// All basic checks passed, verify cascading fields
+	return c.verifyCascadingFields(chain, header, parents, committed)
+}
+
+// verifyCascadingFields verifies all the header fields that are not standalone,
+// rather depend on a batch of previous headers.
+func (c *IBFT) verifyCascadingFields(chain consensus.ChainReader, header *types.Header, parents []*types.Header, committed bool) error {
+	// The genesis block is the always valid dead-end
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	// Ensure that the block's timestamp isn't too close to it's parent
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time.Uint64()+c.config.Period > header.Time.Uint64() {
+		return errInvalidTimestamp
+	}
+	// Blocks are final once committed, reject anything conflicting with them
+	if final := chain.GetHeaderByNumber(number); final != nil && final.Hash() != header.Hash() {
+		if proposalHash(final) != proposalHash(header) {
+			return errConflictingBlock
+		}
+	}
+	// Retrieve the snapshot needed to verify this header and check the validators
+	snap, err := c.snapshot(chain, number-1, header.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	ext, err := decodeExtra(header)
+	if err != nil {
+		return err
+	}
+	validators := snap.validators()
+	if len(ext.Validators) != len(validators) {
+		return errMismatchingValidators
+	}
+	for i, validator := range validators {
+		if ext.Validators[i] != validator {
+			return errMismatchingValidators
+		}
+	}
+	// All basic checks passed, verify the seals and return
+	return c.verifySeals(header, snap, committed)
+}
+
+// snapshot retrieves the authorization snapshot at a given point in time.
+func (c *IBFT) snapshot(chain consensus.ChainReader, number uint64, hash common.Hash, parents []*types.Header) (*Snapshot, error) {
+	// Search for a snapshot in memory or on disk for checkpoints
+	var (
+		headers []*types.Header
+		snap    *Snapshot
+	)
+	for snap == nil {
+		// If an in-memory snapshot was found, use that
+		if s, ok := c.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		// If an on-disk checkpoint snapshot can be found, use that
+		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(c.config, c.signatures, c.db, hash); err == nil {
+				log.Trace("Loaded voting snapshot from disk", "number", number, "hash", hash)
+				snap = s
+				break
+			}
+		}
+		// If we're at an checkpoint block, make a snapshot if it's known
+		if number == 0 || (number%c.config.Epoch == 0 && chain.GetHeaderByNumber(number-1) == nil) {
+			checkpoint := chain.GetHeaderByNumber(number)
+			if checkpoint != nil {
+				ext, err := decodeExtra(checkpoint)
+				if err != nil {
+					return nil, err
+				}
+				hash := checkpoint.Hash()
+
+				snap = newSnapshot(c.config, c.signatures, number, hash, ext.Validators)
+				if err := snap.store(c.db); err != nil {
+					return nil, err
+				}
+				log.Info("Stored checkpoint snapshot to disk", "number", number, "hash", hash)
+				break
+			}
+		}
+		// No snapshot for this header, gather the header and move backward
+		var header *types.Header
+		if len(parents) > 0 {
+			// If we have explicit parents, pick from there (enforced)
+			header = parents[len(parents)-1]
+			if header.Hash() != hash || header.Number.Uint64() != number {
+				return nil, consensus.ErrUnknownAncestor
+			}
+			parents = parents[:len(parents)-1]
+		} else {
+			// No explicit parents (or no more left), reach out to the database
+			header = chain.GetHeader(hash, number)
+			if header == nil {
+				return nil, consensus.ErrUnknownAncestor
+			}
+		}
+		headers = append(headers, header)
+		number, hash = number-1, header.ParentHash
+	}
+	// Previous snapshot found, apply any pending headers on top of it
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	snap, err := snap.apply(headers)
+	if err != nil {
+		return nil, err
+	}
+	c.recents.Add(snap.Hash, snap)
+
+	// If we've generated a new checkpoint snapshot, save to disk
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err = snap.store(c.db); err != nil {
+			return nil, err
+		}
+		log.Trace("Stored voting snapshot to disk", "number", snap.Number, "hash", snap.Hash)
+	}
+	return snap, err
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (c *IBFT) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// VerifySeal implements consensus.Engine, checking whether the proposer seal and
+// the committed seals contained in the header satisfy the consensus protocol
+// requirements.
+func (c *IBFT) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	// Verifying the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	snap, err := c.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	return c.verifySeals(header, snap, true)
+}
+
+// verifySeals checks that the header was proposed by a validator and, if it is
+// required to be committed, that a quorum of validators committed to it.
+func (c *IBFT) verifySeals(header *types.Header, snap *Snapshot, committed bool) error {
+	proposer, err := ecrecover(header, c.signatures)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Validators[proposer]; !ok {
+		return errUnauthorizedProposer
+	}
+	if !committed {
+		return nil
+	}
+	ext, err := decodeExtra(header)
+	if err != nil {
+		return err
+	}
+	var (
+		hash  = commitHash(proposalHash(header), ext.Round)
+		seen  = make(map[common.Address]struct{})
+		valid int
+	)
+	for _, seal := range ext.CommittedSeals {
+		validator, err := recoverAddress(hash, seal)
+		if err != nil {
+			return errInvalidCommittedSeals
+		}
+		if _, ok := snap.Validators[validator]; !ok {
+			return errInvalidCommittedSeals
+		}
+		if _, ok := seen[validator]; ok {
+			return errInvalidCommittedSeals
+		}
+		seen[validator] = struct{}{}
+		valid++
+	}
+	if valid < quorum(len(snap.Validators)) {
+		return errInsufficientCommittedSeals
+	}
+	return nil
+}
+
+// verifyProposal checks whether a block proposed for the next height is valid
+// to be committed on top of the local chain.
+func (c *IBFT) verifyProposal(block *types.Block) error {
+	c.lock.RLock()
+	chain := c.chain
+	c.lock.RUnlock()
+
+	if chain == nil {
+		return errUnknownBlock
+	}
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return errors.New("transaction root hash mismatch")
+	}
+	if len(block.Uncles()) > 0 || block.UncleHash() != uncleHash {
+		return errInvalidUncleHash
+	}
+	return c.verifyHeader(chain, block.Header(), nil, false)
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (c *IBFT) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	// If the block isn't a checkpoint, cast a random vote (good enough for now)
+	header.Coinbase = common.Address{}
+	header.Nonce = types.BlockNonce{}
+
+	number := header.Number.Uint64()
+	// Assemble the voting snapshot to check which votes make sense
+	snap, err := c.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if number%c.config.Epoch != 0 {
+		c.lock.RLock()
+
+		// Gather all the proposals that make sense voting on
+		addresses := make([]common.Address, 0, len(c.proposals))
+		for address, authorize := range c.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		// If there's pending proposals, cast a vote on them
+		if len(addresses) > 0 {
+			header.Coinbase = addresses[rand.Intn(len(addresses))]
+			if c.proposals[header.Coinbase] {
+				copy(header.Nonce[:], nonceAuthVote)
+			} else {
+				copy(header.Nonce[:], nonceDropVote)
+			}
+		}
+		c.lock.RUnlock()
+	}
+	// All blocks are final, so they all weigh the same
+	header.Difficulty = new(big.Int).Set(defaultDifficulty)
+
+	// Embed the validators required to commit the block into the extra data
+	header.Extra, err = encodeExtra(header.Extra, &extra{Validators: snap.validators()})
+	if err != nil {
+		return err
+	}
+	// Mix digest is reserved for now, set to empty
+	header.MixDigest = common.Hash{}
+
+	// Ensure the timestamp has the correct delay
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Time = new(big.Int).Add(parent.Time, new(big.Int).SetUint64(c.config.Period))
+	if now := time.Now().Unix(); header.Time.Int64() < now {
+		header.Time = big.NewInt(now)
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given, and returns the final block.
+func (c *IBFT) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// No block rewards in PoA, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts), nil
+}
+
+// Authorize injects a private key into the consensus engine to propose and
+// commit new blocks with.
+func (c *IBFT) Authorize(signer common.Address, signFn SignerFn) {
+	c.lock.Lock()
+	c.signer = signer
+	c.signFn = signFn
+	c.lock.Unlock()
+
+	c.rounds.authorize(signer)
+}
+
+// sign signs a hash with the local validator key.
+func (c *IBFT) sign(hash []byte) ([]byte, error) {
+	c.lock.RLock()
+	signer, signFn := c.signer, c.signFn
+	c.lock.RUnlock()
+
+	if signFn == nil {
+		return nil, errUnauthorizedSigner
+	}
+	return signFn(accounts.Account{Address: signer}, hash)
+}
+
+// Seal implements consensus.Engine, signing the block as its proposer and handing
+// it to the consensus rounds to be proposed when the local validator's turn
+// comes. Blocks are not delivered on the results channel, rather imported via
+// the chain insertion callback once committed.
+func (c *IBFT) Seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	// For 0-period chains, refuse to seal empty blocks (no reward but would spin sealing)
+	if c.config.Period == 0 && len(block.Transactions()) == 0 {
+		log.Info("Sealing paused, waiting for transactions")
+		return nil
+	}
+	// Bail out if we're unauthorized to propose a block
+	snap, err := c.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	c.lock.RLock()
+	signer := c.signer
+	c.lock.RUnlock()
+
+	if _, authorized := snap.Validators[signer]; !authorized {
+		return errUnauthorizedSigner
+	}
+	// Sign the proposal and hand it over to the consensus rounds
+	ext, err := decodeExtra(header)
+	if err != nil {
+		return err
+	}
+	if ext.Seal, err = c.sign(sigHash(header).Bytes()); err != nil {
+		return err
+	}
+	if header.Extra, err = encodeExtra(header.Extra[:extraVanity], ext); err != nil {
+		return err
+	}
+	if parent := chain.GetHeader(header.ParentHash, number-1); parent != nil {
+		c.rounds.newHead(parent, snap.validators())
+	}
+	c.rounds.propose(block.WithSeal(header))
+	return nil
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (c *IBFT) SealHash(header *types.Header) common.Hash {
+	return sigHash(header)
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. All IBFT blocks have the
+// same difficulty as they are final once committed.
+func (c *IBFT) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(defaultDifficulty)
+}
+
+// Protocols implements consensus.Handler, returning the p2p sub-protocol the
+// validators exchange consensus messages over.
+func (c *IBFT) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{c.handler.protocol()}
+}
+
+// Start implements consensus.Handler, starting to participate in the consensus
+// rounds on top of the given chain.
+func (c *IBFT) Start(chain consensus.ChainReader, insert func(*types.Block) error) error {
+	c.lock.Lock()
+	c.chain, c.insert = chain, insert
+	c.lock.Unlock()
+
+	c.NewChainHead(chain.CurrentHeader())
+	return nil
+}
+
+// NewChainHead implements consensus.Handler, moving the consensus rounds on to
+// the height following the new head.
+func (c *IBFT) NewChainHead(head *types.Header) {
+	c.lock.RLock()
+	chain := c.chain
+	c.lock.RUnlock()
+
+	if chain == nil {
+		return
+	}
+	snap, err := c.snapshot(chain, head.Number.Uint64(), head.Hash(), nil)
+	if err != nil {
+		log.Warn("Failed to retrieve IBFT validators", "number", head.Number, "hash", head.Hash(), "err", err)
+		return
+	}
+	c.rounds.newHead(head, snap.validators())
+}
+
+// broadcast gossips a consensus message to the connected peers.
+func (c *IBFT) broadcast(payload []byte) {
+	c.handler.broadcast(payload)
+}
+
+// commit imports a block the validators agreed upon into the local chain.
+func (c *IBFT) commit(block *types.Block) {
+	c.lock.RLock()
+	insert := c.insert
+	c.lock.RUnlock()
+
+	if insert == nil {
+		return
+	}
+	go func() {
+		if err := insert(block); err != nil {
+			log.Warn("Failed to import committed block", "number", block.Number(), "hash", block.Hash(), "err", err)
+		}
+	}()
+}
+
+// status retrieves the state of the consensus rounds at the current height.
+func (c *IBFT) status() *Status {
+	status := c.rounds.status()
+	status.Peers = c.handler.peerCount()
+	return status
+}
+
+// Close implements consensus.Engine, terminating the consensus rounds and the
+// connections to the remote validators.
+func (c *IBFT) Close() error {
+	c.rounds.stop()
+	c.handler.close()
+	return nil
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC API to allow
+// controlling the validator voting.
+func (c *IBFT) APIs(chain consensus.ChainReader) []rpc.API {
+	return []rpc.API{{
+		Namespace: "ibft",
+		Version:   "1.0",
+		Service:   &API{chain: chain, ibft: c},
+		Public:    false,
+	}}
+}
@@ -0,0 +1,515 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/core/vm"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/params"
+	"github.com/severeum/go-severeum/rlp"
+	"github.com/severeum/go-severeum/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// testValidators creates a number of validator keys, sorted the same way as the
+// validators are ordered in the extra-data.
+func testValidators(n int) ([]*ecdsa.PrivateKey, []common.Address) {
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if crypto.PubkeyToAddress(keys[j].PublicKey).Big().Cmp(crypto.PubkeyToAddress(keys[i].PublicKey).Big()) < 0 {
+				keys[i], keys[j] = keys[j], keys[i]
+			}
+		}
+	}
+	addrs := make([]common.Address, n)
+	for i, key := range keys {
+		addrs[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	return keys, addrs
+}
+
+// testChain creates a chain validated by the given validators.
+func testChain(t *testing.T, validators []common.Address) (*IBFT, *core.BlockChain) {
+	genesis := &core.Genesis{
+		Config:     &params.ChainConfig{ChainID: big.NewInt(1), IBFT: &params.IBFTConfig{Epoch: 30000}},
+		ExtraData:  GenesisExtra(validators),
+		Difficulty: big.NewInt(1),
+		GasLimit:   params.GenesisGasLimit,
+	}
+	db := ethdb.NewMemDatabase()
+	genesis.MustCommit(db)
+
+	engine := New(genesis.Config.IBFT, db)
+	chain, err := core.NewBlockChain(db, nil, genesis.Config, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create test chain: %v", err)
+	}
+	return engine, chain
+}
+
+// testProposal assembles an empty block on top of the current head, casting a
+// vote if requested and signed by the given proposer.
+func testProposal(t *testing.T, engine *IBFT, chain *core.BlockChain, proposer *ecdsa.PrivateKey, vote *common.Address, auth bool) *types.Block {
+	parent := chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   parent.GasLimit(),
+		Root:       parent.Root(),
+	}
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	if vote != nil {
+		header.Coinbase = *vote
+		if auth {
+			copy(header.Nonce[:], nonceAuthVote)
+		}
+	}
+	header.UncleHash, header.TxHash, header.ReceiptHash = types.EmptyUncleHash, types.EmptyRootHash, types.EmptyRootHash
+
+	ext, _ := decodeExtra(header)
+	ext.Seal, _ = crypto.Sign(sigHash(header).Bytes(), proposer)
+	header.Extra, _ = encodeExtra(header.Extra[:extraVanity], ext)
+
+	return types.NewBlockWithHeader(header)
+}
+
+// testCommit adds the committed seals of the given validators to a proposal.
+func testCommit(block *types.Block, keys []*ecdsa.PrivateKey) *types.Block {
+	header := block.Header()
+	ext, _ := decodeExtra(header)
+	for _, key := range keys {
+		seal, _ := crypto.Sign(commitHash(proposalHash(header), ext.Round), key)
+		ext.CommittedSeals = append(ext.CommittedSeals, seal)
+	}
+	header.Extra, _ = encodeExtra(header.Extra[:extraVanity], ext)
+	return block.WithSeal(header)
+}
+
+// Tests that blocks are only accepted if they were proposed by a validator and
+// committed by a quorum of distinct validators.
+func TestCommittedSeals(t *testing.T) {
+	keys, addrs := testValidators(4)
+	engine, chain := testChain(t, addrs)
+	defer chain.Stop()
+
+	outsider, _ := crypto.GenerateKey()
+
+	tests := []struct {
+		proposer   *ecdsa.PrivateKey
+		committers []*ecdsa.PrivateKey
+		err        error
+	}{
+		{keys[1], keys[:2], errInsufficientCommittedSeals},
+		{keys[1], []*ecdsa.PrivateKey{keys[0], keys[0], keys[1]}, errInvalidCommittedSeals},
+		{keys[1], []*ecdsa.PrivateKey{keys[0], keys[1], outsider}, errInvalidCommittedSeals},
+		{outsider, keys[:3], errUnauthorizedProposer},
+		{keys[1], keys[1:], nil},
+	}
+	for i, tt := range tests {
+		block := testCommit(testProposal(t, engine, chain, tt.proposer, nil, false), tt.committers)
+		if _, err := chain.InsertChain(types.Blocks{block}); err != tt.err {
+			t.Errorf("test %d: import error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != 1 {
+		t.Fatalf("chain head mismatch: have %d, want 1", head)
+	}
+	// Committed blocks are final, conflicting ones must be rejected
+	header := chain.CurrentBlock().Header()
+	header.GasLimit--
+	if err := engine.VerifyHeader(chain, header, false); err != errConflictingBlock {
+		t.Errorf("conflicting block error mismatch: have %v, want %v", err, errConflictingBlock)
+	}
+}
+
+// Tests that the validators can vote new validators in, changing the validator
+// set required to commit subsequent blocks.
+func TestValidatorVoting(t *testing.T) {
+	keys, addrs := testValidators(4)
+	engine, chain := testChain(t, addrs)
+	defer chain.Stop()
+
+	newcomer := common.Address{0xff}
+	for i := 0; i < 3; i++ {
+		block := testCommit(testProposal(t, engine, chain, keys[i], &newcomer, true), keys[:3])
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("vote %d: failed to import block: %v", i, err)
+		}
+	}
+	api := &API{chain: chain, ibft: engine}
+	validators, err := api.GetValidators(nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve validators: %v", err)
+	}
+	found := false
+	for _, validator := range validators {
+		found = found || validator == newcomer
+	}
+	if len(validators) != 5 || !found {
+		t.Fatalf("validators mismatch: have %x, want newcomer %x added", validators, newcomer)
+	}
+	// Three seals no longer form a quorum of five validators
+	block := testCommit(testProposal(t, engine, chain, keys[0], nil, false), keys[:3])
+	if _, err := chain.InsertChain(types.Blocks{block}); err != errInsufficientCommittedSeals {
+		t.Errorf("import error mismatch: have %v, want %v", err, errInsufficientCommittedSeals)
+	}
+	genesis := rpc.BlockNumber(0)
+	if validators, _ := api.GetValidators(&genesis); len(validators) != 4 {
+		t.Errorf("genesis validator count mismatch: have %d, want 4", len(validators))
+	}
+}
+
+// testBackend is an in-memory consensus backend, connecting a validator to the
+// others directly, without a networking layer.
+type testBackend struct {
+	key       *ecdsa.PrivateKey
+	verify    func(block *types.Block) error
+	peers     []*rounds
+	committed chan *types.Block
+}
+
+func (b *testBackend) sign(hash []byte) ([]byte, error) { return crypto.Sign(hash, b.key) }
+
+func (b *testBackend) verifyProposal(block *types.Block) error {
+	if b.verify != nil {
+		return b.verify(block)
+	}
+	return nil
+}
+
+func (b *testBackend) broadcast(payload []byte) {
+	for _, peer := range b.peers {
+		go peer.handleMessage(payload)
+	}
+}
+
+func (b *testBackend) commit(block *types.Block) {
+	select {
+	case b.committed <- block:
+	default:
+	}
+}
+
+// testNetwork creates the consensus state machines of a set of validators, all
+// connected to each other apart from the offline ones.
+func testNetwork(keys []*ecdsa.PrivateKey, offline map[int]bool, timeout time.Duration) ([]*rounds, []*testBackend) {
+	var (
+		machines = make([]*rounds, len(keys))
+		backends = make([]*testBackend, len(keys))
+	)
+	for i, key := range keys {
+		backends[i] = &testBackend{key: key, committed: make(chan *types.Block, 1)}
+		machines[i] = newRounds(backends[i], 0, timeout)
+		machines[i].authorize(crypto.PubkeyToAddress(key.PublicKey))
+	}
+	for i := range keys {
+		for j := range keys {
+			if i != j && !offline[i] && !offline[j] {
+				backends[i].peers = append(backends[i].peers, machines[j])
+			}
+		}
+	}
+	return machines, backends
+}
+
+// testSealed creates a block on top of the given head, sealed by the proposer.
+func testSealed(head *types.Header, validators []common.Address, proposer *ecdsa.PrivateKey) *types.Block {
+	header := &types.Header{
+		ParentHash: head.Hash(),
+		Number:     new(big.Int).Add(head.Number, common.Big1),
+		Time:       big.NewInt(time.Now().Unix()),
+		Difficulty: big.NewInt(1),
+	}
+	header.Extra, _ = encodeExtra(nil, &extra{Validators: validators})
+
+	ext, _ := decodeExtra(header)
+	ext.Seal, _ = crypto.Sign(sigHash(header).Bytes(), proposer)
+	header.Extra, _ = encodeExtra(nil, ext)
+
+	return types.NewBlockWithHeader(header)
+}
+
+// checkCommitted waits for the online validators to commit the expected block.
+func checkCommitted(t *testing.T, backends []*testBackend, offline map[int]bool, want *types.Block, validators []common.Address) {
+	for i, backend := range backends {
+		if offline[i] {
+			continue
+		}
+		select {
+		case block := <-backend.committed:
+			if proposalHash(block.Header()) != proposalHash(want.Header()) {
+				t.Fatalf("validator %d: committed block mismatch: have %x, want %x", i, proposalHash(block.Header()), proposalHash(want.Header()))
+			}
+			ext, err := decodeExtra(block.Header())
+			if err != nil {
+				t.Fatalf("validator %d: failed to decode committed block: %v", i, err)
+			}
+			if len(ext.CommittedSeals) < quorum(len(validators)) {
+				t.Fatalf("validator %d: committed seal count mismatch: have %d, want >= %d", i, len(ext.CommittedSeals), quorum(len(validators)))
+			}
+			for _, seal := range ext.CommittedSeals {
+				signer, err := recoverAddress(commitHash(proposalHash(block.Header()), ext.Round), seal)
+				if err != nil {
+					t.Fatalf("validator %d: invalid committed seal: %v", i, err)
+				}
+				found := false
+				for _, validator := range validators {
+					found = found || validator == signer
+				}
+				if !found {
+					t.Fatalf("validator %d: committed seal from non-validator %x", i, signer)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("validator %d: block not committed", i)
+		}
+	}
+}
+
+// Tests that a set of healthy validators agree on the proposer's block in the
+// first round.
+func TestConsensusRounds(t *testing.T) {
+	keys, addrs := testValidators(4)
+	machines, backends := testNetwork(keys, nil, 3*time.Second)
+
+	head := &types.Header{Number: big.NewInt(0), Time: big.NewInt(time.Now().Unix())}
+	for _, m := range machines {
+		m.newHead(head, addrs)
+		defer m.stop()
+	}
+	// Validator 1 proposes in the first round at height 1
+	block := testSealed(head, addrs, keys[1])
+	machines[1].propose(block)
+
+	checkCommitted(t, backends, nil, block, addrs)
+	for i, m := range machines {
+		if status := m.status(); status.Round != 0 {
+			t.Errorf("validator %d: round mismatch: have %d, want 0", i, status.Round)
+		}
+	}
+}
+
+// Tests that the validators move on to the next round and proposer if the first
+// proposer is offline, and that a proposal rejected by too many validators does
+// not get committed.
+func TestRoundChange(t *testing.T) {
+	keys, addrs := testValidators(4)
+	offline := map[int]bool{1: true}
+	machines, backends := testNetwork(keys, offline, 100*time.Millisecond)
+	sigcache, _ := lru.NewARC(16)
+
+	// Validator 3 refuses the proposal of validator 2, forcing a second round change
+	backends[3].verify = func(block *types.Block) error {
+		if proposer, _ := ecrecover(block.Header(), sigcache); proposer == addrs[2] {
+			return errUnauthorizedProposer
+		}
+		return nil
+	}
+	head := &types.Header{Number: big.NewInt(0), Time: big.NewInt(time.Now().Unix() - 10)}
+	for i, m := range machines {
+		if !offline[i] {
+			m.newHead(head, addrs)
+			defer m.stop()
+		}
+	}
+	machines[2].propose(testSealed(head, addrs, keys[2]))
+
+	block := testSealed(head, addrs, keys[3])
+	machines[3].propose(block)
+
+	checkCommitted(t, backends, offline, block, addrs)
+	for i, m := range machines {
+		if offline[i] {
+			continue
+		}
+		if status := m.status(); status.Round != 2 || status.Proposer != addrs[3] {
+			t.Errorf("validator %d: round mismatch: have %d by %x, want 2 by %x", i, status.Round, status.Proposer, addrs[3])
+		}
+	}
+}
+
+// testMessage creates an encoded consensus message signed by the given key.
+func testMessage(t *testing.T, key *ecdsa.PrivateKey, code, height, round uint64, block *types.Block, seal []byte, cert [][]byte, locked uint64) []byte {
+	var digest common.Hash
+	if block != nil {
+		digest = proposalHash(block.Header())
+	}
+	msg, err := newMessage(code, height, round, digest, block, seal, cert, locked, func(hash []byte) ([]byte, error) {
+		return crypto.Sign(hash, key)
+	})
+	if err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		t.Fatalf("failed to encode message: %v", err)
+	}
+	return payload
+}
+
+// Tests that consensus messages are only accepted (and thus relayed) if they are
+// sent by the validators entitled to and carry valid seals and justifications.
+func TestMessageValidation(t *testing.T) {
+	keys, addrs := testValidators(4)
+	outsider, _ := crypto.GenerateKey()
+
+	backend := &testBackend{key: keys[0], committed: make(chan *types.Block, 2)}
+	m := newRounds(backend, 0, time.Hour)
+	m.authorize(addrs[0])
+	defer m.stop()
+
+	head := &types.Header{Number: big.NewInt(0), Time: big.NewInt(time.Now().Unix())}
+	m.newHead(head, addrs)
+
+	// Validator 1 is the proposer of the first round at height 1
+	block := testSealed(head, addrs, keys[1])
+	digest := proposalHash(block.Header())
+	seal := func(key *ecdsa.PrivateKey, hash []byte) []byte {
+		sig, _ := crypto.Sign(hash, key)
+		return sig
+	}
+	var prepared, committed [][]byte
+	for _, key := range keys[:3] {
+		prepared = append(prepared, seal(key, prepareHash(digest, 0)))
+		committed = append(committed, seal(key, commitHash(digest, 0)))
+	}
+	tests := []struct {
+		payload []byte
+		err     error
+	}{
+		// Messages from non-validators are dropped, even about future heights
+		{testMessage(t, outsider, msgPrepare, 2, 0, nil, nil, nil, 0), errUnauthorizedSender},
+		{testMessage(t, outsider, msgFinal, 1, 0, testCommit(block, keys[:3]), nil, nil, 0), errUnauthorizedSender},
+		{testMessage(t, keys[1], msgPrepare, 0, 0, nil, nil, nil, 0), errStaleMessage},
+
+		// Proposals and final blocks are only accepted from the round's proposer
+		{testMessage(t, keys[2], msgPreprepare, 1, 0, block, nil, nil, 0), errUnauthorizedProposer},
+		{testMessage(t, keys[2], msgFinal, 1, 0, testCommit(block, keys[:3]), nil, nil, 0), errUnauthorizedProposer},
+		{testMessage(t, keys[1], msgFinal, 1, 0, testCommit(block, keys[:2]), nil, nil, 0), errInsufficientCommittedSeals},
+		{testMessage(t, keys[1], msgFinal, 1, 0, testCommit(block, []*ecdsa.PrivateKey{keys[0], keys[0], keys[1]}), nil, nil, 0), errInsufficientCommittedSeals},
+		{testMessage(t, keys[2], msgFinal, 1, 1, testCommit(block, keys[:3]), nil, nil, 0), nil},
+		{testMessage(t, keys[1], msgFinal, 1, 0, testCommit(block, keys[1:]), nil, nil, 0), nil},
+
+		// Prepares and commits need to be sealed by their sender
+		{testMessage(t, keys[2], msgPrepare, 1, 0, nil, seal(keys[3], prepareHash(digest, 0)), nil, 0), errInvalidSeal},
+		{testMessage(t, keys[2], msgCommit, 1, 0, nil, seal(keys[2], prepareHash(digest, 0)), nil, 0), errInvalidSeal},
+		{testMessage(t, keys[2], msgPrepare, 1, 0, nil, seal(keys[2], prepareHash(digest, 1)), nil, 0), errInvalidSeal},
+
+		// Locked proposals in round changes need to be prepared by a quorum
+		{testMessage(t, keys[2], msgRoundChange, 1, 1, block, nil, nil, 0), errInvalidCertificate},
+		{testMessage(t, keys[2], msgRoundChange, 1, 1, block, nil, prepared[:2], 0), errInvalidCertificate},
+		{testMessage(t, keys[2], msgRoundChange, 1, 1, block, nil, [][]byte{prepared[0], prepared[0], prepared[1]}, 0), errInvalidCertificate},
+		{testMessage(t, keys[2], msgRoundChange, 1, 2, block, nil, prepared, 1), errInvalidCertificate},
+		{testMessage(t, keys[2], msgRoundChange, 1, 1, block, nil, prepared, 1), errInvalidCertificate},
+		{testMessage(t, keys[2], msgRoundChange, 1, 1, block, nil, prepared, 0), nil},
+		{testMessage(t, keys[3], msgRoundChange, 1, 1, block, nil, committed, 0), nil},
+	}
+	for i, tt := range tests {
+		if err := m.handleMessage(tt.payload); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	// The committed block must have been handed over for import exactly once
+	select {
+	case <-backend.committed:
+	default:
+		t.Fatalf("final block not committed")
+	}
+	select {
+	case <-backend.committed:
+		t.Fatalf("final block committed twice")
+	default:
+	}
+	if len(m.backlog) != 0 {
+		t.Errorf("backlog mismatch: have %d messages, want none", len(m.backlog))
+	}
+	// Future messages of a single validator may only occupy a share of the backlog
+	for i := 0; i < 2*maxSenderBacklog; i++ {
+		if err := m.handleMessage(testMessage(t, keys[3], msgPrepare, uint64(i+2), 0, nil, nil, nil, 0)); err != nil {
+			t.Fatalf("future message %d: failed to store: %v", i, err)
+		}
+	}
+	if err := m.handleMessage(testMessage(t, keys[2], msgPrepare, 2, 0, nil, nil, nil, 0)); err != nil {
+		t.Fatalf("failed to store future message: %v", err)
+	}
+	if len(m.backlog) != maxSenderBacklog+1 {
+		t.Errorf("backlog mismatch: have %d messages, want %d", len(m.backlog), maxSenderBacklog+1)
+	}
+}
+
+// Tests that a proposer re-proposes the proposal locked in the highest round out
+// of the ones reported in the round changes, not an older lock.
+func TestLockedProposal(t *testing.T) {
+	keys, addrs := testValidators(4)
+
+	backend := &testBackend{key: keys[0], committed: make(chan *types.Block, 1)}
+	m := newRounds(backend, 0, time.Hour)
+	m.authorize(addrs[0])
+	defer m.stop()
+
+	head := &types.Header{Number: big.NewInt(0), Time: big.NewInt(time.Now().Unix() - 10)}
+	m.newHead(head, addrs)
+
+	// Validator 1 locked on its own proposal in round 0, validator 2 on its own in
+	// round 2. Validator 0 proposes in round 3.
+	older, newer := testSealed(head, addrs, keys[1]), testSealed(head, addrs, keys[2])
+	certify := func(block *types.Block, round uint64) [][]byte {
+		var seals [][]byte
+		for _, key := range keys[1:] {
+			seal, _ := crypto.Sign(prepareHash(proposalHash(block.Header()), round), key)
+			seals = append(seals, seal)
+		}
+		return seals
+	}
+	changes := [][]byte{
+		testMessage(t, keys[1], msgRoundChange, 1, 3, older, nil, certify(older, 0), 0),
+		testMessage(t, keys[2], msgRoundChange, 1, 3, newer, nil, certify(newer, 2), 2),
+		testMessage(t, keys[3], msgRoundChange, 1, 3, nil, nil, nil, 0),
+	}
+	for i, payload := range changes {
+		if err := m.handleMessage(payload); err != nil {
+			t.Fatalf("round change %d: failed to process: %v", i, err)
+		}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.round != 3 {
+		t.Fatalf("round mismatch: have %d, want 3", m.round)
+	}
+	if m.proposal == nil {
+		t.Fatalf("no proposal made")
+	}
+	if have, want := proposalHash(m.proposal.Header()), proposalHash(newer.Header()); have != want {
+		t.Errorf("proposal mismatch: have %x, want %x (locked in round 2)", have, want)
+	}
+}
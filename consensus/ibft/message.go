@@ -0,0 +1,132 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+// Consensus message codes exchanged between the validators.
+const (
+	msgPreprepare  = iota // Proposer announcing the block for the current round
+	msgPrepare            // Validator accepting the proposal of the current round
+	msgCommit             // Validator committing to the proposal prepared by a quorum
+	msgRoundChange        // Validator requesting to move on to a new round
+	msgFinal              // Proposer announcing the block committed by a quorum
+)
+
+// errInvalidMessage is returned if a consensus message cannot be decoded or its
+// signature is invalid.
+var errInvalidMessage = errors.New("invalid consensus message")
+
+// message is a signed consensus message exchanged between the validators.
+type message struct {
+	Code        uint64      // Type of the consensus message
+	Height      uint64      // Block number the message is about
+	Round       uint64      // Consensus round at the given height
+	Digest      common.Hash // Hash of the proposal being agreed on
+	Proposal    []byte      // RLP encoded block (pre-prepare, round change with a lock, final)
+	Seal        []byte      // Prepared or committed seal of the sender over the digest (prepare, commit)
+	Certificate [][]byte    // Seals of a quorum that prepared the proposal (round change with a lock)
+	LockedRound uint64      // Round the proposal was prepared in (round change with a lock)
+	Signature   []byte      // Signature of the sender over all the above fields
+
+	sender common.Address // Validator who sent the message (recovered from the signature)
+	block  *types.Block   // Decoded proposal, if any
+}
+
+// sigHash returns the hash the sender signs to authenticate the message.
+func (m *message) sigHash() []byte {
+	blob, _ := rlp.EncodeToBytes([]interface{}{m.Code, m.Height, m.Round, m.Digest, m.Proposal, m.Seal, m.Certificate, m.LockedRound})
+	return crypto.Keccak256(blob)
+}
+
+// String implements fmt.Stringer.
+func (m *message) String() string {
+	names := []string{"preprepare", "prepare", "commit", "roundchange", "final"}
+
+	name := fmt.Sprintf("unknown(%d)", m.Code)
+	if m.Code < uint64(len(names)) {
+		name = names[m.Code]
+	}
+	return fmt.Sprintf("%s{#%d r%d %x from %x}", name, m.Height, m.Round, m.Digest[:4], m.sender[:4])
+}
+
+// newMessage creates a consensus message, signing it with the given callback.
+func newMessage(code, height, round uint64, digest common.Hash, block *types.Block, seal []byte, cert [][]byte, locked uint64, sign func([]byte) ([]byte, error)) (*message, error) {
+	msg := &message{
+		Code:        code,
+		Height:      height,
+		Round:       round,
+		Digest:      digest,
+		Seal:        seal,
+		Certificate: cert,
+		LockedRound: locked,
+		block:       block,
+	}
+	if block != nil {
+		blob, err := rlp.EncodeToBytes(block)
+		if err != nil {
+			return nil, err
+		}
+		msg.Proposal = blob
+	}
+	signature, err := sign(msg.sigHash())
+	if err != nil {
+		return nil, err
+	}
+	msg.Signature = signature
+	if msg.sender, err = recoverAddress(msg.sigHash(), signature); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// decodeMessage parses a consensus message received from the network, recovering
+// its sender and decoding the proposal if it carries any.
+func decodeMessage(payload []byte) (*message, error) {
+	msg := new(message)
+	if err := rlp.DecodeBytes(payload, msg); err != nil {
+		return nil, errInvalidMessage
+	}
+	if msg.Code > msgFinal {
+		return nil, errInvalidMessage
+	}
+	sender, err := recoverAddress(msg.sigHash(), msg.Signature)
+	if err != nil {
+		return nil, errInvalidMessage
+	}
+	msg.sender = sender
+
+	if len(msg.Proposal) > 0 {
+		block := new(types.Block)
+		if err := rlp.DecodeBytes(msg.Proposal, block); err != nil {
+			return nil, errInvalidMessage
+		}
+		if block.NumberU64() != msg.Height || proposalHash(block.Header()) != msg.Digest {
+			return nil, errInvalidMessage
+		}
+		msg.block = block
+	}
+	return msg, nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/p2p/enode"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	protocolName    = "ibft"           // Name of the consensus message sub-protocol
+	protocolVersion = 1                // Version of the consensus message sub-protocol
+	protocolLength  = 1                // Number of message codes used by the sub-protocol
+	protocolMaxMsg  = 10 * 1024 * 1024 // Maximum size of a consensus message (carries whole blocks)
+
+	consensusMsg = 0x00 // Message code of a gossiped consensus message
+
+	knownMessages = 4096 // Number of recently seen messages to remember to stop gossip loops
+	peerQueueSize = 256  // Number of messages to queue per peer before dropping new ones
+)
+
+var errMsgTooLarge = errors.New("message too large")
+
+// peer is a remote node speaking the consensus sub-protocol.
+type peer struct {
+	id    enode.ID
+	rw    p2p.MsgReadWriter
+	queue chan []byte   // Consensus messages waiting to be sent to the peer
+	term  chan struct{} // Termination channel to stop the writer
+}
+
+// loop sends the queued consensus messages to the remote peer.
+func (p *peer) loop() {
+	for {
+		select {
+		case payload := <-p.queue:
+			if err := p2p.Send(p.rw, consensusMsg, payload); err != nil {
+				return
+			}
+		case <-p.term:
+			return
+		}
+	}
+}
+
+// handler is the network layer of the engine, gossiping consensus messages with
+// all the connected peers speaking the consensus sub-protocol. Messages are only
+// relayed if they pass the sanity checks of the consensus rounds.
+type handler struct {
+	deliver func([]byte) error // Callback to process a consensus message locally
+	known   *lru.Cache         // Hashes of the recently seen consensus messages
+
+	peers  map[enode.ID]*peer
+	closed bool
+	lock   sync.RWMutex
+}
+
+// newHandler creates a gossip handler delivering consensus messages received
+// from the network to the given callback.
+func newHandler(deliver func([]byte) error) *handler {
+	known, _ := lru.New(knownMessages)
+	return &handler{
+		deliver: deliver,
+		known:   known,
+		peers:   make(map[enode.ID]*peer),
+	}
+}
+
+// protocol returns the p2p sub-protocol the consensus messages are gossiped over.
+func (h *handler) protocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    protocolName,
+		Version: protocolVersion,
+		Length:  protocolLength,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return h.handle(p.ID(), rw)
+		},
+	}
+}
+
+// handle is the callback invoked to manage the life cycle of a consensus peer.
+// When this function terminates, the peer is disconnected.
+func (h *handler) handle(id enode.ID, rw p2p.MsgReadWriter) error {
+	p := &peer{
+		id:    id,
+		rw:    rw,
+		queue: make(chan []byte, peerQueueSize),
+		term:  make(chan struct{}),
+	}
+	h.lock.Lock()
+	if h.closed {
+		h.lock.Unlock()
+		return p2p.DiscQuitting
+	}
+	h.peers[id] = p
+	h.lock.Unlock()
+
+	defer func() {
+		h.lock.Lock()
+		delete(h.peers, id)
+		h.lock.Unlock()
+		close(p.term)
+	}()
+	go p.loop()
+
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		if msg.Size > protocolMaxMsg {
+			msg.Discard()
+			return errMsgTooLarge
+		}
+		if msg.Code != consensusMsg {
+			msg.Discard()
+			continue
+		}
+		var payload []byte
+		if err := msg.Decode(&payload); err != nil {
+			return err
+		}
+		h.handleMessage(id, payload)
+	}
+}
+
+// handleMessage delivers a consensus message received from a peer locally and if
+// it's well formed, relays it to all the other peers.
+func (h *handler) handleMessage(origin enode.ID, payload []byte) {
+	hash := crypto.Keccak256Hash(payload)
+	if ok, _ := h.known.ContainsOrAdd(hash, struct{}{}); ok {
+		return
+	}
+	if err := h.deliver(payload); err != nil {
+		log.Debug("Dropping invalid consensus message", "peer", origin, "err", err)
+		return
+	}
+	h.relay(hash, payload, origin)
+}
+
+// broadcast gossips a locally created consensus message to all the peers.
+func (h *handler) broadcast(payload []byte) {
+	hash := crypto.Keccak256Hash(payload)
+	h.known.Add(hash, struct{}{})
+	h.relay(hash, payload, enode.ID{})
+}
+
+// relay queues a consensus message to all the peers apart from its origin. Slow
+// peers missing messages will catch up via round changes.
+func (h *handler) relay(hash common.Hash, payload []byte, origin enode.ID) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for id, p := range h.peers {
+		if id == origin {
+			continue
+		}
+		select {
+		case p.queue <- payload:
+		default:
+			log.Trace("Consensus message queue full", "peer", id, "hash", hash)
+		}
+	}
+}
+
+// peerCount returns the number of connected consensus peers.
+func (h *handler) peerCount() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return len(h.peers)
+}
+
+// close stops accepting new consensus peers. Existing connections are torn down
+// by the p2p server on shutdown.
+func (h *handler) close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.closed = true
+}
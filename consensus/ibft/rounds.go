@@ -0,0 +1,712 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+const (
+	maxBacklog       = 1024 // Maximum number of messages about future heights or rounds to keep
+	maxSenderBacklog = 64   // Maximum number of backlogged messages of a single validator
+	maxTimeoutShift  = 4    // Maximum number of times the round timeout is doubled
+)
+
+var (
+	// errNotRunning is returned for consensus messages received before the rounds
+	// were started or after they were stopped.
+	errNotRunning = errors.New("consensus rounds not running")
+
+	// errStaleMessage is returned for consensus messages about past heights.
+	errStaleMessage = errors.New("stale consensus message")
+
+	// errUnauthorizedSender is returned for consensus messages not sent by a
+	// validator of the current height.
+	errUnauthorizedSender = errors.New("consensus message from non-validator")
+
+	// errInvalidSeal is returned if a prepare or commit message carries a seal not
+	// created by its sender.
+	errInvalidSeal = errors.New("invalid consensus message seal")
+
+	// errInvalidCertificate is returned if a round change reports a locked proposal
+	// that was not prepared by a quorum of the validators.
+	errInvalidCertificate = errors.New("invalid prepared certificate")
+)
+
+// Consensus states of a validator within a single round.
+const (
+	stateNew         = iota // Waiting for the proposal of the round
+	statePreprepared        // Proposal accepted, waiting for a quorum to prepare it
+	statePrepared           // Prepared by a quorum, waiting for a quorum to commit it
+	stateCommitted          // Committed by a quorum, waiting for the block to be imported
+)
+
+// backend is the environment the consensus rounds run in, giving access to the
+// local validator key, the chain and the network.
+type backend interface {
+	// sign signs a hash with the local validator key.
+	sign(hash []byte) ([]byte, error)
+
+	// verifyProposal checks whether a proposal is valid on top of the local chain.
+	verifyProposal(block *types.Block) error
+
+	// broadcast gossips a consensus message to the remote validators.
+	broadcast(payload []byte)
+
+	// commit imports a block the validators agreed upon into the local chain.
+	commit(block *types.Block)
+}
+
+// rounds is the round based state machine the validators run to agree on the next
+// block. Every height starts at round 0, where the round's proposer announces a
+// block (pre-prepare), the validators accept it (prepare) and once a quorum did,
+// commit to it (commit). A quorum of commits makes the block final. If a round
+// fails to commit in time, the validators move on to the next round, with a new
+// proposer (round change). Validators lock on a proposal prepared by a quorum,
+// which guarantees that no conflicting block can be committed in later rounds.
+type rounds struct {
+	backend backend
+	period  time.Duration // Minimum time between the blocks of consecutive heights
+	timeout time.Duration // Base time to wait for a round to commit
+
+	address    common.Address   // Address of the local validator (zero if not authorized)
+	head       *types.Header    // Head block the validators agree on the child of
+	height     uint64           // Number of the block being agreed on
+	round      uint64           // Current consensus round at this height
+	validators []common.Address // Validators of the current height (ascending order)
+	state      int              // Consensus state within the current round
+
+	pending     *types.Block                           // Local block to propose at the current height
+	proposal    *types.Block                           // Proposal accepted in the current round
+	locked      *types.Block                           // Proposal locked on after a quorum prepared it
+	lockedRound uint64                                 // Round the locked proposal was prepared in
+	lockedCert  [][]byte                               // Prepared or committed seals of a quorum over the locked proposal
+	prepares    map[common.Address]*message            // Prepare messages received in the current round
+	commits     map[common.Address]*message            // Commit messages received in the current round
+	changes     map[uint64]map[common.Address]*message // Round change requests per future round
+	changed     uint64                                 // Highest round the local validator requested
+	backlog     []*message                             // Messages about future heights or rounds
+	final       common.Hash                            // Digest of the last committed block handed over for import
+
+	timer    *time.Timer // Timer to request a round change if the round doesn't commit
+	proposer *time.Timer // Timer to propose the local block once its timestamp arrives
+	gen      uint64      // Generation counter to invalidate stale timers
+	closed   bool        // Whether the state machine was stopped
+
+	lock sync.Mutex
+}
+
+// newRounds creates a consensus state machine running on top of the given backend.
+func newRounds(backend backend, period time.Duration, timeout time.Duration) *rounds {
+	return &rounds{
+		backend:  backend,
+		period:   period,
+		timeout:  timeout,
+		prepares: make(map[common.Address]*message),
+		commits:  make(map[common.Address]*message),
+		changes:  make(map[uint64]map[common.Address]*message),
+	}
+}
+
+// authorize sets the address of the local validator.
+func (r *rounds) authorize(address common.Address) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.address = address
+	r.tryPropose()
+}
+
+// stop terminates all the timers of the state machine.
+func (r *rounds) stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closed = true
+	r.stopTimers()
+}
+
+// newHead moves the state machine on to the height following the given head,
+// validated by the given validators.
+func (r *rounds) newHead(head *types.Header, validators []common.Address) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return
+	}
+	if r.head != nil && (r.head.Hash() == head.Hash() || head.Number.Uint64() < r.head.Number.Uint64()) {
+		return
+	}
+	r.head, r.height, r.validators = head, head.Number.Uint64()+1, validators
+
+	r.locked, r.lockedRound, r.lockedCert, r.changed = nil, 0, nil, 0
+	r.changes = make(map[uint64]map[common.Address]*message)
+	if r.pending != nil && r.pending.ParentHash() != head.Hash() {
+		r.pending = nil
+	}
+	r.startRound(0)
+}
+
+// propose hands a locally created block to the state machine, to be proposed
+// when the local validator becomes the proposer of the current height.
+func (r *rounds) propose(block *types.Block) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.head == nil || block.ParentHash() != r.head.Hash() {
+		return
+	}
+	r.pending = block
+	r.tryPropose()
+}
+
+// handleMessage processes a consensus message received from the network. An
+// error is returned if the message is malformed or failed validation and should
+// not be relayed.
+func (r *rounds) handleMessage(payload []byte) error {
+	msg, err := decodeMessage(payload)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.process(msg)
+}
+
+// status retrieves the state of the consensus rounds at the current height.
+func (r *rounds) status() *Status {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	status := &Status{
+		Validator: r.address,
+		Height:    r.height,
+		Round:     r.round,
+	}
+	if r.head != nil && len(r.validators) > 0 {
+		status.Active = r.active()
+		status.Proposer = r.proposerOf(r.round)
+	}
+	if r.locked != nil {
+		hash := proposalHash(r.locked.Header())
+		status.Locked = &hash
+	}
+	return status
+}
+
+// active returns whether the local node is a validator of the current height.
+func (r *rounds) active() bool {
+	return r.isValidator(r.address)
+}
+
+// isValidator returns whether an address is a validator of the current height.
+func (r *rounds) isValidator(address common.Address) bool {
+	for _, validator := range r.validators {
+		if validator == address {
+			return true
+		}
+	}
+	return false
+}
+
+// proposerOf returns the validator whose turn it is to propose in a round.
+func (r *rounds) proposerOf(round uint64) common.Address {
+	return r.validators[(r.height+round)%uint64(len(r.validators))]
+}
+
+// startRound resets the round specific state and moves on to the given round.
+func (r *rounds) startRound(round uint64) {
+	r.round, r.state, r.proposal = round, stateNew, nil
+	r.prepares = make(map[common.Address]*message)
+	r.commits = make(map[common.Address]*message)
+	for number := range r.changes {
+		if number < round {
+			delete(r.changes, number)
+		}
+	}
+	if round > 0 {
+		log.Debug("IBFT round changed", "number", r.height, "round", round)
+	}
+	r.stopTimers()
+	r.gen++
+
+	if r.active() {
+		// Give the first round the time until the block is due plus the timeout
+		wait := r.timeout << minShift(round)
+		if round == 0 {
+			wait += time.Until(time.Unix(r.head.Time.Int64(), 0).Add(r.period))
+		}
+		r.schedule(wait, false)
+	}
+	r.replayBacklog()
+	r.tryPropose()
+}
+
+// minShift returns the number of times the round timeout is doubled in a round.
+func minShift(round uint64) uint64 {
+	if round > maxTimeoutShift {
+		return maxTimeoutShift
+	}
+	return round
+}
+
+// schedule arms the round timer to request a round change if the current round
+// fails to commit within the given time. If a requested round change did not
+// gather a quorum either, the timer escalates to ever later rounds.
+func (r *rounds) schedule(wait time.Duration, escalate bool) {
+	gen := r.gen
+	r.timer = time.AfterFunc(wait, func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		if r.closed || r.gen != gen {
+			return
+		}
+		next := r.round + 1
+		if escalate && r.changed >= next {
+			next = r.changed + 1
+		}
+		log.Debug("IBFT round timed out", "number", r.height, "round", r.round, "next", next)
+		r.sendRoundChange(next)
+
+		// Keep escalating until a quorum agrees on a new round
+		if r.gen == gen {
+			r.schedule(r.timeout<<minShift(r.changed), true)
+		}
+	})
+}
+
+// stopTimers disarms the round and proposal timers.
+func (r *rounds) stopTimers() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if r.proposer != nil {
+		r.proposer.Stop()
+		r.proposer = nil
+	}
+}
+
+// tryPropose announces a proposal if the local validator is the proposer of the
+// current round. Locked proposals take precedence over freshly created blocks,
+// the one locked in the highest round winning if several are known.
+func (r *rounds) tryPropose() {
+	if r.closed || r.head == nil || !r.active() || r.state != stateNew || r.proposer != nil {
+		return
+	}
+	if r.proposerOf(r.round) != r.address {
+		return
+	}
+	block := r.locked
+	if other, round := r.lockedByOthers(); other != nil && (block == nil || round > r.lockedRound) {
+		block = other
+	}
+	if block == nil {
+		block = r.pending
+	}
+	if block == nil {
+		return
+	}
+	// Fresh blocks need to wait until their timestamp arrives
+	if wait := time.Until(time.Unix(block.Time().Int64(), 0)); wait > 0 {
+		gen := r.gen
+		r.proposer = time.AfterFunc(wait, func() {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+
+			if r.gen == gen {
+				r.proposer = nil
+				r.tryPropose()
+			}
+		})
+		return
+	}
+	log.Debug("Proposing IBFT block", "number", r.height, "round", r.round, "hash", proposalHash(block.Header()))
+	r.send(msgPreprepare, r.round, proposalHash(block.Header()), block, nil, nil, 0)
+}
+
+// lockedByOthers returns the proposal other validators locked on in the highest
+// round, along with that round, as reported in their requests to move on to the
+// current round. Round changes are only accepted if their locked proposal is
+// justified by a quorum of prepares in the reported round.
+func (r *rounds) lockedByOthers() (*types.Block, uint64) {
+	var (
+		block *types.Block
+		round uint64
+	)
+	for _, msg := range r.changes[r.round] {
+		if msg.block == nil || msg.block.ParentHash() != r.head.Hash() {
+			continue
+		}
+		if block == nil || msg.LockedRound > round {
+			block, round = msg.block, msg.LockedRound
+		}
+	}
+	return block, round
+}
+
+// send signs a consensus message, gossips it to the network and processes it
+// locally too.
+func (r *rounds) send(code uint64, round uint64, digest common.Hash, block *types.Block, seal []byte, cert [][]byte, locked uint64) {
+	msg, err := newMessage(code, r.height, round, digest, block, seal, cert, locked, r.backend.sign)
+	if err != nil {
+		log.Error("Failed to sign IBFT message", "code", code, "err", err)
+		return
+	}
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		log.Error("Failed to encode IBFT message", "msg", msg, "err", err)
+		return
+	}
+	r.backend.broadcast(payload)
+	r.process(msg)
+}
+
+// process handles a single consensus message, either locally created or received
+// from the network. An error is returned if the message failed validation and
+// should not be relayed.
+func (r *rounds) process(msg *message) error {
+	if r.closed || r.head == nil {
+		return errNotRunning
+	}
+	// Drop anything stale or not sent by a validator, keep messages from the future
+	// around. Validator changes are rare, so future messages are checked against the
+	// current validators.
+	switch {
+	case msg.Height < r.height:
+		return errStaleMessage
+	case !r.isValidator(msg.sender):
+		return errUnauthorizedSender
+	case msg.Height > r.height:
+		r.store(msg)
+		return nil
+	}
+	// Committed blocks are imported by everyone, validators or not
+	if msg.Code == msgFinal {
+		return r.handleFinal(msg)
+	}
+	if err := r.verify(msg); err != nil {
+		return err
+	}
+	if !r.active() {
+		return nil
+	}
+	if msg.Code == msgRoundChange {
+		r.handleRoundChange(msg)
+		return nil
+	}
+	switch {
+	case msg.Round > r.round:
+		r.store(msg)
+		return nil
+	case msg.Round < r.round:
+		return nil
+	}
+	switch msg.Code {
+	case msgPreprepare:
+		r.handlePreprepare(msg)
+	case msgPrepare:
+		r.handlePrepare(msg)
+	case msgCommit:
+		r.handleCommit(msg)
+	}
+	return nil
+}
+
+// verify checks that a consensus message of the current height was sent by a
+// validator entitled to send it, and that it carries valid seals.
+func (r *rounds) verify(msg *message) error {
+	switch msg.Code {
+	case msgPreprepare:
+		if msg.block == nil {
+			return errInvalidMessage
+		}
+		if msg.sender != r.proposerOf(msg.Round) {
+			return errUnauthorizedProposer
+		}
+	case msgPrepare:
+		if signer, ok := r.sealer(msg.Seal, prepareHash(msg.Digest, msg.Round)); !ok || signer != msg.sender {
+			return errInvalidSeal
+		}
+	case msgCommit:
+		if signer, ok := r.sealer(msg.Seal, commitHash(msg.Digest, msg.Round)); !ok || signer != msg.sender {
+			return errInvalidSeal
+		}
+	case msgRoundChange:
+		// A locked proposal needs to be justified by a quorum having prepared it in
+		// an earlier round
+		if msg.block == nil {
+			break
+		}
+		if msg.LockedRound >= msg.Round || !r.verifyQuorum(msg.Certificate, prepareHash(msg.Digest, msg.LockedRound), commitHash(msg.Digest, msg.LockedRound)) {
+			return errInvalidCertificate
+		}
+	}
+	return nil
+}
+
+// sealer recovers the validator of the current height who created a seal over
+// any of the given hashes.
+func (r *rounds) sealer(seal []byte, hashes ...[]byte) (common.Address, bool) {
+	for _, hash := range hashes {
+		if signer, err := recoverAddress(hash, seal); err == nil && r.isValidator(signer) {
+			return signer, true
+		}
+	}
+	return common.Address{}, false
+}
+
+// verifyQuorum checks whether the seals were created by a quorum of distinct
+// validators of the current height, each signing any of the given hashes.
+func (r *rounds) verifyQuorum(seals [][]byte, hashes ...[]byte) bool {
+	if len(seals) > len(r.validators) {
+		return false
+	}
+	signers := make(map[common.Address]bool, len(seals))
+	for _, seal := range seals {
+		signer, ok := r.sealer(seal, hashes...)
+		if !ok || signers[signer] {
+			return false
+		}
+		signers[signer] = true
+	}
+	return len(signers) >= quorum(len(r.validators))
+}
+
+// store adds a message about a future height or round to the backlog. A single
+// validator may only occupy a limited share of it, so a faulty one cannot evict
+// the messages of the others.
+func (r *rounds) store(msg *message) {
+	owned, oldest := 0, -1
+	for i, stored := range r.backlog {
+		if stored.sender == msg.sender {
+			if oldest < 0 {
+				oldest = i
+			}
+			owned++
+		}
+	}
+	switch {
+	case owned >= maxSenderBacklog:
+		r.backlog = append(r.backlog[:oldest], r.backlog[oldest+1:]...)
+	case len(r.backlog) >= maxBacklog:
+		r.backlog = r.backlog[1:]
+	}
+	r.backlog = append(r.backlog, msg)
+}
+
+// replayBacklog processes all the backlogged messages that became current.
+func (r *rounds) replayBacklog() {
+	backlog := r.backlog
+	r.backlog = nil
+
+	for _, msg := range backlog {
+		switch {
+		case msg.Height < r.height:
+			continue
+		case msg.Height > r.height || (msg.Code != msgRoundChange && msg.Code != msgFinal && msg.Round > r.round):
+			r.backlog = append(r.backlog, msg)
+		default:
+			r.process(msg)
+		}
+	}
+}
+
+// handleFinal imports a block committed at the current height if it was
+// announced by the proposer of its round and carries the committed seals of a
+// quorum of the validators.
+func (r *rounds) handleFinal(msg *message) error {
+	if msg.block == nil {
+		return errInvalidMessage
+	}
+	if msg.sender != r.proposerOf(msg.Round) {
+		return errUnauthorizedProposer
+	}
+	if msg.block.ParentHash() != r.head.Hash() {
+		return errConflictingBlock
+	}
+	ext, err := decodeExtra(msg.block.Header())
+	if err != nil {
+		return err
+	}
+	if !r.verifyQuorum(ext.CommittedSeals, commitHash(msg.Digest, ext.Round)) {
+		return errInsufficientCommittedSeals
+	}
+	// Hand every committed block over for import only once
+	if r.final != msg.Digest {
+		r.final = msg.Digest
+		r.backend.commit(msg.block)
+	}
+	return nil
+}
+
+// handlePreprepare accepts the proposal of the current round if it was sent by
+// the round's proposer and is valid on top of the local chain.
+func (r *rounds) handlePreprepare(msg *message) {
+	if r.state != stateNew {
+		return
+	}
+	if msg.block.ParentHash() != r.head.Hash() {
+		return
+	}
+	// If we're locked on a different proposal, refuse and move on
+	if r.locked != nil && proposalHash(r.locked.Header()) != msg.Digest {
+		log.Debug("Rejecting IBFT proposal conflicting with lock", "number", r.height, "round", r.round, "hash", msg.Digest)
+		r.sendRoundChange(r.round + 1)
+		return
+	}
+	if err := r.backend.verifyProposal(msg.block); err != nil {
+		log.Warn("Rejecting invalid IBFT proposal", "number", r.height, "round", r.round, "hash", msg.Digest, "err", err)
+		r.sendRoundChange(r.round + 1)
+		return
+	}
+	seal, err := r.backend.sign(prepareHash(msg.Digest, r.round))
+	if err != nil {
+		log.Error("Failed to sign IBFT prepare", "err", err)
+		return
+	}
+	r.proposal, r.state = msg.block, statePreprepared
+	r.send(msgPrepare, r.round, msg.Digest, nil, seal, nil, 0)
+
+	r.checkPrepared()
+	r.checkCommitted()
+}
+
+// handlePrepare records a validator's acceptance of a proposal.
+func (r *rounds) handlePrepare(msg *message) {
+	r.prepares[msg.sender] = msg
+	r.checkPrepared()
+}
+
+// checkPrepared commits to the current proposal if a quorum prepared it, locking
+// on it with the prepared seals as justification.
+func (r *rounds) checkPrepared() {
+	if r.state != statePreprepared {
+		return
+	}
+	digest := proposalHash(r.proposal.Header())
+
+	var seals [][]byte
+	for _, validator := range r.validators {
+		if msg, ok := r.prepares[validator]; ok && msg.Digest == digest {
+			seals = append(seals, msg.Seal)
+		}
+	}
+	if len(seals) < quorum(len(r.validators)) {
+		return
+	}
+	r.state, r.locked, r.lockedRound, r.lockedCert = statePrepared, r.proposal, r.round, seals
+
+	seal, err := r.backend.sign(commitHash(digest, r.round))
+	if err != nil {
+		log.Error("Failed to sign IBFT commit", "err", err)
+		return
+	}
+	r.send(msgCommit, r.round, digest, nil, seal, nil, 0)
+}
+
+// handleCommit records a validator's commitment to a proposal.
+func (r *rounds) handleCommit(msg *message) {
+	r.commits[msg.sender] = msg
+	r.checkCommitted()
+}
+
+// checkCommitted finalizes the current proposal if a quorum committed to it. The
+// proposer of the round assembles the committed seals into the block and
+// announces it to everyone.
+func (r *rounds) checkCommitted() {
+	if r.proposal == nil || r.state == stateCommitted {
+		return
+	}
+	digest := proposalHash(r.proposal.Header())
+
+	var seals [][]byte
+	for _, validator := range r.validators {
+		if msg, ok := r.commits[validator]; ok && msg.Digest == digest {
+			seals = append(seals, msg.Seal)
+		}
+	}
+	if len(seals) < quorum(len(r.validators)) {
+		return
+	}
+	r.state, r.locked, r.lockedRound, r.lockedCert = stateCommitted, r.proposal, r.round, seals
+	log.Debug("IBFT proposal committed", "number", r.height, "round", r.round, "hash", digest)
+
+	if r.proposerOf(r.round) != r.address {
+		return
+	}
+	header := r.proposal.Header()
+	ext, err := decodeExtra(header)
+	if err != nil {
+		log.Error("Failed to decode committed IBFT block", "err", err)
+		return
+	}
+	ext.CommittedSeals, ext.Round = seals, r.round
+	if header.Extra, err = encodeExtra(header.Extra[:extraVanity], ext); err != nil {
+		log.Error("Failed to seal committed IBFT block", "err", err)
+		return
+	}
+	r.send(msgFinal, r.round, digest, r.proposal.WithSeal(header), nil, nil, 0)
+}
+
+// handleRoundChange records a validator's request to move on to a future round.
+// If enough validators want to move on for at least one of them to be honest,
+// the local validator joins them. Once a quorum does, the round changes.
+func (r *rounds) handleRoundChange(msg *message) {
+	if msg.Round <= r.round {
+		return
+	}
+	if r.changes[msg.Round] == nil {
+		r.changes[msg.Round] = make(map[common.Address]*message)
+	}
+	r.changes[msg.Round][msg.sender] = msg
+
+	count := len(r.changes[msg.Round])
+	if count >= faulty(len(r.validators))+1 && msg.Round > r.changed {
+		r.sendRoundChange(msg.Round)
+		return
+	}
+	if count >= quorum(len(r.validators)) {
+		r.startRound(msg.Round)
+	}
+}
+
+// sendRoundChange requests to move on to the given round, reporting the locked
+// proposal, if any, along with the round it was locked in and its justification
+// for the next proposer to propose again.
+func (r *rounds) sendRoundChange(round uint64) {
+	if round <= r.changed || round <= r.round {
+		return
+	}
+	r.changed = round
+
+	var digest common.Hash
+	if r.locked != nil {
+		digest = proposalHash(r.locked.Header())
+	}
+	r.send(msgRoundChange, round, digest, r.locked, nil, r.lockedCert, r.lockedRound)
+}
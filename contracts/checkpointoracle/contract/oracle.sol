@@ -0,0 +1,131 @@
+pragma solidity ^0.5.2;
+
+/// @title CheckpointOracle
+/// @notice On-chain registry of the trusted checkpoints used by light clients.
+/// New checkpoints are only accepted if signed by a threshold of the admins.
+contract CheckpointOracle {
+    /// @notice NewCheckpointVote is emitted for every admin signature of an
+    /// accepted checkpoint, so clients can verify the signers themselves.
+    event NewCheckpointVote(uint64 indexed index, bytes32 checkpointHash, uint8 v, bytes32 r, bytes32 s);
+
+    /// @param _adminlist addresses allowed to publish and sign checkpoints
+    /// @param _sectionSize number of blocks in a checkpoint section
+    /// @param _processConfirms number of confirmations before a section can be registered
+    /// @param _threshold number of admin signatures required to accept a checkpoint
+    constructor(address[] memory _adminlist, uint _sectionSize, uint _processConfirms, uint _threshold) public {
+        for (uint i = 0; i < _adminlist.length; i++) {
+            admins[_adminlist[i]] = true;
+            adminList.push(_adminlist[i]);
+        }
+        sectionSize = _sectionSize;
+        processConfirms = _processConfirms;
+        threshold = _threshold;
+    }
+
+    /// @notice Returns the latest registered checkpoint and the block number
+    /// it was registered in.
+    function GetLatestCheckpoint() view public returns (uint64, bytes32, bytes32, bytes32, uint) {
+        return (sectionIndex, sectionHead, chtRoot, bloomRoot, height);
+    }
+
+    /// @notice Returns the list of admins.
+    function GetAllAdmin() public view returns (address[] memory) {
+        address[] memory ret = new address[](adminList.length);
+        for (uint i = 0; i < adminList.length; i++) {
+            ret[i] = adminList[i];
+        }
+        return ret;
+    }
+
+    /// @notice Registers a new checkpoint signed by at least threshold admins.
+    ///
+    /// The signatures are made over keccak256(0x19 || 0x00 || oracle address ||
+    /// checkpoint hash) where the checkpoint hash is keccak256 of the tightly
+    /// packed section index, section head, CHT root and BloomTrie root. They must
+    /// be ordered by the signer addresses in ascending order.
+    ///
+    /// @param _recentNumber a recent block number, protecting against replays on forks
+    /// @param _recentHash the hash of the block at _recentNumber
+    function SetCheckpoint(
+        uint _recentNumber,
+        bytes32 _recentHash,
+        uint64 _sectionIndex,
+        bytes32 _sectionHead,
+        bytes32 _chtRoot,
+        bytes32 _bloomRoot,
+        uint8[] memory v,
+        bytes32[] memory r,
+        bytes32[] memory s
+    ) public returns (bool) {
+        // Ensure the sender is authorized.
+        require(admins[msg.sender]);
+
+        // Pin the transaction to the chain it was created on, so it cannot be
+        // replayed on other forks, accidentally or intentionally.
+        require(blockhash(_recentNumber) == _recentHash);
+
+        // Ensure the batch of signatures are valid.
+        require(v.length == r.length);
+        require(v.length == s.length);
+
+        // Filter out "future" checkpoint.
+        require(block.number >= (_sectionIndex + 1) * sectionSize + processConfirms);
+
+        // Filter out "old" announcement.
+        require(_sectionIndex >= sectionIndex);
+
+        // Filter out "stale" announcement.
+        require(_sectionIndex != sectionIndex || (_sectionIndex == 0 && height == 0));
+
+        bytes32 hash = keccak256(abi.encodePacked(_sectionIndex, _sectionHead, _chtRoot, _bloomRoot));
+        bytes32 signedHash = keccak256(abi.encodePacked(byte(0x19), byte(0), this, hash));
+
+        address lastVoter = address(0);
+
+        // In order for us not to have to maintain a mapping of who has already
+        // voted, and we don't want to count a vote twice, the signatures must
+        // be submitted in strict ordering.
+        for (uint idx = 0; idx < v.length; idx++) {
+            address signer = ecrecover(signedHash, v[idx], r[idx], s[idx]);
+            require(admins[signer]);
+            require(uint256(signer) > uint256(lastVoter));
+            lastVoter = signer;
+            emit NewCheckpointVote(_sectionIndex, hash, v[idx], r[idx], s[idx]);
+
+            // Sufficient signatures present, update latest checkpoint.
+            if (idx + 1 >= threshold) {
+                sectionIndex = _sectionIndex;
+                sectionHead = _sectionHead;
+                chtRoot = _chtRoot;
+                bloomRoot = _bloomRoot;
+                height = block.number;
+                return true;
+            }
+        }
+        // Not enough signatures, reverting un-emits the events.
+        revert();
+    }
+
+    // Map of admins who are authorized to publish and sign checkpoints.
+    mapping(address => bool) admins;
+
+    // List of all admins, used by clients to enumerate the signers.
+    address[] adminList;
+
+    // Latest stored section index and the block number it was registered in.
+    uint64 sectionIndex;
+    uint height;
+
+    // Roots of the latest registered checkpoint.
+    bytes32 sectionHead;
+    bytes32 chtRoot;
+    bytes32 bloomRoot;
+
+    // The number of blocks in a section and the confirmations required before
+    // a section may be registered.
+    uint sectionSize;
+    uint processConfirms;
+
+    // The number of admin signatures required to register a checkpoint.
+    uint threshold;
+}
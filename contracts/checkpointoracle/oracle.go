@@ -0,0 +1,138 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package checkpointoracle is a wrapper of the checkpoint oracle contract, an
+// on-chain registry of light client checkpoints approved by multiple admins.
+package checkpointoracle
+
+//go:generate abigen --sol contract/oracle.sol --pkg contract --out contract/oracle.go
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/severeum/go-severeum/accounts/abi/bind"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/contracts/checkpointoracle/contract"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/params"
+)
+
+var errInvalidSignature = errors.New("invalid checkpoint signature")
+
+// CheckpointOracle is a Go wrapper around an on-chain checkpoint oracle contract.
+type CheckpointOracle struct {
+	address  common.Address
+	contract *contract.CheckpointOracle
+}
+
+// NewCheckpointOracle binds checkpoint contract and returns a registrar instance.
+func NewCheckpointOracle(contractAddr common.Address, backend bind.ContractBackend) (*CheckpointOracle, error) {
+	c, err := contract.NewCheckpointOracle(contractAddr, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckpointOracle{address: contractAddr, contract: c}, nil
+}
+
+// ContractAddr returns the address of the oracle contract.
+func (oracle *CheckpointOracle) ContractAddr() common.Address {
+	return oracle.address
+}
+
+// Contract returns the underlying contract instance.
+func (oracle *CheckpointOracle) Contract() *contract.CheckpointOracle {
+	return oracle.contract
+}
+
+// SignatureHash returns the hash the admins of the oracle deployed at the given
+// address sign to approve a checkpoint, following EIP 191 version 0 (data with
+// intended validator): keccak256(0x19 || 0x00 || oracle address || checkpoint hash).
+func SignatureHash(oracle common.Address, checkpoint common.Hash) common.Hash {
+	return crypto.Keccak256Hash([]byte{0x19, 0x00}, oracle.Bytes(), checkpoint.Bytes())
+}
+
+// SignCheckpoint signs a checkpoint for the oracle deployed at the given address,
+// returning the signature in the [R || S || V] format where V is 0 or 1.
+func SignCheckpoint(oracle common.Address, checkpoint *params.TrustedCheckpoint, sign func(hash []byte) ([]byte, error)) ([]byte, error) {
+	hash := SignatureHash(oracle, checkpoint.Hash())
+	return sign(hash[:])
+}
+
+// RecoverSigner returns the address of the admin which signed a checkpoint for
+// the oracle deployed at the given address.
+func RecoverSigner(oracle common.Address, checkpoint common.Hash, sig []byte) (common.Address, error) {
+	if len(sig) != 65 {
+		return common.Address{}, errInvalidSignature
+	}
+	hash := SignatureHash(oracle, checkpoint)
+	pubkey, err := crypto.SigToPub(hash[:], sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
+
+// LookupCheckpointEvents searches the admin votes emitted in the given range
+// of blocks for the checkpoint of the specified section with the given hash.
+func (oracle *CheckpointOracle) LookupCheckpointEvents(opts *bind.FilterOpts, section uint64, hash common.Hash) ([]*contract.CheckpointOracleNewCheckpointVote, error) {
+	it, err := oracle.contract.FilterNewCheckpointVote(opts, []uint64{section})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var votes []*contract.CheckpointOracleNewCheckpointVote
+	for it.Next() {
+		if common.Hash(it.Event.CheckpointHash) == hash {
+			votes = append(votes, it.Event)
+		}
+	}
+	return votes, it.Error()
+}
+
+// RegisterCheckpoint registers the checkpoint with a batch of admin signatures,
+// ordered by the signer addresses in ascending order. The recent block number
+// and hash pin the transaction to the current chain, so it cannot be replayed
+// on other forks.
+//
+// The signatures are expected in the [R || S || V] format where V is 0 or 1.
+func (oracle *CheckpointOracle) RegisterCheckpoint(opts *bind.TransactOpts, checkpoint *params.TrustedCheckpoint, recentNumber *big.Int, recentHash common.Hash, sigs [][]byte) (*types.Transaction, error) {
+	var (
+		r [][32]byte
+		s [][32]byte
+		v []uint8
+	)
+	for _, sig := range sigs {
+		if len(sig) != 65 {
+			return nil, errInvalidSignature
+		}
+		r = append(r, common.BytesToHash(sig[:32]))
+		s = append(s, common.BytesToHash(sig[32:64]))
+		v = append(v, sig[64]+27)
+	}
+	return oracle.contract.SetCheckpoint(opts, recentNumber, recentHash, checkpoint.SectionIndex, checkpoint.SectionHead, checkpoint.CHTRoot, checkpoint.BloomRoot, v, r, s)
+}
+
+// VoteSignature reconstructs the [R || S || V] signature of an admin vote.
+func VoteSignature(vote *contract.CheckpointOracleNewCheckpointVote) []byte {
+	sig := make([]byte, 65)
+	copy(sig, vote.R[:])
+	copy(sig[32:], vote.S[:])
+	sig[64] = vote.V - 27
+	return sig
+}
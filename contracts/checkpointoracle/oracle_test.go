@@ -0,0 +1,204 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package checkpointoracle
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/severeum/go-severeum/accounts/abi/bind"
+	"github.com/severeum/go-severeum/accounts/abi/bind/backends"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/contracts/checkpointoracle/contract"
+	"github.com/severeum/go-severeum/core"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/params"
+)
+
+const (
+	testSectionSize     = 4
+	testProcessConfirms = 2
+)
+
+// testAccount is an oracle admin used by the tests.
+type testAccount struct {
+	key  *ecdsa.PrivateKey
+	addr common.Address
+}
+
+// newTestAccounts generates n admin accounts sorted by their addresses.
+func newTestAccounts(n int) []testAccount {
+	accounts := make([]testAccount, n)
+	for i := range accounts {
+		key, _ := crypto.GenerateKey()
+		accounts[i] = testAccount{key: key, addr: crypto.PubkeyToAddress(key.PublicKey)}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return bytes.Compare(accounts[i].addr[:], accounts[j].addr[:]) < 0
+	})
+	return accounts
+}
+
+// sign collects the signatures of the given admins on a checkpoint.
+func sign(t *testing.T, oracle common.Address, cp *params.TrustedCheckpoint, signers []testAccount) [][]byte {
+	var sigs [][]byte
+	for _, signer := range signers {
+		sig, err := SignCheckpoint(oracle, cp, func(hash []byte) ([]byte, error) {
+			return crypto.Sign(hash, signer.key)
+		})
+		if err != nil {
+			t.Fatalf("failed to sign checkpoint: %v", err)
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+func TestCheckpointRegister(t *testing.T) {
+	var (
+		admins  = newTestAccounts(3)
+		alloc   = core.GenesisAlloc{}
+		signers []common.Address
+	)
+	for _, admin := range admins {
+		alloc[admin.addr] = core.GenesisAccount{Balance: big.NewInt(1000000000000000000)}
+		signers = append(signers, admin.addr)
+	}
+	backend := backends.NewSimulatedBackend(alloc, 10000000)
+	transactOpts := bind.NewKeyedTransactor(admins[0].key)
+
+	addr, _, _, err := contract.DeployCheckpointOracle(transactOpts, backend, signers, big.NewInt(testSectionSize), big.NewInt(testProcessConfirms), big.NewInt(2))
+	if err != nil {
+		t.Fatalf("failed to deploy oracle: %v", err)
+	}
+	backend.Commit()
+
+	oracle, err := NewCheckpointOracle(addr, backend)
+	if err != nil {
+		t.Fatalf("failed to bind oracle: %v", err)
+	}
+	list, err := oracle.Contract().GetAllAdmin(nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve admins: %v", err)
+	}
+	if len(list) != len(signers) {
+		t.Fatalf("admin count mismatch: have %d, want %d", len(list), len(signers))
+	}
+	for i := range list {
+		if list[i] != signers[i] {
+			t.Fatalf("admin %d mismatch: have %x, want %x", i, list[i], signers[i])
+		}
+	}
+	cp := &params.TrustedCheckpoint{
+		SectionIndex: 0,
+		SectionHead:  common.HexToHash("0x01"),
+		CHTRoot:      common.HexToHash("0x02"),
+		BloomRoot:    common.HexToHash("0x03"),
+	}
+	// register tries to register a checkpoint, reporting whether it was accepted.
+	register := func(opts *bind.TransactOpts, cp *params.TrustedCheckpoint, sigs [][]byte) bool {
+		head := backend.Blockchain().CurrentHeader()
+		tx, err := oracle.RegisterCheckpoint(opts, cp, head.Number, head.Hash(), sigs)
+		if err != nil {
+			return false
+		}
+		backend.Commit()
+		receipt, _ := backend.TransactionReceipt(context.Background(), tx.Hash())
+		return receipt != nil && receipt.Status == 1
+	}
+	// The section must be old enough to be registered
+	if register(transactOpts, cp, sign(t, addr, cp, admins[:2])) {
+		t.Fatalf("future checkpoint registered")
+	}
+	for backend.Blockchain().CurrentHeader().Number.Uint64() < testSectionSize+testProcessConfirms {
+		backend.Commit()
+	}
+	// Insufficient, unordered, duplicated, foreign or mismatching signatures must be rejected
+	if register(transactOpts, cp, sign(t, addr, cp, admins[:1])) {
+		t.Fatalf("checkpoint registered below the threshold")
+	}
+	if register(transactOpts, cp, sign(t, addr, cp, []testAccount{admins[1], admins[0]})) {
+		t.Fatalf("checkpoint registered with unordered signatures")
+	}
+	if register(transactOpts, cp, sign(t, addr, cp, []testAccount{admins[1], admins[1]})) {
+		t.Fatalf("checkpoint registered with duplicated signatures")
+	}
+	if register(transactOpts, cp, sign(t, addr, cp, newTestAccounts(2))) {
+		t.Fatalf("checkpoint registered with foreign signatures")
+	}
+	if register(transactOpts, cp, sign(t, addr, &params.TrustedCheckpoint{SectionIndex: 0}, admins[:2])) {
+		t.Fatalf("checkpoint registered with mismatching signatures")
+	}
+	outsider := newTestAccounts(1)[0]
+	if register(bind.NewKeyedTransactor(outsider.key), cp, sign(t, addr, cp, admins[:2])) {
+		t.Fatalf("checkpoint registered by non-admin")
+	}
+	// Valid signatures of any admin subset must be accepted
+	if !register(transactOpts, cp, sign(t, addr, cp, admins[1:])) {
+		t.Fatalf("valid checkpoint rejected")
+	}
+	height := backend.Blockchain().CurrentHeader().Number
+	index, head, cht, bloom, number, err := oracle.Contract().GetLatestCheckpoint(nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve checkpoint: %v", err)
+	}
+	stored := &params.TrustedCheckpoint{SectionIndex: index, SectionHead: head, CHTRoot: cht, BloomRoot: bloom}
+	if stored.Hash() != cp.Hash() || number.Cmp(height) != 0 {
+		t.Fatalf("checkpoint mismatch: have %+v at %d, want %+v at %d", stored, number, cp, height)
+	}
+	// The votes must be retrievable and recover to the signers
+	end := height.Uint64()
+	votes, err := oracle.LookupCheckpointEvents(&bind.FilterOpts{Start: end, End: &end}, cp.SectionIndex, cp.Hash())
+	if err != nil {
+		t.Fatalf("failed to look up votes: %v", err)
+	}
+	if len(votes) != 2 {
+		t.Fatalf("vote count mismatch: have %d, want %d", len(votes), 2)
+	}
+	for i, vote := range votes {
+		signer, err := RecoverSigner(addr, cp.Hash(), VoteSignature(vote))
+		if err != nil {
+			t.Fatalf("failed to recover signer: %v", err)
+		}
+		if signer != admins[i+1].addr {
+			t.Fatalf("vote %d signer mismatch: have %x, want %x", i, signer, admins[i+1].addr)
+		}
+	}
+	// Stale checkpoints must be rejected, newer ones accepted once old enough
+	if register(transactOpts, cp, sign(t, addr, cp, admins[:2])) {
+		t.Fatalf("stale checkpoint registered")
+	}
+	next := &params.TrustedCheckpoint{
+		SectionIndex: 1,
+		SectionHead:  common.HexToHash("0x04"),
+		CHTRoot:      common.HexToHash("0x05"),
+		BloomRoot:    common.HexToHash("0x06"),
+	}
+	for backend.Blockchain().CurrentHeader().Number.Uint64() < 2*testSectionSize+testProcessConfirms {
+		backend.Commit()
+	}
+	if !register(transactOpts, next, sign(t, addr, next, admins)) {
+		t.Fatalf("newer checkpoint rejected")
+	}
+	if index, _, _, _, _, _ := oracle.Contract().GetLatestCheckpoint(nil); index != next.SectionIndex {
+		t.Fatalf("section index mismatch: have %d, want %d", index, next.SectionIndex)
+	}
+}
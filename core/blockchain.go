@@ -53,6 +53,8 @@ var (
 	blockWriteTimer      = metrics.NewRegisteredTimer("chain/write", nil)
 
 	ErrNoGenesis = errors.New("Genesis not found in chain")
+
+	historyExpiryBatch = uint64(8192) // Maximum number of blocks to expire the history of in one go
 )
 
 const (
@@ -75,6 +77,9 @@ type CacheConfig struct {
 	TrieCleanLimit int           // Memory allowance (MB) to use for caching trie nodes in memory
 	TrieDirtyLimit int           // Memory limit (MB) at which to start flushing dirty trie nodes to disk
 	TrieTimeLimit  time.Duration // Time limit after which to flush the current in-memory trie to disk
+
+	HistoryRetention uint64 // Number of recent blocks to keep bodies and receipts for (0 = keep everything)
+	TxLookupLimit    uint64 // Number of recent blocks to index transactions for (0 = entire chain)
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -114,6 +119,8 @@ type BlockChain struct {
 	checkpoint       int          // checkpoint counts towards the new checkpoint
 	currentBlock     atomic.Value // Current head of the block chain
 	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
+	historyTail      uint64       // Oldest block with a local header and body (0 = full history, atomic)
+	historyHorizon   uint64       // Oldest block whose body and receipts weren't expired (atomic)
 
 	stateCache    state.Database // State database to reuse between imports (contains state cache)
 	bodyCache     *lru.Cache     // Cache for the most recent block bodies
@@ -187,6 +194,11 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	if err := bc.loadLastState(); err != nil {
 		return nil, err
 	}
+	if tail := rawdb.ReadHistoryTail(db); tail > 0 {
+		log.Info("Chain history incomplete", "tail", tail)
+		bc.historyTail = tail
+	}
+	bc.historyHorizon = rawdb.ReadHistoryHorizon(db)
 	// Check the current state of the block hashes and make sure that we do not have any of the bad blocks in our chain
 	for hash := range BadHashes {
 		if header := bc.GetHeaderByHash(hash); header != nil {
@@ -202,6 +214,9 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	}
 	// Take ownership of this particular state
 	go bc.update()
+
+	bc.wg.Add(1)
+	go bc.maintainTxIndex()
 	return bc, nil
 }
 
@@ -710,10 +725,10 @@ func (bc *BlockChain) Stop() {
 
 		for _, offset := range []uint64{0, 1, triesInMemory - 1} {
 			if number := bc.CurrentBlock().NumberU64(); number > offset {
-				recent := bc.GetBlockByNumber(number - offset)
+				recent := bc.GetHeaderByNumber(number - offset)
 
-				log.Info("Writing cached state to disk", "block", recent.Number(), "hash", recent.Hash(), "root", recent.Root())
-				if err := triedb.Commit(recent.Root(), true); err != nil {
+				log.Info("Writing cached state to disk", "block", recent.Number, "hash", recent.Hash(), "root", recent.Root)
+				if err := triedb.Commit(recent.Root, true); err != nil {
 					log.Error("Failed to commit recent state trie", "err", err)
 				}
 			}
@@ -906,6 +921,217 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 	return 0, nil
 }
 
+// HistoryTail returns the number of the oldest block whose header and body are
+// available locally, or zero if the entire chain history is present.
+func (bc *BlockChain) HistoryTail() uint64 {
+	return atomic.LoadUint64(&bc.historyTail)
+}
+
+// HistoryHorizon returns the number of the oldest block whose body and receipts
+// are retained by history expiry, or zero if the history is kept indefinitely.
+// Blocks below the horizon only have their headers available locally.
+func (bc *BlockChain) HistoryHorizon() uint64 {
+	horizon := atomic.LoadUint64(&bc.historyHorizon)
+	if target := bc.expiryTarget(); target > horizon {
+		horizon = target
+	}
+	return horizon
+}
+
+// expiryTarget returns the block number below which the bodies and receipts of
+// the canonical chain should be deleted, according to the retention setting.
+func (bc *BlockChain) expiryTarget() uint64 {
+	retention := bc.cacheConfig.HistoryRetention
+	if retention == 0 {
+		return 0
+	}
+	if head := bc.CurrentBlock().NumberU64(); head > retention {
+		return head - retention
+	}
+	return 0
+}
+
+// expireHistory deletes the bodies, receipts and transaction lookup entries of
+// a batch of canonical blocks below the retention horizon, keeping the headers.
+func (bc *BlockChain) expireHistory() {
+	target := bc.expiryTarget()
+	horizon := atomic.LoadUint64(&bc.historyHorizon)
+	if horizon >= target {
+		return
+	}
+	if horizon == 0 {
+		horizon = 1 // Never expire the genesis block
+	}
+	if target-horizon > historyExpiryBatch {
+		target = horizon + historyExpiryBatch
+	}
+	var (
+		batch   = bc.db.NewBatch()
+		start   = time.Now()
+		deleted int
+	)
+	for number := horizon; number < target; number++ {
+		hash := rawdb.ReadCanonicalHash(bc.db, number)
+		if hash == (common.Hash{}) {
+			continue
+		}
+		if body := rawdb.ReadBody(bc.db, hash, number); body != nil {
+			for _, tx := range body.Transactions {
+				rawdb.DeleteTxLookupEntry(batch, tx.Hash())
+			}
+			deleted++
+		}
+		rawdb.DeleteBody(batch, hash, number)
+		rawdb.DeleteReceipts(batch, hash, number)
+
+		bc.bodyCache.Remove(hash)
+		bc.bodyRLPCache.Remove(hash)
+		bc.receiptsCache.Remove(hash)
+		bc.blockCache.Remove(hash)
+	}
+	rawdb.WriteHistoryHorizon(batch, target)
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to expire chain history", "err", err)
+		return
+	}
+	atomic.StoreUint64(&bc.historyHorizon, target)
+
+	log.Debug("Expired chain history", "blocks", deleted, "horizon", target, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
+// InsertCheckpoint anchors an empty chain at a trusted checkpoint block, making
+// it the head header and head fast block without any of its ancestors. The block
+// and receipts must be verified against the checkpoint hash by the caller, the
+// total difficulty is taken as is.
+//
+// The ancestors of the checkpoint can be filled in afterwards by InsertBackfillChain.
+func (bc *BlockChain) InsertCheckpoint(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	bc.wg.Add(1)
+	defer bc.wg.Done()
+
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	// Only allow anchoring a chain which doesn't have anything to lose
+	if head := bc.CurrentHeader().Number.Uint64(); head >= block.NumberU64() || bc.CurrentBlock().NumberU64() > 0 {
+		return fmt.Errorf("chain not empty: head header #%d, checkpoint #%d", head, block.NumberU64())
+	}
+	if block.NumberU64() == 0 {
+		return errors.New("checkpoint at genesis")
+	}
+	if err := SetReceiptsData(bc.chainConfig, block, receipts); err != nil {
+		return fmt.Errorf("failed to set receipts data: %v", err)
+	}
+	batch := bc.db.NewBatch()
+	rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), td)
+	rawdb.WriteBlock(batch, block)
+	rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), receipts)
+	rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+	rawdb.WriteTxLookupEntries(batch, block)
+	rawdb.WriteHeadFastBlockHash(batch, block.Hash())
+	rawdb.WriteHistoryTail(batch, block.NumberU64())
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	bc.hc.SetCurrentHeader(block.Header())
+	bc.currentFastBlock.Store(block)
+	atomic.StoreUint64(&bc.historyTail, block.NumberU64())
+
+	log.Info("Anchored chain at checkpoint", "number", block.Number(), "hash", block.Hash(), "td", td)
+	return nil
+}
+
+// InsertBackfillChain inserts a batch of historical blocks below the history
+// tail, extending the locally available history backwards. The blocks must be
+// ordered, contiguous and end at the parent of the current tail block. Since the
+// hash chain links them to a trusted block, no consensus verification is done.
+func (bc *BlockChain) InsertBackfillChain(chain types.Blocks) (int, error) {
+	bc.wg.Add(1)
+	defer bc.wg.Done()
+
+	if len(chain) == 0 {
+		return 0, nil
+	}
+	// Do a sanity check that the provided chain is actually ordered and linked
+	for i := 1; i < len(chain); i++ {
+		if chain[i].NumberU64() != chain[i-1].NumberU64()+1 || chain[i].ParentHash() != chain[i-1].Hash() {
+			return i, fmt.Errorf("non contiguous insert: item %d is #%d [%x…], item %d is #%d [%x…] (parent [%x…])", i-1, chain[i-1].NumberU64(),
+				chain[i-1].Hash().Bytes()[:4], i, chain[i].NumberU64(), chain[i].Hash().Bytes()[:4], chain[i].ParentHash().Bytes()[:4])
+		}
+	}
+	// Ensure the batch links up to the current tail and the bodies belong to the headers
+	tail := bc.HistoryTail()
+	if tail == 0 {
+		return 0, errors.New("chain history already complete")
+	}
+	child := bc.GetHeaderByNumber(tail)
+	last := chain[len(chain)-1]
+	if last.NumberU64()+1 != tail || last.Hash() != child.ParentHash {
+		return len(chain) - 1, fmt.Errorf("batch not linked to tail: have #%d [%x…], want #%d [%x…]", last.NumberU64(), last.Hash().Bytes()[:4], tail-1, child.ParentHash.Bytes()[:4])
+	}
+	if first := chain[0]; first.NumberU64() == 0 {
+		return 0, errors.New("genesis in backfill batch")
+	} else if first.NumberU64() == 1 && first.ParentHash() != bc.genesisBlock.Hash() {
+		return 0, fmt.Errorf("backfilled chain not rooted in local genesis: have [%x…], want [%x…]", first.ParentHash().Bytes()[:4], bc.genesisBlock.Hash().Bytes()[:4])
+	}
+	// Verify the bodies, skipping those which are beyond the retention horizon and
+	// will thus be discarded (they might not have been retrieved at all)
+	horizon := bc.HistoryHorizon()
+	for i, block := range chain {
+		if block.NumberU64() < horizon {
+			continue
+		}
+		if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+			return i, fmt.Errorf("transaction root hash mismatch: have %x, want %x", hash, block.TxHash())
+		}
+		if hash := types.CalcUncleHash(block.Uncles()); hash != block.UncleHash() {
+			return i, fmt.Errorf("uncle root hash mismatch: have %x, want %x", hash, block.UncleHash())
+		}
+	}
+	// Derive the total difficulties backwards from the tail and write everything
+	// out, keeping only the headers of blocks beyond the retention horizon
+	var (
+		indexTail = rawdb.ReadTxIndexTail(bc.db)
+		td        = new(big.Int).Set(bc.GetTd(child.Hash(), tail))
+		diff      = child.Difficulty
+		batch     = bc.db.NewBatch()
+		start     = time.Now()
+	)
+	for i := len(chain) - 1; i >= 0; i-- {
+		block := chain[i]
+
+		td.Sub(td, diff)
+		rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), td)
+		if block.NumberU64() < horizon {
+			rawdb.WriteHeader(batch, block.Header())
+		} else {
+			rawdb.WriteBlock(batch, block)
+			if indexTail == nil || block.NumberU64() >= *indexTail {
+				rawdb.WriteTxLookupEntries(batch, block)
+			}
+		}
+		rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+
+		diff = block.Difficulty()
+	}
+	tail = chain[0].NumberU64()
+	if tail == 1 {
+		tail = 0
+	}
+	rawdb.WriteHistoryTail(batch, tail)
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	atomic.StoreUint64(&bc.historyTail, tail)
+
+	log.Debug("Backfilled chain history", "count", len(chain), "elapsed", common.PrettyDuration(time.Since(start)),
+		"number", chain[0].Number(), "hash", chain[0].Hash())
+	if tail == 0 {
+		log.Info("Chain history backfill complete")
+	}
+	return 0, nil
+}
+
 var lastWrite uint64
 
 // WriteBlockWithoutState writes only the block and its metadata to the database,
@@ -1536,6 +1762,7 @@ func (bc *BlockChain) update() {
 		select {
 		case <-futureTimer.C:
 			bc.procFutureBlocks()
+			bc.expireHistory()
 		case <-bc.quit:
 			return
 		}
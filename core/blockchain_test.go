@@ -767,6 +767,236 @@ func TestLightVsFastVsFullChainHeads(t *testing.T) {
 	assert(t, "light", light, height/2, 0, 0)
 }
 
+// Tests that a chain can be anchored at a checkpoint block and its history be
+// backfilled afterwards, ending up identical to a fully imported chain.
+func TestCheckpointBackfill(t *testing.T) {
+	// Configure and generate a sample block chain
+	var (
+		gendb   = ethdb.NewMemDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: funds}},
+		}
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blocks, receipts := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), gendb, 256, func(i int, block *BlockGen) {
+		if i%3 == 2 {
+			tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+			if err != nil {
+				panic(err)
+			}
+			block.AddTx(tx)
+		}
+	})
+	archiveDb := ethdb.NewMemDatabase()
+	gspec.MustCommit(archiveDb)
+	archive, _ := NewBlockChain(archiveDb, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer archive.Stop()
+
+	if n, err := archive.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	// Anchor a new chain at a checkpoint and fast import the blocks after it
+	db := ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+	chain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer chain.Stop()
+
+	cp := blocks[127]
+	if err := chain.InsertCheckpoint(cp, receipts[127], archive.GetTdByHash(cp.Hash())); err != nil {
+		t.Fatalf("failed to insert checkpoint: %v", err)
+	}
+	if tail := chain.HistoryTail(); tail != cp.NumberU64() {
+		t.Fatalf("history tail mismatch: have %d, want %d", tail, cp.NumberU64())
+	}
+	if err := chain.InsertCheckpoint(cp, receipts[127], archive.GetTdByHash(cp.Hash())); err == nil {
+		t.Fatalf("checkpoint inserted into non-empty chain")
+	}
+	headers := make([]*types.Header, 0, len(blocks)-128)
+	for _, block := range blocks[128:] {
+		headers = append(headers, block.Header())
+	}
+	if n, err := chain.InsertHeaderChain(headers, 1); err != nil {
+		t.Fatalf("failed to insert header %d: %v", n, err)
+	}
+	if n, err := chain.InsertReceiptChain(blocks[128:], receipts[128:]); err != nil {
+		t.Fatalf("failed to insert receipt %d: %v", n, err)
+	}
+	if head := chain.CurrentFastBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("fast head mismatch: have #%d, want #%d", head.NumberU64(), blocks[len(blocks)-1].NumberU64())
+	}
+	// Backfill the history in batches, rejecting ones that don't link up
+	if _, err := chain.InsertBackfillChain(blocks[64:126]); err == nil {
+		t.Fatalf("unlinked backfill batch accepted")
+	}
+	for end := 127; end > 0; end -= 50 {
+		start := end - 50
+		if start < 0 {
+			start = 0
+		}
+		if n, err := chain.InsertBackfillChain(blocks[start:end]); err != nil {
+			t.Fatalf("failed to backfill block %d: %v", start+n, err)
+		}
+	}
+	if tail := chain.HistoryTail(); tail != 0 {
+		t.Fatalf("history tail mismatch after backfill: have %d, want 0", tail)
+	}
+	if tail := rawdb.ReadHistoryTail(db); tail != 0 {
+		t.Fatalf("persisted history tail mismatch after backfill: have %d, want 0", tail)
+	}
+	for i := 0; i < len(blocks); i++ {
+		num, hash := blocks[i].NumberU64(), blocks[i].Hash()
+
+		if td, want := chain.GetTdByHash(hash), archive.GetTdByHash(hash); td == nil || td.Cmp(want) != 0 {
+			t.Errorf("block #%d [%x]: td mismatch: have %v, want %v", num, hash, td, want)
+		}
+		if have := chain.GetBlockByNumber(num); have == nil || have.Hash() != hash {
+			t.Errorf("block #%d [%x]: canonical block missing", num, hash)
+		} else if types.DeriveSha(have.Transactions()) != blocks[i].TxHash() {
+			t.Errorf("block #%d [%x]: transactions mismatch", num, hash)
+		}
+		for _, tx := range blocks[i].Transactions() {
+			if _, blockHash, _, _ := rawdb.ReadTransaction(db, tx.Hash()); blockHash != hash {
+				t.Errorf("block #%d [%x]: transaction %x not indexed", num, hash, tx.Hash())
+			}
+		}
+	}
+}
+
+// Tests that history expiry deletes the bodies, receipts and transaction lookup
+// entries of old blocks in batches, while retaining their headers.
+func TestHistoryExpiry(t *testing.T) {
+	// Configure and generate a sample block chain
+	var (
+		db      = ethdb.NewMemDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: funds}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 256, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	// Import the chain with a retention shorter than the chain itself
+	db = ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+
+	cacheConfig := &CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: 5 * time.Minute, HistoryRetention: 100}
+	chain, _ := NewBlockChain(db, cacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	if horizon := chain.HistoryHorizon(); horizon != 156 {
+		t.Fatalf("history horizon mismatch: have %d, want %d", horizon, 156)
+	}
+	// Expire the history in multiple batches and check what remains
+	historyExpiryBatch = 64
+	defer func() { historyExpiryBatch = 8192 }()
+
+	for i := 0; i < 3; i++ {
+		chain.expireHistory()
+	}
+	if horizon := rawdb.ReadHistoryHorizon(db); horizon != 156 {
+		t.Fatalf("persisted history horizon mismatch: have %d, want %d", horizon, 156)
+	}
+	for _, block := range blocks {
+		num, hash := block.NumberU64(), block.Hash()
+		expired := num < 156
+
+		if chain.GetHeaderByNumber(num) == nil {
+			t.Errorf("block #%d [%x]: header missing", num, hash)
+		}
+		if have := rawdb.ReadBody(db, hash, num) == nil; have != expired {
+			t.Errorf("block #%d [%x]: body expiry mismatch: have %v, want %v", num, hash, have, expired)
+		}
+		if have := rawdb.ReadReceipts(db, hash, num) == nil; have != expired {
+			t.Errorf("block #%d [%x]: receipts expiry mismatch: have %v, want %v", num, hash, have, expired)
+		}
+		for _, tx := range block.Transactions() {
+			if have, _, _, _ := rawdb.ReadTransaction(db, tx.Hash()); (have == nil) != expired {
+				t.Errorf("block #%d [%x]: transaction lookup expiry mismatch: have %v, want %v", num, hash, have == nil, expired)
+			}
+		}
+	}
+}
+
+// Tests that the transaction index is limited to the most recent blocks in the
+// background, both when enabling the limit on an existing database and when
+// changing it later on.
+func TestTxIndexLimit(t *testing.T) {
+	// Configure and generate a sample block chain
+	var (
+		db      = ethdb.NewMemDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: funds}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 128, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	db = ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+
+	// Import the chain without any limit and reopen it with a number of limits
+	check := func(limit uint64, tail uint64) {
+		cacheConfig := &CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: 5 * time.Minute, TxLookupLimit: limit}
+		chain, err := NewBlockChain(db, cacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+		if err != nil {
+			t.Fatalf("limit %d: failed to create chain: %v", limit, err)
+		}
+		defer chain.Stop()
+
+		if chain.CurrentBlock().NumberU64() == 0 {
+			if n, err := chain.InsertChain(blocks); err != nil {
+				t.Fatalf("limit %d: failed to process block %d: %v", limit, n, err)
+			}
+		}
+		for start := time.Now(); rawdb.ReadTxIndexTail(db) == nil || *rawdb.ReadTxIndexTail(db) != tail; time.Sleep(10 * time.Millisecond) {
+			if time.Since(start) > 3*time.Second {
+				t.Fatalf("limit %d: index tail mismatch: have %d, want %d", limit, chain.TxIndexTail(), tail)
+			}
+		}
+		for _, block := range blocks {
+			for _, tx := range block.Transactions() {
+				have, _, _, _ := rawdb.ReadTransaction(db, tx.Hash())
+				if indexed := block.NumberU64() >= tail; (have != nil) != indexed {
+					t.Errorf("limit %d: block #%d: transaction indexed mismatch: have %v, want %v", limit, block.NumberU64(), have != nil, indexed)
+				}
+			}
+		}
+	}
+	check(0, 0)
+	check(32, 97)
+	check(64, 65)
+	check(16, 113)
+	check(0, 0)
+}
+
 // Tests that chain reorganisations handle transaction removals and reinsertions.
 func TestChainTxReorgs(t *testing.T) {
 	var (
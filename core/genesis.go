@@ -366,6 +366,22 @@ func DeveloperGenesisBlock(period uint64, faucet common.Address) *Genesis {
 	}
 }
 
+// DeveloperSevashGenesisBlock returns the 'seth --dev --dev.pow' genesis block,
+// sealed by proof-of-work with the given fixed fake difficulty.
+func DeveloperSevashGenesisBlock(difficulty uint64, faucet common.Address) *Genesis {
+	if difficulty == 0 {
+		difficulty = 1
+	}
+	config := *params.AllSevashProtocolChanges
+
+	genesis := DeveloperGenesisBlock(0, faucet)
+	genesis.Config = &config
+	genesis.ExtraData = nil
+	genesis.Difficulty = new(big.Int).SetUint64(difficulty)
+
+	return genesis
+}
+
 func decodePrealloc(data string) GenesisAlloc {
 	var p []struct{ Addr, Balance *big.Int }
 	if err := rlp.NewStream(strings.NewReader(data), 0).Decode(&p); err != nil {
@@ -126,6 +126,42 @@ func WriteFastTrieProgress(db DatabaseWriter, count uint64) {
 	}
 }
 
+// ReadHistoryTail retrieves the number of the oldest block whose header and body
+// are available locally, or zero if the entire chain history is present.
+func ReadHistoryTail(db DatabaseReader) uint64 {
+	data, _ := db.Get(historyTailKey)
+	if len(data) == 0 {
+		return 0
+	}
+	return new(big.Int).SetBytes(data).Uint64()
+}
+
+// WriteHistoryTail stores the number of the oldest block whose header and body
+// are available locally.
+func WriteHistoryTail(db DatabaseWriter, number uint64) {
+	if err := db.Put(historyTailKey, new(big.Int).SetUint64(number).Bytes()); err != nil {
+		log.Crit("Failed to store history tail", "err", err)
+	}
+}
+
+// ReadHistoryHorizon retrieves the number of the oldest block whose body and
+// receipts were not yet deleted by history expiry, or zero if nothing expired.
+func ReadHistoryHorizon(db DatabaseReader) uint64 {
+	data, _ := db.Get(historyHorizonKey)
+	if len(data) == 0 {
+		return 0
+	}
+	return new(big.Int).SetBytes(data).Uint64()
+}
+
+// WriteHistoryHorizon stores the number of the oldest block whose body and
+// receipts were not yet deleted by history expiry.
+func WriteHistoryHorizon(db DatabaseWriter, number uint64) {
+	if err := db.Put(historyHorizonKey, new(big.Int).SetUint64(number).Bytes()); err != nil {
+		log.Crit("Failed to store history horizon", "err", err)
+	}
+}
+
 // ReadHeaderRLP retrieves a block header in its raw RLP database encoding.
 func ReadHeaderRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(headerKey(number, hash))
@@ -17,6 +17,8 @@
 package rawdb
 
 import (
+	"encoding/binary"
+
 	"github.com/severeum/go-severeum/common"
 	"github.com/severeum/go-severeum/core/types"
 	"github.com/severeum/go-severeum/log"
@@ -62,6 +64,26 @@ func DeleteTxLookupEntry(db DatabaseDeleter, hash common.Hash) {
 	db.Delete(txLookupKey(hash))
 }
 
+// ReadTxIndexTail retrieves the number of the oldest block whose transactions
+// are indexed. Nil is returned if the tail was never tracked, which is the case
+// for databases that index every transaction.
+func ReadTxIndexTail(db DatabaseReader) *uint64 {
+	data, _ := db.Get(txIndexTailKey)
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+// WriteTxIndexTail stores the number of the oldest block whose transactions are
+// indexed.
+func WriteTxIndexTail(db DatabaseWriter, number uint64) {
+	if err := db.Put(txIndexTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store transaction index tail", "err", err)
+	}
+}
+
 // ReadTransaction retrieves a specific transaction from the database, along with
 // its added positional metadata.
 func ReadTransaction(db DatabaseReader, hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64) {
@@ -41,6 +41,17 @@ var (
 	// fastTrieProgressKey tracks the number of trie entries imported during fast sync.
 	fastTrieProgressKey = []byte("TrieSync")
 
+	// historyTailKey tracks the oldest block with a locally available header and
+	// body after a checkpoint sync (zero or missing if the entire history is present).
+	historyTailKey = []byte("HistoryTail")
+
+	// historyHorizonKey tracks the oldest block whose body and receipts weren't
+	// deleted by history expiry.
+	historyHorizonKey = []byte("HistoryHorizon")
+
+	// txIndexTailKey tracks the oldest block whose transactions are indexed.
+	txIndexTailKey = []byte("TransactionIndexTail")
+
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`, used for indexes).
 	headerPrefix       = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
 	headerTDSuffix     = []byte("t") // headerPrefix + num (uint64 big endian) + hash + headerTDSuffix -> td
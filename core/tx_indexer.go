@@ -0,0 +1,194 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/rawdb"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/log"
+)
+
+// TxIndexTail returns the number of the oldest block whose transactions are
+// indexed, or zero if the lookup entries of the entire chain are maintained.
+func (bc *BlockChain) TxIndexTail() uint64 {
+	if tail := rawdb.ReadTxIndexTail(bc.db); tail != nil {
+		return *tail
+	}
+	return 0
+}
+
+// txIndexTarget returns the oldest block whose transactions should be indexed
+// when the chain head is at the given number, according to the lookup limit.
+func (bc *BlockChain) txIndexTarget(head uint64) uint64 {
+	limit := bc.cacheConfig.TxLookupLimit
+	if limit == 0 || head < limit {
+		return 0
+	}
+	return head - limit + 1
+}
+
+// maintainTxIndex keeps the transaction lookup entries of only the most recent
+// blocks as the chain head moves, indexing or unindexing ranges of blocks in the
+// background. Lookup entries for newly imported blocks are still written by the
+// insertion itself, this loop only moves the tail of the index.
+//
+// Any previously unmanaged database is considered fully indexed, so limiting
+// the index of an existing chain is done by gradually deleting old entries.
+func (bc *BlockChain) maintainTxIndex() {
+	defer bc.wg.Done()
+
+	var (
+		done   chan struct{}
+		headCh = make(chan ChainHeadEvent, 1)
+	)
+	sub := bc.SubscribeChainHeadEvent(headCh)
+	if sub == nil {
+		return // Chain already stopped
+	}
+	defer sub.Unsubscribe()
+
+	// Bring the index in line with the current head first
+	done = make(chan struct{})
+	go bc.updateTxIndex(bc.CurrentBlock().NumberU64(), done)
+
+	for {
+		select {
+		case head := <-headCh:
+			if done == nil {
+				done = make(chan struct{})
+				go bc.updateTxIndex(head.Block.NumberU64(), done)
+			}
+		case <-done:
+			done = nil
+
+		case <-bc.quit:
+			if done != nil {
+				<-done
+			}
+			return
+		}
+	}
+}
+
+// updateTxIndex moves the tail of the transaction index to where the lookup
+// limit requires it to be for the given chain head, closing done when finished.
+func (bc *BlockChain) updateTxIndex(head uint64, done chan struct{}) {
+	defer close(done)
+
+	var (
+		target = bc.txIndexTarget(head)
+		tail   uint64
+	)
+	if stored := rawdb.ReadTxIndexTail(bc.db); stored != nil {
+		tail = *stored
+	} else {
+		rawdb.WriteTxIndexTail(bc.db, 0)
+	}
+	switch {
+	case target > tail:
+		bc.unindexTransactions(tail, target)
+	case target < tail:
+		bc.indexTransactions(target, tail)
+	}
+}
+
+// indexTransactions creates the lookup entries of the canonical transactions in
+// the block range [from, to), iterating backwards so that the index tail can be
+// moved with every flushed batch. Blocks without a local body are skipped.
+func (bc *BlockChain) indexTransactions(from, to uint64) {
+	var (
+		batch  = bc.db.NewBatch()
+		start  = time.Now()
+		logged = time.Now()
+		tail   = to
+		blocks int
+		txs    int
+	)
+	for tail > from && !bc.getProcInterrupt() {
+		tail--
+		if hash := rawdb.ReadCanonicalHash(bc.db, tail); hash != (common.Hash{}) {
+			if block := rawdb.ReadBlock(bc.db, hash, tail); block != nil {
+				rawdb.WriteTxLookupEntries(batch, block)
+				blocks, txs = blocks+1, txs+len(block.Transactions())
+			}
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			rawdb.WriteTxIndexTail(batch, tail)
+			if err := batch.Write(); err != nil {
+				log.Error("Failed to index transactions", "err", err)
+				return
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Indexing transactions", "blocks", blocks, "txs", txs, "tail", tail, "target", from, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	rawdb.WriteTxIndexTail(batch, tail)
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to index transactions", "err", err)
+		return
+	}
+	log.Info("Indexed transactions", "blocks", blocks, "txs", txs, "tail", tail, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
+// unindexTransactions deletes the lookup entries of the canonical transactions
+// in the block range [from, to), moving the index tail with every flushed batch.
+func (bc *BlockChain) unindexTransactions(from, to uint64) {
+	var (
+		batch  = bc.db.NewBatch()
+		start  = time.Now()
+		logged = time.Now()
+		tail   = from
+		blocks int
+		txs    int
+	)
+	for tail < to && !bc.getProcInterrupt() {
+		if hash := rawdb.ReadCanonicalHash(bc.db, tail); hash != (common.Hash{}) {
+			if body := rawdb.ReadBody(bc.db, hash, tail); body != nil {
+				for _, tx := range body.Transactions {
+					rawdb.DeleteTxLookupEntry(batch, tx.Hash())
+				}
+				blocks, txs = blocks+1, txs+len(body.Transactions)
+			}
+		}
+		tail++
+
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			rawdb.WriteTxIndexTail(batch, tail)
+			if err := batch.Write(); err != nil {
+				log.Error("Failed to unindex transactions", "err", err)
+				return
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Unindexing transactions", "blocks", blocks, "txs", txs, "tail", tail, "target", to, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	rawdb.WriteTxIndexTail(batch, tail)
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to unindex transactions", "err", err)
+		return
+	}
+	log.Info("Unindexed transactions", "blocks", blocks, "txs", txs, "tail", tail, "elapsed", common.PrettyDuration(time.Since(start)))
+}
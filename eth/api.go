@@ -34,6 +34,7 @@ import (
 	"github.com/severeum/go-severeum/core/rawdb"
 	"github.com/severeum/go-severeum/core/state"
 	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/eth/downloader"
 	"github.com/severeum/go-severeum/internal/ethapi"
 	"github.com/severeum/go-severeum/params"
 	"github.com/severeum/go-severeum/rlp"
@@ -285,6 +286,13 @@ func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error
 	return stateDb.RawDump(), nil
 }
 
+// SyncStatus retrieves a detailed breakdown of the chain synchronisation
+// progress, including per-phase queue sizes, throughputs and peer counts, as
+// well as an estimate of the remaining sync time.
+func (api *PublicDebugAPI) SyncStatus() *downloader.SyncStatus {
+	return api.eth.protocolManager.downloader.Status()
+}
+
 // PrivateDebugAPI is the collection of Severeum full node APIs exposed over
 // the private debugging endpoint.
 type PrivateDebugAPI struct {
@@ -18,6 +18,7 @@ package eth
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 
 	"github.com/severeum/go-severeum/accounts"
@@ -66,6 +67,9 @@ func (b *SevAPIBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNum
 	if blockNr == rpc.LatestBlockNumber {
 		return b.eth.blockchain.CurrentBlock().Header(), nil
 	}
+	if err := b.checkHistory(uint64(blockNr), false); err != nil {
+		return nil, err
+	}
 	return b.eth.blockchain.GetHeaderByNumber(uint64(blockNr)), nil
 }
 
@@ -83,9 +87,26 @@ func (b *SevAPIBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNumb
 	if blockNr == rpc.LatestBlockNumber {
 		return b.eth.blockchain.CurrentBlock(), nil
 	}
+	if err := b.checkHistory(uint64(blockNr), true); err != nil {
+		return nil, err
+	}
 	return b.eth.blockchain.GetBlockByNumber(uint64(blockNr)), nil
 }
 
+// checkHistory returns an error if the block with the given number is not
+// available locally, because the chain history before it was skipped by a
+// checkpoint sync and has not been backfilled yet, or - if the body is also
+// requested - because its body and receipts already expired.
+func (b *SevAPIBackend) checkHistory(number uint64, body bool) error {
+	if tail := b.eth.blockchain.HistoryTail(); number > 0 && number < tail {
+		return fmt.Errorf("block #%d unavailable: history pruned or not yet backfilled (oldest available #%d)", number, tail)
+	}
+	if horizon := b.eth.blockchain.HistoryHorizon(); body && number > 0 && number < horizon {
+		return fmt.Errorf("block #%d unavailable: history expired (oldest available #%d)", number, horizon)
+	}
+	return nil
+}
+
 func (b *SevAPIBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
 	// Pending state is only known by the miner
 	if blockNr == rpc.PendingBlockNumber {
@@ -106,9 +127,22 @@ func (b *SevAPIBackend) GetBlock(ctx context.Context, hash common.Hash) (*types.
 }
 
 func (b *SevAPIBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	if header := b.eth.blockchain.GetHeaderByHash(hash); header != nil {
+		if err := b.checkHistory(header.Number.Uint64(), true); err != nil {
+			return nil, err
+		}
+	}
 	return b.eth.blockchain.GetReceiptsByHash(hash), nil
 }
 
+func (b *SevAPIBackend) HistoryHorizon() uint64 {
+	return b.eth.blockchain.HistoryHorizon()
+}
+
+func (b *SevAPIBackend) TxIndexTail() uint64 {
+	return b.eth.blockchain.TxIndexTail()
+}
+
 func (b *SevAPIBackend) GetLogs(ctx context.Context, hash common.Hash) ([][]*types.Log, error) {
 	receipts := b.eth.blockchain.GetReceiptsByHash(hash)
 	if receipts == nil {
@@ -0,0 +1,250 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/common/hexutil"
+	"github.com/severeum/go-severeum/core"
+)
+
+const (
+	devMineTimeout  = 10 * time.Second      // Maximum time to wait for a single block to be mined
+	devMineInterval = 10 * time.Millisecond // Interval to retry sealing while waiting for pending work
+	devHeadChanSize = 10                    // Size of the channel listening to chain head events
+)
+
+var (
+	errDevNotMining       = errors.New("miner not running")
+	errDevUnsupported     = errors.New("consensus engine does not support on-demand sealing")
+	errDevMineTimeout     = errors.New("timeout waiting for block to be mined")
+	errDevUnknownSnapshot = errors.New("unknown snapshot")
+	errDevTimeOverflow    = errors.New("time increase overflows clock offset")
+)
+
+// devEngine is a consensus engine able to timestamp and seal blocks on demand,
+// as needed by developer chains.
+type devEngine interface {
+	// SetTimeOffset shifts the clock used to timestamp and schedule new blocks.
+	SetTimeOffset(offset time.Duration)
+
+	// SealPending forcefully seals the pending block built on top of parent, if
+	// it is timestamped no earlier than the given time.
+	SealPending(parent common.Hash, timestamp uint64) (common.Hash, error)
+}
+
+// devSnapshot is a chain position the developer chain can be reverted to.
+type devSnapshot struct {
+	number uint64        // Number of the head block at the time of the snapshot
+	hash   common.Hash   // Hash of the head block at the time of the snapshot
+	offset time.Duration // Clock offset at the time of the snapshot
+}
+
+// PrivateDevAPI provides private RPC methods to manipulate developer chains,
+// allowing test suites to mine blocks on demand, travel in time and revert the
+// chain to earlier snapshots.
+type PrivateDevAPI struct {
+	eth *Severeum
+
+	offset    time.Duration // Clock offset applied to the miner and consensus engine
+	minTime   uint64        // Timestamp the next mined block must at least have
+	snapshots []devSnapshot // Chain snapshots taken, identified by their index + 1
+
+	lock sync.Mutex
+}
+
+// NewPrivateDevAPI creates a new RPC service to manipulate a developer chain.
+func NewPrivateDevAPI(eth *Severeum) *PrivateDevAPI {
+	return &PrivateDevAPI{eth: eth}
+}
+
+// Mine forces the miner to seal the given number of blocks (1 if omitted), even
+// if they contain no transactions or the block period has not elapsed yet. The
+// hashes of the mined blocks are returned.
+func (api *PrivateDevAPI) Mine(blocks *uint64) ([]common.Hash, error) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	engine, ok := api.eth.engine.(devEngine)
+	if !ok {
+		return nil, errDevUnsupported
+	}
+	if !api.eth.IsMining() {
+		return nil, errDevNotMining
+	}
+	count := uint64(1)
+	if blocks != nil {
+		count = *blocks
+	}
+	heads := make(chan core.ChainHeadEvent, devHeadChanSize)
+	sub := api.eth.blockchain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	hashes := make([]common.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		hash, err := api.mine(engine, heads)
+		if err != nil {
+			return hashes, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// mine seals the pending block on top of the current head as soon as the miner
+// prepared it, waiting until the block becomes the new chain head.
+func (api *PrivateDevAPI) mine(engine devEngine, heads chan core.ChainHeadEvent) (common.Hash, error) {
+	var (
+		parent  = api.eth.blockchain.CurrentBlock().Hash()
+		timeout = time.NewTimer(devMineTimeout)
+		retry   = time.NewTicker(devMineInterval)
+		sealed  common.Hash
+	)
+	defer timeout.Stop()
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-retry.C:
+			if sealed != (common.Hash{}) {
+				continue
+			}
+			// The pending block might not be prepared yet, retry until it is
+			if hash, err := engine.SealPending(parent, api.minTime); err == nil {
+				sealed = hash
+			}
+
+		case head := <-heads:
+			if sealed != (common.Hash{}) && head.Block.Hash() == sealed {
+				api.minTime = 0
+				return sealed, nil
+			}
+
+		case <-timeout.C:
+			return common.Hash{}, errDevMineTimeout
+		}
+	}
+}
+
+// IncreaseTime moves the clock of the miner forward by the given number of
+// seconds, affecting the timestamps of all subsequent blocks. The total clock
+// offset in seconds is returned.
+func (api *PrivateDevAPI) IncreaseTime(seconds uint64) (uint64, error) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	// The offset is kept in nanoseconds, reject anything it cannot represent
+	limit := time.Duration(math.MaxInt64)
+	if api.offset > 0 {
+		limit -= api.offset
+	}
+	if seconds > uint64(limit/time.Second) {
+		return 0, errDevTimeOverflow
+	}
+	if err := api.setOffset(api.offset + time.Duration(seconds)*time.Second); err != nil {
+		return 0, err
+	}
+	return uint64(api.offset / time.Second), nil
+}
+
+// SetNextBlockTimestamp moves the clock of the miner so that the next block is
+// timestamped with the given time. Subsequent blocks keep the same clock offset.
+func (api *PrivateDevAPI) SetNextBlockTimestamp(timestamp uint64) error {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	if head := api.eth.blockchain.CurrentBlock().Time().Uint64(); timestamp <= head {
+		return fmt.Errorf("timestamp %d not after current head %d", timestamp, head)
+	}
+	if err := api.setOffset(time.Until(time.Unix(int64(timestamp), 0))); err != nil {
+		return err
+	}
+	api.minTime = timestamp
+	return nil
+}
+
+// Snapshot records the current head of the chain, returning an identifier that
+// can be used to revert the chain to this position.
+func (api *PrivateDevAPI) Snapshot() hexutil.Uint64 {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	head := api.eth.blockchain.CurrentBlock()
+	api.snapshots = append(api.snapshots, devSnapshot{
+		number: head.NumberU64(),
+		hash:   head.Hash(),
+		offset: api.offset,
+	})
+	return hexutil.Uint64(len(api.snapshots))
+}
+
+// Revert rewinds the chain and the miner clock to a previously taken snapshot.
+// The snapshot and all the ones taken after it are discarded. Transactions in
+// the removed blocks are lost. Nothing is changed if the snapshot cannot be
+// restored.
+func (api *PrivateDevAPI) Revert(id hexutil.Uint64) (bool, error) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	if id == 0 || uint64(id) > uint64(len(api.snapshots)) {
+		return false, errDevUnknownSnapshot
+	}
+	snap := api.snapshots[id-1]
+
+	// Make sure the snapshot can be restored before touching the chain
+	if _, ok := api.eth.engine.(devEngine); !ok {
+		return false, errDevUnsupported
+	}
+	chain := api.eth.blockchain
+	if header := chain.GetHeaderByNumber(snap.number); header == nil || header.Hash() != snap.hash {
+		return false, fmt.Errorf("snapshot block #%d [%x…] no longer canonical", snap.number, snap.hash[:4])
+	}
+	if !chain.HasBlockAndState(snap.hash, snap.number) {
+		return false, fmt.Errorf("snapshot block #%d [%x…] state missing (run with --gcmode=archive)", snap.number, snap.hash[:4])
+	}
+	if err := chain.SetHead(snap.number); err != nil {
+		return false, err
+	}
+	head := chain.CurrentBlock()
+
+	api.snapshots = api.snapshots[:id-1]
+	api.minTime = 0
+	api.setOffset(snap.offset)
+
+	// Notify the miner and transaction pool about the rewound head
+	chain.PostChainEvents([]interface{}{core.ChainHeadEvent{Block: head}}, nil)
+	return true, nil
+}
+
+// setOffset applies a new clock offset to the miner and the consensus engine.
+// The caller must hold the API lock.
+func (api *PrivateDevAPI) setOffset(offset time.Duration) error {
+	engine, ok := api.eth.engine.(devEngine)
+	if !ok {
+		return errDevUnsupported
+	}
+	api.offset = offset
+	engine.SetTimeOffset(offset)
+	api.eth.miner.SetTimeOffset(offset)
+	return nil
+}
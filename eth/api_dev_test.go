@@ -0,0 +1,73 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/common/hexutil"
+	"github.com/severeum/go-severeum/consensus/ethash"
+)
+
+// Tests that clock increases overflowing the offset are rejected without
+// changing it.
+func TestDevIncreaseTimeOverflow(t *testing.T) {
+	api := NewPrivateDevAPI(new(Severeum))
+	api.offset = time.Hour
+
+	for _, seconds := range []uint64{math.MaxUint64, math.MaxInt64/uint64(time.Second) - 3600 + 1} {
+		if _, err := api.IncreaseTime(seconds); err != errDevTimeOverflow {
+			t.Errorf("increase by %d: error mismatch: have %v, want %v", seconds, err, errDevTimeOverflow)
+		}
+		if api.offset != time.Hour {
+			t.Errorf("increase by %d: offset changed: have %v, want %v", seconds, api.offset, time.Hour)
+		}
+	}
+}
+
+// Tests that reverting to a snapshot which cannot be restored leaves the chain
+// and the snapshots untouched.
+func TestDevRevertUnrestorable(t *testing.T) {
+	// Generate more blocks than the number of recent states kept in memory, so
+	// the state of the early ones is garbage collected
+	backend, blocks, stop := newTestAPIBackend(t, 136, nil)
+	defer stop()
+
+	api := NewPrivateDevAPI(backend.eth)
+	chain := backend.eth.blockchain
+
+	// Snapshot an early block and try to revert to it
+	api.snapshots = append(api.snapshots, devSnapshot{number: blocks[1].NumberU64(), hash: blocks[1].Hash()})
+	id := hexutil.Uint64(len(api.snapshots))
+	head := chain.CurrentBlock().Hash()
+
+	if _, err := api.Revert(id); err != errDevUnsupported {
+		t.Fatalf("revert without dev engine: error mismatch: have %v, want %v", err, errDevUnsupported)
+	}
+	backend.eth.engine = ethash.NewFaker()
+	if _, err := api.Revert(id); err == nil {
+		t.Fatalf("revert with missing state succeeded")
+	}
+	if have := chain.CurrentBlock().Hash(); have != head {
+		t.Errorf("head mismatch: have %x, want %x", have, head)
+	}
+	if len(api.snapshots) != 1 || hexutil.Uint64(len(api.snapshots)) != id {
+		t.Errorf("snapshots discarded: have %d, want %d", len(api.snapshots), id)
+	}
+}
@@ -17,13 +17,24 @@
 package eth
 
 import (
+	"context"
+	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/consensus/ethash"
+	"github.com/severeum/go-severeum/core"
+	"github.com/severeum/go-severeum/core/rawdb"
 	"github.com/severeum/go-severeum/core/state"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/core/vm"
 	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/internal/ethapi"
+	"github.com/severeum/go-severeum/params"
 )
 
 var dumper = spew.ConfigState{Indent: "    "}
@@ -88,3 +99,91 @@ func TestStorageRangeAt(t *testing.T) {
 		}
 	}
 }
+
+// newTestAPIBackend creates an API backend on top of a chain of n blocks with a
+// transaction each, imported with the given cache configuration. The returned
+// function releases the backend's resources.
+func newTestAPIBackend(t *testing.T, n int, cacheConfig *core.CacheConfig) (*SevAPIBackend, []*types.Block, func()) {
+	var (
+		db     = ethdb.NewMemDatabase()
+		gspec  = &core.Genesis{Config: params.TestChainConfig, Alloc: core.GenesisAlloc{testBank: {Balance: big.NewInt(1000000000)}}}
+		signer = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, gspec.MustCommit(db), ethash.NewFaker(), db, n, func(i int, block *core.BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(testBank), common.Address{0x00}, big.NewInt(1000), params.TxGas, nil, nil), signer, testBankKey)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	db = ethdb.NewMemDatabase()
+	gspec.MustCommit(db)
+
+	chain, err := core.NewBlockChain(db, cacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	pool := core.NewTxPool(core.TxPoolConfig{Lifetime: time.Hour}, gspec.Config, chain)
+
+	sev := &Severeum{chainConfig: gspec.Config, blockchain: chain, txPool: pool, chainDb: db}
+	return &SevAPIBackend{eth: sev}, blocks, func() {
+		pool.Stop()
+		chain.Stop()
+	}
+}
+
+// Tests that receipts of transactions in expired blocks are reported as such
+// instead of as unknown.
+func TestExpiredReceipt(t *testing.T) {
+	cacheConfig := &core.CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: 5 * time.Minute, HistoryRetention: 8}
+	backend, blocks, stop := newTestAPIBackend(t, 16, cacheConfig)
+	defer stop()
+
+	// Wait until the chain expired the history in the background
+	for start := time.Now(); rawdb.ReadHistoryHorizon(backend.ChainDb()) != 8; time.Sleep(100 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("history not expired: horizon %d, want %d", rawdb.ReadHistoryHorizon(backend.ChainDb()), 8)
+		}
+	}
+	api := ethapi.NewPublicTransactionPoolAPI(backend, new(ethapi.AddrLocker))
+
+	expired := blocks[2].Transactions()[0].Hash()
+	if receipt, err := api.GetTransactionReceipt(context.Background(), expired); err == nil || !strings.Contains(err.Error(), "history expired (oldest available #8)") {
+		t.Fatalf("expired receipt: have %v, %v, want history expired error", receipt, err)
+	}
+	retained := blocks[12].Transactions()[0].Hash()
+	if receipt, err := api.GetTransactionReceipt(context.Background(), retained); err != nil || receipt == nil {
+		t.Fatalf("retained receipt: have %v, %v, want receipt", receipt, err)
+	}
+}
+
+// Tests that transactions outside the indexed range are reported as such by the
+// transaction lookup methods instead of as unknown.
+func TestUnindexedTransaction(t *testing.T) {
+	cacheConfig := &core.CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: 5 * time.Minute, TxLookupLimit: 8}
+	backend, blocks, stop := newTestAPIBackend(t, 16, cacheConfig)
+	defer stop()
+
+	// Wait until the indexer unindexed the old blocks in the background
+	for start := time.Now(); backend.TxIndexTail() != 9; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 3*time.Second {
+			t.Fatalf("index tail mismatch: have %d, want %d", backend.TxIndexTail(), 9)
+		}
+	}
+	api := ethapi.NewPublicTransactionPoolAPI(backend, new(ethapi.AddrLocker))
+
+	unindexed := blocks[2].Transactions()[0].Hash()
+	if tx, err := api.GetTransactionByHash(context.Background(), unindexed); err == nil || !strings.Contains(err.Error(), "not indexed (oldest indexed #9)") {
+		t.Fatalf("unindexed transaction: have %v, %v, want not indexed error", tx, err)
+	}
+	if receipt, err := api.GetTransactionReceipt(context.Background(), unindexed); err == nil || !strings.Contains(err.Error(), "not indexed (oldest indexed #9)") {
+		t.Fatalf("unindexed receipt: have %v, %v, want not indexed error", receipt, err)
+	}
+	indexed := blocks[12].Transactions()[0].Hash()
+	if tx, err := api.GetTransactionByHash(context.Background(), indexed); err != nil || tx == nil || tx.Hash != indexed {
+		t.Fatalf("indexed transaction: have %v, %v, want transaction", tx, err)
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/severeum/go-severeum/consensus"
 	"github.com/severeum/go-severeum/consensus/clique"
 	"github.com/severeum/go-severeum/consensus/ethash"
+	"github.com/severeum/go-severeum/consensus/ibft"
 	"github.com/severeum/go-severeum/core"
 	"github.com/severeum/go-severeum/core/bloombits"
 	"github.com/severeum/go-severeum/core/rawdb"
@@ -54,6 +55,7 @@ import (
 type LesServer interface {
 	Start(srvr *p2p.Server)
 	Stop()
+	APIs() []rpc.API
 	Protocols() []p2p.Protocol
 	SetBloomBitsIndexer(bbIndexer *core.ChainIndexer)
 }
@@ -113,6 +115,10 @@ func New(ctx *node.ServiceContext, config *Config) (*Severeum, error) {
 		log.Warn("Sanitizing invalid miner gas price", "provided", config.MinerGasPrice, "updated", DefaultConfig.MinerGasPrice)
 		config.MinerGasPrice = new(big.Int).Set(DefaultConfig.MinerGasPrice)
 	}
+	if config.HistoryRetention > 0 && config.HistoryRetention < downloader.MaxForkAncestry {
+		log.Warn("Sanitizing too short history retention", "provided", config.HistoryRetention, "updated", uint64(downloader.MaxForkAncestry))
+		config.HistoryRetention = downloader.MaxForkAncestry
+	}
 	// Assemble the Severeum object
 	chainDb, err := CreateDB(ctx, config, "chaindata")
 	if err != nil {
@@ -138,7 +144,9 @@ func New(ctx *node.ServiceContext, config *Config) (*Severeum, error) {
 		bloomRequests:  make(chan chan *bloombits.Retrieval),
 		bloomIndexer:   NewBloomIndexer(chainDb, params.BloomBitsBlocks, params.BloomConfirms),
 	}
-
+	if engine, ok := eth.engine.(*clique.Clique); ok {
+		engine.SetMissedTurnsLimit(config.CliqueMissedTurns)
+	}
 	log.Info("Initialising Severeum protocol", "versions", ProtocolVersions, "network", config.NetworkId)
 
 	if !config.SkipBcVersionCheck {
@@ -156,7 +164,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Severeum, error) {
 			EWASMInterpreter:        config.EWASMInterpreter,
 			EVMInterpreter:          config.EVMInterpreter,
 		}
-		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieCleanLimit: config.TrieCleanCache, TrieDirtyLimit: config.TrieDirtyCache, TrieTimeLimit: config.TrieTimeout}
+		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieCleanLimit: config.TrieCleanCache, TrieDirtyLimit: config.TrieDirtyCache, TrieTimeLimit: config.TrieTimeout, HistoryRetention: config.HistoryRetention, TxLookupLimit: config.TxLookupLimit}
 	)
 	eth.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, eth.chainConfig, eth.engine, vmConfig, eth.shouldPreserve)
 	if err != nil {
@@ -175,9 +183,22 @@ func New(ctx *node.ServiceContext, config *Config) (*Severeum, error) {
 	}
 	eth.txPool = core.NewTxPool(config.TxPool, eth.chainConfig, eth.blockchain)
 
-	if eth.protocolManager, err = NewProtocolManager(eth.chainConfig, config.SyncMode, config.NetworkId, eth.eventMux, eth.txPool, eth.engine, eth.blockchain, chainDb, config.Whitelist); err != nil {
+	// A trusted checkpoint is also enforced on all peers, regardless of sync mode
+	whitelist := config.Whitelist
+	if cp := config.Checkpoint; cp != nil {
+		whitelist = make(map[uint64]common.Hash, len(config.Whitelist)+1)
+		for number, hash := range config.Whitelist {
+			whitelist[number] = hash
+		}
+		whitelist[cp.Number] = cp.Hash
+	}
+	if eth.protocolManager, err = NewProtocolManager(eth.chainConfig, config.SyncMode, config.NetworkId, eth.eventMux, eth.txPool, eth.engine, eth.blockchain, chainDb, whitelist); err != nil {
 		return nil, err
 	}
+	if cp := config.Checkpoint; cp != nil {
+		log.Info("Configured trusted checkpoint", "number", cp.Number, "hash", cp.Hash, "td", cp.Td)
+		eth.protocolManager.downloader.SetCheckpoint(cp)
+	}
 
 	eth.miner = miner.New(eth, eth.chainConfig, eth.EventMux(), eth.engine, config.MinerRecommit, config.MinerGasFloor, config.MinerGasCeil, eth.isLocalBlock)
 	eth.miner.SetExtra(makeExtraData(config.MinerExtraData))
@@ -227,6 +248,9 @@ func CreateConsensusEngine(ctx *node.ServiceContext, chainConfig *params.ChainCo
 	if chainConfig.Clique != nil {
 		return clique.New(chainConfig.Clique, db)
 	}
+	if chainConfig.IBFT != nil {
+		return ibft.New(chainConfig.IBFT, db)
+	}
 	// Otherwise assume proof-of-work
 	switch config.PowMode {
 	case ethash.ModeFake:
@@ -238,6 +262,9 @@ func CreateConsensusEngine(ctx *node.ServiceContext, chainConfig *params.ChainCo
 	case ethash.ModeShared:
 		log.Warn("Sevash used in shared mode")
 		return ethash.NewShared()
+	case ethash.ModeDev:
+		log.Warn("Sevash used in developer mode", "period", config.DevPeriod, "difficulty", config.DevDifficulty)
+		return ethash.NewDeveloper(config.DevPeriod, config.DevDifficulty)
 	default:
 		engine := ethash.New(ethash.Config{
 			CacheDir:       ctx.ResolvePath(config.CacheDir),
@@ -260,6 +287,25 @@ func (s *Severeum) APIs() []rpc.API {
 	// Append any APIs exposed explicitly by the consensus engine
 	apis = append(apis, s.engine.APIs(s.BlockChain())...)
 
+	// Append any APIs exposed by the light server
+	if s.lesServer != nil {
+		apis = append(apis, s.lesServer.APIs()...)
+	}
+
+	// Append the chain manipulation APIs for developer chains, aliased under the
+	// evm namespace for compatibility with existing test suites
+	if s.config.Developer {
+		dev := NewPrivateDevAPI(s)
+		apis = append(apis, rpc.API{
+			Namespace: "dev",
+			Version:   "1.0",
+			Service:   dev,
+		}, rpc.API{
+			Namespace: "evm",
+			Version:   "1.0",
+			Service:   dev,
+		})
+	}
 	// Append all the local APIs and return
 	return append(apis, []rpc.API{
 		{
@@ -436,6 +482,14 @@ func (s *Severeum) StartMining(threads int) error {
 			}
 			clique.Authorize(eb, wallet.SignHash)
 		}
+		if ibft, ok := s.engine.(*ibft.IBFT); ok {
+			wallet, err := s.accountManager.Find(accounts.Account{Address: eb})
+			if wallet == nil || err != nil {
+				log.Error("Severbase account unavailable locally", "err", err)
+				return fmt.Errorf("validator missing: %v", err)
+			}
+			ibft.Authorize(eb, wallet.SignHash)
+		}
 		// If mining is started, we can disable the transaction rejection mechanism
 		// introduced to speed sync times.
 		atomic.StoreUint32(&s.protocolManager.acceptTxs, 1)
@@ -476,10 +530,14 @@ func (s *Severeum) Downloader() *downloader.Downloader { return s.protocolManage
 // Protocols implements node.Service, returning all the currently configured
 // network protocols to start.
 func (s *Severeum) Protocols() []p2p.Protocol {
-	if s.lesServer == nil {
-		return s.protocolManager.SubProtocols
+	protos := s.protocolManager.SubProtocols
+	if handler, ok := s.engine.(consensus.Handler); ok {
+		protos = append(protos, handler.Protocols()...)
+	}
+	if s.lesServer != nil {
+		protos = append(protos, s.lesServer.Protocols()...)
 	}
-	return append(s.protocolManager.SubProtocols, s.lesServer.Protocols()...)
+	return protos
 }
 
 // Start implements node.Service, starting all internal goroutines needed by the
@@ -501,12 +559,43 @@ func (s *Severeum) Start(srvr *p2p.Server) error {
 	}
 	// Start the networking layer and the light server if requested
 	s.protocolManager.Start(maxPeers)
+	s.protocolManager.startEthEntryUpdate(srvr.LocalNode())
 	if s.lesServer != nil {
 		s.lesServer.Start(srvr)
 	}
+	// Let consensus engines running their own protocol follow the chain
+	if handler, ok := s.engine.(consensus.Handler); ok {
+		insert := func(block *types.Block) error {
+			_, err := s.blockchain.InsertChain(types.Blocks{block})
+			return err
+		}
+		if err := handler.Start(s.blockchain, insert); err != nil {
+			return err
+		}
+		go s.consensusLoop(handler)
+	}
 	return nil
 }
 
+// consensusLoop notifies a consensus engine running its own protocol about new
+// chain heads, until the service is terminated.
+func (s *Severeum) consensusLoop(handler consensus.Handler) {
+	heads := make(chan core.ChainHeadEvent, chainHeadChanSize)
+	sub := s.blockchain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case head := <-heads:
+			handler.NewChainHead(head.Block.Header())
+		case <-sub.Err():
+			return
+		case <-s.shutdownChan:
+			return
+		}
+	}
+}
+
 // Stop implements node.Service, terminating all internal goroutines used by the
 // Severeum protocol.
 func (s *Severeum) Stop() error {
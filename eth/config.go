@@ -87,13 +87,25 @@ type Config struct {
 	SyncMode  downloader.SyncMode
 	NoPruning bool
 
+	// Number of recent blocks to keep bodies and receipts for (0 = keep everything)
+	HistoryRetention uint64 `toml:",omitempty"`
+
+	// Number of recent blocks to index transactions for (0 = entire chain)
+	TxLookupLimit uint64 `toml:",omitempty"`
+
 	// Whitelist of required block number -> hash values to accept
 	Whitelist map[uint64]common.Hash `toml:"-"`
 
+	// Trusted checkpoint to start fast sync from, skipping all preceding headers
+	Checkpoint *downloader.Checkpoint `toml:",omitempty"`
+
 	// Light client options
 	LightServ  int `toml:",omitempty"` // Maximum percentage of time allowed for serving LES requests
 	LightPeers int `toml:",omitempty"` // Maximum number of LES client peers
 
+	// Checkpoint oracle to retrieve trusted light client checkpoints from
+	CheckpointOracle *params.CheckpointOracleConfig `toml:",omitempty"`
+
 	// Database options
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
@@ -115,6 +127,9 @@ type Config struct {
 	// Sevash options
 	Sevash ethash.Config
 
+	// Clique options
+	CliqueMissedTurns uint64 `toml:",omitempty"` // Consecutive turns a signer may miss before warning (0 = disabled)
+
 	// Transaction pool options
 	TxPool core.TxPoolConfig
 
@@ -125,7 +140,8 @@ type Config struct {
 	EnablePreimageRecording bool
 
 	// Miscellaneous options
-	DocRoot string `toml:"-"`
+	DocRoot   string `toml:"-"`
+	Developer bool   `toml:"-"` // Enables the chain manipulation APIs of developer chains
 
 	// Type of the EWASM interpreter ("" for default)
 	EWASMInterpreter string
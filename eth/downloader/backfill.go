@@ -0,0 +1,278 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/log"
+)
+
+var (
+	backfillBatch = MaxBodyFetch    // Number of historical blocks to retrieve in one go
+	backfillRetry = 3 * time.Second // Delay between backfill attempts if no progress can be made
+)
+
+var errBackfillTimeout = errors.New("backfill request timed out")
+
+// backfillRequest tracks a single in-flight request of the backfiller. Since the
+// responses share the delivery path of the regular sync, they are only claimed
+// if they match the request exactly.
+type backfillRequest struct {
+	peer    string
+	last    common.Hash     // Hash of the last header expected in a header response
+	headers []*types.Header // Headers whose bodies are being retrieved (body response)
+}
+
+// backfiller retrieves the headers and bodies preceding a trusted checkpoint in
+// the background, extending the local history backwards until the genesis. The
+// retrieved blocks are linked to the checkpoint by their hashes, so they don't
+// need to be verified any further.
+type backfiller struct {
+	d *Downloader
+
+	req      *backfillRequest // Currently pending request (nil if none)
+	headerCh chan []*types.Header
+	bodyCh   chan *bodyPack
+	lock     sync.Mutex
+
+	running int32 // Flag whether the backfill loop is running (atomic)
+}
+
+// newBackfiller creates a backfiller for the chain of a downloader.
+func newBackfiller(d *Downloader) *backfiller {
+	return &backfiller{
+		d:        d,
+		headerCh: make(chan []*types.Header, 1),
+		bodyCh:   make(chan *bodyPack, 1),
+	}
+}
+
+// start launches the backfill loop if the local history is incomplete and it's
+// not yet running.
+func (b *backfiller) start() {
+	if b.d.blockchain == nil || b.d.blockchain.HistoryTail() == 0 {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&b.running, 0, 1) {
+		go b.loop()
+	}
+}
+
+// loop keeps retrieving batches of historical blocks below the history tail
+// until the entire chain is available, pausing while the downloader is busy
+// synchronising.
+func (b *backfiller) loop() {
+	defer atomic.StoreInt32(&b.running, 0)
+
+	for {
+		tail := b.d.blockchain.HistoryTail()
+		if tail == 0 {
+			return
+		}
+		var err error
+		if peers := b.peers(tail - 1); len(peers) == 0 || b.d.Synchronising() {
+			err = errNoPeers
+		} else {
+			err = b.fetch(peers[rand.Intn(len(peers))], tail)
+		}
+		if err != nil {
+			select {
+			case <-time.After(backfillRetry):
+			case <-b.d.quitCh:
+				return
+			}
+		}
+		select {
+		case <-b.d.quitCh:
+			return
+		default:
+		}
+	}
+}
+
+// peers returns the peers able to serve the block with the given number. If its
+// body is not retained locally anyway, all of them suffice to retrieve headers.
+func (b *backfiller) peers(number uint64) []*peerConnection {
+	peers := b.d.peers.AllPeers()
+	if number < b.d.blockchain.HistoryHorizon() {
+		return peers
+	}
+	available := peers[:0]
+	for _, p := range peers {
+		if !p.Pruned(number) {
+			available = append(available, p)
+		}
+	}
+	return available
+}
+
+// fetch retrieves a batch of blocks preceding the history tail from a peer and
+// inserts them into the local chain.
+func (b *backfiller) fetch(p *peerConnection, tail uint64) error {
+	child := b.d.blockchain.GetHeaderByNumber(tail)
+	if child == nil {
+		log.Error("History tail header missing", "number", tail)
+		return errInvalidChain
+	}
+	from, count := uint64(1), int(tail-1)
+	if count > backfillBatch {
+		from, count = tail-uint64(backfillBatch), backfillBatch
+	}
+	// Retrieve the headers and ensure they link up with the tail
+	b.request(&backfillRequest{peer: p.id, last: child.ParentHash})
+	go p.peer.RequestHeadersByNumber(from, count, 0, false)
+
+	var headers []*types.Header
+	select {
+	case headers = <-b.headerCh:
+	case <-time.After(b.d.requestTTL()):
+		b.request(nil)
+		return errBackfillTimeout
+	case <-b.d.quitCh:
+		return errCancelHeaderFetch
+	}
+	if len(headers) != count || headers[0].Number.Uint64() != from {
+		p.log.Debug("Invalid backfill headers", "from", from, "count", count, "delivered", len(headers))
+		p.recordScore(scoreInvalid, 0, 0)
+		b.d.dropPeer(p.id)
+		return errInvalidChain
+	}
+	for i := 1; i < len(headers); i++ {
+		if headers[i].ParentHash != headers[i-1].Hash() || headers[i].Number.Uint64() != headers[i-1].Number.Uint64()+1 {
+			p.log.Debug("Unlinked backfill headers", "number", headers[i].Number)
+			p.recordScore(scoreInvalid, 0, 0)
+			b.d.dropPeer(p.id)
+			return errInvalidChain
+		}
+	}
+	// Retrieve the bodies of all non-empty blocks that aren't expired locally
+	// anyway, possibly in multiple rounds
+	var (
+		horizon = b.d.blockchain.HistoryHorizon()
+		txs     = make(map[common.Hash][]*types.Transaction)
+		uncles  = make(map[common.Hash][]*types.Header)
+		fetch   []*types.Header
+	)
+	for _, header := range headers {
+		if header.Number.Uint64() < horizon {
+			continue
+		}
+		if header.TxHash != types.EmptyRootHash || header.UncleHash != types.EmptyUncleHash {
+			fetch = append(fetch, header)
+		}
+	}
+	for len(fetch) > 0 {
+		hashes := make([]common.Hash, len(fetch))
+		for i, header := range fetch {
+			hashes[i] = header.Hash()
+		}
+		b.request(&backfillRequest{peer: p.id, headers: fetch})
+		go p.peer.RequestBodies(hashes)
+
+		var bodies *bodyPack
+		select {
+		case bodies = <-b.bodyCh:
+		case <-time.After(b.d.requestTTL()):
+			b.request(nil)
+			return errBackfillTimeout
+		case <-b.d.quitCh:
+			return errCancelBodyFetch
+		}
+		for i := range bodies.transactions {
+			hash := fetch[i].Hash()
+			txs[hash], uncles[hash] = bodies.transactions[i], bodies.uncles[i]
+		}
+		fetch = fetch[len(bodies.transactions):]
+	}
+	// Assemble the blocks and extend the local history
+	blocks := make(types.Blocks, len(headers))
+	for i, header := range headers {
+		hash := header.Hash()
+		blocks[i] = types.NewBlockWithHeader(header).WithBody(txs[hash], uncles[hash])
+	}
+	if n, err := b.d.blockchain.InsertBackfillChain(blocks); err != nil {
+		log.Warn("Invalid backfill block", "number", blocks[n].Number(), "hash", blocks[n].Hash(), "err", err)
+		p.recordScore(scoreInvalid, 0, 0)
+		b.d.dropPeer(p.id)
+		return err
+	}
+	return nil
+}
+
+// request sets the currently pending request, or clears it if nil. Any response
+// claimed for a previous, already abandoned request is discarded.
+func (b *backfiller) request(req *backfillRequest) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.req = req
+	select {
+	case <-b.headerCh:
+	default:
+	}
+	select {
+	case <-b.bodyCh:
+	default:
+	}
+}
+
+// deliverHeaders claims a header response if it's the one the backfiller is
+// waiting for, returning whether it was consumed.
+func (b *backfiller) deliverHeaders(id string, headers []*types.Header) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.req == nil || b.req.peer != id || b.req.headers != nil || len(headers) == 0 {
+		return false
+	}
+	if headers[len(headers)-1].Hash() != b.req.last {
+		return false
+	}
+	b.req = nil
+	b.headerCh <- headers
+	return true
+}
+
+// deliverBodies claims a body response if it's the one the backfiller is waiting
+// for, returning whether it was consumed. As only the bodies of non-empty blocks
+// are requested, matching their contents against the headers is unambiguous.
+func (b *backfiller) deliverBodies(id string, transactions [][]*types.Transaction, uncles [][]*types.Header) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.req == nil || b.req.peer != id || b.req.headers == nil {
+		return false
+	}
+	if len(transactions) == 0 || len(transactions) > len(b.req.headers) || len(transactions) != len(uncles) {
+		return false
+	}
+	for i, header := range b.req.headers[:len(transactions)] {
+		if types.DeriveSha(types.Transactions(transactions[i])) != header.TxHash || types.CalcUncleHash(uncles[i]) != header.UncleHash {
+			return false
+		}
+	}
+	b.req = nil
+	b.bodyCh <- &bodyPack{peerID: id, transactions: transactions, uncles: uncles}
+	return true
+}
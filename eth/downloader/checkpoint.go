@@ -0,0 +1,155 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/log"
+)
+
+var (
+	errCheckpointUnavailable = errors.New("remote chain doesn't contain the checkpoint yet")
+	errCheckpointMismatch    = errors.New("retrieved checkpoint doesn't match the trusted one")
+)
+
+// Checkpoint is a trusted (weak subjectivity) block from which a fast sync can
+// start, skipping the retrieval and verification of all headers before it. The
+// history preceding the checkpoint is backfilled in the background afterwards.
+type Checkpoint struct {
+	Number uint64      // Block number of the checkpoint
+	Hash   common.Hash // Block hash of the checkpoint
+	Td     *big.Int    // Total difficulty of the chain up to and including the checkpoint
+}
+
+// SetCheckpoint configures a trusted checkpoint to anchor fast syncs of empty
+// chains at. It must be called before any synchronisation is started.
+func (d *Downloader) SetCheckpoint(cp *Checkpoint) {
+	d.checkpoint = cp
+}
+
+// checkpointFloor returns the block number below which no common ancestor may be
+// accepted, because the local chain is anchored at or already beyond a trusted
+// checkpoint. The returned flag is false if there is no such limit.
+func (d *Downloader) checkpointFloor(localHeight uint64) (uint64, bool) {
+	cp := d.checkpoint
+	if cp == nil || d.mode == LightSync || localHeight < cp.Number {
+		return 0, false
+	}
+	return cp.Number, true
+}
+
+// anchorCheckpoint retrieves the trusted checkpoint block along with its receipts
+// from a remote peer and inserts it into an empty local chain, so fast sync can
+// pick up from there without ever touching the preceding headers.
+func (d *Downloader) anchorCheckpoint(p *peerConnection, height uint64) error {
+	cp := d.checkpoint
+	if cp == nil || d.blockchain.HasFastBlock(cp.Hash, cp.Number) {
+		return nil
+	}
+	// Only anchor chains that have nothing to lose, the others sync normally
+	if d.blockchain.CurrentBlock().NumberU64() > 0 || d.blockchain.CurrentHeader().Number.Uint64() >= cp.Number {
+		return nil
+	}
+	if height <= cp.Number {
+		p.log.Debug("Remote chain below checkpoint", "height", height, "checkpoint", cp.Number)
+		return errCheckpointUnavailable
+	}
+	p.log.Debug("Retrieving trusted checkpoint", "number", cp.Number, "hash", cp.Hash)
+
+	// Retrieve the header, body and receipts of the checkpoint one after the other
+	go p.peer.RequestHeadersByHash(cp.Hash, 1, 0, false)
+	packet, err := d.awaitPacket(p, d.headerCh)
+	if err != nil {
+		return err
+	}
+	headers := packet.(*headerPack).headers
+	if len(headers) != 1 {
+		p.log.Debug("Multiple headers for single request", "headers", len(headers))
+		return errBadPeer
+	}
+	header := headers[0]
+	if header.Hash() != cp.Hash || header.Number.Uint64() != cp.Number {
+		p.log.Warn("Checkpoint header mismatch", "number", header.Number, "hash", header.Hash(), "want", cp.Hash)
+		return errCheckpointMismatch
+	}
+	go p.peer.RequestBodies([]common.Hash{cp.Hash})
+	if packet, err = d.awaitPacket(p, d.bodyCh); err != nil {
+		return err
+	}
+	bodies := packet.(*bodyPack)
+	if len(bodies.transactions) != 1 || len(bodies.uncles) != 1 {
+		return errInvalidBody
+	}
+	txs, uncles := bodies.transactions[0], bodies.uncles[0]
+	if types.DeriveSha(types.Transactions(txs)) != header.TxHash || types.CalcUncleHash(uncles) != header.UncleHash {
+		return errInvalidBody
+	}
+	go p.peer.RequestReceipts([]common.Hash{cp.Hash})
+	if packet, err = d.awaitPacket(p, d.receiptCh); err != nil {
+		return err
+	}
+	receipts := packet.(*receiptPack).receipts
+	if len(receipts) != 1 || types.DeriveSha(types.Receipts(receipts[0])) != header.ReceiptHash {
+		return errInvalidReceipt
+	}
+	// Checkpoint retrieved, anchor the local chain and start backfilling the history
+	block := types.NewBlockWithHeader(header).WithBody(txs, uncles)
+	if err := d.blockchain.InsertCheckpoint(block, receipts[0], cp.Td); err != nil {
+		return err
+	}
+	d.backfill.start()
+	return nil
+}
+
+// awaitPacket waits for a single reply from the given peer on one of the data
+// channels, discarding anything else delivered in the mean time.
+func (d *Downloader) awaitPacket(p *peerConnection, ch chan dataPack) (dataPack, error) {
+	ttl := d.requestTTL()
+	timeout := time.After(ttl)
+	for {
+		var (
+			packet dataPack
+			origin chan dataPack
+		)
+		select {
+		case <-d.cancelCh:
+			return nil, errCancelBlockFetch
+
+		case packet = <-d.headerCh:
+			origin = d.headerCh
+		case packet = <-d.bodyCh:
+			origin = d.bodyCh
+		case packet = <-d.receiptCh:
+			origin = d.receiptCh
+
+		case <-timeout:
+			p.log.Debug("Waiting for checkpoint data timed out", "elapsed", ttl)
+			return nil, errTimeout
+		}
+		// Discard anything not from the origin peer or out of bounds
+		if packet.PeerId() != p.id || origin != ch {
+			log.Debug("Discarded unexpected checkpoint data", "peer", packet.PeerId())
+			continue
+		}
+		return packet, nil
+	}
+}
@@ -112,6 +112,7 @@ type Downloader struct {
 	syncStatsChainHeight uint64 // Highest block number known when syncing started
 	syncStatsState       stateSyncStats
 	syncStatsLock        sync.RWMutex // Lock protecting the sync stats fields
+	status               statusTracker
 
 	lightchain LightChain
 	blockchain BlockChain
@@ -138,6 +139,11 @@ type Downloader struct {
 	trackStateReq  chan *stateReq
 	stateCh        chan dataPack // [eth/63] Channel receiving inbound node state data
 
+	snap *snapSyncer // Range based state syncer preceding the trie healing in snap sync
+
+	checkpoint *Checkpoint // Trusted checkpoint to anchor fast syncs of empty chains at
+	backfill   *backfiller // Background retriever of the history preceding the checkpoint
+
 	// Cancellation and termination
 	cancelPeer string         // Identifier of the peer currently being used as the master (cancel on drop)
 	cancelCh   chan struct{}  // Channel to cancel mid-flight syncs
@@ -202,6 +208,21 @@ type BlockChain interface {
 
 	// InsertReceiptChain inserts a batch of receipts into the local chain.
 	InsertReceiptChain(types.Blocks, []types.Receipts) (int, error)
+
+	// GetHeaderByNumber retrieves a canonical header from the local chain.
+	GetHeaderByNumber(uint64) *types.Header
+
+	// HistoryTail retrieves the oldest block number with a local header and body.
+	HistoryTail() uint64
+
+	// HistoryHorizon retrieves the oldest block number with a retained body and receipts.
+	HistoryHorizon() uint64
+
+	// InsertCheckpoint anchors an empty local chain at a trusted checkpoint block.
+	InsertCheckpoint(*types.Block, types.Receipts, *big.Int) error
+
+	// InsertBackfillChain inserts a batch of historical blocks below the history tail.
+	InsertBackfillChain(types.Blocks) (int, error)
 }
 
 // New creates a new downloader to fetch hashes and blocks from remote peers.
@@ -235,7 +256,12 @@ func New(mode SyncMode, stateDb ethdb.Database, mux *event.TypeMux, chain BlockC
 		},
 		trackStateReq: make(chan *stateReq),
 	}
+	dl.snap = newSnapSyncer(stateDb, dl.requestTTL)
+	dl.backfill = newBackfiller(dl)
+	dl.backfill.start()
+
 	go dl.qosTuner()
+	go dl.statusLoop()
 	go dl.stateFetcher()
 	return dl
 }
@@ -256,7 +282,7 @@ func (d *Downloader) Progress() severeum.SyncProgress {
 	switch d.mode {
 	case FullSync:
 		current = d.blockchain.CurrentBlock().NumberU64()
-	case FastSync:
+	case FastSync, SnapSync:
 		current = d.blockchain.CurrentFastBlock().NumberU64()
 	case LightSync:
 		current = d.lightchain.CurrentHeader().Number.Uint64()
@@ -294,6 +320,21 @@ func (d *Downloader) RegisterLightPeer(id string, version int, peer LightPeer) e
 	return d.RegisterPeer(id, version, &lightPeerWrapper{peer})
 }
 
+// RegisterSnapPeer injects a new snap peer into the set of sources to retrieve
+// state ranges from during snap sync.
+func (d *Downloader) RegisterSnapPeer(id string, peer SnapPeer) error {
+	if err := d.snap.register(id, peer); err != nil {
+		log.Error("Failed to register snap peer", "peer", id, "err", err)
+		return err
+	}
+	return nil
+}
+
+// UnregisterSnapPeer removes a snap peer from the set of state range sources.
+func (d *Downloader) UnregisterSnapPeer(id string) error {
+	return d.snap.unregister(id)
+}
+
 // UnregisterPeer remove a peer from the known list, preventing any action from
 // the specified peer. An effort is also made to return any pending fetches into
 // the queue.
@@ -321,6 +362,9 @@ func (d *Downloader) UnregisterPeer(id string) error {
 // Synchronise tries to sync up our local block chain with a remote peer, both
 // adding various sanity checks as well as wrapping it with various log entries.
 func (d *Downloader) Synchronise(id string, head common.Hash, td *big.Int, mode SyncMode) error {
+	// Retrieve the master peer up front, it might be dropped by the time sync fails
+	master := d.peers.Peer(id)
+
 	err := d.synchronise(id, head, td, mode)
 	switch err {
 	case nil:
@@ -330,6 +374,16 @@ func (d *Downloader) Synchronise(id string, head common.Hash, td *big.Int, mode
 		errEmptyHeaderSet, errPeersUnavailable, errTooOld,
 		errInvalidAncestor, errInvalidChain:
 		log.Warn("Synchronisation failed, dropping peer", "peer", id, "err", err)
+		if master != nil {
+			switch err {
+			case errTimeout, errStallingPeer:
+				master.recordScore(scoreTimeout, 0, 0)
+			case errEmptyHeaderSet, errPeersUnavailable, errTooOld:
+				master.recordScore(scoreUseless, 0, 0)
+			default:
+				master.recordScore(scoreInvalid, 0, 0)
+			}
+		}
 		if d.dropPeer == nil {
 			// The dropPeer method is nil when `--copydb` is used for a local copy.
 			// Timeouts can occur if e.g. compaction hits at the wrong time, and can be ignored
@@ -434,6 +488,12 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 	}
 	height := latest.Number.Uint64()
 
+	// If a trusted checkpoint is configured, fast sync empty chains from there
+	if d.mode == FastSync || d.mode == SnapSync {
+		if err := d.anchorCheckpoint(p, height); err != nil {
+			return err
+		}
+	}
 	origin, err := d.findAncestor(p, latest)
 	if err != nil {
 		return err
@@ -444,10 +504,11 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 	}
 	d.syncStatsChainHeight = height
 	d.syncStatsLock.Unlock()
+	d.resetStatus()
 
 	// Ensure our origin point is below any fast sync pivot point
 	pivot := uint64(0)
-	if d.mode == FastSync {
+	if d.mode == FastSync || d.mode == SnapSync {
 		if height <= uint64(fsMinFullBlocks) {
 			origin = 0
 		} else {
@@ -456,9 +517,16 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 				origin = pivot - 1
 			}
 		}
+		// Never rewind below a checkpoint, the blocks before it might be missing
+		if floor, ok := d.checkpointFloor(d.blockchain.CurrentFastBlock().NumberU64()); ok && origin < floor {
+			origin = floor
+			if pivot <= origin {
+				pivot = origin + 1
+			}
+		}
 	}
 	d.committed = 1
-	if d.mode == FastSync && pivot != 0 {
+	if (d.mode == FastSync || d.mode == SnapSync) && pivot != 0 {
 		d.committed = 0
 	}
 	// Initiate the sync using a concurrent header and content retrieval algorithm
@@ -473,7 +541,7 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 		func() error { return d.fetchReceipts(origin + 1) },        // Receipts are retrieved during fast sync
 		func() error { return d.processHeaders(origin+1, pivot, td) },
 	}
-	if d.mode == FastSync {
+	if d.mode == FastSync || d.mode == SnapSync {
 		fetchers = append(fetchers, func() error { return d.processFastSyncContent(latest) })
 	} else if d.mode == FullSync {
 		fetchers = append(fetchers, d.processFullSyncContent)
@@ -658,7 +726,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 	switch d.mode {
 	case FullSync:
 		localHeight = d.blockchain.CurrentBlock().NumberU64()
-	case FastSync:
+	case FastSync, SnapSync:
 		localHeight = d.blockchain.CurrentFastBlock().NumberU64()
 	default:
 		localHeight = d.lightchain.CurrentHeader().Number.Uint64()
@@ -688,6 +756,10 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 			}
 		}
 	}
+	// If we're past a trusted checkpoint, never reorganise below it
+	if number, ok := d.checkpointFloor(localHeight); ok && floor < int64(number)-1 {
+		floor = int64(number) - 1
+	}
 	from, count, skip, max := calculateRequestSpan(remoteHeight, localHeight)
 
 	p.log.Trace("Span searching for common ancestor", "count", count, "from", from, "skip", skip)
@@ -739,7 +811,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 				switch d.mode {
 				case FullSync:
 					known = d.blockchain.HasBlock(h, n)
-				case FastSync:
+				case FastSync, SnapSync:
 					known = d.blockchain.HasFastBlock(h, n)
 				default:
 					known = d.lightchain.HasHeader(h, n)
@@ -812,7 +884,7 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 				switch d.mode {
 				case FullSync:
 					known = d.blockchain.HasBlock(h, n)
-				case FastSync:
+				case FastSync, SnapSync:
 					known = d.blockchain.HasFastBlock(h, n)
 				default:
 					known = d.lightchain.HasHeader(h, n)
@@ -1008,7 +1080,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64, pivot uint64)
 			case d.headerProcCh <- nil:
 			case <-d.cancelCh:
 			}
-			return errBadPeer
+			return errTimeout
 		}
 	}
 }
@@ -1148,6 +1220,15 @@ func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliv
 				// Deliver the received chunk of data and check chain validity
 				accepted, err := deliver(packet)
 				if err == errInvalidChain {
+					// The master peer is penalised when the sync fails, only
+					// account the invalid delivery here if another peer sent it
+					d.cancelLock.RLock()
+					master := peer.id == d.cancelPeer
+					d.cancelLock.RUnlock()
+
+					if !master {
+						peer.recordScore(scoreInvalid, 0, 0)
+					}
 					return err
 				}
 				// Unless a peer delivered something completely else than requested (usually
@@ -1160,6 +1241,7 @@ func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliv
 				switch {
 				case err == nil && packet.Items() == 0:
 					peer.log.Trace("Requested data not delivered", "type", kind)
+					peer.recordScore(scoreUseless, 0, 0)
 				case err == nil:
 					peer.log.Trace("Delivered new batch of data", "type", kind, "count", packet.Stats())
 				default:
@@ -1210,6 +1292,7 @@ func (d *Downloader) fetchParts(errCancel error, deliveryCh chan dataPack, deliv
 						setIdle(peer, 0)
 					} else {
 						peer.log.Debug("Stalling delivery, dropping", "type", kind)
+						peer.recordScore(scoreTimeout, 0, 0)
 						if d.dropPeer == nil {
 							// The dropPeer method is nil when `--copydb` is used for a local copy.
 							// Timeouts can occur if e.g. compaction hits at the wrong time, and can be ignored
@@ -1357,7 +1440,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 				// This check cannot be executed "as is" for full imports, since blocks may still be
 				// queued for processing when the header download completes. However, as long as the
 				// peer gave us something useful, we're already happy/progressed (above check).
-				if d.mode == FastSync || d.mode == LightSync {
+				if d.mode == FastSync || d.mode == SnapSync || d.mode == LightSync {
 					head := d.lightchain.CurrentHeader()
 					if td.Cmp(d.lightchain.GetTd(head.Hash(), head.Number.Uint64())) > 0 {
 						return errStallingPeer
@@ -1385,7 +1468,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 				chunk := headers[:limit]
 
 				// In case of header only syncing, validate the chunk immediately
-				if d.mode == FastSync || d.mode == LightSync {
+				if d.mode == FastSync || d.mode == SnapSync || d.mode == LightSync {
 					// Collect the yet unknown headers to mark them as uncertain
 					unknown := make([]*types.Header, 0, len(headers))
 					for _, header := range chunk {
@@ -1413,7 +1496,7 @@ func (d *Downloader) processHeaders(origin uint64, pivot uint64, td *big.Int) er
 					}
 				}
 				// Unless we're doing light chains, schedule the headers for associated content retrieval
-				if d.mode == FullSync || d.mode == FastSync {
+				if d.mode == FullSync || d.mode == FastSync || d.mode == SnapSync {
 					// If we've reached the allowed number of pending headers, stall a bit
 					for d.queue.PendingBlocks() >= maxQueuedHeaders || d.queue.PendingReceipts() >= maxQueuedHeaders {
 						select {
@@ -1661,26 +1744,76 @@ func (d *Downloader) commitPivotBlock(result *fetchResult) error {
 // DeliverHeaders injects a new batch of block headers received from a remote
 // node into the download schedule.
 func (d *Downloader) DeliverHeaders(id string, headers []*types.Header) (err error) {
-	return d.deliver(id, d.headerCh, &headerPack{id, headers}, headerInMeter, headerDropMeter)
+	if d.backfill.deliverHeaders(id, headers) {
+		headerInMeter.Mark(int64(len(headers)))
+		return nil
+	}
+	return d.deliver(id, d.headerCh, &headerPack{id, headers}, headerInMeter, headerDropMeter, &d.status.headers)
 }
 
 // DeliverBodies injects a new batch of block bodies received from a remote node.
 func (d *Downloader) DeliverBodies(id string, transactions [][]*types.Transaction, uncles [][]*types.Header) (err error) {
-	return d.deliver(id, d.bodyCh, &bodyPack{id, transactions, uncles}, bodyInMeter, bodyDropMeter)
+	if d.backfill.deliverBodies(id, transactions, uncles) {
+		bodyInMeter.Mark(int64(len(transactions)))
+		return nil
+	}
+	return d.deliver(id, d.bodyCh, &bodyPack{id, transactions, uncles}, bodyInMeter, bodyDropMeter, &d.status.bodies)
 }
 
 // DeliverReceipts injects a new batch of receipts received from a remote node.
 func (d *Downloader) DeliverReceipts(id string, receipts [][]*types.Receipt) (err error) {
-	return d.deliver(id, d.receiptCh, &receiptPack{id, receipts}, receiptInMeter, receiptDropMeter)
+	return d.deliver(id, d.receiptCh, &receiptPack{id, receipts}, receiptInMeter, receiptDropMeter, &d.status.receipts)
 }
 
 // DeliverNodeData injects a new batch of node state data received from a remote node.
 func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
-	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
+	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter, &d.status.state)
+}
+
+// DeliverAccountRange injects a new range of accounts received from a remote
+// snap peer.
+func (d *Downloader) DeliverAccountRange(id string, reqid uint64, hashes []common.Hash, accounts [][]byte, proof [][]byte) error {
+	snapAccountInMeter.Mark(int64(len(hashes)))
+	select {
+	case d.snap.accountCh <- &accountResponse{peer: id, id: reqid, hashes: hashes, accounts: accounts, proof: proof}:
+		d.status.state.add(len(hashes))
+		return nil
+	default:
+		snapAccountDropMeter.Mark(int64(len(hashes)))
+		return errSnapBusy
+	}
+}
+
+// DeliverStorageRanges injects a new set of storage ranges received from a
+// remote snap peer.
+func (d *Downloader) DeliverStorageRanges(id string, reqid uint64, hashes [][]common.Hash, slots [][][]byte, proof [][]byte) error {
+	snapStorageInMeter.Mark(int64(len(hashes)))
+	select {
+	case d.snap.storageCh <- &storageResponse{peer: id, id: reqid, hashes: hashes, slots: slots, proof: proof}:
+		d.status.state.add(len(hashes))
+		return nil
+	default:
+		snapStorageDropMeter.Mark(int64(len(hashes)))
+		return errSnapBusy
+	}
+}
+
+// DeliverByteCodes injects a new batch of bytecodes received from a remote snap
+// peer.
+func (d *Downloader) DeliverByteCodes(id string, reqid uint64, codes [][]byte) error {
+	snapCodeInMeter.Mark(int64(len(codes)))
+	select {
+	case d.snap.codeCh <- &codeResponse{peer: id, id: reqid, codes: codes}:
+		d.status.state.add(len(codes))
+		return nil
+	default:
+		snapCodeDropMeter.Mark(int64(len(codes)))
+		return errSnapBusy
+	}
 }
 
 // deliver injects a new batch of data received from a remote node.
-func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
+func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter, phase *phaseTracker) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
 	inMeter.Mark(int64(packet.Items()))
 	defer func() {
@@ -1697,6 +1830,7 @@ func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, i
 	}
 	select {
 	case destCh <- packet:
+		phase.add(packet.Items())
 		return nil
 	case <-cancel:
 		return errNoSyncActive
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -55,6 +56,7 @@ type downloadTester struct {
 	ownBlocks   map[common.Hash]*types.Block   // Blocks belonging to the tester
 	ownReceipts map[common.Hash]types.Receipts // Receipts belonging to the tester
 	ownChainTd  map[common.Hash]*big.Int       // Total difficulties of the blocks in the local chain
+	ownTail     uint64                         // Oldest block with a header and body after a checkpoint sync
 
 	lock sync.RWMutex
 }
@@ -269,6 +271,88 @@ func (dl *downloadTester) InsertReceiptChain(blocks types.Blocks, receipts []typ
 	return len(blocks), nil
 }
 
+// GetHeaderByNumber retrieves a header from the testers canonical chain.
+func (dl *downloadTester) GetHeaderByNumber(number uint64) *types.Header {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	for _, hash := range dl.ownHashes {
+		if header := dl.ownHeaders[hash]; header != nil && header.Number.Uint64() == number {
+			return header
+		}
+	}
+	return nil
+}
+
+// HistoryTail retrieves the oldest block with a header and body after a checkpoint sync.
+func (dl *downloadTester) HistoryTail() uint64 {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.ownTail
+}
+
+// HistoryHorizon retrieves the oldest block with a retained body, always the
+// genesis as the tester doesn't expire history.
+func (dl *downloadTester) HistoryHorizon() uint64 {
+	return 0
+}
+
+// InsertCheckpoint anchors the simulated chain at a checkpoint block.
+func (dl *downloadTester) InsertCheckpoint(block *types.Block, receipts types.Receipts, td *big.Int) error {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if len(dl.ownHashes) > 1 {
+		return errors.New("chain not empty")
+	}
+	dl.ownHashes = append(dl.ownHashes, block.Hash())
+	dl.ownHeaders[block.Hash()] = block.Header()
+	dl.ownBlocks[block.Hash()] = block
+	dl.ownReceipts[block.Hash()] = receipts
+	dl.ownChainTd[block.Hash()] = td
+	dl.ownTail = block.NumberU64()
+	return nil
+}
+
+// InsertBackfillChain injects a batch of historical blocks below the history tail.
+func (dl *downloadTester) InsertBackfillChain(blocks types.Blocks) (int, error) {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	// Find the tail block and ensure the batch links up with it
+	tail := 0
+	for i, hash := range dl.ownHashes {
+		if dl.ownHeaders[hash].Number.Uint64() == dl.ownTail {
+			tail = i
+			break
+		}
+	}
+	child := dl.ownHeaders[dl.ownHashes[tail]]
+	if last := blocks[len(blocks)-1]; last.Hash() != child.ParentHash {
+		return len(blocks) - 1, errors.New("unlinked batch")
+	}
+	td := new(big.Int).Set(dl.ownChainTd[child.Hash()])
+	for i := len(blocks) - 1; i >= 0; i-- {
+		td.Sub(td, child.Difficulty)
+		child = blocks[i].Header()
+
+		dl.ownHeaders[child.Hash()] = child
+		dl.ownBlocks[child.Hash()] = blocks[i]
+		dl.ownChainTd[child.Hash()] = new(big.Int).Set(td)
+	}
+	hashes := append([]common.Hash{}, dl.ownHashes[:tail]...)
+	for _, block := range blocks {
+		hashes = append(hashes, block.Hash())
+	}
+	dl.ownHashes = append(hashes, dl.ownHashes[tail:]...)
+
+	if dl.ownTail = blocks[0].NumberU64(); dl.ownTail == 1 {
+		dl.ownTail = 0
+	}
+	return 0, nil
+}
+
 // Rollback removes some recently added elements from the chain.
 func (dl *downloadTester) Rollback(hashes []common.Hash) {
 	dl.lock.Lock()
@@ -1089,6 +1173,62 @@ func testBlockHeaderAttackerDropping(t *testing.T, protocol int) {
 	}
 }
 
+// ratedTesterPeer is a test peer tracking the reputation events reported to it.
+type ratedTesterPeer struct {
+	*downloadTesterPeer
+	events map[scoreEvent]int
+}
+
+func (p *ratedTesterPeer) Score() int64                    { return 0 }
+func (p *ratedTesterPeer) RecordServed(int, time.Duration) { p.events[scoreServed]++ }
+func (p *ratedTesterPeer) RecordUseless()                  { p.events[scoreUseless]++ }
+func (p *ratedTesterPeer) RecordTimeout()                  { p.events[scoreTimeout]++ }
+func (p *ratedTesterPeer) RecordInvalid()                  { p.events[scoreInvalid]++ }
+
+// Tests that a failed synchronisation penalises the master peer exactly once,
+// even if the peer was already dropped while syncing.
+func TestSyncFailurePenalty(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		result error
+		event  scoreEvent
+	}{
+		{errTimeout, scoreTimeout},
+		{errStallingPeer, scoreTimeout},
+		{errEmptyHeaderSet, scoreUseless},
+		{errInvalidChain, scoreInvalid},
+	}
+	tester := newTester()
+	defer tester.terminate()
+	chain := testChainBase.shorten(1)
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("test %d", i)
+		peer := &ratedTesterPeer{
+			downloadTesterPeer: &downloadTesterPeer{dl: tester, id: id, chain: chain},
+			events:             make(map[scoreEvent]int),
+		}
+		tester.lock.Lock()
+		tester.peers[id] = peer.downloadTesterPeer
+		tester.lock.Unlock()
+		if err := tester.downloader.RegisterPeer(id, 63, peer); err != nil {
+			t.Fatalf("test %d: failed to register new peer: %v", i, err)
+		}
+		// Drop the peer mid-sync, as the fetchers do upon detecting a failure
+		tester.downloader.synchroniseMock = func(string, common.Hash) error {
+			tester.dropPeer(id)
+			return tt.result
+		}
+		tester.downloader.Synchronise(id, tester.genesis.Hash(), big.NewInt(1000), FullSync)
+
+		want := map[scoreEvent]int{tt.event: 1}
+		if !reflect.DeepEqual(peer.events, want) {
+			t.Errorf("test %d: score events mismatch for %v: have %v, want %v", i, tt.result, peer.events, want)
+		}
+	}
+}
+
 // Tests that synchronisation progress (origin block number, current block number
 // and highest block number) is tracked and updated correctly.
 func TestSyncProgress62(t *testing.T)      { testSyncProgress(t, 62, FullSync) }
@@ -1567,3 +1707,142 @@ func TestRemoteHeaderRequestSpan(t *testing.T) {
 		}
 	}
 }
+
+// Tests that fast sync can be anchored at a trusted checkpoint, skipping all the
+// headers before it, and that the preceding history is backfilled afterwards.
+func TestCheckpointSync63(t *testing.T) { testCheckpointSync(t, 63, FastSync) }
+func TestCheckpointSync64(t *testing.T) { testCheckpointSync(t, 64, FastSync) }
+
+func testCheckpointSync(t *testing.T, protocol int, mode SyncMode) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheItems - 15)
+	tester.newPeer("peer", protocol, chain)
+
+	number := uint64(chain.len() / 2)
+	hash := chain.chain[number]
+	tester.downloader.SetCheckpoint(&Checkpoint{Number: number, Hash: hash, Td: chain.td(hash)})
+
+	// Synchronise with the peer and make sure the sync started at the checkpoint
+	var origin uint64
+	tester.downloader.syncInitHook = func(o uint64, height uint64) { origin = o }
+	if err := tester.sync("peer", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	if origin != number {
+		t.Fatalf("sync origin mismatch: have %d, want %d", origin, number)
+	}
+	if head := tester.CurrentFastBlock(); head.Hash() != chain.headBlock().Hash() {
+		t.Fatalf("fast head mismatch: have #%d, want #%d", head.NumberU64(), chain.headBlock().NumberU64())
+	}
+	// Wait for the history to be backfilled and check that everything's present
+	for start := time.Now(); tester.HistoryTail() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("history not backfilled, tail at #%d", tester.HistoryTail())
+		}
+	}
+	for i := 0; i < chain.len(); i++ {
+		hash := chain.chain[i]
+		if tester.GetBlockByHash(hash) == nil {
+			t.Fatalf("block #%d missing", i)
+		}
+		if have, want := tester.GetTd(hash, uint64(i)), chain.td(hash); have == nil || have.Cmp(want) != 0 {
+			t.Fatalf("block #%d td mismatch: have %v, want %v", i, have, want)
+		}
+	}
+}
+
+// Tests that a checkpoint not matching the remote chain aborts the sync without
+// touching the local chain.
+func TestCheckpointMismatch(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheItems - 15)
+	tester.newPeer("peer", 63, chain)
+
+	number := uint64(chain.len() / 2)
+	tester.downloader.SetCheckpoint(&Checkpoint{Number: number, Hash: chain.chain[number-1], Td: big.NewInt(1)})
+
+	if err := tester.sync("peer", nil, FastSync); err != errCheckpointMismatch {
+		t.Fatalf("sync error mismatch: have %v, want %v", err, errCheckpointMismatch)
+	}
+	if head := tester.CurrentHeader(); head.Number.Uint64() != 0 {
+		t.Fatalf("local chain modified: head #%d", head.Number)
+	}
+}
+
+// Tests that the detailed sync status tracks the items retrieved in each phase
+// and derives throughput estimates from them.
+func TestSyncStatus63Full(t *testing.T) { testSyncStatus(t, 63, FullSync) }
+func TestSyncStatus63Fast(t *testing.T) { testSyncStatus(t, 63, FastSync) }
+
+func testSyncStatus(t *testing.T, protocol int, mode SyncMode) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	chain := testChainBase.shorten(blockCacheItems - 15)
+	tester.newPeer("peer", protocol, chain)
+
+	if err := tester.sync("peer", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	status := tester.downloader.Status()
+	if status.Syncing {
+		t.Errorf("sync reported running after completion")
+	}
+	if status.Mode != mode.String() {
+		t.Errorf("sync mode mismatch: have %s, want %s", status.Mode, mode)
+	}
+	if want := uint64(chain.len() - 1); status.CurrentBlock != want || status.HighestBlock != want {
+		t.Errorf("block progress mismatch: have %d/%d, want %d/%d", status.CurrentBlock, status.HighestBlock, want, want)
+	}
+	if status.ETA != 0 {
+		t.Errorf("completed sync has remaining time estimate: %ds", status.ETA)
+	}
+	if status.Headers.Fetched < uint64(chain.len()-1) {
+		t.Errorf("fetched headers mismatch: have %d, want at least %d", status.Headers.Fetched, chain.len()-1)
+	}
+	if status.Bodies.Fetched == 0 {
+		t.Errorf("no fetched bodies accounted")
+	}
+	if fetched := status.Receipts.Fetched; (mode == FastSync) != (fetched > 0) {
+		t.Errorf("fetched receipts mismatch in %s sync: have %d", mode, fetched)
+	}
+	// Sample the throughput manually and ensure it's reported
+	tester.downloader.status.lock.Lock()
+	tester.downloader.status.headers.sample(time.Second)
+	tester.downloader.status.lock.Unlock()
+
+	if rate := tester.downloader.Status().Headers.Rate; rate <= 0 {
+		t.Errorf("header throughput not estimated: have %v", rate)
+	}
+}
+
+// Tests that the sync status can be retrieved before any sync cycle was ever
+// started, when the queue's scheduling structures are not yet allocated.
+func TestSyncStatusBeforeSync(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	status := tester.downloader.Status()
+	if status.Syncing {
+		t.Errorf("sync reported running before start")
+	}
+	if status.Headers.Pending != 0 || status.Headers.InFlight != 0 {
+		t.Errorf("header tasks reported before sync: pending %d, inflight %d", status.Headers.Pending, status.Headers.InFlight)
+	}
+	if status.ETA != 0 {
+		t.Errorf("idle downloader has remaining time estimate: %ds", status.ETA)
+	}
+	updateStatusMetrics(status)
+}
@@ -40,4 +40,12 @@ var (
 
 	stateInMeter   = metrics.NewRegisteredMeter("eth/downloader/states/in", nil)
 	stateDropMeter = metrics.NewRegisteredMeter("eth/downloader/states/drop", nil)
+
+	snapAccountInMeter   = metrics.NewRegisteredMeter("eth/downloader/snap/accounts/in", nil)
+	snapAccountDropMeter = metrics.NewRegisteredMeter("eth/downloader/snap/accounts/drop", nil)
+	snapStorageInMeter   = metrics.NewRegisteredMeter("eth/downloader/snap/storage/in", nil)
+	snapStorageDropMeter = metrics.NewRegisteredMeter("eth/downloader/snap/storage/drop", nil)
+	snapCodeInMeter      = metrics.NewRegisteredMeter("eth/downloader/snap/codes/in", nil)
+	snapCodeDropMeter    = metrics.NewRegisteredMeter("eth/downloader/snap/codes/drop", nil)
+	snapTimeoutMeter     = metrics.NewRegisteredMeter("eth/downloader/snap/timeout", nil)
 )
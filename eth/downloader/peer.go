@@ -90,6 +90,32 @@ type Peer interface {
 	RequestNodeData([]common.Hash) error
 }
 
+// HistoryPeer is an optional extension of Peer, implemented by remote nodes which
+// advertise the oldest block they still serve bodies and receipts for.
+type HistoryPeer interface {
+	HistoryHorizon() uint64
+}
+
+// RatedPeer is an optional extension of Peer, implemented by remote nodes whose
+// reputation is tracked by the networking layer.
+type RatedPeer interface {
+	Score() int64
+	RecordServed(items int, latency time.Duration) // Peer delivered requested data
+	RecordUseless()                                // Peer delivered an empty or unusable response
+	RecordTimeout()                                // Peer failed to answer a request in time
+	RecordInvalid()                                // Peer delivered invalid data
+}
+
+// scoreEvent is an observed behaviour of a remote peer, affecting its reputation.
+type scoreEvent int
+
+const (
+	scoreServed scoreEvent = iota
+	scoreUseless
+	scoreTimeout
+	scoreInvalid
+)
+
 // lightPeerWrapper wraps a LightPeer struct, stubbing out the Peer-only methods.
 type lightPeerWrapper struct {
 	peer LightPeer
@@ -266,6 +292,8 @@ func (p *peerConnection) setIdle(started time.Time, delivered int, throughput *f
 	}
 	// Otherwise update the throughput with a new measurement
 	elapsed := time.Since(started) + 1 // +1 (ns) to ensure non-zero divisor
+	p.recordScore(scoreServed, delivered, elapsed)
+
 	measured := float64(delivered) / (float64(elapsed) / float64(time.Second))
 
 	*throughput = (1-measurementImpact)*(*throughput) + measurementImpact*measured
@@ -313,6 +341,42 @@ func (p *peerConnection) NodeDataCapacity(targetRTT time.Duration) int {
 	return int(math.Min(1+math.Max(1, p.stateThroughput*float64(targetRTT)/float64(time.Second)), float64(MaxStateFetch)))
 }
 
+// Pruned retrieves whether the peer advertised that it doesn't serve the body and
+// receipts of the block with the given number any more.
+func (p *peerConnection) Pruned(number uint64) bool {
+	if hp, ok := p.peer.(HistoryPeer); ok {
+		return number < hp.HistoryHorizon()
+	}
+	return false
+}
+
+// score retrieves the reputation of the remote peer, or zero if it's not tracked.
+func (p *peerConnection) score() int64 {
+	if rp, ok := p.peer.(RatedPeer); ok {
+		return rp.Score()
+	}
+	return 0
+}
+
+// recordScore reports an observed behaviour of the remote peer to its reputation
+// tracker, if there is one.
+func (p *peerConnection) recordScore(event scoreEvent, items int, latency time.Duration) {
+	rp, ok := p.peer.(RatedPeer)
+	if !ok {
+		return
+	}
+	switch event {
+	case scoreServed:
+		rp.RecordServed(items, latency)
+	case scoreUseless:
+		rp.RecordUseless()
+	case scoreTimeout:
+		rp.RecordTimeout()
+	case scoreInvalid:
+		rp.RecordInvalid()
+	}
+}
+
 // MarkLacking appends a new entity to the set of items (blocks, receipts, states)
 // that a peer is known not to have (i.e. have been requested before). If the
 // set reaches its maximum allowed capacity, items are randomly dropped off.
@@ -531,8 +595,20 @@ func (ps *peerSet) idlePeers(minProtocol, maxProtocol int, idleCheck func(*peerC
 			total++
 		}
 	}
+	// Sort the peers by throughput, deferring peers with a bad reputation until
+	// all the reputable ones are busy
+	reputable := make(map[*peerConnection]bool, len(idle))
+	for _, p := range idle {
+		reputable[p] = p.score() >= 0
+	}
 	for i := 0; i < len(idle); i++ {
 		for j := i + 1; j < len(idle); j++ {
+			if reputable[idle[i]] != reputable[idle[j]] {
+				if reputable[idle[j]] {
+					idle[i], idle[j] = idle[j], idle[i]
+				}
+				continue
+			}
 			if throughput(idle[i]) < throughput(idle[j]) {
 				idle[i], idle[j] = idle[j], idle[i]
 			}
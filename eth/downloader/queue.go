@@ -203,6 +203,20 @@ func (q *queue) InFlightReceipts() bool {
 	return len(q.receiptPendPool) > 0
 }
 
+// fillStatus fills the number of pending tasks and in-flight requests of the
+// header, body and receipt retrieval phases into a sync status snapshot.
+func (q *queue) fillStatus(status *SyncStatus) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.headerTaskQueue != nil { // Only allocated when the skeleton is scheduled
+		status.Headers.Pending = q.headerTaskQueue.Size()
+	}
+	status.Headers.InFlight = len(q.headerPendPool)
+	status.Bodies.Pending, status.Bodies.InFlight = q.blockTaskQueue.Size(), len(q.blockPendPool)
+	status.Receipts.Pending, status.Receipts.InFlight = q.receiptTaskQueue.Size(), len(q.receiptPendPool)
+}
+
 // Idle returns if the queue is fully idle or has some data still inside.
 func (q *queue) Idle() bool {
 	q.lock.Lock()
@@ -336,7 +350,7 @@ func (q *queue) Schedule(headers []*types.Header, from uint64) []*types.Header {
 		q.blockTaskPool[hash] = header
 		q.blockTaskQueue.Push(header, -int64(header.Number.Uint64()))
 
-		if q.mode == FastSync {
+		if q.mode == FastSync || q.mode == SnapSync {
 			q.receiptTaskPool[hash] = header
 			q.receiptTaskQueue.Push(header, -int64(header.Number.Uint64()))
 		}
@@ -514,7 +528,7 @@ func (q *queue) reserveHeaders(p *peerConnection, count int, taskPool map[common
 		}
 		if q.resultCache[index] == nil {
 			components := 1
-			if q.mode == FastSync {
+			if q.mode == FastSync || q.mode == SnapSync {
 				components = 2
 			}
 			q.resultCache[index] = &fetchResult{
@@ -534,7 +548,7 @@ func (q *queue) reserveHeaders(p *peerConnection, count int, taskPool map[common
 			continue
 		}
 		// Otherwise unless the peer is known not to have the data, add to the retrieve list
-		if p.Lacks(hash) {
+		if p.Lacks(hash) || p.Pruned(header.Number.Uint64()) {
 			skip = append(skip, header)
 		} else {
 			send = append(send, header)
@@ -0,0 +1,739 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/state"
+	"github.com/severeum/go-severeum/core/types"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/rlp"
+	"github.com/severeum/go-severeum/trie"
+)
+
+const (
+	snapAccountChunks   = 16              // Number of account hash ranges to fill concurrently
+	snapRequestBytes    = 512 * 1024      // Soft response size limit to request from snap peers
+	snapMaxStorageBatch = 128             // Maximum number of storage tries to request at once
+	snapMaxCodeBatch    = 64              // Maximum number of bytecodes to request at once
+	snapResponseBuffer  = 256             // Number of responses to buffer before dropping them
+	snapLogInterval     = 8 * time.Second // Time interval between state sync progress reports
+)
+
+// errSnapBusy is returned if a snap response is dropped due to a full buffer.
+var errSnapBusy = errors.New("snap response buffer full")
+
+// emptyCode is the known hash of the empty EVM bytecode.
+var emptyCode = crypto.Keccak256Hash(nil)
+
+// SnapPeer encapsulates the methods required to retrieve contiguous ranges of
+// the state from a remote peer speaking the snap protocol.
+type SnapPeer interface {
+	RequestAccountRange(id uint64, root common.Hash, origin, limit common.Hash, bytes uint64) error
+	RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error
+	RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error
+}
+
+// accountTask is a contiguous chunk of the account trie to fill from ranges.
+type accountTask struct {
+	origin common.Hash // First account hash in the chunk (progress reporting)
+	next   common.Hash // Next account hash to retrieve
+	last   common.Hash // Last account hash in the chunk
+	trie   *trie.Trie  // Trie accumulating the accounts of the chunk
+	busy   bool        // Whether a request is in flight for the chunk
+	done   bool        // Whether the chunk was fully retrieved
+}
+
+// storageTask is a storage trie to fill from ranges. Small tries are retrieved
+// in batches, large ones are continued from the last retrieved slot.
+type storageTask struct {
+	account common.Hash // Hash of an account owning the storage trie
+	root    common.Hash // Storage root the trie is expected to have
+	next    common.Hash // Next slot hash to retrieve
+	trie    *trie.Trie  // Trie accumulating the slots (nil until the first response)
+}
+
+// snapRequest is an in-flight range request sent to a snap peer.
+type snapRequest struct {
+	id    uint64      // Request ID to match up the response with
+	peer  string      // Peer the request was sent to
+	timer *time.Timer // Timer to fire when the request times out
+
+	account *accountTask   // Account chunk requested (account ranges)
+	storage []*storageTask // Storage tries requested (storage ranges)
+	codes   []common.Hash  // Code hashes requested (bytecodes)
+}
+
+// accountResponse is a range of accounts delivered by a snap peer.
+type accountResponse struct {
+	peer     string
+	id       uint64
+	hashes   []common.Hash
+	accounts [][]byte
+	proof    [][]byte
+}
+
+// storageResponse is a set of storage ranges delivered by a snap peer.
+type storageResponse struct {
+	peer   string
+	id     uint64
+	hashes [][]common.Hash
+	slots  [][][]byte
+	proof  [][]byte
+}
+
+// codeResponse is a batch of bytecodes delivered by a snap peer.
+type codeResponse struct {
+	peer  string
+	id    uint64
+	codes [][]byte
+}
+
+// snapSyncer fills the state of a fast sync pivot from contiguous account and
+// storage ranges retrieved from snap peers. Progress is retained across pivot
+// moves: ranges filled from an older root are fixed up by the trie healing that
+// follows the range retrieval.
+type snapSyncer struct {
+	db     ethdb.Database       // Database to store the retrieved state into
+	triedb *trie.Database       // Trie database to assemble the ranges with
+	ttl    func() time.Duration // Request timeout to apply to new requests
+
+	peers    map[string]SnapPeer // Set of snap peers to retrieve ranges from
+	peerJoin chan struct{}       // Notification channel for new snap peers
+	peerDrop chan string         // Notification channel for departed snap peers
+	lock     sync.RWMutex        // Lock protecting the peer set
+
+	accountCh chan *accountResponse // Channel receiving inbound account ranges
+	storageCh chan *storageResponse // Channel receiving inbound storage ranges
+	codeCh    chan *codeResponse    // Channel receiving inbound bytecodes
+
+	// Sync progress, only accessed by the goroutine running the sync
+	root         common.Hash              // State root currently being synced
+	accountTasks []*accountTask           // Chunks of the account trie to fill
+	storageTasks []*storageTask           // Storage tries waiting to be retrieved
+	storageRoots map[common.Hash]struct{} // Storage roots scheduled for retrieval
+	codeTasks    map[common.Hash]struct{} // Bytecodes waiting to be retrieved
+	healStorage  []common.Hash            // Storage roots failed to be filled from ranges
+	requests     map[uint64]*snapRequest  // Currently in-flight requests
+	busy         map[string]struct{}      // Peers with an in-flight request
+	stateless    map[string]struct{}      // Peers not serving the current root
+	timeoutCh    chan *snapRequest        // Channel receiving timed out requests
+
+	accountSynced uint64    // Number of accounts retrieved
+	slotSynced    uint64    // Number of storage slots retrieved
+	codeSynced    uint64    // Number of bytecodes retrieved
+	bytesSynced   uint64    // Number of state bytes retrieved
+	logTime       time.Time // Time of the last progress report
+}
+
+// newSnapSyncer creates a range based state syncer storing its results into the
+// given database.
+func newSnapSyncer(db ethdb.Database, ttl func() time.Duration) *snapSyncer {
+	return &snapSyncer{
+		db:           db,
+		triedb:       trie.NewDatabase(db),
+		ttl:          ttl,
+		peers:        make(map[string]SnapPeer),
+		peerJoin:     make(chan struct{}, 1),
+		peerDrop:     make(chan string, snapResponseBuffer),
+		accountCh:    make(chan *accountResponse, snapResponseBuffer),
+		storageCh:    make(chan *storageResponse, snapResponseBuffer),
+		codeCh:       make(chan *codeResponse, snapResponseBuffer),
+		storageRoots: make(map[common.Hash]struct{}),
+		codeTasks:    make(map[common.Hash]struct{}),
+		requests:     make(map[uint64]*snapRequest),
+		busy:         make(map[string]struct{}),
+		stateless:    make(map[string]struct{}),
+		timeoutCh:    make(chan *snapRequest),
+	}
+}
+
+// register injects a new snap peer into the set of range sources.
+func (s *snapSyncer) register(id string, peer SnapPeer) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.peers[id]; ok {
+		return errAlreadyRegistered
+	}
+	s.peers[id] = peer
+
+	select {
+	case s.peerJoin <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// unregister removes a snap peer from the set of range sources.
+func (s *snapSyncer) unregister(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.peers[id]; !ok {
+		return errNotRegistered
+	}
+	delete(s.peers, id)
+
+	select {
+	case s.peerDrop <- id:
+	default:
+	}
+	return nil
+}
+
+// sync fills the state trie of the given root from ranges retrieved from snap
+// peers. It returns once all the ranges are filled or no snap peer can serve the
+// root any more, leaving the rest of the state to the trie healing.
+func (s *snapSyncer) sync(root common.Hash, cancel chan struct{}, abort chan struct{}) error {
+	if root == types.EmptyRootHash {
+		return nil
+	}
+	if s.root != root {
+		s.root = root
+		s.stateless = make(map[string]struct{})
+	}
+	if s.accountTasks == nil {
+		s.accountTasks = newAccountTasks(s.triedb)
+	}
+	quit := make(chan struct{})
+	defer func() {
+		close(quit)
+		for _, req := range s.requests {
+			s.revert(req)
+		}
+	}()
+	log.Debug("Starting state sync from ranges", "root", root)
+
+	for !s.finished() {
+		s.assignTasks(quit)
+
+		// If nothing could be requested, no peer is serving the root any more
+		if len(s.requests) == 0 {
+			log.Debug("No snap peers available, healing state", "root", root)
+			return nil
+		}
+		select {
+		case <-s.peerJoin:
+			// New peer arrived, try to assign it download tasks
+
+		case id := <-s.peerDrop:
+			for _, req := range s.requests {
+				if req.peer == id {
+					s.revert(req)
+				}
+			}
+
+		case <-cancel:
+			return errCancelStateFetch
+
+		case <-abort:
+			return errCancelStateFetch
+
+		case req := <-s.timeoutCh:
+			// Ignore stale timeouts of requests already answered
+			if s.requests[req.id] != req {
+				continue
+			}
+			log.Debug("Snap request timed out", "peer", req.peer, "reqid", req.id)
+			snapTimeoutMeter.Mark(1)
+			s.revert(req)
+
+		case res := <-s.accountCh:
+			if err := s.processAccounts(res); err != nil {
+				return err
+			}
+
+		case res := <-s.storageCh:
+			if err := s.processStorage(res); err != nil {
+				return err
+			}
+
+		case res := <-s.codeCh:
+			if err := s.processCodes(res); err != nil {
+				return err
+			}
+		}
+		s.report(false)
+	}
+	s.report(true)
+	return nil
+}
+
+// finished returns whether all the account chunks, storage tries and bytecodes
+// have been retrieved.
+func (s *snapSyncer) finished() bool {
+	for _, task := range s.accountTasks {
+		if !task.done {
+			return false
+		}
+	}
+	return len(s.storageTasks) == 0 && len(s.codeTasks) == 0 && len(s.requests) == 0
+}
+
+// unfinished returns the storage roots and bytecodes the range retrieval did not
+// manage to fill. These are not reachable by healing the account trie, since the
+// accounts referencing them may already be stored locally.
+func (s *snapSyncer) unfinished() ([]common.Hash, []common.Hash) {
+	var storage, codes []common.Hash
+
+	heal := s.healStorage[:0]
+	for _, root := range s.healStorage {
+		if ok, _ := s.db.Has(root[:]); !ok {
+			heal = append(heal, root)
+		}
+	}
+	s.healStorage = heal
+
+	storage = append(storage, s.healStorage...)
+	for _, task := range s.storageTasks {
+		storage = append(storage, task.root)
+	}
+	for hash := range s.codeTasks {
+		codes = append(codes, hash)
+	}
+	return storage, codes
+}
+
+// newAccountTasks splits the account hash space into evenly sized chunks.
+func newAccountTasks(triedb *trie.Database) []*accountTask {
+	tasks := make([]*accountTask, 0, snapAccountChunks)
+	for i := 0; i < snapAccountChunks; i++ {
+		var origin, last common.Hash
+		origin[0] = byte(i * 256 / snapAccountChunks)
+		last[0] = byte((i+1)*256/snapAccountChunks - 1)
+		for j := 1; j < common.HashLength; j++ {
+			last[j] = 0xff
+		}
+		tr, _ := trie.New(common.Hash{}, triedb)
+		tasks = append(tasks, &accountTask{origin: origin, next: origin, last: last, trie: tr})
+	}
+	return tasks
+}
+
+// idlePeers retrieves the snap peers able to serve the current root and without
+// an in-flight request.
+func (s *snapSyncer) idlePeers() map[string]SnapPeer {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	idle := make(map[string]SnapPeer)
+	for id, peer := range s.peers {
+		if _, ok := s.busy[id]; ok {
+			continue
+		}
+		if _, ok := s.stateless[id]; ok {
+			continue
+		}
+		idle[id] = peer
+	}
+	return idle
+}
+
+// assignTasks attempts to assign new tasks to all idle peers, prioritising the
+// storage tries and bytecodes of already retrieved accounts to keep the pending
+// queues short.
+func (s *snapSyncer) assignTasks(quit chan struct{}) {
+	for id, peer := range s.idlePeers() {
+		req := &snapRequest{id: s.newRequestID(), peer: id}
+
+		var err error
+		switch {
+		case len(s.storageTasks) > 0:
+			err = s.requestStorage(req, peer)
+		case len(s.codeTasks) > 0:
+			err = s.requestCodes(req, peer)
+		default:
+			var task *accountTask
+			for _, t := range s.accountTasks {
+				if !t.busy && !t.done {
+					task = t
+					break
+				}
+			}
+			if task == nil {
+				return
+			}
+			task.busy = true
+			req.account = task
+			err = peer.RequestAccountRange(req.id, s.root, task.next, task.last, snapRequestBytes)
+		}
+		s.requests[req.id] = req
+		s.busy[id] = struct{}{}
+
+		if err != nil {
+			log.Debug("Failed to request state ranges", "peer", id, "err", err)
+			s.stateless[id] = struct{}{}
+			s.revert(req)
+			continue
+		}
+		req.timer = time.AfterFunc(s.ttl(), func() {
+			select {
+			case s.timeoutCh <- req:
+			case <-quit:
+			}
+		})
+	}
+}
+
+// requestStorage assigns a batch of storage tries to a request and sends it. A
+// continued large trie is requested on its own.
+func (s *snapSyncer) requestStorage(req *snapRequest, peer SnapPeer) error {
+	if task := s.storageTasks[0]; task.trie != nil {
+		req.storage = []*storageTask{task}
+		s.storageTasks = s.storageTasks[1:]
+		return peer.RequestStorageRanges(req.id, s.root, []common.Hash{task.account}, task.next[:], nil, snapRequestBytes)
+	}
+	var accounts []common.Hash
+	for len(s.storageTasks) > 0 && len(req.storage) < snapMaxStorageBatch {
+		task := s.storageTasks[0]
+		if task.trie != nil {
+			break
+		}
+		req.storage = append(req.storage, task)
+		accounts = append(accounts, task.account)
+		s.storageTasks = s.storageTasks[1:]
+	}
+	return peer.RequestStorageRanges(req.id, s.root, accounts, nil, nil, snapRequestBytes)
+}
+
+// requestCodes assigns a batch of bytecodes to a request and sends it.
+func (s *snapSyncer) requestCodes(req *snapRequest, peer SnapPeer) error {
+	for hash := range s.codeTasks {
+		req.codes = append(req.codes, hash)
+		delete(s.codeTasks, hash)
+		if len(req.codes) >= snapMaxCodeBatch {
+			break
+		}
+	}
+	return peer.RequestByteCodes(req.id, req.codes, snapRequestBytes)
+}
+
+// newRequestID generates a request ID not used by any in-flight request.
+func (s *snapSyncer) newRequestID() uint64 {
+	for {
+		id := rand.Uint64()
+		if _, ok := s.requests[id]; !ok {
+			return id
+		}
+	}
+}
+
+// finish marks a request as answered, releasing its peer.
+func (s *snapSyncer) finish(req *snapRequest) {
+	if req.timer != nil {
+		req.timer.Stop()
+	}
+	delete(s.requests, req.id)
+	delete(s.busy, req.peer)
+}
+
+// revert cancels an in-flight request, returning its tasks to the queues.
+func (s *snapSyncer) revert(req *snapRequest) {
+	s.finish(req)
+
+	if req.account != nil {
+		req.account.busy = false
+	}
+	s.storageTasks = append(req.storage, s.storageTasks...)
+	for _, hash := range req.codes {
+		s.codeTasks[hash] = struct{}{}
+	}
+}
+
+// request retrieves the in-flight request a response belongs to, if any.
+func (s *snapSyncer) request(peer string, id uint64) *snapRequest {
+	req := s.requests[id]
+	if req == nil || req.peer != peer {
+		log.Trace("Unrequested state range", "peer", peer, "reqid", id)
+		return nil
+	}
+	return req
+}
+
+// processAccounts verifies and stores a range of accounts, scheduling their
+// storage tries and bytecodes for retrieval.
+func (s *snapSyncer) processAccounts(res *accountResponse) error {
+	req := s.request(res.peer, res.id)
+	if req == nil || req.account == nil {
+		return nil
+	}
+	// An empty response without proofs means the peer doesn't have the root
+	if len(res.hashes) == 0 && len(res.proof) == 0 {
+		log.Debug("Peer rejected account range", "peer", res.peer, "root", s.root)
+		s.stateless[res.peer] = struct{}{}
+		s.revert(req)
+		return nil
+	}
+	task := req.account
+	accounts := make([]*state.Account, len(res.accounts))
+	for i, blob := range res.accounts {
+		accounts[i] = new(state.Account)
+		if err := rlp.DecodeBytes(blob, accounts[i]); err != nil {
+			log.Debug("Invalid account in range", "peer", res.peer, "err", err)
+			s.stateless[res.peer] = struct{}{}
+			s.revert(req)
+			return nil
+		}
+	}
+	more, err := verifyRange(s.root, task.next, res.hashes, res.accounts, res.proof)
+	if err != nil {
+		log.Debug("Invalid account range", "peer", res.peer, "err", err)
+		s.stateless[res.peer] = struct{}{}
+		s.revert(req)
+		return nil
+	}
+	s.finish(req)
+	task.busy = false
+
+	// Range valid, fill the chunk with the accounts and schedule their content
+	if !more {
+		task.done = true
+	}
+	for i, hash := range res.hashes {
+		if bytes.Compare(hash[:], task.last[:]) > 0 {
+			task.done = true
+			break
+		}
+		if err := task.trie.TryUpdate(hash[:], res.accounts[i]); err != nil {
+			return err
+		}
+		s.scheduleContent(hash, accounts[i])
+
+		s.accountSynced++
+		s.bytesSynced += uint64(common.HashLength + len(res.accounts[i]))
+
+		if hash == task.last {
+			task.done = true
+			break
+		}
+		task.next = incHash(hash)
+	}
+	return s.commit(task.trie)
+}
+
+// scheduleContent schedules the storage trie and bytecode of an account for
+// retrieval, unless they are empty or already known locally.
+func (s *snapSyncer) scheduleContent(hash common.Hash, account *state.Account) {
+	if root := account.Root; root != types.EmptyRootHash {
+		if _, ok := s.storageRoots[root]; !ok {
+			if ok, _ := s.db.Has(root[:]); !ok {
+				s.storageRoots[root] = struct{}{}
+				s.storageTasks = append(s.storageTasks, &storageTask{account: hash, root: root})
+			}
+		}
+	}
+	if code := common.BytesToHash(account.CodeHash); code != emptyCode {
+		if _, ok := s.codeTasks[code]; !ok {
+			if ok, _ := s.db.Has(code[:]); !ok {
+				s.codeTasks[code] = struct{}{}
+			}
+		}
+	}
+}
+
+// processStorage verifies and stores a set of storage ranges. Complete tries are
+// checked against their expected root, incomplete ones are continued.
+func (s *snapSyncer) processStorage(res *storageResponse) error {
+	req := s.request(res.peer, res.id)
+	if req == nil || req.storage == nil {
+		return nil
+	}
+	// An empty response means the peer doesn't have the root
+	if len(res.hashes) == 0 || len(res.hashes) > len(req.storage) {
+		log.Debug("Peer rejected storage ranges", "peer", res.peer, "root", s.root, "tries", len(req.storage), "delivered", len(res.hashes))
+		s.stateless[res.peer] = struct{}{}
+		s.revert(req)
+		return nil
+	}
+	s.finish(req)
+
+	var requeue []*storageTask
+	for i, task := range req.storage {
+		// Put back any tries the peer didn't get to
+		if i >= len(res.hashes) {
+			requeue = append(requeue, task)
+			continue
+		}
+		hashes, slots := res.hashes[i], res.slots[i]
+
+		// Only the last trie can be incomplete, prove its boundaries if so
+		partial := i == len(res.hashes)-1 && len(res.proof) > 0
+		if partial {
+			more, err := verifyRange(task.root, task.next, hashes, slots, res.proof)
+			if err != nil {
+				log.Debug("Invalid storage range", "peer", res.peer, "account", task.account, "err", err)
+				s.healStorage = append(s.healStorage, task.root)
+				delete(s.storageRoots, task.root)
+				continue
+			}
+			partial = more
+		}
+		if task.trie == nil {
+			task.trie, _ = trie.New(common.Hash{}, s.triedb)
+		}
+		for j, hash := range hashes {
+			if err := task.trie.TryUpdate(hash[:], slots[j]); err != nil {
+				return err
+			}
+			s.slotSynced++
+			s.bytesSynced += uint64(common.HashLength + len(slots[j]))
+		}
+		// If the trie is incomplete, flush what we have and continue later
+		if partial && len(hashes) > 0 {
+			task.next = incHash(hashes[len(hashes)-1])
+			if err := s.commit(task.trie); err != nil {
+				return err
+			}
+			requeue = append(requeue, task)
+			continue
+		}
+		// Storage trie complete, only store it if it's correct, heal it otherwise
+		if root := task.trie.Hash(); root != task.root {
+			log.Debug("Storage trie root mismatch", "account", task.account, "have", root, "want", task.root)
+			s.healStorage = append(s.healStorage, task.root)
+			delete(s.storageRoots, task.root)
+			continue
+		}
+		if err := s.commit(task.trie); err != nil {
+			return err
+		}
+		delete(s.storageRoots, task.root)
+	}
+	s.storageTasks = append(requeue, s.storageTasks...)
+	return nil
+}
+
+// processCodes verifies and stores a batch of bytecodes.
+func (s *snapSyncer) processCodes(res *codeResponse) error {
+	req := s.request(res.peer, res.id)
+	if req == nil || req.codes == nil {
+		return nil
+	}
+	if len(res.codes) == 0 {
+		log.Debug("Peer rejected bytecodes", "peer", res.peer, "codes", len(req.codes))
+		s.stateless[res.peer] = struct{}{}
+		s.revert(req)
+		return nil
+	}
+	s.finish(req)
+
+	missing := make(map[common.Hash]struct{}, len(req.codes))
+	for _, hash := range req.codes {
+		missing[hash] = struct{}{}
+	}
+	batch := s.db.NewBatch()
+	for _, code := range res.codes {
+		hash := crypto.Keccak256Hash(code)
+		if _, ok := missing[hash]; !ok {
+			continue
+		}
+		delete(missing, hash)
+		if err := batch.Put(hash[:], code); err != nil {
+			return err
+		}
+		s.codeSynced++
+		s.bytesSynced += uint64(len(code))
+	}
+	if err := batch.Write(); err != nil {
+		return fmt.Errorf("DB write error: %v", err)
+	}
+	for hash := range missing {
+		s.codeTasks[hash] = struct{}{}
+	}
+	return nil
+}
+
+// commit flushes a trie filled from ranges into the database.
+func (s *snapSyncer) commit(tr *trie.Trie) error {
+	root, err := tr.Commit(nil)
+	if err != nil {
+		return err
+	}
+	if err := s.triedb.Commit(root, false); err != nil {
+		return fmt.Errorf("DB write error: %v", err)
+	}
+	return nil
+}
+
+// report displays the state sync progress for the user to see, estimated from
+// the position of the account chunks.
+func (s *snapSyncer) report(force bool) {
+	if !force && time.Since(s.logTime) < snapLogInterval {
+		return
+	}
+	s.logTime = time.Now()
+
+	var done float64
+	for _, task := range s.accountTasks {
+		if task.done {
+			done++
+			continue
+		}
+		origin := binary.BigEndian.Uint64(task.origin[:8])
+		next := binary.BigEndian.Uint64(task.next[:8])
+		last := binary.BigEndian.Uint64(task.last[:8])
+		done += float64(next-origin) / float64(last-origin)
+	}
+	progress := fmt.Sprintf("%.2f%%", 100*done/float64(len(s.accountTasks)))
+
+	log.Info("State sync in progress", "synced", progress, "accounts", s.accountSynced, "slots", s.slotSynced, "codes", s.codeSynced,
+		"bytes", common.StorageSize(s.bytesSynced), "pending", len(s.storageTasks)+len(s.codeTasks))
+}
+
+// verifyRange checks that a range of trie entries starting at the origin is the
+// exact content of the trie between the origin and the last entry, proven by the
+// Merkle proof of the two edges. It reports whether the trie has more entries
+// beyond the range.
+func verifyRange(root common.Hash, origin common.Hash, keys []common.Hash, values [][]byte, proof [][]byte) (bool, error) {
+	db := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	var (
+		blobs = make([][]byte, len(keys))
+		last  []byte
+	)
+	for i, key := range keys {
+		blobs[i] = common.CopyBytes(key[:])
+	}
+	if len(keys) > 0 {
+		last = blobs[len(blobs)-1]
+	}
+	return trie.VerifyRangeProof(root, origin[:], last, blobs, values, db)
+}
+
+// incHash returns the hash directly following the given one.
+func incHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/state"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/event"
+	"github.com/severeum/go-severeum/rlp"
+	"github.com/severeum/go-severeum/trie"
+)
+
+// snapTestPeer is a snap peer serving state ranges from a local database, with
+// responses capped at a small size to exercise continuations.
+type snapTestPeer struct {
+	id     string
+	d      *Downloader
+	triedb *trie.Database
+	limit  uint64      // Response size limit, regardless of the requested one
+	forge  common.Hash // Account whose storage is served with a forged first slot
+}
+
+// serveRange collects the consecutive entries of a trie starting at the origin,
+// stopping at the first one beyond the limit or when running out of bytes.
+func (p *snapTestPeer) serveRange(tr *trie.Trie, origin []byte, limit []byte) ([]common.Hash, [][]byte, bool) {
+	var (
+		keys   []common.Hash
+		values [][]byte
+		size   uint64
+	)
+	it := trie.NewIterator(tr.NodeIterator(origin))
+	for it.Next() {
+		if size >= p.limit {
+			return keys, values, true
+		}
+		keys = append(keys, common.BytesToHash(it.Key))
+		values = append(values, common.CopyBytes(it.Value))
+		size += uint64(common.HashLength + len(it.Value))
+
+		if limit != nil && bytes.Compare(it.Key, limit) >= 0 {
+			break
+		}
+	}
+	return keys, values, false
+}
+
+// proveRange proves the origin and the last key of a range.
+func (p *snapTestPeer) proveRange(tr *trie.Trie, origin []byte, keys []common.Hash) [][]byte {
+	db := ethdb.NewMemDatabase()
+	tr.Prove(origin, 0, db)
+	if len(keys) > 0 {
+		tr.Prove(keys[len(keys)-1][:], 0, db)
+	}
+	var proof [][]byte
+	for _, key := range db.Keys() {
+		blob, _ := db.Get(key)
+		proof = append(proof, blob)
+	}
+	return proof
+}
+
+func (p *snapTestPeer) RequestAccountRange(id uint64, root common.Hash, origin, limit common.Hash, bytes uint64) error {
+	tr, err := trie.New(root, p.triedb)
+	if err != nil {
+		go p.d.DeliverAccountRange(p.id, id, nil, nil, nil)
+		return nil
+	}
+	keys, values, _ := p.serveRange(tr, origin[:], limit[:])
+	go p.d.DeliverAccountRange(p.id, id, keys, values, p.proveRange(tr, origin[:], keys))
+	return nil
+}
+
+func (p *snapTestPeer) RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error {
+	var (
+		hashes [][]common.Hash
+		slots  [][][]byte
+		proof  [][]byte
+	)
+	if tr, err := trie.New(root, p.triedb); err == nil {
+		for _, account := range accounts {
+			var acc state.Account
+			if err := rlp.DecodeBytes(tr.Get(account[:]), &acc); err != nil {
+				break
+			}
+			st, _ := trie.New(acc.Root, p.triedb)
+			keys, values, abort := p.serveRange(st, origin, nil)
+			if account == p.forge && len(values) > 0 {
+				values[0] = []byte{0x01}
+			}
+			hashes, slots = append(hashes, keys), append(slots, values)
+
+			if origin != nil || abort {
+				if origin == nil {
+					origin = common.Hash{}.Bytes()
+				}
+				proof = p.proveRange(st, origin, keys)
+				break
+			}
+		}
+	}
+	go p.d.DeliverStorageRanges(p.id, id, hashes, slots, proof)
+	return nil
+}
+
+func (p *snapTestPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	var codes [][]byte
+	for _, hash := range hashes {
+		if code, err := p.triedb.Node(hash); err == nil {
+			codes = append(codes, code)
+		}
+	}
+	go p.d.DeliverByteCodes(p.id, id, codes)
+	return nil
+}
+
+// makeSnapTestState creates a state with a few thousand accounts, some of them
+// with storage and code, and one with a storage trie large enough to need many
+// range requests.
+func makeSnapTestState(t *testing.T) (*state.StateDB, common.Hash) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	for i := 0; i < 2000; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		statedb.AddBalance(addr, big.NewInt(int64(i+1)))
+		if i%20 == 0 {
+			statedb.SetCode(addr, []byte{byte(i), byte(i >> 8), 0x60})
+			for j := 0; j < 5; j++ {
+				statedb.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i+j+1))))
+			}
+		}
+	}
+	large := common.BigToAddress(big.NewInt(1))
+	for j := 0; j < 1000; j++ {
+		statedb.SetState(large, common.BigToHash(big.NewInt(int64(100+j))), common.BigToHash(big.NewInt(int64(j+1))))
+	}
+	root, err := statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	statedb, _ = state.New(root, statedb.Database())
+	return statedb, root
+}
+
+// healState runs a node-by-node trie sync on top of the ranges filled by snap
+// sync, returning the number of trie nodes that needed healing.
+func healState(t *testing.T, d *Downloader, src *trie.Database, root common.Hash) int {
+	sched := state.NewStateSync(root, d.stateDB)
+
+	storage, codes := d.snap.unfinished()
+	for _, root := range storage {
+		sched.AddSubTrie(root, 64, common.Hash{}, nil)
+	}
+	for _, hash := range codes {
+		sched.AddRawEntry(hash, 64, common.Hash{})
+	}
+	healed := 0
+	for missing := sched.Missing(0); len(missing) > 0; missing = sched.Missing(0) {
+		results := make([]trie.SyncResult, len(missing))
+		for i, hash := range missing {
+			data, err := src.Node(hash)
+			if err != nil {
+				t.Fatalf("failed to retrieve node data for %x: %v", hash, err)
+			}
+			results[i] = trie.SyncResult{Hash: hash, Data: data}
+		}
+		if _, index, err := sched.Process(results); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		batch := d.stateDB.NewBatch()
+		if _, err := sched.Commit(batch); err != nil {
+			t.Fatalf("failed to commit data: %v", err)
+		}
+		batch.Write()
+		healed += len(missing)
+	}
+	return healed
+}
+
+// checkSnapState verifies that every trie node and bytecode of the source state
+// is present in the synced database.
+func checkSnapState(t *testing.T, src *state.StateDB, db ethdb.Database) {
+	t.Helper()
+
+	nodes := 0
+	for it := state.NewNodeIterator(src); it.Next(); {
+		if it.Hash == (common.Hash{}) {
+			continue
+		}
+		if ok, _ := db.Has(it.Hash[:]); !ok {
+			t.Fatalf("state entry %x missing", it.Hash)
+		}
+		nodes++
+	}
+	if nodes == 0 {
+		t.Fatalf("no state entries iterated")
+	}
+}
+
+// Tests that the state can be filled from ranges served by snap peers, leaving
+// only the boundaries of the account chunks to heal.
+func TestSnapSync(t *testing.T) {
+	src, root := makeSnapTestState(t)
+	srcdb := src.Database().TrieDB()
+
+	d := New(SnapSync, ethdb.NewMemDatabase(), new(event.TypeMux), nil, nil, func(string) {})
+	defer d.Terminate()
+
+	d.RegisterSnapPeer("snap", &snapTestPeer{id: "snap", d: d, triedb: srcdb, limit: 4096})
+	if err := d.snap.sync(root, make(chan struct{}), make(chan struct{})); err != nil {
+		t.Fatalf("failed to snap sync state: %v", err)
+	}
+	if healed := healState(t, d, srcdb, root); healed > 2*snapAccountChunks {
+		t.Errorf("too many nodes healed: have %d, want at most %d", healed, 2*snapAccountChunks)
+	}
+	checkSnapState(t, src, d.stateDB)
+}
+
+// Tests that if no snap peer is able to serve the requested root, the sync falls
+// back to healing the entire state.
+func TestSnapSyncStateless(t *testing.T) {
+	src, root := makeSnapTestState(t)
+	srcdb := src.Database().TrieDB()
+
+	d := New(SnapSync, ethdb.NewMemDatabase(), new(event.TypeMux), nil, nil, func(string) {})
+	defer d.Terminate()
+
+	empty := trie.NewDatabase(ethdb.NewMemDatabase())
+	d.RegisterSnapPeer("stateless", &snapTestPeer{id: "stateless", d: d, triedb: empty, limit: 4096})
+	if err := d.snap.sync(root, make(chan struct{}), make(chan struct{})); err != nil {
+		t.Fatalf("failed to snap sync state: %v", err)
+	}
+	healState(t, d, srcdb, root)
+	checkSnapState(t, src, d.stateDB)
+}
+
+// Tests that complete storage tries not matching their expected root are not
+// stored, rather healed.
+func TestSnapSyncForgedStorage(t *testing.T) {
+	src, root := makeSnapTestState(t)
+	srcdb := src.Database().TrieDB()
+
+	// Assemble the storage trie the peer will forge
+	var (
+		forged = crypto.Keccak256Hash(common.BigToAddress(big.NewInt(21)).Bytes())
+		acc    state.Account
+	)
+	tr, _ := trie.New(root, srcdb)
+	if err := rlp.DecodeBytes(tr.Get(forged[:]), &acc); err != nil {
+		t.Fatalf("failed to decode forged account: %v", err)
+	}
+	st, _ := trie.New(acc.Root, srcdb)
+	keys, values, _ := (&snapTestPeer{limit: 4096}).serveRange(st, nil, nil)
+	values[0] = []byte{0x01}
+
+	junk, _ := trie.New(common.Hash{}, trie.NewDatabase(ethdb.NewMemDatabase()))
+	for i, key := range keys {
+		junk.Update(key[:], values[i])
+	}
+	// Sync the state and ensure the forged trie was dropped and healed
+	d := New(SnapSync, ethdb.NewMemDatabase(), new(event.TypeMux), nil, nil, func(string) {})
+	defer d.Terminate()
+
+	d.RegisterSnapPeer("forger", &snapTestPeer{id: "forger", d: d, triedb: srcdb, limit: 4096, forge: forged})
+	if err := d.snap.sync(root, make(chan struct{}), make(chan struct{})); err != nil {
+		t.Fatalf("failed to snap sync state: %v", err)
+	}
+	if ok, _ := d.stateDB.Has(junk.Hash().Bytes()); ok {
+		t.Errorf("forged storage trie stored")
+	}
+	healState(t, d, srcdb, root)
+	checkSnapState(t, src, d.stateDB)
+}
+
+// Tests that range responses are rejected unless they are proven to be the exact
+// content of the trie between their edges.
+func TestSnapRangeVerification(t *testing.T) {
+	src, root := makeSnapTestState(t)
+
+	tr, _ := trie.New(root, src.Database().TrieDB())
+	peer := &snapTestPeer{limit: 4096}
+
+	origin := common.Hash{}
+	keys, values, _ := peer.serveRange(tr, origin[:], nil)
+	proof := peer.proveRange(tr, origin[:], keys)
+
+	if more, err := verifyRange(root, origin, keys, values, proof); err != nil {
+		t.Fatalf("valid range rejected: %v", err)
+	} else if !more {
+		t.Errorf("partial range reported as complete")
+	}
+	if _, err := verifyRange(root, origin, keys, values, proof[:1]); err == nil {
+		t.Errorf("range with missing proof nodes accepted")
+	}
+	if _, err := verifyRange(root, origin, keys[:len(keys)-1], values[:len(values)-1], proof); err == nil {
+		t.Errorf("range with unproven end accepted")
+	}
+	if _, err := verifyRange(root, origin, append(keys[:1:1], keys[2:]...), append(values[:1:1], values[2:]...), proof); err == nil {
+		t.Errorf("range with gap accepted")
+	}
+	swapped := append([]common.Hash{}, keys...)
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	if _, err := verifyRange(root, origin, swapped, values, proof); err == nil {
+		t.Errorf("unsorted range accepted")
+	}
+	forged := append([][]byte{}, values...)
+	forged[len(forged)/2] = []byte{0x01}
+	if _, err := verifyRange(root, origin, keys, forged, proof); err == nil {
+		t.Errorf("forged range accepted")
+	}
+}
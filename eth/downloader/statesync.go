@@ -212,7 +212,8 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 // stateSync schedules requests for downloading a particular state trie defined
 // by a given state root.
 type stateSync struct {
-	d *Downloader // Downloader instance to access and manage current peerset
+	d    *Downloader // Downloader instance to access and manage current peerset
+	root common.Hash // State root currently being synced
 
 	sched  *trie.Sync                 // State trie sync scheduler defining the tasks
 	keccak hash.Hash                  // Keccak256 hasher to verify deliveries with
@@ -239,6 +240,7 @@ type stateTask struct {
 func newStateSync(d *Downloader, root common.Hash) *stateSync {
 	return &stateSync{
 		d:       d,
+		root:    root,
 		sched:   state.NewStateSync(root, d.stateDB),
 		keccak:  sha3.NewLegacyKeccak256(),
 		tasks:   make(map[common.Hash]*stateTask),
@@ -286,6 +288,21 @@ func (s *stateSync) loop() (err error) {
 		}
 	}()
 
+	// In snap sync, fill the bulk of the state from ranges before healing the
+	// trie. Storage and code the ranges missed are not reachable from the local
+	// account trie any more, so schedule them for healing explicitly.
+	if s.d.mode == SnapSync && s.sched.Pending() > 0 {
+		if err = s.d.snap.sync(s.root, s.cancel, s.d.cancelCh); err != nil {
+			return err
+		}
+		storage, codes := s.d.snap.unfinished()
+		for _, root := range storage {
+			s.sched.AddSubTrie(root, 64, common.Hash{}, nil)
+		}
+		for _, hash := range codes {
+			s.sched.AddRawEntry(hash, 64, common.Hash{})
+		}
+	}
 	// Keep assigning new tasks until the sync completes or aborts
 	for s.sched.Pending() > 0 {
 		if err = s.commit(false); err != nil {
@@ -310,6 +327,7 @@ func (s *stateSync) loop() (err error) {
 				// 2 items are the minimum requested, if even that times out, we've no use of
 				// this peer at the moment.
 				log.Warn("Stalling state sync, dropping peer", "peer", req.peer.id)
+				req.peer.recordScore(scoreTimeout, 0, 0)
 				s.d.dropPeer(req.peer.id)
 			}
 			// Process all the received blobs and check for stale delivery
@@ -0,0 +1,266 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/metrics"
+)
+
+var (
+	statusInterval = 8 * time.Second // Interval between progress samples and log lines
+	statusImpact   = 0.25            // Impact of a new sample on the throughput estimates
+)
+
+var (
+	headerPendingGauge   = metrics.NewRegisteredGauge("eth/downloader/headers/pending", nil)
+	headerInFlightGauge  = metrics.NewRegisteredGauge("eth/downloader/headers/inflight", nil)
+	headerPeersGauge     = metrics.NewRegisteredGauge("eth/downloader/headers/peers", nil)
+	headerRateGauge      = metrics.NewRegisteredGauge("eth/downloader/headers/rate", nil)
+	bodyPendingGauge     = metrics.NewRegisteredGauge("eth/downloader/bodies/pending", nil)
+	bodyInFlightGauge    = metrics.NewRegisteredGauge("eth/downloader/bodies/inflight", nil)
+	bodyPeersGauge       = metrics.NewRegisteredGauge("eth/downloader/bodies/peers", nil)
+	bodyRateGauge        = metrics.NewRegisteredGauge("eth/downloader/bodies/rate", nil)
+	receiptPendingGauge  = metrics.NewRegisteredGauge("eth/downloader/receipts/pending", nil)
+	receiptInFlightGauge = metrics.NewRegisteredGauge("eth/downloader/receipts/inflight", nil)
+	receiptPeersGauge    = metrics.NewRegisteredGauge("eth/downloader/receipts/peers", nil)
+	receiptRateGauge     = metrics.NewRegisteredGauge("eth/downloader/receipts/rate", nil)
+	statePendingGauge    = metrics.NewRegisteredGauge("eth/downloader/states/pending", nil)
+	statePeersGauge      = metrics.NewRegisteredGauge("eth/downloader/states/peers", nil)
+	stateRateGauge       = metrics.NewRegisteredGauge("eth/downloader/states/rate", nil)
+
+	blockRateGauge = metrics.NewRegisteredGauge("eth/downloader/blocks/rate", nil)
+	etaGauge       = metrics.NewRegisteredGauge("eth/downloader/eta", nil)
+)
+
+// PhaseStatus contains the progress statistics of a single phase of the sync,
+// i.e. the retrieval of headers, block bodies, receipts or state entries.
+type PhaseStatus struct {
+	Pending  int     `json:"pending"`  // Number of tasks waiting to be scheduled
+	InFlight int     `json:"inflight"` // Number of requests currently in flight
+	Peers    int     `json:"peers"`    // Number of peers busy serving the phase
+	Idle     int     `json:"idle"`     // Number of capable peers currently idle
+	Fetched  uint64  `json:"fetched"`  // Number of items retrieved in the current sync cycle
+	Rate     float64 `json:"rate"`     // Recent throughput in items per second
+}
+
+// SyncStatus is a detailed snapshot of the downloader's progress, breaking the
+// sync down into its individual phases.
+type SyncStatus struct {
+	Syncing bool   `json:"syncing"` // Whether a sync cycle is currently running
+	Mode    string `json:"mode"`    // Sync mode of the current or last sync cycle
+
+	StartingBlock uint64 `json:"startingBlock"` // Block number where sync began
+	CurrentBlock  uint64 `json:"currentBlock"`  // Current block number where sync is at
+	HighestBlock  uint64 `json:"highestBlock"`  // Highest alleged block number in the chain
+	PulledStates  uint64 `json:"pulledStates"`  // Number of state trie entries already downloaded
+	KnownStates   uint64 `json:"knownStates"`   // Total number of state trie entries known about
+
+	BlockRate float64 `json:"blockRate"` // Recent import rate in blocks per second
+	ETA       uint64  `json:"eta"`       // Estimated seconds until the chain head is reached (0 = unknown)
+
+	Headers  PhaseStatus `json:"headers"`
+	Bodies   PhaseStatus `json:"bodies"`
+	Receipts PhaseStatus `json:"receipts"`
+	State    PhaseStatus `json:"state"`
+}
+
+// phaseTracker measures the throughput of a single sync phase.
+type phaseTracker struct {
+	fetched uint64  // Number of items retrieved in the current sync cycle (atomic)
+	sampled uint64  // Number of retrieved items at the last sample
+	rate    float64 // Estimated throughput in items per second
+}
+
+// add accounts a batch of items retrieved for the phase.
+func (t *phaseTracker) add(items int) {
+	atomic.AddUint64(&t.fetched, uint64(items))
+}
+
+// sample updates the throughput estimate with the items retrieved since the
+// last sample.
+func (t *phaseTracker) sample(elapsed time.Duration) {
+	fetched := atomic.LoadUint64(&t.fetched)
+	t.rate = (1-statusImpact)*t.rate + statusImpact*float64(fetched-t.sampled)/elapsed.Seconds()
+	t.sampled = fetched
+}
+
+// reset clears the retrieval counters at the start of a new sync cycle.
+func (t *phaseTracker) reset() {
+	atomic.StoreUint64(&t.fetched, 0)
+	t.sampled, t.rate = 0, 0
+}
+
+// statusTracker aggregates the throughput of all sync phases along with the
+// block import rate used to estimate the remaining sync time.
+type statusTracker struct {
+	headers  phaseTracker
+	bodies   phaseTracker
+	receipts phaseTracker
+	state    phaseTracker
+
+	block     uint64  // Current block at the last sample
+	blockRate float64 // Estimated import rate in blocks per second
+	lock      sync.RWMutex
+}
+
+// resetStatus clears the throughput statistics at the start of a sync cycle.
+func (d *Downloader) resetStatus() {
+	current := d.Progress().CurrentBlock
+
+	d.status.lock.Lock()
+	defer d.status.lock.Unlock()
+
+	for _, t := range []*phaseTracker{&d.status.headers, &d.status.bodies, &d.status.receipts, &d.status.state} {
+		t.reset()
+	}
+	d.status.block, d.status.blockRate = current, 0
+}
+
+// Status retrieves a detailed snapshot of the sync progress, including the
+// per-phase queue sizes, peer counts and throughputs, as well as an estimate of
+// the remaining sync time.
+func (d *Downloader) Status() *SyncStatus {
+	progress := d.Progress()
+	status := &SyncStatus{
+		Syncing:       d.Synchronising(),
+		Mode:          d.mode.String(),
+		StartingBlock: progress.StartingBlock,
+		CurrentBlock:  progress.CurrentBlock,
+		HighestBlock:  progress.HighestBlock,
+		PulledStates:  progress.PulledStates,
+		KnownStates:   progress.KnownStates,
+	}
+	d.queue.fillStatus(status)
+	if progress.KnownStates > progress.PulledStates {
+		status.State.Pending = int(progress.KnownStates - progress.PulledStates)
+	}
+
+	for _, phase := range []struct {
+		status *PhaseStatus
+		idle   func() ([]*peerConnection, int)
+	}{
+		{&status.Headers, d.peers.HeaderIdlePeers},
+		{&status.Bodies, d.peers.BodyIdlePeers},
+		{&status.Receipts, d.peers.ReceiptIdlePeers},
+		{&status.State, d.peers.NodeDataIdlePeers},
+	} {
+		idle, total := phase.idle()
+		phase.status.Idle, phase.status.Peers = len(idle), total-len(idle)
+	}
+	d.status.lock.RLock()
+	defer d.status.lock.RUnlock()
+
+	for _, phase := range []struct {
+		status  *PhaseStatus
+		tracker *phaseTracker
+	}{
+		{&status.Headers, &d.status.headers},
+		{&status.Bodies, &d.status.bodies},
+		{&status.Receipts, &d.status.receipts},
+		{&status.State, &d.status.state},
+	} {
+		phase.status.Fetched = atomic.LoadUint64(&phase.tracker.fetched)
+		phase.status.Rate = phase.tracker.rate
+	}
+	status.BlockRate = d.status.blockRate
+	if status.Syncing && status.BlockRate > 0 && status.HighestBlock > status.CurrentBlock {
+		status.ETA = uint64(float64(status.HighestBlock-status.CurrentBlock) / status.BlockRate)
+	}
+	return status
+}
+
+// statusLoop periodically samples the sync throughput, updating the metrics and
+// reporting the progress in the logs while a sync cycle is running.
+func (d *Downloader) statusLoop() {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.quitCh:
+			return
+		}
+		elapsed := time.Since(last)
+		last = time.Now()
+
+		// Integrate the new sample into the throughput estimates
+		current := d.Progress().CurrentBlock
+
+		d.status.lock.Lock()
+		for _, t := range []*phaseTracker{&d.status.headers, &d.status.bodies, &d.status.receipts, &d.status.state} {
+			t.sample(elapsed)
+		}
+		if current >= d.status.block {
+			d.status.blockRate = (1-statusImpact)*d.status.blockRate + statusImpact*float64(current-d.status.block)/elapsed.Seconds()
+		}
+		d.status.block = current
+		d.status.lock.Unlock()
+
+		// Publish the fresh status and report it if syncing
+		status := d.Status()
+		updateStatusMetrics(status)
+
+		if !status.Syncing {
+			continue
+		}
+		context := []interface{}{
+			"mode", status.Mode, "current", status.CurrentBlock, "highest", status.HighestBlock,
+			"blocks/s", int(status.BlockRate),
+		}
+		if status.ETA > 0 {
+			context = append(context, "eta", common.PrettyDuration(time.Duration(status.ETA)*time.Second))
+		}
+		context = append(context, "headers", status.Headers.Pending, "bodies", status.Bodies.Pending)
+		if status.Mode != FullSync.String() {
+			context = append(context, "receipts", status.Receipts.Pending, "states", status.PulledStates, "pending", status.State.Pending)
+		}
+		log.Info("Synchronisation progress", context...)
+	}
+}
+
+// updateStatusMetrics publishes a sync status snapshot into the metrics system.
+func updateStatusMetrics(status *SyncStatus) {
+	headerPendingGauge.Update(int64(status.Headers.Pending))
+	headerInFlightGauge.Update(int64(status.Headers.InFlight))
+	headerPeersGauge.Update(int64(status.Headers.Peers))
+	headerRateGauge.Update(int64(status.Headers.Rate))
+
+	bodyPendingGauge.Update(int64(status.Bodies.Pending))
+	bodyInFlightGauge.Update(int64(status.Bodies.InFlight))
+	bodyPeersGauge.Update(int64(status.Bodies.Peers))
+	bodyRateGauge.Update(int64(status.Bodies.Rate))
+
+	receiptPendingGauge.Update(int64(status.Receipts.Pending))
+	receiptInFlightGauge.Update(int64(status.Receipts.InFlight))
+	receiptPeersGauge.Update(int64(status.Receipts.Peers))
+	receiptRateGauge.Update(int64(status.Receipts.Rate))
+
+	statePendingGauge.Update(int64(status.State.Pending))
+	statePeersGauge.Update(int64(status.State.Peers))
+	stateRateGauge.Update(int64(status.State.Rate))
+
+	blockRateGauge.Update(int64(status.BlockRate))
+	etaGauge.Update(int64(status.ETA))
+}
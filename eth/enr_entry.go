@@ -0,0 +1,78 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"github.com/severeum/go-severeum/core"
+	"github.com/severeum/go-severeum/core/forkid"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/p2p/enr"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+// ethEntry is the "eth" ENR entry which advertises eth protocol
+// on the discovery network.
+type ethEntry struct {
+	ForkID forkid.ID // Fork identifier per EIP-2124
+
+	// Ignore additional fields (for forward compatibility).
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+// ENRKey implements enr.Entry.
+func (e ethEntry) ENRKey() string {
+	return "eth"
+}
+
+// currentEthEntry returns the "eth" entry matching the current chain head.
+func (pm *ProtocolManager) currentEthEntry() *ethEntry {
+	return &ethEntry{ForkID: forkid.NewID(pm.blockchain)}
+}
+
+// startEthEntryUpdate keeps the "eth" entry of the local node record up to date
+// as the chain passes fork blocks. It stops when the blockchain is stopped.
+func (pm *ProtocolManager) startEthEntryUpdate(ln *enode.LocalNode) {
+	newHead := make(chan core.ChainHeadEvent, 10)
+	sub := pm.blockchain.SubscribeChainHeadEvent(newHead)
+
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case <-newHead:
+				// LocalNode only bumps the record sequence number if the
+				// entry actually changed.
+				ln.Set(pm.currentEthEntry())
+			case <-sub.Err():
+				// Would be nice to sync with Stop, but there is no
+				// good way to do that.
+				return
+			}
+		}
+	}()
+}
+
+// dialFilter reports whether n should be dialed. Nodes announcing a fork ID
+// incompatible with the local chain are rejected. Nodes without an "eth" entry
+// are accepted because most of the network doesn't publish it yet.
+func (pm *ProtocolManager) dialFilter(n *enode.Node) bool {
+	var entry ethEntry
+	if err := n.Load(&entry); err != nil {
+		return enr.IsNotFound(err)
+	}
+	return pm.forkFilter(entry.ForkID) == nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"testing"
+
+	"github.com/severeum/go-severeum/core/forkid"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/eth/downloader"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/p2p/enr"
+)
+
+// Tests that dial candidates are filtered by the fork ID in their "eth" entry.
+func TestDialFilter(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	key, _ := crypto.GenerateKey()
+	makeNode := func(entries ...enr.Entry) *enode.Node {
+		var r enr.Record
+		for _, e := range entries {
+			r.Set(e)
+		}
+		if err := enode.SignV4(&r, key); err != nil {
+			t.Fatal(err)
+		}
+		n, err := enode.New(enode.ValidSchemes, &r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	tests := []struct {
+		node *enode.Node
+		want bool
+	}{
+		// Nodes without the entry are accepted.
+		{makeNode(), true},
+		// Nodes on the same chain are accepted.
+		{makeNode(pm.currentEthEntry()), true},
+		// Nodes on a different chain are rejected.
+		{makeNode(&ethEntry{ForkID: forkid.ID{Hash: [4]byte{1, 2, 3, 4}}}), false},
+		// Nodes with a malformed entry are rejected.
+		{makeNode(enr.WithEntry("eth", "garbage")), false},
+	}
+	for i, tt := range tests {
+		if have := pm.dialFilter(tt.node); have != tt.want {
+			t.Errorf("test %d: filter result mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/severeum/go-severeum/core"
 	"github.com/severeum/go-severeum/eth/downloader"
 	"github.com/severeum/go-severeum/eth/gasprice"
+	"github.com/severeum/go-severeum/params"
 )
 
 var _ = (*configMarshaling)(nil)
@@ -23,10 +24,14 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		NetworkId               uint64
 		SyncMode                downloader.SyncMode
 		NoPruning               bool
-		LightServ               int  `toml:",omitempty"`
-		LightPeers              int  `toml:",omitempty"`
-		SkipBcVersionCheck      bool `toml:"-"`
-		DatabaseHandles         int  `toml:"-"`
+		HistoryRetention        uint64                         `toml:",omitempty"`
+		TxLookupLimit           uint64                         `toml:",omitempty"`
+		Checkpoint              *downloader.Checkpoint         `toml:",omitempty"`
+		LightServ               int                            `toml:",omitempty"`
+		LightPeers              int                            `toml:",omitempty"`
+		CheckpointOracle        *params.CheckpointOracleConfig `toml:",omitempty"`
+		SkipBcVersionCheck      bool                           `toml:"-"`
+		DatabaseHandles         int                            `toml:"-"`
 		DatabaseCache           int
 		TrieCleanCache          int
 		TrieDirtyCache          int
@@ -40,10 +45,12 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		MinerRecommit           time.Duration
 		MinerNoverify           bool
 		Sevash                  ethash.Config
+		CliqueMissedTurns       uint64 `toml:",omitempty"`
 		TxPool                  core.TxPoolConfig
 		GPO                     gasprice.Config
 		EnablePreimageRecording bool
 		DocRoot                 string `toml:"-"`
+		Developer               bool   `toml:"-"`
 		EWASMInterpreter        string
 		EVMInterpreter          string
 	}
@@ -52,8 +59,12 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.NetworkId = c.NetworkId
 	enc.SyncMode = c.SyncMode
 	enc.NoPruning = c.NoPruning
+	enc.HistoryRetention = c.HistoryRetention
+	enc.TxLookupLimit = c.TxLookupLimit
+	enc.Checkpoint = c.Checkpoint
 	enc.LightServ = c.LightServ
 	enc.LightPeers = c.LightPeers
+	enc.CheckpointOracle = c.CheckpointOracle
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
@@ -69,10 +80,12 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.MinerRecommit = c.MinerRecommit
 	enc.MinerNoverify = c.MinerNoverify
 	enc.Sevash = c.Sevash
+	enc.CliqueMissedTurns = c.CliqueMissedTurns
 	enc.TxPool = c.TxPool
 	enc.GPO = c.GPO
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
 	enc.DocRoot = c.DocRoot
+	enc.Developer = c.Developer
 	enc.EWASMInterpreter = c.EWASMInterpreter
 	enc.EVMInterpreter = c.EVMInterpreter
 	return &enc, nil
@@ -85,10 +98,14 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		NetworkId               *uint64
 		SyncMode                *downloader.SyncMode
 		NoPruning               *bool
-		LightServ               *int  `toml:",omitempty"`
-		LightPeers              *int  `toml:",omitempty"`
-		SkipBcVersionCheck      *bool `toml:"-"`
-		DatabaseHandles         *int  `toml:"-"`
+		HistoryRetention        *uint64                        `toml:",omitempty"`
+		TxLookupLimit           *uint64                        `toml:",omitempty"`
+		Checkpoint              *downloader.Checkpoint         `toml:",omitempty"`
+		LightServ               *int                           `toml:",omitempty"`
+		LightPeers              *int                           `toml:",omitempty"`
+		CheckpointOracle        *params.CheckpointOracleConfig `toml:",omitempty"`
+		SkipBcVersionCheck      *bool                          `toml:"-"`
+		DatabaseHandles         *int                           `toml:"-"`
 		DatabaseCache           *int
 		TrieCleanCache          *int
 		TrieDirtyCache          *int
@@ -102,10 +119,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		MinerRecommit           *time.Duration
 		MinerNoverify           *bool
 		Sevash                  *ethash.Config
+		CliqueMissedTurns       *uint64 `toml:",omitempty"`
 		TxPool                  *core.TxPoolConfig
 		GPO                     *gasprice.Config
 		EnablePreimageRecording *bool
 		DocRoot                 *string `toml:"-"`
+		Developer               *bool   `toml:"-"`
 		EWASMInterpreter        *string
 		EVMInterpreter          *string
 	}
@@ -125,12 +144,24 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.NoPruning != nil {
 		c.NoPruning = *dec.NoPruning
 	}
+	if dec.HistoryRetention != nil {
+		c.HistoryRetention = *dec.HistoryRetention
+	}
+	if dec.TxLookupLimit != nil {
+		c.TxLookupLimit = *dec.TxLookupLimit
+	}
+	if dec.Checkpoint != nil {
+		c.Checkpoint = dec.Checkpoint
+	}
 	if dec.LightServ != nil {
 		c.LightServ = *dec.LightServ
 	}
 	if dec.LightPeers != nil {
 		c.LightPeers = *dec.LightPeers
 	}
+	if dec.CheckpointOracle != nil {
+		c.CheckpointOracle = dec.CheckpointOracle
+	}
 	if dec.SkipBcVersionCheck != nil {
 		c.SkipBcVersionCheck = *dec.SkipBcVersionCheck
 	}
@@ -176,6 +207,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.Sevash != nil {
 		c.Sevash = *dec.Sevash
 	}
+	if dec.CliqueMissedTurns != nil {
+		c.CliqueMissedTurns = *dec.CliqueMissedTurns
+	}
 	if dec.TxPool != nil {
 		c.TxPool = *dec.TxPool
 	}
@@ -188,6 +222,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.DocRoot != nil {
 		c.DocRoot = *dec.DocRoot
 	}
+	if dec.Developer != nil {
+		c.Developer = *dec.Developer
+	}
 	if dec.EWASMInterpreter != nil {
 		c.EWASMInterpreter = *dec.EWASMInterpreter
 	}
@@ -27,17 +27,21 @@ import (
 	"time"
 
 	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/common/hexutil"
 	"github.com/severeum/go-severeum/consensus"
 	"github.com/severeum/go-severeum/consensus/misc"
 	"github.com/severeum/go-severeum/core"
+	"github.com/severeum/go-severeum/core/forkid"
 	"github.com/severeum/go-severeum/core/types"
 	"github.com/severeum/go-severeum/eth/downloader"
 	"github.com/severeum/go-severeum/eth/fetcher"
+	"github.com/severeum/go-severeum/eth/snap"
 	"github.com/severeum/go-severeum/ethdb"
 	"github.com/severeum/go-severeum/event"
 	"github.com/severeum/go-severeum/log"
 	"github.com/severeum/go-severeum/p2p"
 	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/p2p/enr"
 	"github.com/severeum/go-severeum/params"
 	"github.com/severeum/go-severeum/rlp"
 )
@@ -50,12 +54,16 @@ const (
 	// The number is referenced from the size of tx pool.
 	txChanSize = 4096
 
+	// chainHeadChanSize is the size of channel listening to ChainHeadEvent.
+	chainHeadChanSize = 10
+
 	// minimim number of peers to broadcast new blocks to
 	minBroadcastPeers = 4
 )
 
 var (
-	daoChallengeTimeout = 15 * time.Second // Time allowance for a node to reply to the DAO handshake challenge
+	daoChallengeTimeout  = 15 * time.Second // Time allowance for a node to reply to the DAO handshake challenge
+	historyRangeInterval = time.Minute      // Interval between checks for changes in the available history
 )
 
 // errIncompatibleConfig is returned if the requested protocols and configs are
@@ -70,16 +78,19 @@ type ProtocolManager struct {
 	networkID uint64
 
 	fastSync  uint32 // Flag whether fast sync is enabled (gets disabled if we already have blocks)
+	snapSync  uint32 // Flag whether fast sync should fill the state from snap ranges
 	acceptTxs uint32 // Flag whether we're considered synchronised (enables transaction processing)
 
 	txpool      txPool
 	blockchain  *core.BlockChain
 	chainconfig *params.ChainConfig
+	forkFilter  forkid.Filter // Fork ID filter, constant across the lifetime of the node
 	maxPeers    int
 
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
 	peers      *peerSet
+	histories  *historySet
 
 	SubProtocols []p2p.Protocol
 
@@ -111,7 +122,9 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		txpool:      txpool,
 		blockchain:  blockchain,
 		chainconfig: config,
+		forkFilter:  forkid.NewFilter(blockchain),
 		peers:       newPeerSet(),
+		histories:   newHistorySet(),
 		whitelist:   whitelist,
 		newPeerCh:   make(chan *peer),
 		noMorePeers: make(chan struct{}),
@@ -119,18 +132,21 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		quitSync:    make(chan struct{}),
 	}
 	// Figure out whether to allow fast sync or not
-	if mode == downloader.FastSync && blockchain.CurrentBlock().NumberU64() > 0 {
+	if (mode == downloader.FastSync || mode == downloader.SnapSync) && blockchain.CurrentBlock().NumberU64() > 0 {
 		log.Warn("Blockchain not empty, fast sync disabled")
 		mode = downloader.FullSync
 	}
-	if mode == downloader.FastSync {
+	if mode == downloader.FastSync || mode == downloader.SnapSync {
 		manager.fastSync = uint32(1)
 	}
+	if mode == downloader.SnapSync {
+		manager.snapSync = uint32(1)
+	}
 	// Initiate a sub-protocol for every implemented version we can handle
 	manager.SubProtocols = make([]p2p.Protocol, 0, len(ProtocolVersions))
 	for i, version := range ProtocolVersions {
 		// Skip protocol version if incompatible with the mode of operation
-		if mode == downloader.FastSync && version < eth63 {
+		if (mode == downloader.FastSync || mode == downloader.SnapSync) && version < eth63 {
 			continue
 		}
 		// Compatible; initialise the sub-protocol
@@ -159,6 +175,8 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 				}
 				return nil
 			},
+			Attributes: []enr.Entry{manager.currentEthEntry()},
+			DialFilter: manager.dialFilter,
 		})
 	}
 	if len(manager.SubProtocols) == 0 {
@@ -167,6 +185,12 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 	// Construct the different synchronisation mechanisms
 	manager.downloader = downloader.New(mode, chaindb, manager.eventMux, blockchain, nil, manager.removePeer)
 
+	// Serve and retrieve state ranges over the snap protocol alongside eth
+	manager.SubProtocols = append(manager.SubProtocols, snap.MakeProtocols(blockchain.StateCache().TrieDB(), manager.downloader)...)
+
+	// Advertise the locally available history over the hist protocol alongside eth
+	manager.SubProtocols = append(manager.SubProtocols, manager.historyProtocol())
+
 	validator := func(header *types.Header) error {
 		return engine.VerifyHeader(blockchain, header, true)
 	}
@@ -221,6 +245,7 @@ func (pm *ProtocolManager) Start(maxPeers int) {
 	// start sync handlers
 	go pm.syncer()
 	go pm.txsyncLoop()
+	go pm.historyRangeLoop()
 }
 
 func (pm *ProtocolManager) Stop() {
@@ -249,7 +274,9 @@ func (pm *ProtocolManager) Stop() {
 }
 
 func (pm *ProtocolManager) newPeer(pv int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
-	return newPeer(pv, p, newMeteredMsgWriter(rw))
+	peer := newPeer(pv, p, newMeteredMsgWriter(rw))
+	peer.histories = pm.histories
+	return peer
 }
 
 // handle is the callback invoked to manage the life cycle of an eth peer. When
@@ -269,7 +296,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		number  = head.Number.Uint64()
 		td      = pm.blockchain.GetTd(hash, number)
 	)
-	if err := p.Handshake(pm.networkID, td, hash, genesis.Hash()); err != nil {
+	if err := p.Handshake(pm.networkID, td, hash, genesis.Hash(), forkid.NewID(pm.blockchain), pm.forkFilter); err != nil {
 		p.Log().Debug("Severeum handshake failed", "err", err)
 		return err
 	}
@@ -791,6 +818,39 @@ func (pm *ProtocolManager) txBroadcastLoop() {
 	}
 }
 
+// historyHorizon returns the oldest block whose body and receipts are available
+// locally, accounting for both checkpoint syncs and history expiry.
+func (pm *ProtocolManager) historyHorizon() uint64 {
+	horizon := pm.blockchain.HistoryHorizon()
+	if tail := pm.blockchain.HistoryTail(); tail > horizon {
+		horizon = tail
+	}
+	return horizon
+}
+
+// historyRangeLoop periodically re-advertises the locally available history to
+// all capable peers whenever it changed due to expiry or backfilling.
+func (pm *ProtocolManager) historyRangeLoop() {
+	ticker := time.NewTicker(historyRangeInterval)
+	defer ticker.Stop()
+
+	last := pm.historyHorizon()
+	for {
+		select {
+		case <-ticker.C:
+			horizon := pm.historyHorizon()
+			if horizon == last {
+				continue
+			}
+			pm.histories.broadcast(horizon)
+			last = horizon
+
+		case <-pm.quitSync:
+			return
+		}
+	}
+}
+
 // NodeInfo represents a short summary of the Severeum sub-protocol metadata
 // known about the host peer.
 type NodeInfo struct {
@@ -799,16 +859,23 @@ type NodeInfo struct {
 	Genesis    common.Hash         `json:"genesis"`    // SHA3 hash of the host's genesis block
 	Config     *params.ChainConfig `json:"config"`     // Chain configuration for the fork rules
 	Head       common.Hash         `json:"head"`       // SHA3 hash of the host's best owned block
+	ForkID     struct {
+		Hash hexutil.Bytes `json:"hash"` // CRC32 checksum of the genesis and passed fork blocks
+		Next uint64        `json:"next"` // Block number of the next known fork, 0 if none
+	} `json:"forkid"` // Fork identifier of the current head (EIP-2124)
 }
 
 // NodeInfo retrieves some protocol metadata about the running host node.
 func (pm *ProtocolManager) NodeInfo() *NodeInfo {
 	currentBlock := pm.blockchain.CurrentBlock()
-	return &NodeInfo{
+	info := &NodeInfo{
 		Network:    pm.networkID,
 		Difficulty: pm.blockchain.GetTd(currentBlock.Hash(), currentBlock.NumberU64()),
 		Genesis:    pm.blockchain.Genesis().Hash(),
 		Config:     pm.blockchain.Config(),
 		Head:       currentBlock.Hash(),
 	}
+	forkID := forkid.NewID(pm.blockchain)
+	info.ForkID.Hash, info.ForkID.Next = forkID.Hash[:], forkID.Next
+	return info
 }
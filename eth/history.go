@@ -0,0 +1,150 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/severeum/go-severeum/p2p"
+)
+
+// Constants of the history protocol, a capability running alongside eth through
+// which nodes advertise the oldest block they still serve bodies and receipts of.
+const (
+	historyProtocolName    = "hist"
+	historyProtocolVersion = 1
+	historyProtocolLength  = 1
+	historyMaxMsgSize      = 1024 // Maximum cap on the size of a history protocol message
+
+	// HistoryRangeMsg advertises the range of the locally available history.
+	HistoryRangeMsg = 0x00
+)
+
+// historyRangeData is the network packet for advertising the oldest block whose
+// body and receipts a node can still serve.
+type historyRangeData struct {
+	Horizon uint64 // Oldest block with available body and receipts (0 = entire chain)
+}
+
+// historyPeer is a remote node speaking the history protocol.
+type historyPeer struct {
+	rw      p2p.MsgReadWriter
+	horizon uint64 // Oldest block the peer serves bodies and receipts for (atomic)
+}
+
+// historySet tracks the history ranges advertised by the connected peers.
+type historySet struct {
+	peers map[string]*historyPeer
+	lock  sync.RWMutex
+}
+
+// newHistorySet creates an empty set of history peers.
+func newHistorySet() *historySet {
+	return &historySet{
+		peers: make(map[string]*historyPeer),
+	}
+}
+
+// register injects a new history peer into the set.
+func (hs *historySet) register(id string, p *historyPeer) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+
+	hs.peers[id] = p
+}
+
+// unregister removes a history peer from the set, unless it was replaced by a
+// newer connection already.
+func (hs *historySet) unregister(id string, p *historyPeer) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+
+	if hs.peers[id] == p {
+		delete(hs.peers, id)
+	}
+}
+
+// horizon retrieves the oldest block the given peer advertised to serve bodies
+// and receipts for. Peers not speaking the history protocol serve everything.
+func (hs *historySet) horizon(id string) uint64 {
+	hs.lock.RLock()
+	defer hs.lock.RUnlock()
+
+	if p := hs.peers[id]; p != nil {
+		return atomic.LoadUint64(&p.horizon)
+	}
+	return 0
+}
+
+// broadcast advertises the locally available history to all the history peers.
+func (hs *historySet) broadcast(horizon uint64) {
+	hs.lock.RLock()
+	peers := make([]*historyPeer, 0, len(hs.peers))
+	for _, p := range hs.peers {
+		peers = append(peers, p)
+	}
+	hs.lock.RUnlock()
+
+	for _, p := range peers {
+		p2p.Send(p.rw, HistoryRangeMsg, &historyRangeData{Horizon: horizon})
+	}
+}
+
+// historyProtocol returns the p2p sub-protocol the history ranges are advertised
+// over. It is a separate capability to leave the eth protocol versions intact.
+func (pm *ProtocolManager) historyProtocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    historyProtocolName,
+		Version: historyProtocolVersion,
+		Length:  historyProtocolLength,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return pm.handleHistory(fmt.Sprintf("%x", p.ID().Bytes()[:8]), rw)
+		},
+	}
+}
+
+// handleHistory is the callback invoked to manage the life cycle of a history
+// peer. The local history range is advertised right away, after which the ranges
+// advertised by the remote peer are tracked until it disconnects.
+func (pm *ProtocolManager) handleHistory(id string, rw p2p.MsgReadWriter) error {
+	p := &historyPeer{rw: rw}
+	pm.histories.register(id, p)
+	defer pm.histories.unregister(id, p)
+
+	if err := p2p.Send(rw, HistoryRangeMsg, &historyRangeData{Horizon: pm.historyHorizon()}); err != nil {
+		return err
+	}
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		if msg.Size > historyMaxMsgSize {
+			return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, historyMaxMsgSize)
+		}
+		if msg.Code != HistoryRangeMsg {
+			return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+		}
+		var data historyRangeData
+		if err := msg.Decode(&data); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		atomic.StoreUint64(&p.horizon, data.Horizon)
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 	mapset "github.com/deckarep/golang-set"
 	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/forkid"
 	"github.com/severeum/go-severeum/core/types"
 	"github.com/severeum/go-severeum/p2p"
 	"github.com/severeum/go-severeum/rlp"
@@ -85,6 +86,8 @@ type peer struct {
 	td   *big.Int
 	lock sync.RWMutex
 
+	histories *historySet // History ranges advertised over the history protocol
+
 	knownTxs    mapset.Set                // Set of transaction hashes known to be known by this peer
 	knownBlocks mapset.Set                // Set of block hashes known to be known by this peer
 	queuedTxs   chan []*types.Transaction // Queue of transactions to broadcast to the peer
@@ -173,6 +176,35 @@ func (p *peer) SetHead(hash common.Hash, td *big.Int) {
 	p.td.Set(td)
 }
 
+// RecordServed rewards the peer for delivering the requested data.
+func (p *peer) RecordServed(items int, latency time.Duration) {
+	p.RecordScore(p2p.ScoreServed, items, latency)
+}
+
+// RecordUseless penalises the peer for an empty or unusable response.
+func (p *peer) RecordUseless() {
+	p.RecordScore(p2p.ScoreUseless, 0, 0)
+}
+
+// RecordTimeout penalises the peer for failing to answer a request in time.
+func (p *peer) RecordTimeout() {
+	p.RecordScore(p2p.ScoreTimeout, 0, 0)
+}
+
+// RecordInvalid penalises the peer for delivering invalid data.
+func (p *peer) RecordInvalid() {
+	p.RecordScore(p2p.ScoreInvalid, 0, 0)
+}
+
+// HistoryHorizon retrieves the oldest block whose body and receipts the peer
+// advertised to serve. Peers not advertising their history serve everything.
+func (p *peer) HistoryHorizon() uint64 {
+	if p.histories == nil {
+		return 0
+	}
+	return p.histories.horizon(p.id)
+}
+
 // MarkBlock marks a block as known for the peer, ensuring that the block will
 // never be propagated to this particular peer.
 func (p *peer) MarkBlock(hash common.Hash) {
@@ -329,12 +361,26 @@ func (p *peer) RequestReceipts(hashes []common.Hash) error {
 
 // Handshake executes the eth protocol handshake, negotiating version number,
 // network IDs, difficulties, head and genesis blocks.
-func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash) error {
+func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash, forkID forkid.ID, forkFilter forkid.Filter) error {
 	// Send out own handshake in a new thread
 	errc := make(chan error, 2)
-	var status statusData // safe to read after two values have been received from errc
 
+	var (
+		status   statusData   // safe to read after two values have been received from errc
+		status64 statusData64 // safe to read after two values have been received from errc
+	)
 	go func() {
+		if p.version >= eth64 {
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData64{
+				ProtocolVersion: uint32(p.version),
+				NetworkId:       network,
+				TD:              td,
+				CurrentBlock:    head,
+				GenesisBlock:    genesis,
+				ForkID:          forkID,
+			})
+			return
+		}
 		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
 			ProtocolVersion: uint32(p.version),
 			NetworkId:       network,
@@ -344,6 +390,10 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 		})
 	}()
 	go func() {
+		if p.version >= eth64 {
+			errc <- p.readStatus64(network, &status64, genesis, forkFilter)
+			return
+		}
 		errc <- p.readStatus(network, &status, genesis)
 	}()
 	timeout := time.NewTimer(handshakeTimeout)
@@ -358,11 +408,17 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 			return p2p.DiscReadTimeout
 		}
 	}
-	p.td, p.head = status.TD, status.CurrentBlock
+	if p.version >= eth64 {
+		p.td, p.head = status64.TD, status64.CurrentBlock
+	} else {
+		p.td, p.head = status.TD, status.CurrentBlock
+	}
 	return nil
 }
 
-func (p *peer) readStatus(network uint64, status *statusData, genesis common.Hash) (err error) {
+// readStatusMsg reads the status message of the remote peer and decodes it
+// into status.
+func (p *peer) readStatusMsg(status interface{}) error {
 	msg, err := p.rw.ReadMsg()
 	if err != nil {
 		return err
@@ -373,10 +429,34 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 	if msg.Size > ProtocolMaxMsgSize {
 		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
 	}
-	// Decode the handshake and make sure everything matches
-	if err := msg.Decode(&status); err != nil {
+	if err := msg.Decode(status); err != nil {
 		return errResp(ErrDecode, "msg %v: %v", msg, err)
 	}
+	return nil
+}
+
+func (p *peer) readStatus(network uint64, status *statusData, genesis common.Hash) (err error) {
+	if err := p.readStatusMsg(status); err != nil {
+		return err
+	}
+	// Make sure everything in the handshake matches
+	if status.GenesisBlock != genesis {
+		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", status.GenesisBlock[:8], genesis[:8])
+	}
+	if status.NetworkId != network {
+		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", status.NetworkId, network)
+	}
+	if int(status.ProtocolVersion) != p.version {
+		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status.ProtocolVersion, p.version)
+	}
+	return nil
+}
+
+func (p *peer) readStatus64(network uint64, status *statusData64, genesis common.Hash, forkFilter forkid.Filter) (err error) {
+	if err := p.readStatusMsg(status); err != nil {
+		return err
+	}
+	// Make sure everything in the handshake matches
 	if status.GenesisBlock != genesis {
 		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", status.GenesisBlock[:8], genesis[:8])
 	}
@@ -386,6 +466,11 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 	if int(status.ProtocolVersion) != p.version {
 		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status.ProtocolVersion, p.version)
 	}
+	// The peer is on our chain, but it may have missed a fork (or we did). There
+	// is no point in syncing with it, the error tells which side is out of date.
+	if err := forkFilter(status.ForkID); err != nil {
+		return errResp(ErrForkIDRejected, "%x/%d: %v", status.ForkID.Hash, status.ForkID.Next, err)
+	}
 	return nil
 }
 
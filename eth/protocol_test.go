@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/forkid"
 	"github.com/severeum/go-severeum/core/types"
 	"github.com/severeum/go-severeum/crypto"
 	"github.com/severeum/go-severeum/eth/downloader"
@@ -92,6 +93,132 @@ func testStatusMsgErrors(t *testing.T, protocol int) {
 	}
 }
 
+func TestStatusMsgErrors64(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	var (
+		genesis = pm.blockchain.Genesis()
+		head    = pm.blockchain.CurrentHeader()
+		td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
+		forkID  = forkid.NewID(pm.blockchain)
+	)
+	defer pm.Stop()
+
+	tests := []struct {
+		code      uint64
+		data      interface{}
+		filter    forkid.Filter
+		wantError error
+	}{
+		{
+			code: TxMsg, data: []interface{}{},
+			wantError: errResp(ErrNoStatusMsg, "first msg has code 2 (!= 0)"),
+		},
+		{
+			code: StatusMsg, data: statusData64{10, DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash(), forkID},
+			wantError: errResp(ErrProtocolVersionMismatch, "10 (!= 64)"),
+		},
+		{
+			code: StatusMsg, data: statusData64{64, 999, td, head.Hash(), genesis.Hash(), forkID},
+			wantError: errResp(ErrNetworkIdMismatch, "999 (!= 1)"),
+		},
+		{
+			code: StatusMsg, data: statusData64{64, DefaultConfig.NetworkId, td, head.Hash(), common.Hash{3}, forkID},
+			wantError: errResp(ErrGenesisBlockMismatch, "0300000000000000 (!= %x)", genesis.Hash().Bytes()[:8]),
+		},
+		{
+			code: StatusMsg, data: statusData64{64, DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash(), forkid.ID{Hash: [4]byte{0x00, 0x01, 0x02, 0x03}}},
+			wantError: errResp(ErrForkIDRejected, "00010203/0: %v", forkid.ErrLocalIncompatibleOrStale),
+		},
+		{
+			code: StatusMsg, data: statusData64{64, DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash(), forkID},
+			filter:    func(forkid.ID) error { return forkid.ErrRemoteStale },
+			wantError: errResp(ErrForkIDRejected, "%x/%d: %v", forkID.Hash, forkID.Next, forkid.ErrRemoteStale),
+		},
+	}
+
+	filter := pm.forkFilter
+	for i, test := range tests {
+		if pm.forkFilter = filter; test.filter != nil {
+			pm.forkFilter = test.filter
+		}
+		p, errc := newTestPeer("peer", eth64, pm, false)
+		// The send call might hang until reset because
+		// the protocol might not read the payload.
+		go p2p.Send(p.app, test.code, test.data)
+
+		select {
+		case err := <-errc:
+			if err == nil {
+				t.Errorf("test %d: protocol returned nil error, want %q", i, test.wantError)
+			} else if err.Error() != test.wantError.Error() {
+				t.Errorf("test %d: wrong error: got %q, want %q", i, err, test.wantError)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("protocol did not shut down within 2 seconds")
+		}
+		p.close()
+	}
+}
+
+// Tests that eth/64 peers announcing a compatible fork ID are accepted.
+func TestStatusMsgForkID64(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	p, _ := newTestPeer("peer", eth64, pm, true)
+	defer p.close()
+
+	// The peer is only registered after a successful handshake.
+	for i := 0; pm.peers.Peer(p.id) == nil; i++ {
+		if i == 100 {
+			t.Fatalf("peer not registered after handshake")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Tests that history ranges are exchanged over the hist protocol and tracked for
+// the eth peer of the same node.
+func TestHistoryRange(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	p, _ := newTestPeer("peer", eth64, pm, true)
+	defer p.close()
+
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- pm.handleHistory(p.id, net) }()
+
+	// The local history range is advertised as soon as the protocol starts
+	if err := p2p.ExpectMsg(app, HistoryRangeMsg, &historyRangeData{Horizon: pm.historyHorizon()}); err != nil {
+		t.Fatalf("history range mismatch: %v", err)
+	}
+	// Remote announcements should be reflected by the eth peer
+	if err := p2p.Send(app, HistoryRangeMsg, &historyRangeData{Horizon: 1024}); err != nil {
+		t.Fatalf("failed to send history range: %v", err)
+	}
+	for i := 0; p.HistoryHorizon() != 1024; i++ {
+		if i == 100 {
+			t.Fatalf("history horizon mismatch: have %d, want %d", p.HistoryHorizon(), 1024)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Local changes should be broadcast to the history peers
+	go pm.histories.broadcast(2048)
+	if err := p2p.ExpectMsg(app, HistoryRangeMsg, &historyRangeData{Horizon: 2048}); err != nil {
+		t.Fatalf("history broadcast mismatch: %v", err)
+	}
+	// Once the protocol terminates, the peer is assumed to serve everything
+	app.Close()
+	<-errc
+	if horizon := p.HistoryHorizon(); horizon != 0 {
+		t.Fatalf("history horizon not cleared: have %d, want 0", horizon)
+	}
+}
+
 // This test checks that received transactions are added to the local pool.
 func TestRecvTransactions62(t *testing.T) { testRecvTransactions(t, 62) }
 func TestRecvTransactions63(t *testing.T) { testRecvTransactions(t, 63) }
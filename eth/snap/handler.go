@@ -0,0 +1,373 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/state"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/eth/downloader"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/rlp"
+	"github.com/severeum/go-severeum/trie"
+)
+
+const (
+	// softResponseLimit is the target maximum size of replies to data retrievals.
+	softResponseLimit = 2 * 1024 * 1024
+
+	// maxCodeLookups is the maximum number of bytecodes to serve. This number is
+	// there to limit the number of disk lookups.
+	maxCodeLookups = 1024
+
+	// maxStorageLookups is the maximum number of accounts to serve storage ranges
+	// of. This number is there to limit the number of trie lookups and proofs, as
+	// empty storage tries don't count against the byte budget.
+	maxStorageLookups = 1024
+)
+
+// emptyCode is the known hash of the empty EVM bytecode.
+var emptyCode = crypto.Keccak256Hash(nil)
+
+// Syncer is the state synchroniser consuming the data retrieved over the snap
+// protocol. It is implemented by the downloader.
+type Syncer interface {
+	// RegisterSnapPeer injects a new snap peer into the set of state sources.
+	RegisterSnapPeer(id string, peer downloader.SnapPeer) error
+
+	// UnregisterSnapPeer removes a snap peer from the set of state sources.
+	UnregisterSnapPeer(id string) error
+
+	// DeliverAccountRange injects a range of accounts received from a remote peer.
+	DeliverAccountRange(id string, reqid uint64, hashes []common.Hash, accounts [][]byte, proof [][]byte) error
+
+	// DeliverStorageRanges injects ranges of storage slots received from a remote peer.
+	DeliverStorageRanges(id string, reqid uint64, hashes [][]common.Hash, slots [][][]byte, proof [][]byte) error
+
+	// DeliverByteCodes injects a batch of bytecodes received from a remote peer.
+	DeliverByteCodes(id string, reqid uint64, codes [][]byte) error
+}
+
+// MakeProtocols constructs the P2P protocol definitions for `snap`, serving state
+// data from the given trie database and feeding retrieved data into the syncer.
+func MakeProtocols(triedb *trie.Database, syncer Syncer) []p2p.Protocol {
+	protocols := make([]p2p.Protocol, len(ProtocolVersions))
+	for i, version := range ProtocolVersions {
+		version := version // Closure
+
+		protocols[i] = p2p.Protocol{
+			Name:    ProtocolName,
+			Version: version,
+			Length:  ProtocolLengths[i],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				return Handle(triedb, syncer, newPeer(version, p, rw))
+			},
+			NodeInfo: func() interface{} {
+				return nil
+			},
+			PeerInfo: func(id enode.ID) interface{} {
+				return nil
+			},
+		}
+	}
+	return protocols
+}
+
+// Handle is the callback invoked to manage the life cycle of a `snap` peer.
+// When this function terminates, the peer is disconnected.
+func Handle(triedb *trie.Database, syncer Syncer, peer *Peer) error {
+	if err := syncer.RegisterSnapPeer(peer.id, peer); err != nil {
+		return err
+	}
+	defer syncer.UnregisterSnapPeer(peer.id)
+
+	for {
+		if err := handleMessage(triedb, syncer, peer); err != nil {
+			peer.Log().Debug("Message handling failed in `snap`", "err", err)
+			return err
+		}
+	}
+}
+
+// handleMessage is invoked whenever an inbound message is received from a
+// remote peer on the `snap` protocol. The remote connection is torn down upon
+// returning any error.
+func handleMessage(triedb *trie.Database, syncer Syncer, peer *Peer) error {
+	// Read the next message from the remote peer, and ensure it's fully consumed
+	msg, err := peer.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return fmt.Errorf("%v: %v > %v", errMsgTooLarge, msg.Size, ProtocolMaxMsgSize)
+	}
+	defer msg.Discard()
+
+	// Handle the message depending on its contents
+	switch msg.Code {
+	case GetAccountRangeMsg:
+		var req GetAccountRangePacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		accounts, proof := ServiceGetAccountRange(triedb, &req)
+		return p2p.Send(peer.rw, AccountRangeMsg, &AccountRangePacket{
+			ID:       req.ID,
+			Accounts: accounts,
+			Proof:    proof,
+		})
+
+	case AccountRangeMsg:
+		var res AccountRangePacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		hashes, accounts := res.Unpack()
+		if err := syncer.DeliverAccountRange(peer.id, res.ID, hashes, accounts, res.Proof); err != nil {
+			peer.Log().Debug("Failed to deliver account range", "err", err)
+		}
+
+	case GetStorageRangesMsg:
+		var req GetStorageRangesPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		slots, proof := ServiceGetStorageRanges(triedb, &req)
+		return p2p.Send(peer.rw, StorageRangesMsg, &StorageRangesPacket{
+			ID:    req.ID,
+			Slots: slots,
+			Proof: proof,
+		})
+
+	case StorageRangesMsg:
+		var res StorageRangesPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		hashes, slots := res.Unpack()
+		if err := syncer.DeliverStorageRanges(peer.id, res.ID, hashes, slots, res.Proof); err != nil {
+			peer.Log().Debug("Failed to deliver storage ranges", "err", err)
+		}
+
+	case GetByteCodesMsg:
+		var req GetByteCodesPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, ByteCodesMsg, &ByteCodesPacket{
+			ID:    req.ID,
+			Codes: ServiceGetByteCodes(triedb, &req),
+		})
+
+	case ByteCodesMsg:
+		var res ByteCodesPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		if err := syncer.DeliverByteCodes(peer.id, res.ID, res.Codes); err != nil {
+			peer.Log().Debug("Failed to deliver byte codes", "err", err)
+		}
+
+	default:
+		return fmt.Errorf("%v: %v", errInvalidMsgCode, msg.Code)
+	}
+	return nil
+}
+
+// ServiceGetAccountRange assembles the response to an account range query. The
+// accounts are returned in trie order, starting at the origin and stopping at
+// the first account at or beyond the limit, or when the byte budget runs out.
+// The returned proof contains the Merkle paths of the origin and last account.
+//
+// If the requested state is not available, an empty response without a proof
+// is returned, signalling the remote side that the root is unknown.
+func ServiceGetAccountRange(triedb *trie.Database, req *GetAccountRangePacket) ([]*AccountData, [][]byte) {
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	tr, err := trie.New(req.Root, triedb)
+	if err != nil {
+		return nil, nil
+	}
+	var (
+		accounts []*AccountData
+		size     uint64
+		last     []byte
+	)
+	it := trie.NewIterator(tr.NodeIterator(req.Origin[:]))
+	for size < req.Bytes && it.Next() {
+		hash, body := common.BytesToHash(it.Key), common.CopyBytes(it.Value)
+
+		accounts = append(accounts, &AccountData{Hash: hash, Body: body})
+		size += uint64(common.HashLength + len(body))
+		last = hash[:]
+
+		if bytes.Compare(hash[:], req.Limit[:]) >= 0 {
+			break
+		}
+	}
+	if it.Err != nil {
+		return nil, nil
+	}
+	proof, err := proveRange(tr, req.Origin[:], last)
+	if err != nil {
+		return nil, nil
+	}
+	return accounts, proof
+}
+
+// ServiceGetStorageRanges assembles the response to a storage ranges query. The
+// slots of each requested account are returned in trie order until the byte
+// budget runs out. If the last slot set is incomplete, because it either started
+// at an origin or was truncated, its boundary proofs are attached.
+func ServiceGetStorageRanges(triedb *trie.Database, req *GetStorageRangesPacket) ([][]*StorageData, [][]byte) {
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	// The limit only applies to the last requested account, drop it if truncated
+	if len(req.Accounts) > maxStorageLookups {
+		req.Accounts, req.Limit = req.Accounts[:maxStorageLookups], nil
+	}
+	accTrie, err := trie.New(req.Root, triedb)
+	if err != nil {
+		return nil, nil
+	}
+	var (
+		slots [][]*StorageData
+		proof [][]byte
+		size  uint64
+	)
+	for i, account := range req.Accounts {
+		// If we've exceeded the requested data limit, abort without opening
+		// a new storage range (that we'd need to prove due to exceeded size)
+		if size >= req.Bytes {
+			break
+		}
+		blob, err := accTrie.TryGet(account[:])
+		if err != nil || blob == nil {
+			break
+		}
+		var acc state.Account
+		if err := rlp.DecodeBytes(blob, &acc); err != nil {
+			break
+		}
+		// The first account might start from a different origin and the last
+		// one might end at a different limit
+		var origin, limit []byte
+		if i == 0 && len(req.Origin) > 0 {
+			origin = common.BytesToHash(req.Origin).Bytes()
+		}
+		if i == len(req.Accounts)-1 && len(req.Limit) > 0 {
+			limit = common.BytesToHash(req.Limit).Bytes()
+		}
+		stTrie, err := trie.New(acc.Root, triedb)
+		if err != nil {
+			break
+		}
+		// Retrieve the requested state and bail out if we exceed the byte budget
+		var (
+			storage []*StorageData
+			last    []byte
+			abort   bool
+		)
+		it := trie.NewIterator(stTrie.NodeIterator(origin))
+		for it.Next() {
+			if size >= req.Bytes {
+				abort = true
+				break
+			}
+			hash, body := common.BytesToHash(it.Key), common.CopyBytes(it.Value)
+
+			storage = append(storage, &StorageData{Hash: hash, Body: body})
+			size += uint64(common.HashLength + len(body))
+			last = hash[:]
+
+			if limit != nil && bytes.Compare(hash[:], limit) >= 0 {
+				break
+			}
+		}
+		if it.Err != nil {
+			break
+		}
+		slots = append(slots, storage)
+
+		// If the storage range was incomplete, prove its boundaries and stop
+		// serving further accounts, the remote side will ask for them again
+		if origin != nil || abort {
+			if proof, err = proveRange(stTrie, origin, last); err != nil {
+				return nil, nil
+			}
+			break
+		}
+	}
+	return slots, proof
+}
+
+// ServiceGetByteCodes assembles the response to a bytecode query. Unknown codes
+// are silently skipped.
+func ServiceGetByteCodes(triedb *trie.Database, req *GetByteCodesPacket) [][]byte {
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	if len(req.Hashes) > maxCodeLookups {
+		req.Hashes = req.Hashes[:maxCodeLookups]
+	}
+	var (
+		codes [][]byte
+		size  uint64
+	)
+	for _, hash := range req.Hashes {
+		if hash == emptyCode {
+			// Peers should not request the empty code, but if they do, at
+			// least send them back a correct response without db lookups
+			codes = append(codes, []byte{})
+		} else if blob, err := triedb.Node(hash); err == nil && len(blob) > 0 {
+			codes = append(codes, blob)
+			size += uint64(len(blob))
+		}
+		if size > req.Bytes {
+			break
+		}
+	}
+	return codes
+}
+
+// proveRange constructs the Merkle proofs of the two boundaries of a range in
+// the given trie, returning the deduplicated set of trie nodes.
+func proveRange(tr *trie.Trie, origin []byte, last []byte) ([][]byte, error) {
+	if origin == nil {
+		origin = common.Hash{}.Bytes()
+	}
+	if last == nil {
+		last = origin
+	}
+	db := ethdb.NewMemDatabase()
+	if err := tr.ProveRange(origin, last, db); err != nil {
+		return nil, err
+	}
+	proof := make([][]byte, 0, db.Len())
+	for _, key := range db.Keys() {
+		blob, _ := db.Get(key)
+		proof = append(proof, blob)
+	}
+	return proof, nil
+}
@@ -0,0 +1,336 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/core/state"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/eth/downloader"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/rlp"
+	"github.com/severeum/go-severeum/trie"
+)
+
+// makeTestState creates a state with the given number of accounts. Every tenth
+// account has a few storage slots and some code, the first one has a storage
+// trie with the requested number of slots.
+func makeTestState(t *testing.T, accounts int, slots int) (*trie.Database, common.Hash) {
+	db := state.NewDatabase(ethdb.NewMemDatabase())
+	statedb, _ := state.New(common.Hash{}, db)
+	for i := 0; i < accounts; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		statedb.AddBalance(addr, big.NewInt(int64(i+1)))
+		if i%10 == 0 {
+			statedb.SetCode(addr, []byte{byte(i), byte(i >> 8), 0x60})
+			for j := 0; j < 3; j++ {
+				statedb.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i+j+1))))
+			}
+		}
+	}
+	first := common.BigToAddress(big.NewInt(1))
+	for j := 0; j < slots; j++ {
+		statedb.SetState(first, common.BigToHash(big.NewInt(int64(1000+j))), common.BigToHash(big.NewInt(int64(j+1))))
+	}
+	root, err := statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	return db.TrieDB(), root
+}
+
+// verifyEdges checks that the first and last key of a range are proven by the
+// given proof, and that the origin is proven either present or absent.
+func verifyEdges(t *testing.T, root common.Hash, origin []byte, keys []common.Hash, values [][]byte, proof [][]byte) {
+	t.Helper()
+
+	db := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	if _, _, err := trie.VerifyProof(root, origin, db); err != nil {
+		t.Fatalf("origin %x not proven: %v", origin, err)
+	}
+	if len(keys) > 0 {
+		last := keys[len(keys)-1]
+		value, _, err := trie.VerifyProof(root, last[:], db)
+		if err != nil {
+			t.Fatalf("last key %x not proven: %v", last, err)
+		}
+		if !bytes.Equal(value, values[len(values)-1]) {
+			t.Fatalf("last value mismatch: have %x, want %x", value, values[len(values)-1])
+		}
+	}
+}
+
+// Tests that the entire account trie can be retrieved in consecutive ranges,
+// each of them carrying valid boundary proofs.
+func TestServiceAccountRange(t *testing.T) {
+	triedb, root := makeTestState(t, 500, 0)
+
+	// Collect all the accounts from the trie directly
+	tr, _ := trie.New(root, triedb)
+	var want []common.Hash
+	for it := trie.NewIterator(tr.NodeIterator(nil)); it.Next(); {
+		want = append(want, common.BytesToHash(it.Key))
+	}
+	// Retrieve the accounts in small ranges and check them against the trie
+	var (
+		have   []common.Hash
+		origin common.Hash
+		limit  = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+	)
+	for requests := 0; ; requests++ {
+		if requests > len(want) {
+			t.Fatalf("range retrieval not progressing")
+		}
+		accounts, proof := ServiceGetAccountRange(triedb, &GetAccountRangePacket{Root: root, Origin: origin, Limit: limit, Bytes: 1000})
+		if len(proof) == 0 {
+			t.Fatalf("range %d: missing proof", requests)
+		}
+		res := &AccountRangePacket{Accounts: accounts}
+		hashes, bodies := res.Unpack()
+		verifyEdges(t, root, origin[:], hashes, bodies, proof)
+
+		if len(hashes) == 0 {
+			break
+		}
+		have = append(have, hashes...)
+		origin = hashes[len(hashes)-1]
+		for i := len(origin) - 1; i >= 0; i-- {
+			if origin[i]++; origin[i] != 0 {
+				break
+			}
+		}
+	}
+	if len(have) != len(want) {
+		t.Fatalf("account count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Fatalf("account %d mismatch: have %x, want %x", i, have[i], want[i])
+		}
+	}
+}
+
+// Tests that account ranges stop at the requested limit, including the first
+// account beyond it to prove there's nothing in between.
+func TestServiceAccountRangeLimit(t *testing.T) {
+	triedb, root := makeTestState(t, 500, 0)
+
+	limit := common.HexToHash("0x1000000000000000000000000000000000000000000000000000000000000000")
+	accounts, _ := ServiceGetAccountRange(triedb, &GetAccountRangePacket{Root: root, Limit: limit, Bytes: softResponseLimit})
+	if len(accounts) == 0 {
+		t.Fatalf("no accounts returned")
+	}
+	for i, acc := range accounts[:len(accounts)-1] {
+		if bytes.Compare(acc.Hash[:], limit[:]) >= 0 {
+			t.Fatalf("account %d beyond limit: %x", i, acc.Hash)
+		}
+	}
+	if last := accounts[len(accounts)-1]; bytes.Compare(last.Hash[:], limit[:]) < 0 {
+		t.Fatalf("last account within limit: %x", last.Hash)
+	}
+}
+
+// Tests that requesting an unknown state root results in an empty response
+// without any proofs.
+func TestServiceUnknownRoot(t *testing.T) {
+	triedb, _ := makeTestState(t, 10, 0)
+
+	root := common.HexToHash("0xdeadbeef")
+	if accounts, proof := ServiceGetAccountRange(triedb, &GetAccountRangePacket{Root: root, Bytes: softResponseLimit}); len(accounts) != 0 || len(proof) != 0 {
+		t.Errorf("account range served for unknown root: %d accounts, %d proof nodes", len(accounts), len(proof))
+	}
+	if slots, proof := ServiceGetStorageRanges(triedb, &GetStorageRangesPacket{Root: root, Accounts: []common.Hash{{}}, Bytes: softResponseLimit}); len(slots) != 0 || len(proof) != 0 {
+		t.Errorf("storage ranges served for unknown root: %d sets, %d proof nodes", len(slots), len(proof))
+	}
+}
+
+// Tests that small storage tries are served whole without proofs, whereas large
+// ones are served in proven chunks.
+func TestServiceStorageRanges(t *testing.T) {
+	triedb, root := makeTestState(t, 100, 2000)
+
+	var small []common.Hash
+	for i := 10; i < 100; i += 10 {
+		small = append(small, crypto.Keccak256Hash(common.BigToAddress(big.NewInt(int64(i+1))).Bytes()))
+	}
+	slots, proof := ServiceGetStorageRanges(triedb, &GetStorageRangesPacket{Root: root, Accounts: small, Bytes: softResponseLimit})
+	if len(slots) != len(small) {
+		t.Fatalf("storage set count mismatch: have %d, want %d", len(slots), len(small))
+	}
+	for i, set := range slots {
+		if len(set) != 3 {
+			t.Errorf("storage set %d: slot count mismatch: have %d, want 3", i, len(set))
+		}
+	}
+	if len(proof) != 0 {
+		t.Errorf("complete storage sets proven: %d proof nodes", len(proof))
+	}
+	// Retrieve the large storage trie in chunks
+	var (
+		large  = crypto.Keccak256Hash(common.BigToAddress(big.NewInt(1)).Bytes())
+		acc    state.Account
+		origin []byte
+		total  int
+	)
+	tr, _ := trie.New(root, triedb)
+	if err := rlp.DecodeBytes(tr.Get(large[:]), &acc); err != nil {
+		t.Fatalf("failed to decode large account: %v", err)
+	}
+	for requests := 0; ; requests++ {
+		if requests > 2003 {
+			t.Fatalf("storage retrieval not progressing")
+		}
+		req := &GetStorageRangesPacket{Root: root, Accounts: []common.Hash{large}, Origin: origin, Bytes: 4096}
+		slots, proof := ServiceGetStorageRanges(triedb, req)
+		if len(slots) != 1 {
+			t.Fatalf("storage set count mismatch: have %d, want 1", len(slots))
+		}
+		res := &StorageRangesPacket{Slots: slots}
+		hashes, values := res.Unpack()
+		total += len(hashes[0])
+
+		if len(proof) == 0 {
+			if origin != nil {
+				t.Fatalf("continued storage range not proven")
+			}
+			break
+		}
+		if origin == nil {
+			origin = common.Hash{}.Bytes()
+		}
+		verifyEdges(t, acc.Root, origin, hashes[0], values[0], proof)
+		if len(hashes[0]) == 0 {
+			break
+		}
+		next := hashes[0][len(hashes[0])-1]
+		for i := len(next) - 1; i >= 0; i-- {
+			if next[i]++; next[i] != 0 {
+				break
+			}
+		}
+		origin = next[:]
+	}
+	if total != 2003 {
+		t.Fatalf("large storage slot count mismatch: have %d, want %d", total, 2003)
+	}
+}
+
+// Tests that the number of accounts served storage ranges of is capped, even if
+// their storage is empty and doesn't count against the byte budget.
+func TestServiceStorageRangesLimit(t *testing.T) {
+	triedb, root := makeTestState(t, 3*maxStorageLookups, 0)
+
+	var accounts []common.Hash
+	for i := 0; i < 3*maxStorageLookups && len(accounts) < 2*maxStorageLookups; i++ {
+		if i%10 != 0 {
+			accounts = append(accounts, crypto.Keccak256Hash(common.BigToAddress(big.NewInt(int64(i+1))).Bytes()))
+		}
+	}
+	slots, proof := ServiceGetStorageRanges(triedb, &GetStorageRangesPacket{Root: root, Accounts: accounts, Limit: common.Hash{0x01}.Bytes(), Bytes: softResponseLimit})
+	if len(slots) != maxStorageLookups {
+		t.Fatalf("storage set count mismatch: have %d, want %d", len(slots), maxStorageLookups)
+	}
+	if len(proof) != 0 {
+		t.Errorf("complete storage sets proven: %d proof nodes", len(proof))
+	}
+}
+
+// Tests that bytecodes are served by hash, skipping unknown ones.
+func TestServiceByteCodes(t *testing.T) {
+	triedb, _ := makeTestState(t, 100, 0)
+
+	code := []byte{10, 0, 0x60}
+	req := &GetByteCodesPacket{
+		Hashes: []common.Hash{crypto.Keccak256Hash(code), common.HexToHash("0xdeadbeef"), emptyCode},
+		Bytes:  softResponseLimit,
+	}
+	codes := ServiceGetByteCodes(triedb, req)
+	if len(codes) != 2 {
+		t.Fatalf("code count mismatch: have %d, want 2", len(codes))
+	}
+	if !bytes.Equal(codes[0], code) {
+		t.Errorf("code mismatch: have %x, want %x", codes[0], code)
+	}
+	if len(codes[1]) != 0 {
+		t.Errorf("empty code mismatch: have %x", codes[1])
+	}
+}
+
+// testSyncer is a mock state syncer recording the deliveries.
+type testSyncer struct {
+	peers    map[string]downloader.SnapPeer
+	accounts chan []common.Hash
+}
+
+func (s *testSyncer) RegisterSnapPeer(id string, peer downloader.SnapPeer) error {
+	s.peers[id] = peer
+	return nil
+}
+
+func (s *testSyncer) UnregisterSnapPeer(id string) error {
+	delete(s.peers, id)
+	return nil
+}
+
+func (s *testSyncer) DeliverAccountRange(id string, reqid uint64, hashes []common.Hash, accounts [][]byte, proof [][]byte) error {
+	s.accounts <- hashes
+	return nil
+}
+
+func (s *testSyncer) DeliverStorageRanges(id string, reqid uint64, hashes [][]common.Hash, slots [][][]byte, proof [][]byte) error {
+	return nil
+}
+
+func (s *testSyncer) DeliverByteCodes(id string, reqid uint64, codes [][]byte) error {
+	return nil
+}
+
+// Tests that account range requests are served and delivered over the wire.
+func TestAccountRangeRoundtrip(t *testing.T) {
+	triedb, root := makeTestState(t, 100, 0)
+
+	// Connect a serving and a requesting peer
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+	defer net.Close()
+
+	server := &testSyncer{peers: make(map[string]downloader.SnapPeer)}
+	client := &testSyncer{peers: make(map[string]downloader.SnapPeer), accounts: make(chan []common.Hash, 1)}
+
+	serverPeer := newPeer(snap1, p2p.NewPeer(enode.ID{1}, "server", nil), app)
+	clientPeer := newPeer(snap1, p2p.NewPeer(enode.ID{2}, "client", nil), net)
+
+	go Handle(triedb, server, serverPeer)
+	go Handle(triedb, client, clientPeer)
+
+	limit := common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+	if err := clientPeer.RequestAccountRange(1, root, common.Hash{}, limit, softResponseLimit); err != nil {
+		t.Fatalf("failed to request account range: %v", err)
+	}
+	if hashes := <-client.accounts; len(hashes) != 100 {
+		t.Fatalf("account count mismatch: have %d, want %d", len(hashes), 100)
+	}
+}
@@ -1092,19 +1092,45 @@ func (s *PublicTransactionPoolAPI) GetTransactionCount(ctx context.Context, addr
 }
 
 // GetTransactionByHash returns the transaction for the given hash
-func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) *RPCTransaction {
+func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) (*RPCTransaction, error) {
 	// Try to return an already finalized transaction
 	if tx, blockHash, blockNumber, index := rawdb.ReadTransaction(s.b.ChainDb(), hash); tx != nil {
-		return newRPCTransaction(tx, blockHash, blockNumber, index)
+		return newRPCTransaction(tx, blockHash, blockNumber, index), nil
 	}
 	// No finalized transaction, try to retrieve it from the pool
 	if tx := s.b.GetPoolTransaction(hash); tx != nil {
-		return newRPCPendingTransaction(tx)
+		return newRPCPendingTransaction(tx), nil
+	}
+	// Transaction unknown, return as such unless it might not be indexed
+	return nil, unindexedTxError(s.b)
+}
+
+// unindexedTxError returns an error for transactions neither found in the chain
+// nor the pool, if they might be contained in blocks whose transactions are not
+// indexed or whose history expired. Nil is returned if the entire chain is
+// indexed and the transaction is thus unknown.
+func unindexedTxError(b Backend) error {
+	tail, horizon := b.TxIndexTail(), b.HistoryHorizon()
+	switch {
+	case tail > 0 && tail >= horizon:
+		return fmt.Errorf("transaction unavailable: not indexed (oldest indexed #%d)", tail)
+	case horizon > 0:
+		return fmt.Errorf("transaction unavailable: history expired (oldest available #%d)", horizon)
 	}
-	// Transaction unknown, return as such
 	return nil
 }
 
+// TxIndexTail returns the number of the oldest block whose transactions can be
+// looked up by hash. Transactions of older blocks are not indexed, either due to
+// the lookup limit or because their history expired, and are reported unknown.
+func (s *PublicTransactionPoolAPI) TxIndexTail() hexutil.Uint64 {
+	tail := s.b.TxIndexTail()
+	if horizon := s.b.HistoryHorizon(); horizon > tail {
+		tail = horizon
+	}
+	return hexutil.Uint64(tail)
+}
+
 // GetRawTransactionByHash returns the bytes of the transaction for the given hash.
 func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Context, hash common.Hash) (hexutil.Bytes, error) {
 	var tx *types.Transaction
@@ -1124,6 +1150,11 @@ func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Context,
 func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
 	tx, blockHash, blockNumber, index := rawdb.ReadTransaction(s.b.ChainDb(), hash)
 	if tx == nil {
+		// The lookup entries of unindexed and expired blocks are deleted, so
+		// unknown transactions might be in those. Report instead of hiding.
+		if s.b.GetPoolTransaction(hash) == nil {
+			return nil, unindexedTxError(s.b)
+		}
 		return nil, nil
 	}
 	receipts, err := s.b.GetReceipts(ctx, blockHash)
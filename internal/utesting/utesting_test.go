@@ -0,0 +1,54 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package utesting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunTests(t *testing.T) {
+	tests := []Test{
+		{Name: "TestOK", Fn: func(t *T) { t.Log("output") }},
+		{Name: "TestFail", Fn: func(t *T) {
+			t.Fatal("failed")
+			t.Log("unreachable")
+		}},
+		{Name: "TestSkip", Fn: func(t *T) { t.Skip("skipped") }},
+	}
+	var report bytes.Buffer
+	results := RunTests(tests, &report)
+
+	if n := CountFailures(results); n != 1 {
+		t.Errorf("wrong failure count %d, want 1", n)
+	}
+	if results[0].Failed || results[0].Output != "output\n" {
+		t.Errorf("wrong result for TestOK: %+v", results[0])
+	}
+	if !results[1].Failed || results[1].Output != "failed\n" {
+		t.Errorf("wrong result for TestFail: %+v", results[1])
+	}
+	if !results[2].Skipped || results[2].Failed {
+		t.Errorf("wrong result for TestSkip: %+v", results[2])
+	}
+	for _, want := range []string{"-- OK TestOK", "-- FAIL TestFail", "-- SKIP TestSkip"} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, report.String())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"errors"
+
+	"github.com/severeum/go-severeum/common/hexutil"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/params"
+)
+
+var (
+	errNoClientPool      = errors.New("light server not running")
+	errNoLocalCheckpoint = errors.New("checkpoint not available")
+)
+
+// PrivateLightServerAPI provides an API to manage the client pool of a light
+// server, i.e. the capacities and token balances of its clients.
+type PrivateLightServerAPI struct {
+	server *LesServer
+}
+
+// NewPrivateLightServerAPI creates a new LES server API.
+func NewPrivateLightServerAPI(server *LesServer) *PrivateLightServerAPI {
+	return &PrivateLightServerAPI{server: server}
+}
+
+// pool returns the client pool of the server, which is only created when the
+// protocol manager is started.
+func (api *PrivateLightServerAPI) pool() (*clientPool, error) {
+	if pool := api.server.protocolManager.clientPool; pool != nil {
+		return pool, nil
+	}
+	return nil, errNoClientPool
+}
+
+// CapacityInfo contains the capacity statistics of the light server.
+type CapacityInfo struct {
+	Total    hexutil.Uint64 `json:"total"`    // Total capacity of the server
+	Priority hexutil.Uint64 `json:"priority"` // Capacity used by the connected priority clients
+	Free     hexutil.Uint64 `json:"free"`     // Capacity assigned to each free client
+}
+
+// Capacity returns the total capacity of the server, the capacity used by the
+// connected priority clients and the capacity of a single free client.
+func (api *PrivateLightServerAPI) Capacity() (*CapacityInfo, error) {
+	pool, err := api.pool()
+	if err != nil {
+		return nil, err
+	}
+	total, priority, free := pool.capacityStats()
+	return &CapacityInfo{
+		Total:    hexutil.Uint64(total),
+		Priority: hexutil.Uint64(priority),
+		Free:     hexutil.Uint64(free),
+	}, nil
+}
+
+// SetClientCapacity assigns a capacity to a client, applied while the client
+// has priority status (i.e. is a trusted peer or has a positive balance). Zero
+// resets the client to the default capacity.
+func (api *PrivateLightServerAPI) SetClientCapacity(id enode.ID, capacity hexutil.Uint64) error {
+	pool, err := api.pool()
+	if err != nil {
+		return err
+	}
+	return pool.setCapacity(id, uint64(capacity))
+}
+
+// AddBalance credits tokens to the balance of a client, granting it priority
+// status until the balance is drained by the cost of the served requests.
+func (api *PrivateLightServerAPI) AddBalance(id enode.ID, amount hexutil.Uint64) (hexutil.Uint64, error) {
+	pool, err := api.pool()
+	if err != nil {
+		return 0, err
+	}
+	return hexutil.Uint64(pool.addBalance(id, uint64(amount))), nil
+}
+
+// ClientInfo contains the client pool record of a single client.
+type ClientInfo struct {
+	Capacity  hexutil.Uint64 `json:"capacity"`  // Assigned capacity, zero for the default one
+	Balance   hexutil.Uint64 `json:"balance"`   // Remaining token balance
+	Connected bool           `json:"connected"` // Whether the client is connected with priority status
+}
+
+// ClientInfo retrieves the assigned capacity and remaining balance of a client.
+func (api *PrivateLightServerAPI) ClientInfo(id enode.ID) (*ClientInfo, error) {
+	pool, err := api.pool()
+	if err != nil {
+		return nil, err
+	}
+	capacity, balance, connected := pool.clientStatus(id)
+	return &ClientInfo{
+		Capacity:  hexutil.Uint64(capacity),
+		Balance:   hexutil.Uint64(balance),
+		Connected: connected,
+	}, nil
+}
+
+// LatestCheckpoint returns the latest checkpoint of the server, i.e. the roots of
+// the most recent section for which both the CHT and the BloomTrie are available.
+func (api *PrivateLightServerAPI) LatestCheckpoint() (*params.TrustedCheckpoint, error) {
+	sections := api.server.localSections()
+	if sections == 0 {
+		return nil, errNoLocalCheckpoint
+	}
+	return api.server.localCheckpoint(sections - 1), nil
+}
+
+// GetCheckpoint returns the checkpoint of the given section, to be signed and
+// published in the checkpoint oracle.
+func (api *PrivateLightServerAPI) GetCheckpoint(index uint64) (*params.TrustedCheckpoint, error) {
+	if index >= api.server.localSections() {
+		return nil, errNoLocalCheckpoint
+	}
+	return api.server.localCheckpoint(index), nil
+}
+
+// RequestCostInfo contains the measured serving time and the advertised cost of
+// a request type. The measured values are in nanoseconds.
+type RequestCostInfo struct {
+	Name           string         `json:"name"`
+	Code           hexutil.Uint64 `json:"code"`
+	Samples        hexutil.Uint64 `json:"samples"`        // Number of requests measured
+	MeasuredBase   hexutil.Uint64 `json:"measuredBase"`   // Measured serving time of the request itself
+	MeasuredReq    hexutil.Uint64 `json:"measuredReq"`    // Measured serving time of each requested item
+	AdvertisedBase hexutil.Uint64 `json:"advertisedBase"` // Base cost advertised to new clients
+	AdvertisedReq  hexutil.Uint64 `json:"advertisedReq"`  // Per item cost advertised to new clients
+}
+
+// RequestCosts returns the measured serving times and the advertised costs of
+// all request types served by the light server.
+func (api *PrivateLightServerAPI) RequestCosts() []RequestCostInfo {
+	return api.server.costTracker.costInfo()
+}
@@ -51,6 +51,14 @@ func (b *LesApiBackend) CurrentBlock() *types.Block {
 	return types.NewBlockWithHeader(b.eth.BlockChain().CurrentHeader())
 }
 
+func (b *LesApiBackend) HistoryHorizon() uint64 {
+	return 0
+}
+
+func (b *LesApiBackend) TxIndexTail() uint64 {
+	return 0
+}
+
 func (b *LesApiBackend) SetHead(number uint64) {
 	b.eth.protocolManager.downloader.Cancel()
 	b.eth.blockchain.SetHead(number)
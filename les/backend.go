@@ -18,11 +18,13 @@
 package les
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/severeum/go-severeum/accounts"
+	"github.com/severeum/go-severeum/accounts/abi/bind"
 	"github.com/severeum/go-severeum/common"
 	"github.com/severeum/go-severeum/common/hexutil"
 	"github.com/severeum/go-severeum/consensus"
@@ -65,6 +67,9 @@ type LightSevereum struct {
 	bloomRequests chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
 	bloomIndexer  *core.ChainIndexer
 
+	oracle  *checkpointOracle // Checkpoint oracle handler, nil if not configured
+	closeCh chan struct{}     // Channel to signal the background processes to exit
+
 	ApiBackend *LesApiBackend
 
 	eventMux       *event.TypeMux
@@ -107,6 +112,8 @@ func New(ctx *node.ServiceContext, config *eth.Config) (*LightSevereum, error) {
 		networkId:      config.NetworkId,
 		bloomRequests:  make(chan chan *bloombits.Retrieval),
 		bloomIndexer:   eth.NewBloomIndexer(chainDb, params.BloomBitsBlocksClient, params.HelperTrieConfirmations),
+		oracle:         newCheckpointOracle(config.CheckpointOracle),
+		closeCh:        make(chan struct{}),
 	}
 
 	leth.relay = NewLesTxRelay(peers, leth.reqDist)
@@ -148,6 +155,50 @@ func New(ctx *node.ServiceContext, config *eth.Config) (*LightSevereum, error) {
 	return leth, nil
 }
 
+// SetContractBackend sets the backend used to interact with the checkpoint
+// oracle contract, starting the periodic retrieval of the latest checkpoint.
+func (s *LightSevereum) SetContractBackend(backend bind.ContractBackend) {
+	if s.oracle == nil || s.oracle.isRunning() {
+		return
+	}
+	if err := s.oracle.start(backend); err != nil {
+		log.Error("Failed to start checkpoint oracle", "err", err)
+		return
+	}
+	s.wg.Add(1)
+	go s.checkpointLoop()
+}
+
+// checkpointLoop periodically retrieves the latest checkpoint from the oracle,
+// adding it to the chain if it's newer than the current trusted checkpoint.
+func (s *LightSevereum) checkpointLoop() {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(checkpointQueryDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+			cp, err := s.oracle.latestCheckpoint(ctx)
+			cancel()
+
+			switch {
+			case err == errNoOracleCheckpoint:
+			case err != nil:
+				log.Debug("Failed to retrieve oracle checkpoint", "err", err)
+			case s.blockchain.AddTrustedCheckpoint(cp):
+				log.Info("Updated trusted checkpoint from oracle", "section", cp.SectionIndex, "hash", cp.Hash())
+			}
+			timer.Reset(checkpointQueryInterval)
+
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
 func lesTopic(genesisHash common.Hash, protocolVersion uint) discv5.Topic {
 	var name string
 	switch protocolVersion {
@@ -244,6 +295,7 @@ func (s *LightSevereum) Start(srvr *p2p.Server) error {
 // Stop implements node.Service, terminating all internal goroutines used by the
 // Severeum protocol.
 func (s *LightSevereum) Stop() error {
+	close(s.closeCh)
 	s.odr.Stop()
 	s.bloomIndexer.Close()
 	s.chtIndexer.Close()
@@ -0,0 +1,137 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/severeum/go-severeum/accounts/abi/bind"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/contracts/checkpointoracle"
+	"github.com/severeum/go-severeum/contracts/checkpointoracle/contract"
+	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/params"
+)
+
+const (
+	checkpointQueryDelay    = time.Minute      // Delay of the first oracle query, allowing the chain to sync up
+	checkpointQueryInterval = 10 * time.Minute // Interval of the subsequent oracle queries
+	checkpointQueryTimeout  = time.Minute      // Timeout of a single oracle query
+)
+
+var (
+	errOracleNotRunning   = errors.New("checkpoint oracle not running")
+	errNoOracleCheckpoint = errors.New("no checkpoint registered in the oracle")
+)
+
+// checkpointOracle retrieves the latest checkpoint registered in the on-chain
+// checkpoint oracle contract and verifies it against the locally configured
+// set of trusted signers.
+type checkpointOracle struct {
+	config   *params.CheckpointOracleConfig
+	contract *checkpointoracle.CheckpointOracle
+	running  int32 // Flag whether the contract backend is set
+}
+
+// newCheckpointOracle creates a checkpoint oracle handler, or returns nil if no
+// oracle is configured.
+func newCheckpointOracle(config *params.CheckpointOracleConfig) *checkpointOracle {
+	if config == nil {
+		log.Info("Checkpoint oracle is not enabled")
+		return nil
+	}
+	if config.Threshold == 0 || config.Threshold > uint64(len(config.Signers)) {
+		log.Error("Invalid checkpoint oracle config", "signers", len(config.Signers), "threshold", config.Threshold)
+		return nil
+	}
+	log.Info("Configured checkpoint oracle", "address", config.Address, "signers", len(config.Signers), "threshold", config.Threshold)
+	return &checkpointOracle{config: config}
+}
+
+// start binds the oracle contract to the given backend, enabling checkpoint
+// retrievals.
+func (oracle *checkpointOracle) start(backend bind.ContractBackend) error {
+	c, err := checkpointoracle.NewCheckpointOracle(oracle.config.Address, backend)
+	if err != nil {
+		return err
+	}
+	oracle.contract = c
+	atomic.StoreInt32(&oracle.running, 1)
+	return nil
+}
+
+// isRunning returns whether the contract backend is set.
+func (oracle *checkpointOracle) isRunning() bool {
+	return atomic.LoadInt32(&oracle.running) == 1
+}
+
+// latestCheckpoint retrieves the latest checkpoint registered in the oracle. The
+// checkpoint is only returned if its votes, emitted when it was registered, are
+// signed by at least threshold of the trusted signers.
+func (oracle *checkpointOracle) latestCheckpoint(ctx context.Context) (*params.TrustedCheckpoint, error) {
+	if !oracle.isRunning() {
+		return nil, errOracleNotRunning
+	}
+	index, head, cht, bloom, height, err := oracle.contract.Contract().GetLatestCheckpoint(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return nil, err
+	}
+	cp := &params.TrustedCheckpoint{
+		Name:         "oracle",
+		SectionIndex: index,
+		SectionHead:  head,
+		CHTRoot:      cht,
+		BloomRoot:    bloom,
+	}
+	if cp.Empty() || height.Sign() == 0 {
+		return nil, errNoOracleCheckpoint
+	}
+	number := height.Uint64()
+	votes, err := oracle.contract.LookupCheckpointEvents(&bind.FilterOpts{Start: number, End: &number, Context: ctx}, index, cp.Hash())
+	if err != nil {
+		return nil, err
+	}
+	if err := oracle.verifyVotes(cp.Hash(), votes); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// verifyVotes checks whether a checkpoint is signed by at least threshold of
+// the trusted signers. Signatures of any other oracle admins are ignored.
+func (oracle *checkpointOracle) verifyVotes(hash common.Hash, votes []*contract.CheckpointOracleNewCheckpointVote) error {
+	signed := make(map[common.Address]bool)
+	for _, vote := range votes {
+		signer, err := checkpointoracle.RecoverSigner(oracle.config.Address, hash, checkpointoracle.VoteSignature(vote))
+		if err != nil {
+			continue
+		}
+		for _, trusted := range oracle.config.Signers {
+			if signer == trusted {
+				signed[signer] = true
+			}
+		}
+	}
+	if uint64(len(signed)) < oracle.config.Threshold {
+		return fmt.Errorf("checkpoint %x signed by %d trusted signers, %d required", hash, len(signed), oracle.config.Threshold)
+	}
+	return nil
+}
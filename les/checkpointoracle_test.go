@@ -0,0 +1,120 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/severeum/go-severeum/accounts/abi/bind"
+	"github.com/severeum/go-severeum/accounts/abi/bind/backends"
+	"github.com/severeum/go-severeum/common"
+	"github.com/severeum/go-severeum/contracts/checkpointoracle"
+	"github.com/severeum/go-severeum/contracts/checkpointoracle/contract"
+	"github.com/severeum/go-severeum/core"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/params"
+)
+
+// Tests that the light client only accepts oracle checkpoints signed by enough
+// of the locally trusted signers, regardless of the admins of the contract.
+func TestCheckpointOracleVerify(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := crypto.PubkeyToAddress(keys[i].PublicKey), crypto.PubkeyToAddress(keys[j].PublicKey)
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	var (
+		admins []common.Address
+		alloc  = core.GenesisAlloc{}
+	)
+	for _, key := range keys {
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		admins = append(admins, addr)
+		alloc[addr] = core.GenesisAccount{Balance: big.NewInt(1000000000000000000)}
+	}
+	backend := backends.NewSimulatedBackend(alloc, 10000000)
+	opts := bind.NewKeyedTransactor(keys[0])
+
+	addr, _, _, err := contract.DeployCheckpointOracle(opts, backend, admins, big.NewInt(1), big.NewInt(1), big.NewInt(2))
+	if err != nil {
+		t.Fatalf("failed to deploy oracle: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		backend.Commit()
+	}
+	// Register a checkpoint signed by the first two admins
+	cp := &params.TrustedCheckpoint{
+		Name:         "oracle",
+		SectionIndex: 0,
+		SectionHead:  common.HexToHash("0x01"),
+		CHTRoot:      common.HexToHash("0x02"),
+		BloomRoot:    common.HexToHash("0x03"),
+	}
+	var sigs [][]byte
+	for _, key := range keys[:2] {
+		sig, err := crypto.Sign(checkpointoracle.SignatureHash(addr, cp.Hash()).Bytes(), key)
+		if err != nil {
+			t.Fatalf("failed to sign checkpoint: %v", err)
+		}
+		sigs = append(sigs, sig)
+	}
+	registrar, _ := checkpointoracle.NewCheckpointOracle(addr, backend)
+	head := backend.Blockchain().CurrentHeader()
+	if _, err := registrar.RegisterCheckpoint(opts, cp, head.Number, head.Hash(), sigs); err != nil {
+		t.Fatalf("failed to register checkpoint: %v", err)
+	}
+	backend.Commit()
+
+	tests := []struct {
+		signers   []common.Address
+		threshold uint64
+		accept    bool
+	}{
+		{admins, 2, true},
+		{admins[:2], 2, true},
+		{admins[1:], 1, true},
+		{admins[1:], 2, false},
+		{[]common.Address{admins[2]}, 1, false},
+	}
+	for i, tt := range tests {
+		oracle := newCheckpointOracle(&params.CheckpointOracleConfig{Address: addr, Signers: tt.signers, Threshold: tt.threshold})
+		if _, err := oracle.latestCheckpoint(context.Background()); err != errOracleNotRunning {
+			t.Fatalf("test %d: error mismatch before start: have %v, want %v", i, err, errOracleNotRunning)
+		}
+		if err := oracle.start(backend); err != nil {
+			t.Fatalf("test %d: failed to start oracle: %v", i, err)
+		}
+		have, err := oracle.latestCheckpoint(context.Background())
+		if tt.accept {
+			if err != nil {
+				t.Errorf("test %d: checkpoint rejected: %v", i, err)
+			} else if *have != *cp {
+				t.Errorf("test %d: checkpoint mismatch: have %+v, want %+v", i, have, cp)
+			}
+		} else if err == nil {
+			t.Errorf("test %d: checkpoint accepted with insufficient trusted signatures", i)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/severeum/go-severeum/common/mclock"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/les/flowcontrol"
+	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+var (
+	errCapacityTooLow   = errors.New("capacity is lower than the free client capacity")
+	errCapacityExceeded = errors.New("not enough total capacity available")
+
+	clientInfoPrefix = []byte("clientInfo-") // clientInfoPrefix + id -> client info
+)
+
+// clientPool is the admission control subsystem of the LES server. It assigns a
+// flow control capacity (minimum recharge rate) to every connected client and
+// maintains two tiers of clients:
+//
+//   - Priority clients are either whitelisted (trusted p2p peers) or backed by a
+//     positive token balance which is drained by the cost of the served requests.
+//     They receive their individually assigned capacity and may evict free clients
+//     if the total capacity of the server is exhausted.
+//   - Free clients receive the default capacity and are admitted by the free
+//     client pool according to their recent usage, within the capacity left by
+//     the priority clients.
+type clientPool struct {
+	lock   sync.Mutex
+	db     ethdb.Database
+	free   *freeClientPool
+	closed bool
+
+	defParams              flowcontrol.ServerParams // Flow control parameters of free clients
+	maxPeers               int                      // Maximum number of connected clients
+	totalCap, totalConnCap uint64                   // Total capacity of the server and that of connected priority clients
+	clients                map[enode.ID]*clientInfo // Cached records of connected priority clients, loaded lazily
+	priority               map[enode.ID]*priorityClient
+}
+
+// clientInfoKey = clientInfoPrefix + id
+func clientInfoKey(id enode.ID) []byte {
+	return append(append([]byte{}, clientInfoPrefix...), id[:]...)
+}
+
+// clientInfo is the persisted record of a client's assigned capacity and balance.
+type clientInfo struct {
+	Capacity uint64 // Capacity assigned to the client while having priority, 0 = default
+	Balance  uint64 // Token balance drained by the cost of the served requests
+}
+
+// priorityClient is a client connected within the priority tier.
+type priorityClient struct {
+	peer        *peer
+	capacity    uint64
+	whitelisted bool
+	dropped     bool // Set if the client is being disconnected by the pool
+	disconnect  func()
+}
+
+// newClientPool creates a client pool serving at most maxPeers clients, each free
+// client receiving the given default flow control parameters.
+func newClientPool(db ethdb.Database, defParams flowcontrol.ServerParams, maxPeers int, clock mclock.Clock) *clientPool {
+	return &clientPool{
+		db:        db,
+		free:      newFreeClientPool(db, maxPeers, 10000, clock),
+		defParams: defParams,
+		maxPeers:  maxPeers,
+		totalCap:  defParams.MinRecharge * uint64(maxPeers),
+		clients:   make(map[enode.ID]*clientInfo),
+		priority:  make(map[enode.ID]*priorityClient),
+	}
+}
+
+// stop persists the state of the pool and rejects any further connections.
+func (cp *clientPool) stop() {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	cp.closed = true
+	for id := range cp.clients {
+		cp.storeInfo(id)
+	}
+	cp.free.stop()
+}
+
+// info retrieves the record of a client, loading it from the database if not
+// yet cached. The caller must hold the pool lock.
+func (cp *clientPool) info(id enode.ID) *clientInfo {
+	if info, ok := cp.clients[id]; ok {
+		return info
+	}
+	info := new(clientInfo)
+	if enc, err := cp.db.Get(clientInfoKey(id)); err == nil {
+		if err := rlp.DecodeBytes(enc, info); err != nil {
+			log.Error("Failed to decode client info", "id", id, "err", err)
+		}
+	}
+	cp.clients[id] = info
+	return info
+}
+
+// release drops the cached record of a client unless it's connected with priority
+// status, whose balance is drained in memory. Records of all other clients are
+// persisted right away, so they are only cached while being accessed. The caller
+// must hold the pool lock.
+func (cp *clientPool) release(id enode.ID) {
+	if _, ok := cp.priority[id]; !ok {
+		delete(cp.clients, id)
+	}
+}
+
+// storeInfo persists the record of a client. The caller must hold the pool lock.
+func (cp *clientPool) storeInfo(id enode.ID) {
+	info, ok := cp.clients[id]
+	if !ok {
+		return
+	}
+	key := clientInfoKey(id)
+	if info.Capacity == 0 && info.Balance == 0 {
+		cp.db.Delete(key)
+		return
+	}
+	enc, err := rlp.EncodeToBytes(info)
+	if err != nil {
+		log.Error("Failed to encode client info", "id", id, "err", err)
+		return
+	}
+	cp.db.Put(key, enc)
+}
+
+// hasPriority returns whether a client belongs to the priority tier. The caller
+// must hold the pool lock.
+func (cp *clientPool) hasPriority(id enode.ID, whitelisted bool) bool {
+	return whitelisted || cp.info(id).Balance > 0
+}
+
+// capacity returns the capacity a client would be assigned when connecting. The
+// caller must hold the pool lock.
+func (cp *clientPool) capacity(id enode.ID, whitelisted bool) uint64 {
+	if cp.hasPriority(id, whitelisted) {
+		if capacity := cp.info(id).Capacity; capacity != 0 {
+			return capacity
+		}
+	}
+	return cp.defParams.MinRecharge
+}
+
+// params calculates the flow control parameters corresponding to a capacity,
+// scaling the buffer limit of free clients proportionally.
+func (cp *clientPool) params(capacity uint64) *flowcontrol.ServerParams {
+	return &flowcontrol.ServerParams{
+		BufLimit:    cp.defParams.BufLimit / cp.defParams.MinRecharge * capacity,
+		MinRecharge: capacity,
+	}
+}
+
+// clientParams returns the flow control parameters to be announced to a client
+// in the handshake, according to the tier it would be connected in.
+func (cp *clientPool) clientParams(id enode.ID, whitelisted bool) *flowcontrol.ServerParams {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	defer cp.release(id)
+
+	return cp.params(cp.capacity(id, whitelisted))
+}
+
+// connect admits or rejects a client after a successful handshake. Priority
+// clients are accepted as long as their capacity fits into the total capacity
+// after evicting free clients, whitelisted ones even beyond. Free clients are
+// admitted by the free client pool based on the given address.
+//
+// Note: the disconnectFn callback should not block.
+func (cp *clientPool) connect(p *peer, address string, whitelisted bool, disconnectFn func()) bool {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if cp.closed {
+		return false
+	}
+	id := p.ID()
+	defer cp.release(id)
+
+	if !cp.hasPriority(id, whitelisted) {
+		if address == "" {
+			return true // Not a tcp connection, nothing to limit
+		}
+		return cp.free.connect(address, disconnectFn)
+	}
+	if _, ok := cp.priority[id]; ok {
+		log.Debug("Priority client already connected", "id", id)
+		return false
+	}
+	capacity := cp.capacity(id, whitelisted)
+	if !whitelisted && (len(cp.priority) >= cp.maxPeers || cp.totalConnCap+capacity > cp.totalCap) {
+		log.Debug("Priority client rejected", "id", id, "capacity", capacity)
+		return false
+	}
+	cp.priority[id] = &priorityClient{peer: p, capacity: capacity, whitelisted: whitelisted, disconnect: disconnectFn}
+	cp.totalConnCap += capacity
+	cp.updateFreeLimits()
+
+	log.Debug("Priority client accepted", "id", id, "capacity", capacity, "whitelisted", whitelisted)
+	return true
+}
+
+// disconnect should be called when a connection admitted by the pool terminates.
+func (cp *clientPool) disconnect(p *peer, address string) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if cp.closed {
+		return
+	}
+	id := p.ID()
+	if c, ok := cp.priority[id]; ok && c.peer == p {
+		delete(cp.priority, id)
+		cp.totalConnCap -= c.capacity
+		cp.updateFreeLimits()
+		cp.storeInfo(id)
+		cp.release(id)
+		log.Debug("Priority client disconnected", "id", id)
+		return
+	}
+	if address != "" {
+		cp.free.disconnect(address)
+	}
+}
+
+// updateFreeLimits recalculates the number of free clients fitting next to the
+// connected priority clients, evicting free clients if necessary. The caller
+// must hold the pool lock.
+func (cp *clientPool) updateFreeLimits() {
+	var count int
+	if cp.totalConnCap < cp.totalCap {
+		count = int((cp.totalCap - cp.totalConnCap) / cp.defParams.MinRecharge)
+	}
+	if limit := cp.maxPeers - len(cp.priority); count > limit {
+		count = limit
+	}
+	cp.free.setLimits(count)
+}
+
+// charge drains the token balance of a balance-backed priority client with the
+// cost of a served request. Clients running out of tokens are disconnected and
+// may reconnect as free clients.
+func (cp *clientPool) charge(p *peer, cost uint64) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	c, ok := cp.priority[p.ID()]
+	if !ok || c.whitelisted || c.peer != p {
+		return
+	}
+	info := cp.info(p.ID())
+	if info.Balance > cost {
+		info.Balance -= cost
+		return
+	}
+	info.Balance = 0
+	if !c.dropped {
+		log.Debug("Priority client ran out of balance", "id", p.ID())
+		c.dropped = true
+		c.disconnect()
+	}
+}
+
+// setCapacity assigns a capacity to a client, used while it has priority status.
+// Raising the capacity of a connected priority client takes effect immediately,
+// lowering it disconnects the client so it can reconnect with the new flow
+// control parameters.
+func (cp *clientPool) setCapacity(id enode.ID, capacity uint64) error {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if capacity != 0 && capacity < cp.defParams.MinRecharge {
+		return errCapacityTooLow
+	}
+	defer cp.release(id)
+
+	info := cp.info(id)
+	if c, ok := cp.priority[id]; ok {
+		newCap := capacity
+		if newCap == 0 {
+			newCap = cp.defParams.MinRecharge
+		}
+		switch {
+		case newCap > c.capacity:
+			if !c.whitelisted && cp.totalConnCap+newCap-c.capacity > cp.totalCap {
+				return errCapacityExceeded
+			}
+			cp.totalConnCap += newCap - c.capacity
+			c.capacity = newCap
+			c.peer.fcClient.UpdateParams(cp.params(newCap))
+			cp.updateFreeLimits()
+		case newCap < c.capacity && !c.dropped:
+			c.dropped = true
+			c.disconnect()
+		}
+	}
+	info.Capacity = capacity
+	cp.storeInfo(id)
+	return nil
+}
+
+// addBalance credits tokens to the balance of a client, granting it priority
+// status from its next connection on. It returns the new balance.
+func (cp *clientPool) addBalance(id enode.ID, amount uint64) uint64 {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	defer cp.release(id)
+
+	info := cp.info(id)
+	if info.Balance+amount < info.Balance {
+		info.Balance = ^uint64(0)
+	} else {
+		info.Balance += amount
+	}
+	cp.storeInfo(id)
+	return info.Balance
+}
+
+// clientStatus returns the assigned capacity and balance of a client, along
+// with whether it's currently connected with priority status.
+func (cp *clientPool) clientStatus(id enode.ID) (capacity, balance uint64, connected bool) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	defer cp.release(id)
+
+	info := cp.info(id)
+	_, connected = cp.priority[id]
+	return info.Capacity, info.Balance, connected
+}
+
+// capacityStats returns the total capacity of the server, the capacity used by
+// the connected priority clients and the capacity of a single free client.
+func (cp *clientPool) capacityStats() (total, priority, free uint64) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	return cp.totalCap, cp.totalConnCap, cp.defParams.MinRecharge
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/severeum/go-severeum/common/mclock"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/les/flowcontrol"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/p2p/enode"
+)
+
+var testClientParams = flowcontrol.ServerParams{BufLimit: 1000, MinRecharge: 10}
+
+// newTestClient creates a connectable client peer with the given identity.
+func newTestClient(cm *flowcontrol.ClientManager, id byte) *peer {
+	p := newPeer(lpv2, NetworkId, p2p.NewPeer(enode.ID{id}, fmt.Sprintf("client #%d", id), nil), nil)
+	p.fcClient = flowcontrol.NewClientNode(cm, &testClientParams)
+	return p
+}
+
+// Tests that balance-backed priority clients evict free clients if the total
+// capacity is exhausted, and that they are demoted once their balance is drained.
+func TestClientPoolPriority(t *testing.T) {
+	var (
+		clock   mclock.Simulated
+		db      = ethdb.NewMemDatabase()
+		cm      = flowcontrol.NewClientManager(50, 10, 1000000000)
+		pool    = newClientPool(db, testClientParams, 4, &clock)
+		kicked  = make(map[byte]bool)
+		address = func(id byte) string { return fmt.Sprintf("10.0.0.%d", id) }
+	)
+	defer cm.Stop()
+
+	// Fill the pool with free clients
+	for id := byte(1); id <= 4; id++ {
+		id := id
+		if !pool.connect(newTestClient(cm, id), address(id), false, func() { kicked[id] = true }) {
+			t.Fatalf("free client #%d rejected", id)
+		}
+	}
+	if pool.connect(newTestClient(cm, 5), address(5), false, func() {}) {
+		t.Fatalf("free client accepted over the limit")
+	}
+	// Fund a client with double capacity and ensure it evicts two free ones
+	pool.addBalance(enode.ID{6}, 100)
+	if err := pool.setCapacity(enode.ID{6}, 2*testClientParams.MinRecharge); err != nil {
+		t.Fatalf("failed to set capacity: %v", err)
+	}
+	if params := pool.clientParams(enode.ID{6}, false); params.MinRecharge != 2*testClientParams.MinRecharge || params.BufLimit != 2*testClientParams.BufLimit {
+		t.Fatalf("priority params mismatch: have %+v", params)
+	}
+	priority := newTestClient(cm, 6)
+	if !pool.connect(priority, address(6), false, func() { kicked[6] = true }) {
+		t.Fatalf("priority client rejected")
+	}
+	if len(kicked) != 2 {
+		t.Fatalf("evicted free client count mismatch: have %d, want %d", len(kicked), 2)
+	}
+	if _, _, connected := pool.clientStatus(enode.ID{6}); !connected {
+		t.Fatalf("priority client not reported connected")
+	}
+	// A second priority client with the same capacity should still fit
+	pool.addBalance(enode.ID{7}, 100)
+	pool.setCapacity(enode.ID{7}, 2*testClientParams.MinRecharge)
+	if !pool.connect(newTestClient(cm, 7), address(7), false, func() {}) {
+		t.Fatalf("second priority client rejected")
+	}
+	if len(kicked) != 4 {
+		t.Fatalf("evicted free client count mismatch: have %d, want %d", len(kicked), 4)
+	}
+	// A third one should be rejected as there are no free clients left to evict
+	pool.addBalance(enode.ID{8}, 100)
+	if pool.connect(newTestClient(cm, 8), address(8), false, func() {}) {
+		t.Fatalf("priority client accepted over the total capacity")
+	}
+	// Drain the balance of the first priority client and check that it's dropped
+	pool.charge(priority, 60)
+	if kicked[6] {
+		t.Fatalf("priority client dropped with remaining balance")
+	}
+	pool.charge(priority, 60)
+	if !kicked[6] {
+		t.Fatalf("priority client not dropped after draining its balance")
+	}
+	pool.disconnect(priority, address(6))
+	if _, balance, connected := pool.clientStatus(enode.ID{6}); balance != 0 || connected {
+		t.Fatalf("drained client status mismatch: balance %d, connected %v", balance, connected)
+	}
+	// Free capacity should be available again
+	if !pool.connect(newTestClient(cm, 9), address(9), false, func() {}) {
+		t.Fatalf("free client rejected after priority client left")
+	}
+	// Only the records of the connected priority clients should remain cached
+	if len(pool.clients) != 1 || pool.clients[enode.ID{7}] == nil {
+		t.Fatalf("cached client records mismatch: have %d, want only the connected priority client", len(pool.clients))
+	}
+}
+
+// Tests that whitelisted clients are always admitted and that the client records
+// are persisted across pool restarts.
+func TestClientPoolWhitelist(t *testing.T) {
+	var (
+		clock mclock.Simulated
+		db    = ethdb.NewMemDatabase()
+		cm    = flowcontrol.NewClientManager(50, 10, 1000000000)
+		pool  = newClientPool(db, testClientParams, 1, &clock)
+	)
+	defer cm.Stop()
+
+	if err := pool.setCapacity(enode.ID{1}, testClientParams.MinRecharge/2); err != errCapacityTooLow {
+		t.Fatalf("error mismatch: have %v, want %v", err, errCapacityTooLow)
+	}
+	pool.setCapacity(enode.ID{1}, 5*testClientParams.MinRecharge)
+	pool.addBalance(enode.ID{2}, 1000)
+
+	if !pool.connect(newTestClient(cm, 1), "10.0.0.1", true, func() {}) {
+		t.Fatalf("whitelisted client rejected over the total capacity")
+	}
+	if total, used, _ := pool.capacityStats(); used <= total {
+		t.Fatalf("whitelisted capacity not accounted: total %d, used %d", total, used)
+	}
+	pool.stop()
+
+	// Reload the pool and check the persisted records
+	pool = newClientPool(db, testClientParams, 1, &clock)
+	if capacity, _, _ := pool.clientStatus(enode.ID{1}); capacity != 5*testClientParams.MinRecharge {
+		t.Fatalf("persisted capacity mismatch: have %d, want %d", capacity, 5*testClientParams.MinRecharge)
+	}
+	if _, balance, _ := pool.clientStatus(enode.ID{2}); balance != 1000 {
+		t.Fatalf("persisted balance mismatch: have %d, want %d", balance, 1000)
+	}
+}
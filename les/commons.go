@@ -77,33 +77,8 @@ func (c *lesCommons) makeProtocols(versions []uint) []p2p.Protocol {
 // nodeInfo retrieves some protocol metadata about the running host node.
 func (c *lesCommons) nodeInfo() interface{} {
 	var cht params.TrustedCheckpoint
-	sections, _, _ := c.chtIndexer.Sections()
-	sections2, _, _ := c.bloomTrieIndexer.Sections()
-
-	if !c.protocolManager.lightSync {
-		// convert to client section size if running in server mode
-		sections /= c.iConfig.PairChtSize / c.iConfig.ChtSize
-	}
-
-	if sections2 < sections {
-		sections = sections2
-	}
-	if sections > 0 {
-		sectionIndex := sections - 1
-		sectionHead := c.bloomTrieIndexer.SectionHead(sectionIndex)
-		var chtRoot common.Hash
-		if c.protocolManager.lightSync {
-			chtRoot = light.GetChtRoot(c.chainDb, sectionIndex, sectionHead)
-		} else {
-			idxV2 := (sectionIndex+1)*c.iConfig.PairChtSize/c.iConfig.ChtSize - 1
-			chtRoot = light.GetChtRoot(c.chainDb, idxV2, sectionHead)
-		}
-		cht = params.TrustedCheckpoint{
-			SectionIndex: sectionIndex,
-			SectionHead:  sectionHead,
-			CHTRoot:      chtRoot,
-			BloomRoot:    light.GetBloomTrieRoot(c.chainDb, sectionIndex, sectionHead),
-		}
+	if sections := c.localSections(); sections > 0 {
+		cht = *c.localCheckpoint(sections - 1)
 	}
 
 	chain := c.protocolManager.blockchain
@@ -118,3 +93,38 @@ func (c *lesCommons) nodeInfo() interface{} {
 		CHT:        cht,
 	}
 }
+
+// localSections returns the number of sections for which both the CHT and the
+// BloomTrie are available locally, in terms of the client section size.
+func (c *lesCommons) localSections() uint64 {
+	sections, _, _ := c.chtIndexer.Sections()
+	sections2, _, _ := c.bloomTrieIndexer.Sections()
+
+	if !c.protocolManager.lightSync {
+		// convert to client section size if running in server mode
+		sections /= c.iConfig.PairChtSize / c.iConfig.ChtSize
+	}
+	if sections2 < sections {
+		sections = sections2
+	}
+	return sections
+}
+
+// localCheckpoint returns the post-processed trie roots (CHT and BloomTrie) of
+// the given section, in terms of the client section size.
+func (c *lesCommons) localCheckpoint(sectionIndex uint64) *params.TrustedCheckpoint {
+	sectionHead := c.bloomTrieIndexer.SectionHead(sectionIndex)
+	var chtRoot common.Hash
+	if c.protocolManager.lightSync {
+		chtRoot = light.GetChtRoot(c.chainDb, sectionIndex, sectionHead)
+	} else {
+		idxV2 := (sectionIndex+1)*c.iConfig.PairChtSize/c.iConfig.ChtSize - 1
+		chtRoot = light.GetChtRoot(c.chainDb, idxV2, sectionHead)
+	}
+	return &params.TrustedCheckpoint{
+		SectionIndex: sectionIndex,
+		SectionHead:  sectionHead,
+		CHTRoot:      chtRoot,
+		BloomRoot:    light.GetBloomTrieRoot(c.chainDb, sectionIndex, sectionHead),
+	}
+}
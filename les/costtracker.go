@@ -0,0 +1,370 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/severeum/go-severeum/common/hexutil"
+	"github.com/severeum/go-severeum/ethdb"
+	"github.com/severeum/go-severeum/metrics"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+const (
+	costFactor     = 2    // Multiplier applied to the estimated serving time (in ns) to get the advertised cost
+	minCostSamples = 100  // Number of samples after which the measured costs replace the reference ones
+	minCostRatio   = 0.25 // Lowest ratio of the reference costs the measured ones can go down to
+)
+
+// reqAvgTimeCost is the reference table of the average serving time (in ns) of
+// each request type, used until enough requests have been measured locally.
+var reqAvgTimeCost = requestCostTable{
+	GetBlockHeadersMsg:     {150000, 30000},
+	GetBlockBodiesMsg:      {0, 700000},
+	GetReceiptsMsg:         {0, 1000000},
+	GetCodeMsg:             {0, 450000},
+	GetProofsV1Msg:         {0, 600000},
+	GetHeaderProofsMsg:     {0, 1000000},
+	SendTxMsg:              {0, 450000},
+	GetProofsV2Msg:         {0, 600000},
+	GetHelperTrieProofsMsg: {0, 1000000},
+	SendTxV2Msg:            {0, 450000},
+	GetTxStatusMsg:         {0, 250000},
+}
+
+// reqMaxAmount is the maximum number of items that can be asked for in a single
+// request of each type.
+var reqMaxAmount = map[uint64]uint64{
+	GetBlockHeadersMsg:     MaxHeaderFetch,
+	GetBlockBodiesMsg:      MaxBodyFetch,
+	GetReceiptsMsg:         MaxReceiptFetch,
+	GetCodeMsg:             MaxCodeFetch,
+	GetProofsV1Msg:         MaxProofsFetch,
+	GetHeaderProofsMsg:     MaxHelperTrieProofsFetch,
+	SendTxMsg:              MaxTxSend,
+	GetProofsV2Msg:         MaxProofsFetch,
+	GetHelperTrieProofsMsg: MaxHelperTrieProofsFetch,
+	SendTxV2Msg:            MaxTxSend,
+	GetTxStatusMsg:         MaxTxStatus,
+}
+
+// reqNames contains the names of the served request types, used by the metrics
+// and the RPC API.
+var reqNames = map[uint64]string{
+	GetBlockHeadersMsg:     "headers",
+	GetBlockBodiesMsg:      "bodies",
+	GetReceiptsMsg:         "receipts",
+	GetCodeMsg:             "code",
+	GetProofsV1Msg:         "proofsV1",
+	GetHeaderProofsMsg:     "headerProofs",
+	SendTxMsg:              "sendTx",
+	GetProofsV2Msg:         "proofsV2",
+	GetHelperTrieProofsMsg: "helperTrieProofs",
+	SendTxV2Msg:            "sendTxV2",
+	GetTxStatusMsg:         "txStatus",
+}
+
+type requestCosts struct {
+	baseCost, reqCost uint64
+}
+
+type requestCostTable map[uint64]*requestCosts
+
+type RequestCostList []struct {
+	MsgCode, BaseCost, ReqCost uint64
+}
+
+func (list RequestCostList) decode() requestCostTable {
+	table := make(requestCostTable)
+	for _, e := range list {
+		table[e.MsgCode] = &requestCosts{
+			baseCost: e.BaseCost,
+			reqCost:  e.ReqCost,
+		}
+	}
+	return table
+}
+
+type linReg struct {
+	sumX, sumY, sumXX, sumXY float64
+	cnt                      uint64
+}
+
+const linRegMaxCnt = 100000
+
+func (l *linReg) add(x, y float64) {
+	if l.cnt >= linRegMaxCnt {
+		sub := float64(l.cnt+1-linRegMaxCnt) / linRegMaxCnt
+		l.sumX -= l.sumX * sub
+		l.sumY -= l.sumY * sub
+		l.sumXX -= l.sumXX * sub
+		l.sumXY -= l.sumXY * sub
+		l.cnt = linRegMaxCnt - 1
+	}
+	l.cnt++
+	l.sumX += x
+	l.sumY += y
+	l.sumXX += x * x
+	l.sumXY += x * y
+}
+
+func (l *linReg) calc() (b, m float64) {
+	if l.cnt == 0 {
+		return 0, 0
+	}
+	cnt := float64(l.cnt)
+	d := cnt*l.sumXX - l.sumX*l.sumX
+	if d < 0.001 {
+		return l.sumY / cnt, 0
+	}
+	m = (cnt*l.sumXY - l.sumX*l.sumY) / d
+	b = (l.sumY / cnt) - (m * l.sumX / cnt)
+	return b, m
+}
+
+func (l *linReg) toBytes() []byte {
+	var arr [40]byte
+	binary.BigEndian.PutUint64(arr[0:8], math.Float64bits(l.sumX))
+	binary.BigEndian.PutUint64(arr[8:16], math.Float64bits(l.sumY))
+	binary.BigEndian.PutUint64(arr[16:24], math.Float64bits(l.sumXX))
+	binary.BigEndian.PutUint64(arr[24:32], math.Float64bits(l.sumXY))
+	binary.BigEndian.PutUint64(arr[32:40], l.cnt)
+	return arr[:]
+}
+
+func linRegFromBytes(data []byte) *linReg {
+	if len(data) != 40 {
+		return nil
+	}
+	l := &linReg{}
+	l.sumX = math.Float64frombits(binary.BigEndian.Uint64(data[0:8]))
+	l.sumY = math.Float64frombits(binary.BigEndian.Uint64(data[8:16]))
+	l.sumXX = math.Float64frombits(binary.BigEndian.Uint64(data[16:24]))
+	l.sumXY = math.Float64frombits(binary.BigEndian.Uint64(data[24:32]))
+	l.cnt = binary.BigEndian.Uint64(data[32:40])
+	return l
+}
+
+// costMetrics contains the metrics reported for a single request type.
+type costMetrics struct {
+	servingTimer                  metrics.Timer
+	measuredBase, measuredReq     metrics.Gauge
+	advertisedBase, advertisedReq metrics.Gauge
+}
+
+func newCostMetrics(name string) *costMetrics {
+	prefix := fmt.Sprintf("les/server/cost/%s/", name)
+	return &costMetrics{
+		servingTimer:   metrics.GetOrRegisterTimer(fmt.Sprintf("les/server/serve/%s", name), nil),
+		measuredBase:   metrics.GetOrRegisterGauge(prefix+"measured/base", nil),
+		measuredReq:    metrics.GetOrRegisterGauge(prefix+"measured/req", nil),
+		advertisedBase: metrics.GetOrRegisterGauge(prefix+"advertised/base", nil),
+		advertisedReq:  metrics.GetOrRegisterGauge(prefix+"advertised/req", nil),
+	}
+}
+
+// costTracker measures the real serving time of the requests served by the
+// light server and derives the cost table advertised to newly connected clients.
+// Until enough requests of a type have been measured, the reference serving
+// times are advertised, and the measured costs are never allowed to go below
+// a fraction of the reference ones, nor above what fits into the buffer of a
+// free client for the largest request.
+type costTracker struct {
+	db       ethdb.Database
+	bufLimit uint64 // Buffer limit of free clients, bounding the cost of any request
+	lock     sync.RWMutex
+	stats    map[uint64]*linReg
+	metrics  map[uint64]*costMetrics
+
+	testCostList RequestCostList // Cost list overriding the measured one, used in tests
+}
+
+type costTrackerRlp []struct {
+	MsgCode uint64
+	Data    []byte
+}
+
+var servingStatsKey = []byte("_requestServingStats")
+
+// newCostTracker creates a cost tracker and loads the serving time statistics
+// persisted by a previous run. The advertised costs are capped so that any request
+// fits into the given buffer limit.
+func newCostTracker(db ethdb.Database, bufLimit uint64) *costTracker {
+	ct := &costTracker{
+		db:       db,
+		bufLimit: bufLimit,
+		stats:    make(map[uint64]*linReg),
+		metrics:  make(map[uint64]*costMetrics),
+	}
+	for _, code := range reqList {
+		ct.stats[code] = &linReg{}
+		ct.metrics[code] = newCostMetrics(reqNames[code])
+	}
+	if db != nil {
+		data, err := db.Get(servingStatsKey)
+		var statsRlp costTrackerRlp
+		if err == nil {
+			err = rlp.DecodeBytes(data, &statsRlp)
+		}
+		if err == nil {
+			for _, r := range statsRlp {
+				if ct.stats[r.MsgCode] != nil {
+					if l := linRegFromBytes(r.Data); l != nil {
+						ct.stats[r.MsgCode] = l
+					}
+				}
+			}
+		}
+	}
+	ct.updateGauges()
+	return ct
+}
+
+// store persists the serving time statistics.
+func (ct *costTracker) store() {
+	ct.lock.RLock()
+	defer ct.lock.RUnlock()
+
+	if ct.db == nil {
+		return
+	}
+	statsRlp := make(costTrackerRlp, len(reqList))
+	for i, code := range reqList {
+		statsRlp[i].MsgCode = code
+		statsRlp[i].Data = ct.stats[code].toBytes()
+	}
+	if data, err := rlp.EncodeToBytes(statsRlp); err == nil {
+		ct.db.Put(servingStatsKey, data)
+	}
+}
+
+// update adds the measured serving time of a request of the given type, asking
+// for amount items, to the statistics.
+func (ct *costTracker) update(code, amount uint64, servingTime time.Duration) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	l, ok := ct.stats[code]
+	if !ok || amount == 0 {
+		return
+	}
+	l.add(float64(amount), float64(servingTime))
+	ct.metrics[code].servingTimer.Update(servingTime)
+}
+
+// measured returns the serving time (in ns) estimated from the local statistics
+// and the number of samples the estimate is based on. Callers must hold the lock.
+func (ct *costTracker) measured(code uint64) (base, req float64, samples uint64) {
+	l := ct.stats[code]
+	b, m := l.calc()
+	if m < 0 {
+		b += m
+		m = 0
+	}
+	if b < 0 {
+		b = 0
+	}
+	return b, m, l.cnt
+}
+
+// advertised returns the costs advertised for the given request type. Callers
+// must hold the lock.
+func (ct *costTracker) advertised(code uint64) (base, req uint64) {
+	ref := reqAvgTimeCost[code]
+	b, m, samples := ct.measured(code)
+	if samples < minCostSamples {
+		b, m = float64(ref.baseCost), float64(ref.reqCost)
+	} else {
+		if minBase := float64(ref.baseCost) * minCostRatio; b < minBase {
+			b = minBase
+		}
+		if minReq := float64(ref.reqCost) * minCostRatio; m < minReq {
+			m = minReq
+		}
+	}
+	// Scale the costs down if the largest request wouldn't fit into the buffer
+	if max := (b + m*float64(reqMaxAmount[code])) * costFactor; max > float64(ct.bufLimit) {
+		ratio := float64(ct.bufLimit) / max
+		b, m = b*ratio, m*ratio
+	}
+	return uint64(b * costFactor), uint64(m * costFactor)
+}
+
+// makeCostList returns the cost table advertised to a newly connected client.
+func (ct *costTracker) makeCostList() RequestCostList {
+	if ct.testCostList != nil {
+		return ct.testCostList
+	}
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	list := make(RequestCostList, len(reqList))
+	for i, code := range reqList {
+		list[i].MsgCode = code
+		list[i].BaseCost, list[i].ReqCost = ct.advertised(code)
+	}
+	ct.updateGaugesLocked()
+	return list
+}
+
+// updateGauges refreshes the cost metrics of all request types.
+func (ct *costTracker) updateGauges() {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	ct.updateGaugesLocked()
+}
+
+func (ct *costTracker) updateGaugesLocked() {
+	for _, code := range reqList {
+		b, m, _ := ct.measured(code)
+		base, req := ct.advertised(code)
+
+		cm := ct.metrics[code]
+		cm.measuredBase.Update(int64(b))
+		cm.measuredReq.Update(int64(m))
+		cm.advertisedBase.Update(int64(base))
+		cm.advertisedReq.Update(int64(req))
+	}
+}
+
+// costInfo returns the measured and advertised costs of all request types.
+func (ct *costTracker) costInfo() []RequestCostInfo {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	infos := make([]RequestCostInfo, len(reqList))
+	for i, code := range reqList {
+		b, m, samples := ct.measured(code)
+		base, req := ct.advertised(code)
+		infos[i] = RequestCostInfo{
+			Name:           reqNames[code],
+			Code:           hexutil.Uint64(code),
+			Samples:        hexutil.Uint64(samples),
+			MeasuredBase:   hexutil.Uint64(b),
+			MeasuredReq:    hexutil.Uint64(m),
+			AdvertisedBase: hexutil.Uint64(base),
+			AdvertisedReq:  hexutil.Uint64(req),
+		}
+	}
+	return infos
+}
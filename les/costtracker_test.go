@@ -0,0 +1,107 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package les
+
+import (
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/ethdb"
+)
+
+// testCostBufLimit is the buffer limit of free clients the tested costs are capped by.
+const testCostBufLimit = 300000000
+
+// costOf returns the advertised costs of a request type from a cost list.
+func costOf(list RequestCostList, code uint64) (base, req uint64) {
+	for _, e := range list {
+		if e.MsgCode == code {
+			return e.BaseCost, e.ReqCost
+		}
+	}
+	return 0, 0
+}
+
+// Tests that the cost tracker advertises the reference costs until enough
+// requests are measured, then switches to the measured ones, bounded from below
+// and from above.
+func TestCostTracker(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	ct := newCostTracker(db, testCostBufLimit)
+
+	ref := reqAvgTimeCost[GetBlockHeadersMsg]
+	base, req := costOf(ct.makeCostList(), GetBlockHeadersMsg)
+	if base != ref.baseCost*costFactor || req != ref.reqCost*costFactor {
+		t.Fatalf("initial cost mismatch: have %d/%d, want %d/%d", base, req, ref.baseCost*costFactor, ref.reqCost*costFactor)
+	}
+	// Measure expensive header requests: 1ms base and 100µs per header
+	for i := 0; i < minCostSamples; i++ {
+		amount := uint64(i%10 + 1)
+		ct.update(GetBlockHeadersMsg, amount, time.Millisecond+time.Duration(amount)*100*time.Microsecond)
+	}
+	base, req = costOf(ct.makeCostList(), GetBlockHeadersMsg)
+	if want := uint64(time.Millisecond) * costFactor; base < want*99/100 || base > want*101/100 {
+		t.Errorf("measured base cost mismatch: have %d, want %d", base, want)
+	}
+	if want := uint64(100*time.Microsecond) * costFactor; req < want*99/100 || req > want*101/100 {
+		t.Errorf("measured request cost mismatch: have %d, want %d", req, want)
+	}
+	// Measure very cheap body requests, the costs must not go below the limit
+	for i := 0; i < minCostSamples; i++ {
+		ct.update(GetBlockBodiesMsg, uint64(i%10+1), time.Microsecond)
+	}
+	ref = reqAvgTimeCost[GetBlockBodiesMsg]
+	if _, req := costOf(ct.makeCostList(), GetBlockBodiesMsg); req != uint64(float64(ref.reqCost)*minCostRatio)*costFactor {
+		t.Errorf("cheap request cost mismatch: have %d, want %d", req, uint64(float64(ref.reqCost)*minCostRatio)*costFactor)
+	}
+	// Measure very expensive receipt requests, the largest one must still fit into the buffer
+	for i := 0; i < minCostSamples; i++ {
+		amount := uint64(i%10 + 1)
+		ct.update(GetReceiptsMsg, amount, time.Second+time.Duration(amount)*time.Second)
+	}
+	base, req = costOf(ct.makeCostList(), GetReceiptsMsg)
+	if max := base + MaxReceiptFetch*req; max > testCostBufLimit || max < testCostBufLimit*99/100 {
+		t.Errorf("expensive request cost mismatch: have %d, want at most %d", max, testCostBufLimit)
+	}
+	// Requests without items are ignored, unknown requests too
+	ct.update(GetCodeMsg, 0, time.Second)
+	ct.update(BlockHeadersMsg, 1, time.Second)
+	for _, info := range ct.costInfo() {
+		switch uint64(info.Code) {
+		case GetBlockHeadersMsg, GetBlockBodiesMsg, GetReceiptsMsg:
+			if info.Samples != minCostSamples {
+				t.Errorf("%s sample count mismatch: have %d, want %d", info.Name, info.Samples, minCostSamples)
+			}
+		default:
+			if info.Samples != 0 {
+				t.Errorf("%s sample count mismatch: have %d, want 0", info.Name, info.Samples)
+			}
+		}
+	}
+	// The statistics must survive a restart
+	list := ct.makeCostList()
+	ct.store()
+	if have := newCostTracker(db, testCostBufLimit).makeCostList(); len(have) != len(list) {
+		t.Fatalf("cost list length mismatch after reload: have %d, want %d", len(have), len(list))
+	} else {
+		for i := range list {
+			if have[i] != list[i] {
+				t.Errorf("cost %d mismatch after reload: have %+v, want %+v", i, have[i], list[i])
+			}
+		}
+	}
+}
@@ -37,6 +37,8 @@ type ClientNode struct {
 	lock     sync.Mutex
 	cm       *ClientManager
 	cmNode   *cmNode
+
+	servingStarted mclock.AbsTime // Time the serving of the current request started at
 }
 
 func NewClientNode(cm *ClientManager, params *ServerParams) *ClientNode {
@@ -50,6 +52,27 @@ func NewClientNode(cm *ClientManager, params *ServerParams) *ClientNode {
 	return node
 }
 
+// Params returns the current flow control parameters of the client.
+func (peer *ClientNode) Params() *ServerParams {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+
+	return peer.params
+}
+
+// UpdateParams changes the flow control parameters of the client, e.g. after
+// its assigned capacity has been raised.
+func (peer *ClientNode) UpdateParams(params *ServerParams) {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+
+	peer.recalcBV(mclock.Now())
+	peer.params = params
+	if peer.bufValue > params.BufLimit {
+		peer.bufValue = params.BufLimit
+	}
+}
+
 func (peer *ClientNode) Remove(cm *ClientManager) {
 	cm.removeNode(peer.cmNode)
 }
@@ -72,24 +95,33 @@ func (peer *ClientNode) AcceptRequest() (uint64, bool) {
 
 	time := mclock.Now()
 	peer.recalcBV(time)
-	return peer.bufValue, peer.cm.accept(peer.cmNode, time)
+	accepted := peer.cm.accept(peer.cmNode, time)
+	peer.servingStarted = mclock.Now()
+	return peer.bufValue, accepted
 }
 
-func (peer *ClientNode) RequestProcessed(cost uint64) (bv, realCost uint64) {
+// RequestProcessed finalizes the accounting of a request accepted by AcceptRequest,
+// deducting its cost from the buffer. Besides the new buffer value and the cost
+// of the request in the client manager's recharge model, it returns the real
+// time spent serving the request, excluding any time spent queueing for it.
+func (peer *ClientNode) RequestProcessed(cost uint64) (bv, realCost uint64, servingTime time.Duration) {
 	peer.lock.Lock()
 	defer peer.lock.Unlock()
 
-	time := mclock.Now()
-	peer.recalcBV(time)
+	now := mclock.Now()
+	if now > peer.servingStarted {
+		servingTime = time.Duration(now - peer.servingStarted)
+	}
+	peer.recalcBV(now)
 	peer.bufValue -= cost
-	rcValue, rcost := peer.cm.processed(peer.cmNode, time)
+	rcValue, rcost := peer.cm.processed(peer.cmNode, now)
 	if rcValue < peer.params.BufLimit {
 		bv := peer.params.BufLimit - rcValue
 		if bv > peer.bufValue {
 			peer.bufValue = bv
 		}
 	}
-	return peer.bufValue, rcost
+	return peer.bufValue, rcost, servingTime
 }
 
 type ServerNode struct {
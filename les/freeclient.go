@@ -110,8 +110,12 @@ func (f *freeClientPool) connect(address string, disconnectFn func()) bool {
 		recentUsage = int64(math.Exp(float64(e.logUsage-f.logOffset(now)) / fixedPointMultiplier))
 	}
 	e.linUsage = recentUsage - int64(now)
+	if f.connectedLimit == 0 {
+		log.Debug("Client rejected", "address", address)
+		return false
+	}
 	// check whether (linUsage+connectedBias) is smaller than the highest entry in the connected pool
-	if f.connPool.Size() == f.connectedLimit {
+	if f.connPool.Size() >= f.connectedLimit {
 		i := f.connPool.PopItem().(*freeClientPoolEntry)
 		if e.linUsage+int64(connectedBias)-i.linUsage < 0 {
 			// kick it out and accept the new client
@@ -139,6 +143,33 @@ func (f *freeClientPool) connect(address string, disconnectFn func()) bool {
 	return true
 }
 
+// setLimits changes the maximum number of simultaneously connected free clients,
+// kicking out the ones with the highest recent usage if the new limit is lower
+// than the number of currently connected clients.
+//
+// Note: the disconnectFn callbacks should not block.
+func (f *freeClientPool) setLimits(connectedLimit int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if connectedLimit < 0 {
+		connectedLimit = 0
+	}
+	f.connectedLimit = connectedLimit
+	if f.closed {
+		return
+	}
+	now := f.clock.Now()
+	for f.connPool.Size() > f.connectedLimit {
+		i := f.connPool.PopItem().(*freeClientPoolEntry)
+		f.calcLogUsage(i, now)
+		i.connected = false
+		f.disconnPool.Push(i, -i.logUsage)
+		log.Debug("Client kicked out", "address", i.address)
+		i.disconnectFn()
+	}
+}
+
 // disconnect should be called when a connection is terminated. If the disconnection
 // was initiated by the pool itself using disconnectFn then calling disconnect is
 // not necessary but permitted.
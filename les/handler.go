@@ -100,7 +100,7 @@ type ProtocolManager struct {
 	odr         *LesOdr
 	server      *LesServer
 	serverPool  *serverPool
-	clientPool  *freeClientPool
+	clientPool  *clientPool
 	lesTopic    discv5.Topic
 	reqDist     *requestDistributor
 	retriever   *retrieveManager
@@ -163,6 +163,18 @@ func NewProtocolManager(chainConfig *params.ChainConfig, indexerConfig *light.In
 	return manager, nil
 }
 
+// requestProcessed finalizes the flow control accounting of a request served to
+// a client, charging its cost to the client's token balance and feeding its
+// measured serving time into the server's cost statistics.
+func (pm *ProtocolManager) requestProcessed(p *peer, msgCode, reqCnt, cost uint64) (bv uint64) {
+	bv, _, servingTime := p.fcClient.RequestProcessed(cost)
+	if pm.clientPool != nil {
+		pm.clientPool.charge(p, cost)
+	}
+	pm.server.costTracker.update(msgCode, reqCnt, servingTime)
+	return bv
+}
+
 // removePeer initiates disconnection from a peer by removing it from the peer set
 func (pm *ProtocolManager) removePeer(id string) {
 	pm.peers.Unregister(id)
@@ -174,7 +186,7 @@ func (pm *ProtocolManager) Start(maxPeers int) {
 	if pm.lightSync {
 		go pm.syncer()
 	} else {
-		pm.clientPool = newFreeClientPool(pm.chainDb, maxPeers, 10000, mclock.System{})
+		pm.clientPool = newClientPool(pm.chainDb, *pm.server.defParams, maxPeers, mclock.System{})
 		go func() {
 			for range pm.newPeerCh {
 			}
@@ -248,6 +260,11 @@ func (pm *ProtocolManager) handle(p *peer) error {
 
 	p.Log().Debug("Light Severeum peer connected", "name", p.Name())
 
+	// Assign the flow control parameters of the client's tier in server mode
+	trusted := p.Peer.Info().Network.Trusted
+	if !pm.lightSync {
+		p.fcParams = pm.clientPool.clientParams(p.ID(), trusted)
+	}
 	// Execute the LES handshake
 	var (
 		genesis = pm.blockchain.Genesis()
@@ -261,16 +278,16 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		return err
 	}
 
-	if !pm.lightSync && !p.Peer.Info().Network.Trusted {
-		addr, ok := p.RemoteAddr().(*net.TCPAddr)
-		// test peer address is not a tcp address, don't use client pool if can not typecast
-		if ok {
-			id := addr.IP.String()
-			if !pm.clientPool.connect(id, func() { go pm.removePeer(p.id) }) {
-				return p2p.DiscTooManyPeers
-			}
-			defer pm.clientPool.disconnect(id)
+	if !pm.lightSync {
+		// test peer address is not a tcp address, don't limit free clients if can not typecast
+		var address string
+		if addr, ok := p.RemoteAddr().(*net.TCPAddr); ok {
+			address = addr.IP.String()
+		}
+		if !pm.clientPool.connect(p, address, trusted, func() { go pm.removePeer(p.id) }) {
+			return p2p.DiscTooManyPeers
 		}
+		defer pm.clientPool.disconnect(p, address)
 	}
 
 	if rw, ok := p.rw.(*meteredMsgReadWriter); ok {
@@ -342,12 +359,13 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			return true
 		}
 		bufValue, _ := p.fcClient.AcceptRequest()
+		params := p.fcClient.Params()
 		cost := costs.baseCost + reqCnt*costs.reqCost
-		if cost > pm.server.defParams.BufLimit {
-			cost = pm.server.defParams.BufLimit
+		if cost > params.BufLimit {
+			cost = params.BufLimit
 		}
 		if cost > bufValue {
-			recharge := time.Duration((cost - bufValue) * 1000000 / pm.server.defParams.MinRecharge)
+			recharge := time.Duration((cost - bufValue) * 1000000 / params.MinRecharge)
 			p.Log().Error("Request came too early", "recharge", common.PrettyDuration(recharge))
 			return true
 		}
@@ -489,8 +507,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 		}
 
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + query.Amount*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, query.Amount, rcost)
+		bv := pm.requestProcessed(p, msg.Code, query.Amount, costs.baseCost+query.Amount*costs.reqCost)
 		return p.SendBlockHeaders(req.ReqID, bv, headers)
 
 	case BlockHeadersMsg:
@@ -548,8 +565,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				}
 			}
 		}
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		bv := pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 		return p.SendBlockBodiesRLP(req.ReqID, bv, bodies)
 
 	case BlockBodiesMsg:
@@ -613,8 +629,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				}
 			}
 		}
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		bv := pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 		return p.SendCode(req.ReqID, bv, data)
 
 	case CodeMsg:
@@ -679,8 +694,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		bv := pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 		return p.SendReceiptsRLP(req.ReqID, bv, receipts)
 
 	case ReceiptsMsg:
@@ -753,8 +767,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				}
 			}
 		}
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		bv := pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 		return p.SendProofs(req.ReqID, bv, proofs)
 
 	case GetProofsV2Msg:
@@ -815,8 +828,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				break
 			}
 		}
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		bv := pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 		return p.SendProofsV2(req.ReqID, bv, nodes.NodeList())
 
 	case ProofsV1Msg:
@@ -902,8 +914,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				}
 			}
 		}
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		bv := pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 		return p.SendHeaderProofs(req.ReqID, bv, proofs)
 
 	case GetHelperTrieProofsMsg:
@@ -963,8 +974,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				break
 			}
 		}
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		bv := pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 		return p.SendHelperTrieProofs(req.ReqID, bv, HelperTrieResps{Proofs: nodes.NodeList(), AuxData: auxData})
 
 	case HeaderProofsMsg:
@@ -1023,8 +1033,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		}
 		pm.txpool.AddRemotes(txs)
 
-		_, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 
 	case SendTxV2Msg:
 		if pm.txpool == nil {
@@ -1058,8 +1067,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 		}
 
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		bv := pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 
 		return p.SendTxStatus(req.ReqID, bv, stats)
 
@@ -1079,8 +1087,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if reject(uint64(reqCnt), MaxTxStatus) {
 			return errResp(ErrRequestRejected, "")
 		}
-		bv, rcost := p.fcClient.RequestProcessed(costs.baseCost + uint64(reqCnt)*costs.reqCost)
-		pm.server.fcCostStats.update(msg.Code, uint64(reqCnt), rcost)
+		bv := pm.requestProcessed(p, msg.Code, uint64(reqCnt), costs.baseCost+uint64(reqCnt)*costs.reqCost)
 
 		return p.SendTxStatus(req.ReqID, bv, pm.txStatus(req.Hashes))
 
@@ -190,7 +190,8 @@ func newTestProtocolManager(lightSync bool, blocks int, generator func(int, *cor
 		}
 
 		srv.fcManager = flowcontrol.NewClientManager(50, 10, 1000000000)
-		srv.fcCostStats = newCostStats(nil)
+		srv.costTracker = newCostTracker(nil, testBufLimit)
+		srv.costTracker.testCostList = testRCL()
 	}
 	pm.Start(1000)
 	return pm, nil
@@ -69,7 +69,8 @@ type LightChain struct {
 	procInterrupt int32 // interrupt signaler for block processing
 	wg            sync.WaitGroup
 
-	engine consensus.Engine
+	engine     consensus.Engine
+	checkpoint *params.TrustedCheckpoint // Latest trusted checkpoint added to the chain
 }
 
 // NewLightChain returns a fully initialised light chain using information
@@ -99,7 +100,13 @@ func NewLightChain(odr OdrBackend, config *params.ChainConfig, engine consensus.
 	if bc.genesisBlock == nil {
 		return nil, core.ErrNoGenesis
 	}
-	if cp, ok := trustedCheckpoints[bc.genesisBlock.Hash()]; ok {
+	// Use the newer of the hardcoded checkpoint and the last one obtained from
+	// the checkpoint oracle
+	cp := trustedCheckpoints[bc.genesisBlock.Hash()]
+	if stored := ReadTrustedCheckpoint(bc.chainDb); stored != nil && (cp == nil || stored.SectionIndex > cp.SectionIndex) {
+		cp = stored
+	}
+	if cp != nil {
 		bc.addTrustedCheckpoint(cp)
 	}
 	if err := bc.loadLastState(); err != nil {
@@ -129,9 +136,34 @@ func (self *LightChain) addTrustedCheckpoint(cp *params.TrustedCheckpoint) {
 	if self.odr.BloomIndexer() != nil {
 		self.odr.BloomIndexer().AddCheckpoint(cp.SectionIndex, cp.SectionHead)
 	}
+	self.checkpoint = cp
 	log.Info("Added trusted checkpoint", "chain", cp.Name, "block", (cp.SectionIndex+1)*self.indexerConfig.ChtSize-1, "hash", cp.SectionHead)
 }
 
+// AddTrustedCheckpoint adds a checkpoint obtained from the checkpoint oracle to
+// the blockchain if it's newer than the current one, persisting it to be used
+// after a restart too. It returns whether the checkpoint was added.
+func (self *LightChain) AddTrustedCheckpoint(cp *params.TrustedCheckpoint) bool {
+	self.chainmu.Lock()
+	defer self.chainmu.Unlock()
+
+	if self.checkpoint != nil && cp.SectionIndex <= self.checkpoint.SectionIndex {
+		return false
+	}
+	WriteTrustedCheckpoint(self.chainDb, cp)
+	self.addTrustedCheckpoint(cp)
+	return true
+}
+
+// TrustedCheckpoint returns the latest trusted checkpoint added to the chain,
+// or nil if there is none.
+func (self *LightChain) TrustedCheckpoint() *params.TrustedCheckpoint {
+	self.chainmu.RLock()
+	defer self.chainmu.RUnlock()
+
+	return self.checkpoint
+}
+
 func (self *LightChain) getProcInterrupt() bool {
 	return atomic.LoadInt32(&self.procInterrupt) == 1
 }
@@ -117,8 +117,37 @@ var (
 	ErrNoHeader           = errors.New("header not found")
 	chtPrefix             = []byte("chtRoot-") // chtPrefix + chtNum (uint64 big endian) -> trie root hash
 	ChtTablePrefix        = "cht-"
+
+	trustedCheckpointKey = []byte("trustedCheckpoint") // trustedCheckpointKey -> RLP encoded checkpoint retrieved from the checkpoint oracle
 )
 
+// ReadTrustedCheckpoint retrieves the last checkpoint obtained from the checkpoint
+// oracle, or nil if there is none.
+func ReadTrustedCheckpoint(db ethdb.Database) *params.TrustedCheckpoint {
+	data, _ := db.Get(trustedCheckpointKey)
+	if len(data) == 0 {
+		return nil
+	}
+	cp := new(params.TrustedCheckpoint)
+	if err := rlp.DecodeBytes(data, cp); err != nil {
+		log.Error("Invalid trusted checkpoint RLP", "err", err)
+		return nil
+	}
+	return cp
+}
+
+// WriteTrustedCheckpoint stores a checkpoint obtained from the checkpoint oracle
+// into the database.
+func WriteTrustedCheckpoint(db ethdb.Database, cp *params.TrustedCheckpoint) {
+	data, err := rlp.EncodeToBytes(cp)
+	if err != nil {
+		log.Crit("Failed to RLP encode trusted checkpoint", "err", err)
+	}
+	if err := db.Put(trustedCheckpointKey, data); err != nil {
+		log.Crit("Failed to store trusted checkpoint", "err", err)
+	}
+}
+
 // ChtNode structures are stored in the Canonical Hash Trie in an RLP encoded format
 type ChtNode struct {
 	Hash common.Hash
@@ -150,6 +150,12 @@ func (self *Miner) SetRecommitInterval(interval time.Duration) {
 	self.worker.setRecommitInterval(interval)
 }
 
+// SetTimeOffset shifts the clock used to timestamp new blocks by the given offset,
+// recommitting the pending work if mining. It is meant for developer chains.
+func (self *Miner) SetTimeOffset(offset time.Duration) {
+	self.worker.setTimeOffset(offset)
+}
+
 // Pending returns the currently pending block and associated state.
 func (self *Miner) Pending() (*types.Block, *state.StateDB) {
 	return self.worker.pending()
@@ -167,6 +167,7 @@ type worker struct {
 	// atomic status counters
 	running int32 // The indicator whether the consensus engine is running or not.
 	newTxs  int32 // New arrival transaction count since last sealing work submitting.
+	offset  int64 // Clock offset in nanoseconds to timestamp new blocks with (developer chains).
 
 	// External functions
 	isLocalBlock func(block *types.Block) bool // Function used to determine whether the specified block is mined by local miner.
@@ -245,6 +246,24 @@ func (w *worker) setRecommitInterval(interval time.Duration) {
 	w.resubmitIntervalCh <- interval
 }
 
+// setTimeOffset shifts the clock used to timestamp new blocks by the given
+// offset and, if mining, recommits the pending work with the new time.
+func (w *worker) setTimeOffset(offset time.Duration) {
+	atomic.StoreInt64(&w.offset, int64(offset))
+
+	if w.isRunning() {
+		select {
+		case w.startCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// now returns the current time, shifted by any configured clock offset.
+func (w *worker) now() time.Time {
+	return time.Now().Add(time.Duration(atomic.LoadInt64(&w.offset)))
+}
+
 // pending returns the pending state and corresponding block.
 func (w *worker) pending() (*types.Block, *state.StateDB) {
 	// return a snapshot to avoid contention on currentMu mutex
@@ -280,6 +299,27 @@ func (w *worker) isRunning() bool {
 	return atomic.LoadInt32(&w.running) == 1
 }
 
+// instantSealer is implemented by consensus engines that can be configured to
+// seal blocks on demand instead of continuously.
+type instantSealer interface {
+	InstantSeal() bool
+}
+
+// instantSeal returns whether the consensus engine only seals blocks on demand,
+// in which case new work needs to be committed as soon as transactions arrive.
+func (w *worker) instantSeal() bool {
+	if w.config.Clique != nil && w.config.Clique.Period == 0 {
+		return true
+	}
+	if w.config.IBFT != nil && w.config.IBFT.Period == 0 {
+		return true
+	}
+	if engine, ok := w.engine.(instantSealer); ok {
+		return engine.InstantSeal()
+	}
+	return false
+}
+
 // close terminates all background threads maintained by the worker.
 // Note the worker does not support being closed multiple times.
 func (w *worker) close() {
@@ -343,18 +383,18 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 		select {
 		case <-w.startCh:
 			clearPending(w.chain.CurrentBlock().NumberU64())
-			timestamp = time.Now().Unix()
+			timestamp = w.now().Unix()
 			commit(false, commitInterruptNewHead)
 
 		case head := <-w.chainHeadCh:
 			clearPending(head.Block.NumberU64())
-			timestamp = time.Now().Unix()
+			timestamp = w.now().Unix()
 			commit(false, commitInterruptNewHead)
 
 		case <-timer.C:
 			// If mining is running resubmit a new work cycle periodically to pull in
 			// higher priced transactions. Disable this overhead for pending blocks.
-			if w.isRunning() && (w.config.Clique == nil || w.config.Clique.Period > 0) {
+			if w.isRunning() && !w.instantSeal() {
 				// Short circuit if no new transaction arrives.
 				if atomic.LoadInt32(&w.newTxs) == 0 {
 					timer.Reset(recommit)
@@ -469,8 +509,8 @@ func (w *worker) mainLoop() {
 				w.updateSnapshot()
 			} else {
 				// If we're mining, but nothing is being processed, wake on new transactions
-				if w.config.Clique != nil && w.config.Clique.Period == 0 {
-					w.commitNewWork(nil, false, time.Now().Unix())
+				if w.instantSeal() {
+					w.commitNewWork(nil, false, w.now().Unix())
 				}
 			}
 			atomic.AddInt32(&w.newTxs, int32(len(ev.Txs)))
@@ -827,7 +867,7 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 		timestamp = parent.Time().Int64() + 1
 	}
 	// this will ensure we're not going off too far in the future
-	if now := time.Now().Unix(); timestamp > now+1 {
+	if now := w.now().Unix(); timestamp > now+1 {
 		wait := time.Duration(timestamp-now) * time.Second
 		log.Info("Mining too far in the future", "wait", common.PrettyDuration(wait))
 		time.Sleep(wait)
@@ -18,12 +18,15 @@ package p2p
 
 import (
 	"container/heap"
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"time"
 
 	"github.com/severeum/go-severeum/log"
+	"github.com/severeum/go-severeum/p2p/dnsdisc"
 	"github.com/severeum/go-severeum/p2p/enode"
 	"github.com/severeum/go-severeum/p2p/netutil"
 )
@@ -44,6 +47,11 @@ const (
 	// Endpoint resolution is throttled with bounded backoff.
 	initialResolveDelay = 60 * time.Second
 	maxResolveDelay     = time.Hour
+
+	// Each discovery lookup retrieves a batch of random nodes from the DNS
+	// node lists, if configured.
+	dnsLookupBatch   = 16
+	dnsLookupTimeout = 10 * time.Second
 )
 
 // NodeDialer is used to connect to nodes in the network, typically by using
@@ -82,6 +90,9 @@ type dialstate struct {
 
 	start     time.Time     // time when the dialer was first used
 	bootnodes []*enode.Node // default dials when there are no peers
+
+	scores func(enode.ID) int64   // reputation of remote nodes, nil if not tracked
+	filter func(*enode.Node) bool // dial candidate filter, nil if all are accepted
 }
 
 type discoverTable interface {
@@ -166,7 +177,14 @@ func (s *dialstate) newTasks(nRunning int, peers map[enode.ID]*Peer, now time.Ti
 
 	var newtasks []task
 	addDial := func(flag connFlag, n *enode.Node) bool {
-		if err := s.checkDial(n, peers); err != nil {
+		err := s.checkDial(n, peers)
+		if err == nil && s.score(n.ID()) < ScoreBanThreshold {
+			err = errBadReputation
+		}
+		if err == nil && s.filter != nil && !s.filter(n) {
+			err = errFiltered
+		}
+		if err != nil {
 			log.Trace("Skipping dial candidate", "id", n.ID(), "addr", &net.TCPAddr{IP: n.IP(), Port: n.TCP()}, "err", err)
 			return false
 		}
@@ -218,7 +236,7 @@ func (s *dialstate) newTasks(nRunning int, peers map[enode.ID]*Peer, now time.Ti
 	// Use random nodes from the table for half of the necessary
 	// dynamic dials.
 	randomCandidates := needDynDials / 2
-	if randomCandidates > 0 {
+	if randomCandidates > 0 && s.ntab != nil {
 		n := s.ntab.ReadRandomNodes(s.randomNodes)
 		for i := 0; i < randomCandidates && i < n; i++ {
 			if addDial(dynDialedConn, s.randomNodes[i]) {
@@ -258,6 +276,8 @@ var (
 	errAlreadyConnected = errors.New("already connected")
 	errRecentlyDialed   = errors.New("recently dialed")
 	errNotWhitelisted   = errors.New("not contained in netrestrict whitelist")
+	errBadReputation    = errors.New("bad reputation")
+	errFiltered         = errors.New("rejected by protocol filter")
 )
 
 func (s *dialstate) checkDial(n *enode.Node, peers map[enode.ID]*Peer) error {
@@ -285,7 +305,26 @@ func (s *dialstate) taskDone(t task, now time.Time) {
 	case *discoverTask:
 		s.lookupRunning = false
 		s.lookupBuf = append(s.lookupBuf, t.results...)
+
+		// Prefer dialing nodes that served us well in the past
+		if s.scores != nil {
+			scores := make(map[enode.ID]int64, len(s.lookupBuf))
+			for _, n := range s.lookupBuf {
+				scores[n.ID()] = s.scores(n.ID())
+			}
+			sort.SliceStable(s.lookupBuf, func(i, j int) bool {
+				return scores[s.lookupBuf[i].ID()] > scores[s.lookupBuf[j].ID()]
+			})
+		}
+	}
+}
+
+// score returns the reputation of a remote node, or zero if not tracked.
+func (s *dialstate) score(id enode.ID) int64 {
+	if s.scores == nil {
+		return 0
 	}
+	return s.scores(id)
 }
 
 func (t *dialTask) Do(srv *Server) {
@@ -368,7 +407,30 @@ func (t *discoverTask) Do(srv *Server) {
 		time.Sleep(next.Sub(now))
 	}
 	srv.lastLookup = time.Now()
-	t.results = srv.ntab.LookupRandom()
+	if srv.ntab != nil {
+		t.results = srv.ntab.LookupRandom()
+	}
+	if srv.dnsdisc != nil {
+		t.results = append(t.results, lookupDNS(srv.dnsdisc)...)
+	}
+}
+
+// lookupDNS retrieves a batch of random dialable nodes from the DNS node lists.
+func lookupDNS(client *dnsdisc.Client) []*enode.Node {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	var nodes []*enode.Node
+	for i := 0; i < dnsLookupBatch; i++ {
+		n := client.RandomNode(ctx)
+		if n == nil {
+			break
+		}
+		if !n.Incomplete() && n.TCP() != 0 {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
 }
 
 func (t *discoverTask) String() string {
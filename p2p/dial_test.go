@@ -221,6 +221,34 @@ func TestDialStateDynDial(t *testing.T) {
 	})
 }
 
+// This test checks that dynamic dials work without a discovery table, i.e. when
+// the candidates only come from DNS node lists.
+func TestDialStateNoTable(t *testing.T) {
+	runDialTest(t, dialtest{
+		init: newDialState(enode.ID{}, nil, nil, nil, 2, nil),
+		rounds: []round{
+			// A lookup is launched, the table isn't read.
+			{
+				new: []task{&discoverTask{}},
+			},
+			// Dynamic dials are launched from the lookup results.
+			{
+				done: []task{
+					&discoverTask{results: []*enode.Node{
+						newNode(uintID(1), nil),
+						newNode(uintID(2), nil),
+						newNode(uintID(3), nil),
+					}},
+				},
+				new: []task{
+					&dialTask{flags: dynDialedConn, dest: newNode(uintID(1), nil)},
+					&dialTask{flags: dynDialedConn, dest: newNode(uintID(2), nil)},
+				},
+			},
+		},
+	})
+}
+
 // Tests that bootnodes are dialed if no peers are connectd, but not otherwise.
 func TestDialStateDynDialBootnode(t *testing.T) {
 	bootnodes := []*enode.Node{
@@ -442,6 +470,72 @@ func TestDialStateNetRestrict(t *testing.T) {
 	})
 }
 
+// This test checks that candidates rejected by the dial filter are not dialed.
+func TestDialStateFilter(t *testing.T) {
+	table := fakeTable{
+		newNode(uintID(1), net.ParseIP("127.0.0.1")),
+		newNode(uintID(2), net.ParseIP("127.0.0.2")),
+		newNode(uintID(3), net.ParseIP("127.0.0.3")),
+		newNode(uintID(4), net.ParseIP("127.0.0.4")),
+		newNode(uintID(5), net.ParseIP("127.0.0.5")),
+		newNode(uintID(6), net.ParseIP("127.0.0.6")),
+	}
+	dialer := newDialState(enode.ID{}, nil, nil, table, 10, nil)
+	dialer.filter = func(n *enode.Node) bool {
+		return n.IP()[3]%2 == 0
+	}
+
+	runDialTest(t, dialtest{
+		init: dialer,
+		rounds: []round{
+			{
+				new: []task{
+					&dialTask{flags: dynDialedConn, dest: table[1]},
+					&dialTask{flags: dynDialedConn, dest: table[3]},
+					&discoverTask{},
+				},
+			},
+		},
+	})
+}
+
+// This test checks that discovery results are dialed in order of reputation and
+// that nodes with a bad reputation are not dialed at all.
+func TestDialStateReputation(t *testing.T) {
+	scores := map[enode.ID]int64{
+		uintID(3): ScoreBanThreshold - 1,
+		uintID(5): 50,
+		uintID(6): 10,
+	}
+	dialer := newDialState(enode.ID{}, nil, nil, fakeTable{}, 4, nil)
+	dialer.scores = func(id enode.ID) int64 { return scores[id] }
+
+	runDialTest(t, dialtest{
+		init: dialer,
+		rounds: []round{
+			{
+				new: []task{&discoverTask{}},
+			},
+			{
+				done: []task{
+					&discoverTask{results: []*enode.Node{
+						newNode(uintID(3), nil),
+						newNode(uintID(4), nil),
+						newNode(uintID(5), nil),
+						newNode(uintID(6), nil),
+					}},
+				},
+				new: []task{
+					&dialTask{flags: dynDialedConn, dest: newNode(uintID(5), nil)},
+					&dialTask{flags: dynDialedConn, dest: newNode(uintID(6), nil)},
+					&dialTask{flags: dynDialedConn, dest: newNode(uintID(4), nil)},
+					&discoverTask{},
+				},
+			},
+		},
+	})
+}
+
 // This test checks that static dials are launched.
 func TestDialStateStaticDial(t *testing.T) {
 	wantStatic := []*enode.Node{
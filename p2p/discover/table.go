@@ -86,7 +86,8 @@ type Table struct {
 // sockets and without generating a private key.
 type transport interface {
 	self() *enode.Node
-	ping(enode.ID, *net.UDPAddr) error
+	ping(enode.ID, *net.UDPAddr) (seq uint64, err error)
+	requestENR(*enode.Node) (*enode.Node, error)
 	findnode(toid enode.ID, addr *net.UDPAddr, target encPubkey) ([]*node, error)
 	close()
 }
@@ -237,6 +238,18 @@ func (tab *Table) Resolve(n *enode.Node) *enode.Node {
 	return nil
 }
 
+// Ping checks whether n is reachable by sending a ping and waiting for the
+// reply. It returns the sequence number of the node record announced by n.
+func (tab *Table) Ping(n *enode.Node) (seq uint64, err error) {
+	return tab.net.ping(n.ID(), &net.UDPAddr{IP: n.IP(), Port: n.UDP()})
+}
+
+// RequestENR fetches the latest node record of n from the node itself. The
+// returned node is n if the remote record is not newer.
+func (tab *Table) RequestENR(n *enode.Node) (*enode.Node, error) {
+	return tab.net.requestENR(n)
+}
+
 // LookupRandom finds random nodes in the network.
 func (tab *Table) LookupRandom() []*enode.Node {
 	var target encPubkey
@@ -451,7 +464,17 @@ func (tab *Table) doRevalidate(done chan<- struct{}) {
 	}
 
 	// Ping the selected node and wait for a pong.
-	err := tab.net.ping(last.ID(), last.addr())
+	remoteSeq, err := tab.net.ping(last.ID(), last.addr())
+
+	// Also fetch the record if the node announced a newer one.
+	if err == nil && last.Seq() < remoteSeq {
+		n, rerr := tab.net.requestENR(unwrapNode(last))
+		if rerr != nil {
+			log.Debug("ENR request failed", "id", last.ID(), "addr", last.addr(), "err", rerr)
+		} else {
+			last = &node{Node: *n, addedAt: last.addedAt}
+		}
+	}
 
 	tab.mutex.Lock()
 	defer tab.mutex.Unlock()
@@ -133,6 +133,40 @@ func TestBucket_bumpNoDuplicates(t *testing.T) {
 	}
 }
 
+// This checks that revalidation fetches the node record when the remote node
+// announces a newer sequence number in its pong.
+func TestTable_revalidateSyncRecord(t *testing.T) {
+	transport := newPingRecorder()
+	tab, db := newTestTable(transport)
+	defer tab.Close()
+	defer db.Close()
+
+	<-tab.initDone
+
+	// Insert a node.
+	var r enr.Record
+	r.Set(enr.IP(net.IP{127, 0, 0, 1}))
+	id := enode.ID{1}
+	n1 := wrapNode(enode.SignNull(&r, id))
+	tab.add(n1)
+
+	// Update the node record.
+	r.Set(enr.WithEntry("foo", "bar"))
+	r.SetSeq(5)
+	n2 := enode.SignNull(&r, id)
+	transport.mu.Lock()
+	transport.records[id] = n2
+	transport.mu.Unlock()
+
+	tab.doRevalidate(make(chan struct{}, 1))
+	tab.mutex.Lock()
+	defer tab.mutex.Unlock()
+	b := tab.bucket(id)
+	if len(b.entries) != 1 || b.entries[0].Seq() != n2.Seq() {
+		t.Fatalf("table contains old record, want seq %d", n2.Seq())
+	}
+}
+
 // This checks that the table-wide IP limit is applied correctly.
 func TestTable_IPLimit(t *testing.T) {
 	transport := newPingRecorder()
@@ -530,9 +564,10 @@ func (tn *preminedTestnet) findnode(toid enode.ID, toaddr *net.UDPAddr, target e
 	return result, nil
 }
 
-func (*preminedTestnet) close()                                        {}
-func (*preminedTestnet) waitping(from enode.ID) error                  { return nil }
-func (*preminedTestnet) ping(toid enode.ID, toaddr *net.UDPAddr) error { return nil }
+func (*preminedTestnet) close()                                                  {}
+func (*preminedTestnet) waitping(from enode.ID) error                            { return nil }
+func (*preminedTestnet) ping(toid enode.ID, toaddr *net.UDPAddr) (uint64, error) { return 0, nil }
+func (*preminedTestnet) requestENR(n *enode.Node) (*enode.Node, error)           { return n, nil }
 
 // mine generates a testnet struct literal with nodes at
 // various distances to the given target.
@@ -29,6 +29,7 @@ import (
 	"github.com/severeum/go-severeum/crypto"
 	"github.com/severeum/go-severeum/log"
 	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/p2p/enr"
 	"github.com/severeum/go-severeum/p2p/netutil"
 	"github.com/severeum/go-severeum/rlp"
 )
@@ -62,6 +63,8 @@ const (
 	pongPacket
 	findnodePacket
 	neighborsPacket
+	enrRequestPacket
+	enrResponsePacket
 )
 
 // RPC request structures
@@ -103,6 +106,21 @@ type (
 		Rest []rlp.RawValue `rlp:"tail"`
 	}
 
+	// enrRequest queries for the remote node's record.
+	enrRequest struct {
+		Expiration uint64
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
+	// enrResponse is the reply to enrRequest.
+	enrResponse struct {
+		ReplyTok []byte // Hash of the enrRequest packet.
+		Record   enr.Record
+		// Ignore additional fields (for forward compatibility).
+		Rest []rlp.RawValue `rlp:"tail"`
+	}
+
 	rpcNode struct {
 		IP  net.IP // len 4 for IPv4 or 16 for IPv6
 		UDP uint16 // for discovery protocol
@@ -127,6 +145,23 @@ func makeEndpoint(addr *net.UDPAddr, tcpPort uint16) rpcEndpoint {
 	return rpcEndpoint{IP: ip, UDP: uint16(addr.Port), TCP: tcpPort}
 }
 
+// enrSeqTail encodes the local record sequence number as the first tail element
+// of ping and pong packets (EIP-868). Implementations unaware of it ignore it.
+func enrSeqTail(seq uint64) []rlp.RawValue {
+	enc, _ := rlp.EncodeToBytes(seq)
+	return []rlp.RawValue{enc}
+}
+
+// enrSeqFromTail returns the record sequence number contained in the tail of a
+// ping or pong packet, or zero if the sender didn't include it.
+func enrSeqFromTail(rest []rlp.RawValue) uint64 {
+	var seq uint64
+	if len(rest) == 0 || rlp.DecodeBytes(rest[0], &seq) != nil {
+		return 0
+	}
+	return seq
+}
+
 func (t *udp) nodeFromRPC(sender *net.UDPAddr, rn rpcNode) (*node, error) {
 	if rn.UDP <= 1024 {
 		return nil, errors.New("low port")
@@ -284,19 +319,22 @@ func (t *udp) ourEndpoint() rpcEndpoint {
 	return makeEndpoint(a, uint16(n.TCP()))
 }
 
-// ping sends a ping message to the given node and waits for a reply.
-func (t *udp) ping(toid enode.ID, toaddr *net.UDPAddr) error {
-	return <-t.sendPing(toid, toaddr, nil)
+// ping sends a ping message to the given node and waits for a reply. It returns
+// the record sequence number announced by the remote node.
+func (t *udp) ping(toid enode.ID, toaddr *net.UDPAddr) (seq uint64, err error) {
+	err = <-t.sendPing(toid, toaddr, func(p *pong) { seq = enrSeqFromTail(p.Rest) })
+	return seq, err
 }
 
 // sendPing sends a ping message to the given node and invokes the callback
 // when the reply arrives.
-func (t *udp) sendPing(toid enode.ID, toaddr *net.UDPAddr, callback func()) <-chan error {
+func (t *udp) sendPing(toid enode.ID, toaddr *net.UDPAddr, callback func(*pong)) <-chan error {
 	req := &ping{
 		Version:    4,
 		From:       t.ourEndpoint(),
 		To:         makeEndpoint(toaddr, 0), // TODO: maybe use known TCP port from DB
 		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Rest:       enrSeqTail(t.localNode.Node().Seq()),
 	}
 	packet, hash, err := encodePacket(t.priv, pingPacket, req)
 	if err != nil {
@@ -307,7 +345,7 @@ func (t *udp) sendPing(toid enode.ID, toaddr *net.UDPAddr, callback func()) <-ch
 	errc := t.pending(toid, pongPacket, func(p interface{}) bool {
 		ok := bytes.Equal(p.(*pong).ReplyTok, hash)
 		if ok && callback != nil {
-			callback()
+			callback(p.(*pong))
 		}
 		return ok
 	})
@@ -316,6 +354,53 @@ func (t *udp) sendPing(toid enode.ID, toaddr *net.UDPAddr, callback func()) <-ch
 	return errc
 }
 
+// requestENR fetches the node record of n. The returned node is n itself if the
+// remote record is not newer than the one already known.
+func (t *udp) requestENR(n *enode.Node) (*enode.Node, error) {
+	addr := &net.UDPAddr{IP: n.IP(), Port: n.UDP()}
+	// The remote node only answers if it has a recent endpoint proof from us.
+	if time.Since(t.db.LastPongReceived(n.ID())) > bondExpiration {
+		if _, err := t.ping(n.ID(), addr); err != nil {
+			return nil, err
+		}
+		t.waitping(n.ID())
+	}
+
+	req := &enrRequest{Expiration: uint64(time.Now().Add(expiration).Unix())}
+	packet, hash, err := encodePacket(t.priv, enrRequestPacket, req)
+	if err != nil {
+		return nil, err
+	}
+	var resp *enrResponse
+	errc := t.pending(n.ID(), enrResponsePacket, func(r interface{}) bool {
+		matched := bytes.Equal(r.(*enrResponse).ReplyTok, hash)
+		if matched {
+			resp = r.(*enrResponse)
+		}
+		return matched
+	})
+	t.write(addr, req.name(), packet)
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+
+	// Verify the response record.
+	respN, err := enode.New(enode.ValidSchemes, &resp.Record)
+	if err != nil {
+		return nil, err
+	}
+	if respN.ID() != n.ID() {
+		return nil, fmt.Errorf("invalid ID in response record")
+	}
+	if respN.Seq() < n.Seq() {
+		return n, nil // response record is older
+	}
+	if err := netutil.CheckRelayIP(addr.IP, respN.IP()); err != nil {
+		return nil, fmt.Errorf("invalid IP in response record: %v", err)
+	}
+	return respN, nil
+}
+
 func (t *udp) waitping(from enode.ID) error {
 	return <-t.pending(from, pingPacket, func(interface{}) bool { return true })
 }
@@ -607,6 +692,10 @@ func decodePacket(buf []byte) (packet, encPubkey, []byte, error) {
 		req = new(findnode)
 	case neighborsPacket:
 		req = new(neighbors)
+	case enrRequestPacket:
+		req = new(enrRequest)
+	case enrResponsePacket:
+		req = new(enrResponse)
 	default:
 		return nil, fromKey, hash, fmt.Errorf("unknown type: %d", ptype)
 	}
@@ -627,11 +716,12 @@ func (req *ping) handle(t *udp, from *net.UDPAddr, fromKey encPubkey, mac []byte
 		To:         makeEndpoint(from, req.From.TCP),
 		ReplyTok:   mac,
 		Expiration: uint64(time.Now().Add(expiration).Unix()),
+		Rest:       enrSeqTail(t.localNode.Node().Seq()),
 	})
 	n := wrapNode(enode.NewV4(key, from.IP, int(req.From.TCP), from.Port))
 	t.handleReply(n.ID(), pingPacket, req)
 	if time.Since(t.db.LastPongReceived(n.ID())) > bondExpiration {
-		t.sendPing(n.ID(), from, func() { t.tab.addThroughPing(n) })
+		t.sendPing(n.ID(), from, func(*pong) { t.tab.addThroughPing(n) })
 	} else {
 		t.tab.addThroughPing(n)
 	}
@@ -710,6 +800,33 @@ func (req *neighbors) handle(t *udp, from *net.UDPAddr, fromKey encPubkey, mac [
 
 func (req *neighbors) name() string { return "NEIGHBORS/v4" }
 
+func (req *enrRequest) handle(t *udp, from *net.UDPAddr, fromKey encPubkey, mac []byte) error {
+	if expired(req.Expiration) {
+		return errExpired
+	}
+	if time.Since(t.db.LastPongReceived(fromKey.id())) > bondExpiration {
+		// Like findnode, the request is only answered for bonded nodes because
+		// the response is larger than the request.
+		return errUnknownNode
+	}
+	t.send(from, enrResponsePacket, &enrResponse{
+		ReplyTok: mac,
+		Record:   *t.localNode.Node().Record(),
+	})
+	return nil
+}
+
+func (req *enrRequest) name() string { return "ENRREQUEST/v4" }
+
+func (req *enrResponse) handle(t *udp, from *net.UDPAddr, fromKey encPubkey, mac []byte) error {
+	if !t.handleReply(fromKey.id(), enrResponsePacket, req) {
+		return errUnsolicitedReply
+	}
+	return nil
+}
+
+func (req *enrResponse) name() string { return "ENRRESPONSE/v4" }
+
 func expired(ts uint64) bool {
 	return time.Unix(int64(ts), 0).Before(time.Now())
 }
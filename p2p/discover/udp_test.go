@@ -37,6 +37,7 @@ import (
 	"github.com/severeum/go-severeum/common"
 	"github.com/severeum/go-severeum/crypto"
 	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/p2p/enr"
 	"github.com/severeum/go-severeum/rlp"
 )
 
@@ -140,7 +141,7 @@ func TestUDP_pingTimeout(t *testing.T) {
 
 	toaddr := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 2222}
 	toid := enode.ID{1, 2, 3, 4}
-	if err := test.udp.ping(toid, toaddr); err != errTimeout {
+	if _, err := test.udp.ping(toid, toaddr); err != errTimeout {
 		t.Error("expected timeout error, got", err)
 	}
 }
@@ -395,6 +396,83 @@ func TestUDP_successfulPing(t *testing.T) {
 	}
 }
 
+func TestUDP_pongSeq(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	// The pong reply announces the sequence number of the local record.
+	test.packetIn(nil, pingPacket, &ping{From: testRemote, To: testLocalAnnounced, Version: 4, Expiration: futureExp})
+	test.waitPacketOut(func(p *pong) {
+		if seq := enrSeqFromTail(p.Rest); seq != test.udp.localNode.Node().Seq() {
+			t.Errorf("wrong sequence number in pong: got %d, want %d", seq, test.udp.localNode.Node().Seq())
+		}
+	})
+}
+
+func TestUDP_enrRequest(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	// The request is rejected if there is no bond.
+	test.packetIn(errUnknownNode, enrRequestPacket, &enrRequest{Expiration: futureExp})
+
+	// Create the bond and check that the local record is returned.
+	test.table.db.UpdateLastPongReceived(encodePubkey(&test.remotekey.PublicKey).id(), time.Now())
+	test.packetIn(nil, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	test.waitPacketOut(func(p *enrResponse) {
+		if !bytes.Equal(p.ReplyTok, test.sent[1][:macSize]) {
+			t.Errorf("wrong reply token %x", p.ReplyTok)
+		}
+		n, err := enode.New(enode.ValidSchemes, &p.Record)
+		if err != nil {
+			t.Fatalf("invalid record: %v", err)
+		}
+		if !reflect.DeepEqual(n, test.udp.localNode.Node()) {
+			t.Errorf("wrong node in response: got %v, want %v", n, test.udp.localNode.Node())
+		}
+	})
+}
+
+func TestUDP_requestENR(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	// Create the bond so the request is sent without pinging first.
+	remote := enode.NewV4(&test.remotekey.PublicKey, test.remoteaddr.IP, 0, test.remoteaddr.Port)
+	test.table.db.UpdateLastPongReceived(remote.ID(), time.Now())
+
+	var r enr.Record
+	r.Set(enr.IP(test.remoteaddr.IP))
+	r.Set(enr.UDP(test.remoteaddr.Port))
+	r.Set(enr.WithEntry("foo", "bar"))
+	r.SetSeq(3)
+	enode.SignV4(&r, test.remotekey)
+
+	type result struct {
+		n   *enode.Node
+		err error
+	}
+	resultc := make(chan result, 1)
+	go func() {
+		n, err := test.udp.requestENR(remote)
+		resultc <- result{n, err}
+	}()
+	hash, _ := test.waitPacketOut(func(p *enrRequest) {})
+	test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: hash, Record: r})
+
+	res := <-resultc
+	if res.err != nil {
+		t.Fatalf("requestENR error: %v", res.err)
+	}
+	if res.n.Seq() != 3 {
+		t.Errorf("wrong record returned: got seq %d, want 3", res.n.Seq())
+	}
+	var foo string
+	if err := res.n.Load(enr.WithEntry("foo", &foo)); err != nil || foo != "bar" {
+		t.Errorf("record entry missing: %v", err)
+	}
+}
+
 var testPackets = []struct {
 	input      string
 	wantPacket interface{}
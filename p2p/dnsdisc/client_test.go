@@ -0,0 +1,323 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/common/mclock"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/p2p/enr"
+)
+
+const (
+	signingKeySeed = 0x111111
+	nodesSeed1     = 0x2945237
+	nodesSeed2     = 0x4567299
+)
+
+func TestClientSyncTree(t *testing.T) {
+	nodes := testNodes(nodesSeed1, 10)
+	tree, url := makeTestTree("n", nodes, []string{"enrtree://AKPYQIUQIL7PSIACI32J7FGZW56E5FKHEFCCOFHILBIMW3M6LWXS2@morenodes.example.org"})
+	r := mapResolver(tree.ToTXT("n"))
+
+	c, _ := NewClient(Config{Resolver: r})
+	stree, err := c.SyncTree(url)
+	if err != nil {
+		t.Fatal("sync error:", err)
+	}
+	if !reflect.DeepEqual(sortByID(stree.Nodes()), sortByID(nodes)) {
+		t.Errorf("wrong nodes in synced tree")
+	}
+	if !reflect.DeepEqual(stree.Links(), tree.Links()) {
+		t.Errorf("wrong links in synced tree: have %v, want %v", stree.Links(), tree.Links())
+	}
+	if stree.Seq() != tree.Seq() || stree.Signature() != tree.Signature() {
+		t.Errorf("root mismatch in synced tree")
+	}
+}
+
+// In this test, syncing the tree fails because it contains an invalid ENR entry.
+func TestClientSyncTreeBadNode(t *testing.T) {
+	tree, url := makeTestTree("n", testNodes(nodesSeed1, 3), nil)
+	r := mapResolver(tree.ToTXT("n"))
+	// Replace a node record by a record signed with a different key, keeping the
+	// hash of the original.
+	other := testNodes(nodesSeed2, 1)[0]
+	for name, txt := range r {
+		if name != "n" && bytes.HasPrefix([]byte(txt), []byte("enr:")) {
+			r[name] = other.RecordText()
+			break
+		}
+	}
+	c, _ := NewClient(Config{Resolver: r})
+	_, err := c.SyncTree(url)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if ne, ok := err.(nameError); !ok || ne.err != errHashMismatch {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+// Tests that trees signed by a key other than the one in the URL are rejected.
+func TestClientSyncTreeBadSignature(t *testing.T) {
+	tree, _ := makeTestTree("n", testNodes(nodesSeed1, 3), nil)
+	r := mapResolver(tree.ToTXT("n"))
+
+	wrongKey := testKey(signingKeySeed + 1)
+	url := (&linkEntry{domain: "n", pubkey: &wrongKey.PublicKey}).String()
+	c, _ := NewClient(Config{Resolver: r})
+	if _, err := c.SyncTree(url); !reflect.DeepEqual(err, entryError{"root", errInvalidSig}) {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
+
+// This test checks that RandomNode hits all entries, including those of linked
+// trees.
+func TestClientRandomNode(t *testing.T) {
+	nodes := testNodes(nodesSeed1, 30)
+	linked := testNodes(nodesSeed2, 10)
+	ltree, lurl := makeTestTree("linked", linked, nil)
+	tree, url := makeTestTree("n", nodes, []string{lurl})
+	r := mapResolver(tree.ToTXT("n"))
+	for name, txt := range ltree.ToTXT("linked") {
+		r[name] = txt
+	}
+	c, _ := NewClient(Config{Resolver: r}, url)
+	checkRandomNode(t, c, append(nodes, linked...))
+}
+
+// This test verifies that RandomNode picks up new nodes once the root of the
+// tree is updated.
+func TestClientRandomNodeUpdate(t *testing.T) {
+	var (
+		clock    = new(mclock.Simulated)
+		nodes    = testNodes(nodesSeed1, 30)
+		resolver = newMapResolver()
+		cfg      = Config{
+			Resolver:        resolver,
+			RecheckInterval: 20 * time.Minute,
+		}
+		c, _ = NewClient(cfg)
+	)
+	c.clock = clock
+	tree1, url := makeTestTree("n", nodes[:25], nil)
+
+	// Sync the original tree.
+	resolver.add(tree1.ToTXT("n"))
+	c.AddTree(url)
+	checkRandomNode(t, c, nodes[:25])
+
+	// Update some nodes and ensure RandomNode returns the new nodes as well.
+	keys := testKeys(nodesSeed1, len(nodes))
+	for i, n := range nodes[:len(nodes)/2] {
+		r := n.Record()
+		r.Set(enr.IP{127, 0, 0, 1})
+		r.SetSeq(55)
+		enode.SignV4(r, keys[i])
+		n2, _ := enode.New(enode.ValidSchemes, r)
+		nodes[i] = n2
+	}
+	tree2, _ := makeTestTree("n", nodes, nil)
+	clock.Run(cfg.RecheckInterval + 1*time.Second)
+	resolver.clear()
+	resolver.add(tree2.ToTXT("n"))
+	checkRandomNode(t, c, nodes)
+}
+
+// Tests that RandomNode gives up when the resolver fails.
+func TestClientRandomNodeError(t *testing.T) {
+	_, url := makeTestTree("n", testNodes(nodesSeed1, 3), nil)
+	c, _ := NewClient(Config{Resolver: newMapResolver()}, url)
+	if n := c.RandomNode(context.Background()); n != nil {
+		t.Fatalf("got node %v from empty resolver", n.ID())
+	}
+}
+
+// Tests that RandomNode doesn't block other users of the client while waiting
+// for DNS lookups.
+func TestClientRandomNodeConcurrent(t *testing.T) {
+	nodes := testNodes(nodesSeed1, 3)
+	tree, url := makeTestTree("n", nodes, nil)
+	r := &blockingResolver{
+		mapResolver: newMapResolver(tree.ToTXT("n")),
+		started:     make(chan struct{}, 1),
+		release:     make(chan struct{}),
+	}
+	c, _ := NewClient(Config{Resolver: r}, url)
+
+	result := make(chan *enode.Node)
+	go func() { result <- c.RandomNode(context.Background()) }()
+	<-r.started
+
+	// While the lookup is pending, the client must remain usable
+	done := make(chan struct{})
+	go func() {
+		c.AddTree("enrtree://AKPYQIUQIL7PSIACI32J7FGZW56E5FKHEFCCOFHILBIMW3M6LWXS2@morenodes.example.org")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("AddTree blocked by pending DNS lookup")
+	}
+	close(r.release)
+	if n := <-result; n == nil {
+		t.Fatalf("RandomNode returned nil")
+	}
+}
+
+// blockingResolver is a DNS resolver which holds back its first lookup until
+// released.
+type blockingResolver struct {
+	mapResolver
+	started chan struct{}
+	release chan struct{}
+}
+
+func (br *blockingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	select {
+	case br.started <- struct{}{}:
+		<-br.release
+	default:
+	}
+	return br.mapResolver.LookupTXT(ctx, name)
+}
+
+func checkRandomNode(t *testing.T, c *Client, wantNodes []*enode.Node) {
+	t.Helper()
+
+	var (
+		want     = make(map[enode.ID]*enode.Node)
+		maxCalls = len(wantNodes) * 20
+		calls    = 0
+		ctx      = context.Background()
+	)
+	for _, n := range wantNodes {
+		want[n.ID()] = n
+	}
+	for ; len(want) > 0 && calls < maxCalls; calls++ {
+		n := c.RandomNode(ctx)
+		if n == nil {
+			t.Fatalf("RandomNode returned nil (call %d)", calls)
+		}
+		if want[n.ID()] != nil && reflect.DeepEqual(want[n.ID()], n) {
+			delete(want, n.ID())
+		}
+	}
+	t.Logf("checkRandomNode called RandomNode %d times to find %d nodes", calls, len(wantNodes))
+	for _, n := range want {
+		t.Errorf("RandomNode didn't discover node %v", n.ID())
+	}
+}
+
+func makeTestTree(domain string, nodes []*enode.Node, links []string) (*Tree, string) {
+	tree, err := MakeTree(1, nodes, links)
+	if err != nil {
+		panic(err)
+	}
+	url, err := tree.Sign(testKey(signingKeySeed), domain)
+	if err != nil {
+		panic(err)
+	}
+	return tree, url
+}
+
+// testKeys creates deterministic private keys for testing.
+func testKeys(seed int64, n int) []*ecdsa.PrivateKey {
+	rand := rand.New(rand.NewSource(seed))
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := 0; i < n; i++ {
+		seed := make([]byte, 32)
+		rand.Read(seed)
+		key, err := crypto.ToECDSA(seed)
+		if err != nil {
+			panic("can't generate key: " + err.Error())
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+func testKey(seed int64) *ecdsa.PrivateKey {
+	return testKeys(seed, 1)[0]
+}
+
+func testNodes(seed int64, n int) []*enode.Node {
+	keys := testKeys(seed, n)
+	nodes := make([]*enode.Node, n)
+	for i, key := range keys {
+		record := new(enr.Record)
+		record.SetSeq(uint64(i))
+		enode.SignV4(record, key)
+		n, err := enode.New(enode.ValidSchemes, record)
+		if err != nil {
+			panic(err)
+		}
+		nodes[i] = n
+	}
+	return nodes
+}
+
+func sortByID(nodes []*enode.Node) []*enode.Node {
+	sorted := make([]*enode.Node, len(nodes))
+	copy(sorted, nodes)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].ID().Bytes(), sorted[j].ID().Bytes()) < 0
+	})
+	return sorted
+}
+
+// mapResolver is an in-memory DNS resolver serving the records of a map.
+type mapResolver map[string]string
+
+func newMapResolver(maps ...map[string]string) mapResolver {
+	mr := make(mapResolver)
+	for _, m := range maps {
+		mr.add(m)
+	}
+	return mr
+}
+
+func (mr mapResolver) clear() {
+	for k := range mr {
+		delete(mr, k)
+	}
+}
+
+func (mr mapResolver) add(m map[string]string) {
+	for k, v := range m {
+		mr[k] = v
+	}
+}
+
+func (mr mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if record, ok := mr[name]; ok {
+		return []string{record}, nil
+	}
+	return nil, errors.New("not found")
+}
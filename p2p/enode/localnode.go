@@ -53,6 +53,7 @@ type LocalNode struct {
 	entries     map[string]enr.Entry
 	udpTrack    *netutil.IPTracker // predicts external UDP endpoint
 	staticIP    net.IP
+	natIP       net.IP
 	fallbackIP  net.IP
 	fallbackUDP int
 }
@@ -137,6 +138,18 @@ func (ln *LocalNode) SetStaticIP(ip net.IP) {
 	ln.updateEndpoints()
 }
 
+// SetNATIP sets the external IP address reported by the NAT gateway. Unlike the
+// static IP, it doesn't disable endpoint prediction: gateways behind another NAT,
+// e.g. a carrier-grade NAT, report an address which isn't reachable from the
+// Internet. The address is used while no prediction can be made.
+func (ln *LocalNode) SetNATIP(ip net.IP) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	ln.natIP = ip
+	ln.updateEndpoints()
+}
+
 // SetFallbackIP sets the last-resort IP address. This address is used
 // if no endpoint prediction can be made and no static IP is set.
 func (ln *LocalNode) SetFallbackIP(ip net.IP) {
@@ -186,6 +199,8 @@ func (ln *LocalNode) updateEndpoints() {
 	} else if ip, port := predictAddr(ln.udpTrack); ip != nil {
 		newIP = ip
 		newUDP = port
+	} else if ln.natIP != nil {
+		newIP = ln.natIP
 	}
 
 	// Update the record.
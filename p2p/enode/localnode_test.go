@@ -17,6 +17,7 @@
 package enode
 
 import (
+	"net"
 	"testing"
 
 	"github.com/severeum/go-severeum/crypto"
@@ -74,3 +75,41 @@ func TestLocalNodeSeqPersist(t *testing.T) {
 		t.Fatalf("wrong seq %d on instance with changed key, want 1", s)
 	}
 }
+
+// This test checks that the IP and UDP port of the local record follow the
+// endpoint predicted from the statements of other nodes.
+func TestLocalNodeEndpointPrediction(t *testing.T) {
+	ln, db := newLocalNodeForTesting()
+	defer db.Close()
+
+	ln.SetFallbackIP(net.IP{127, 0, 0, 1})
+	ln.SetFallbackUDP(30303)
+	ln.SetNATIP(net.IP{100, 64, 0, 1})
+	checkLocalEndpoint(t, ln, net.IP{100, 64, 0, 1}, 30303)
+
+	// Statements override the NAT IP.
+	statements := func(endpoint *net.UDPAddr) {
+		for i := 0; i < iptrackMinStatements; i++ {
+			from := &net.UDPAddr{IP: net.IP{10, 0, 0, byte(i)}, Port: 30303}
+			ln.UDPEndpointStatement(from, endpoint)
+		}
+	}
+	statements(&net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 40000})
+	checkLocalEndpoint(t, ln, net.IP{1, 2, 3, 4}, 40000)
+
+	// The record is updated when the endpoint changes.
+	statements(&net.UDPAddr{IP: net.IP{5, 6, 7, 8}, Port: 40001})
+	checkLocalEndpoint(t, ln, net.IP{5, 6, 7, 8}, 40001)
+
+	// The static IP overrides the prediction.
+	ln.SetStaticIP(net.IP{9, 9, 9, 9})
+	checkLocalEndpoint(t, ln, net.IP{9, 9, 9, 9}, 30303)
+}
+
+func checkLocalEndpoint(t *testing.T, ln *LocalNode, ip net.IP, udp int) {
+	t.Helper()
+	n := ln.Node()
+	if !n.IP().Equal(ip) || n.UDP() != udp {
+		t.Fatalf("wrong endpoint %v:%d, want %v:%d", n.IP(), n.UDP(), ip, udp)
+	}
+}
@@ -18,6 +18,7 @@ package enode
 
 import (
 	"crypto/ecdsa"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -27,8 +28,11 @@ import (
 	"strings"
 
 	"github.com/severeum/go-severeum/p2p/enr"
+	"github.com/severeum/go-severeum/rlp"
 )
 
+var errMissingPrefix = errors.New("missing 'enr:' prefix for base64-encoded record")
+
 // Node represents a host on the network.
 type Node struct {
 	r  enr.Record
@@ -48,6 +52,27 @@ func New(validSchemes enr.IdentityScheme, r *enr.Record) (*Node, error) {
 	return node, nil
 }
 
+// Parse decodes and verifies a node record in text form, i.e. the prefix "enr:"
+// followed by the URL-safe base64 encoding of the RLP record. Node URLs as
+// accepted by ParseV4 are decoded as well.
+func Parse(validSchemes enr.IdentityScheme, input string) (*Node, error) {
+	if strings.HasPrefix(input, "enode://") {
+		return ParseV4(input)
+	}
+	if !strings.HasPrefix(input, "enr:") {
+		return nil, errMissingPrefix
+	}
+	bin, err := base64.RawURLEncoding.DecodeString(input[4:])
+	if err != nil {
+		return nil, err
+	}
+	var r enr.Record
+	if err := rlp.DecodeBytes(bin, &r); err != nil {
+		return nil, err
+	}
+	return New(validSchemes, &r)
+}
+
 // ID returns the node identifier.
 func (n *Node) ID() ID {
 	return n.id
@@ -128,6 +153,14 @@ func (n *Node) String() string {
 	return n.v4URL()
 }
 
+// RecordText returns the text form of the node record, which can be decoded
+// by Parse. Unlike the URL returned by String, it contains all entries of the
+// record along with its signature.
+func (n *Node) RecordText() string {
+	enc, _ := rlp.EncodeToBytes(&n.r) // always succeeds for a valid record
+	return "enr:" + base64.RawURLEncoding.EncodeToString(enc)
+}
+
 // MarshalText implements encoding.TextMarshaler.
 func (n *Node) MarshalText() ([]byte, error) {
 	return []byte(n.v4URL()), nil
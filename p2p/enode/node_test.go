@@ -60,3 +60,32 @@ func TestPythonInterop(t *testing.T) {
 		}
 	}
 }
+
+// Tests that node records survive a round trip through their text form.
+func TestNodeRecordText(t *testing.T) {
+	var r enr.Record
+	r.Set(enr.IP{127, 0, 0, 1})
+	r.Set(enr.TCP(30303))
+	r.Set(enr.UDP(30304))
+	if err := SignV4(&r, privkey); err != nil {
+		t.Fatalf("can't sign record: %v", err)
+	}
+	n, err := New(ValidSchemes, &r)
+	if err != nil {
+		t.Fatalf("can't create node: %v", err)
+	}
+	text := n.RecordText()
+	dec, err := Parse(ValidSchemes, text)
+	if err != nil {
+		t.Fatalf("can't parse %q: %v", text, err)
+	}
+	if dec.ID() != n.ID() || dec.Seq() != n.Seq() || !dec.IP().Equal(n.IP()) || dec.TCP() != n.TCP() || dec.UDP() != n.UDP() {
+		t.Errorf("node mismatch after round trip: have %v, want %v", dec, n)
+	}
+	if _, err := Parse(ValidSchemes, text[4:]); err != errMissingPrefix {
+		t.Errorf("wrong error for missing prefix: %v", err)
+	}
+	if _, err := Parse(ValidSchemes, text[:len(text)-4]); err == nil {
+		t.Errorf("truncated record accepted")
+	}
+}
@@ -45,14 +45,18 @@ const (
 	dbDiscoverPing      = dbDiscoverRoot + ":lastping"
 	dbDiscoverPong      = dbDiscoverRoot + ":lastpong"
 	dbDiscoverFindFails = dbDiscoverRoot + ":findfail"
+	dbPeerRoot          = ":peer"
+	dbPeerScore         = dbPeerRoot + ":score"
+	dbPeerScoreTime     = dbPeerRoot + ":scoretime"
 	dbLocalRoot         = ":local"
 	dbLocalSeq          = dbLocalRoot + ":seq"
 )
 
 var (
-	dbNodeExpiration = 24 * time.Hour // Time after which an unseen node should be dropped.
-	dbCleanupCycle   = time.Hour      // Time period for running the expiration task.
-	dbVersion        = 7
+	dbNodeExpiration  = 24 * time.Hour     // Time after which an unseen node should be dropped.
+	dbScoreExpiration = 7 * 24 * time.Hour // Time after which an unchanged peer score should be dropped.
+	dbCleanupCycle    = time.Hour          // Time period for running the expiration task.
+	dbVersion         = 7
 )
 
 // DB is the node database, storing previously seen nodes and any collected metadata about
@@ -269,17 +273,28 @@ func (db *DB) expirer() {
 }
 
 // expireNodes iterates over the database and deletes all nodes that have not
-// been seen (i.e. received a pong from) for some allotted time.
+// been seen (i.e. received a pong from) for some allotted time, as well as any
+// peer scores that have not been updated for a longer while.
 func (db *DB) expireNodes() error {
-	threshold := time.Now().Add(-dbNodeExpiration)
-
+	var (
+		threshold      = time.Now().Add(-dbNodeExpiration)
+		scoreThreshold = time.Now().Add(-dbScoreExpiration)
+	)
 	// Find discovered nodes that are older than the allowance
 	it := db.lvl.NewIterator(nil, nil)
 	defer it.Release()
 
 	for it.Next() {
-		// Skip the item if not a discovery node
+		// Drop stale peer scores of nodes not seen through discovery
 		id, field := splitKey(it.Key())
+		if field == dbPeerScoreTime {
+			if _, updated := db.PeerScore(id); updated.Before(scoreThreshold) {
+				db.lvl.Delete(makeKey(id, dbPeerScore), nil)
+				db.lvl.Delete(makeKey(id, dbPeerScoreTime), nil)
+			}
+			continue
+		}
+		// Skip the item if not a discovery node
 		if field != dbDiscoverRoot {
 			continue
 		}
@@ -326,6 +341,20 @@ func (db *DB) UpdateFindFails(id ID, fails int) error {
 	return db.storeInt64(makeKey(id, dbDiscoverFindFails), int64(fails))
 }
 
+// PeerScore retrieves the reputation score of a remote node along with the
+// time it was last updated.
+func (db *DB) PeerScore(id ID) (int64, time.Time) {
+	return db.fetchInt64(makeKey(id, dbPeerScore)), time.Unix(db.fetchInt64(makeKey(id, dbPeerScoreTime)), 0)
+}
+
+// UpdatePeerScore stores the reputation score of a remote node.
+func (db *DB) UpdatePeerScore(id ID, score int64, instance time.Time) error {
+	if err := db.storeInt64(makeKey(id, dbPeerScore), score); err != nil {
+		return err
+	}
+	return db.storeInt64(makeKey(id, dbPeerScoreTime), instance.Unix())
+}
+
 // LocalSeq retrieves the local record sequence counter.
 func (db *DB) localSeq(id ID) uint64 {
 	return db.fetchUint64(makeKey(id, dbLocalSeq))
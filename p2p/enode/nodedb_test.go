@@ -142,6 +142,16 @@ func TestDBFetchStore(t *testing.T) {
 	if stored := db.FindFails(node.ID()); stored != num {
 		t.Errorf("find-node fails: value mismatch: have %v, want %v", stored, num)
 	}
+	// Check fetch/store operations on a peer score object
+	if score, updated := db.PeerScore(node.ID()); score != 0 || updated.Unix() != 0 {
+		t.Errorf("peer score: non-existing object: %v, %v", score, updated)
+	}
+	if err := db.UpdatePeerScore(node.ID(), -int64(num), inst); err != nil {
+		t.Errorf("peer score: failed to update: %v", err)
+	}
+	if score, updated := db.PeerScore(node.ID()); score != -int64(num) || updated.Unix() != inst.Unix() {
+		t.Errorf("peer score: value mismatch: have %v/%v, want %v/%v", score, updated, -num, inst)
+	}
 	// Check fetch/store operations on an actual node object
 	if stored := db.Node(node.ID()); stored != nil {
 		t.Errorf("node: non-existing object: %v", stored)
@@ -370,3 +380,25 @@ func TestDBExpiration(t *testing.T) {
 		}
 	}
 }
+
+func TestDBPeerScoreExpiration(t *testing.T) {
+	db, _ := OpenDB("")
+	defer db.Close()
+
+	var (
+		fresh = ID{0x01}
+		stale = ID{0x02}
+	)
+	db.UpdatePeerScore(fresh, 10, time.Now())
+	db.UpdatePeerScore(stale, -10, time.Now().Add(-dbScoreExpiration-time.Minute))
+
+	if err := db.expireNodes(); err != nil {
+		t.Fatalf("failed to expire nodes: %v", err)
+	}
+	if score, _ := db.PeerScore(fresh); score != 10 {
+		t.Errorf("fresh score mismatch: have %d, want %d", score, 10)
+	}
+	if score, updated := db.PeerScore(stale); score != 0 || updated.Unix() != 0 {
+		t.Errorf("stale score not expired: %d, %v", score, updated)
+	}
+}
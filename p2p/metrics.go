@@ -37,6 +37,9 @@ const (
 	MetricsInboundTraffic   = "p2p/InboundTraffic"   // Name for the registered inbound traffic meter
 	MetricsOutboundConnects = "p2p/OutboundConnects" // Name for the registered outbound connects meter
 	MetricsOutboundTraffic  = "p2p/OutboundTraffic"  // Name for the registered outbound traffic meter
+	MetricsRejectedConns    = "p2p/RejectedConns"    // Prefix of the meters counting rejected connections by reason
+	MetricsInboundMessages  = "p2p/InboundMessages"  // Prefix of the meters metering ingress payload bytes by protocol and message code
+	MetricsOutboundMessages = "p2p/OutboundMessages" // Prefix of the meters metering egress payload bytes by protocol and message code
 
 	MeteredPeerLimit = 1024 // This amount of peers are individually metered
 )
@@ -86,6 +89,12 @@ func SubscribeMeteredPeerEvent(ch chan<- MeteredPeerEvent) event.Subscription {
 	return meteredPeerFeed.Subscribe(ch)
 }
 
+// rejectConn marks the meter of the given rejection reason and returns err.
+func rejectConn(reason string, err error) error {
+	metrics.GetOrRegisterMeter(MetricsRejectedConns+"/"+reason, nil).Mark(1)
+	return err
+}
+
 // meteredConn is a wrapper around a net.Conn that meters both the
 // inbound and outbound network traffic.
 type meteredConn struct {
@@ -0,0 +1,410 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/severeum/go-severeum/rlp"
+)
+
+// The multiplexed transport gives every subprotocol its own stream on the
+// connection. Messages are split into chunks which are interleaved with the
+// chunks of other streams, so a large message of one protocol doesn't hold back
+// the messages of the others. Each stream has its own receive window: a sender
+// may only start a message while the receiver has window left, and the window
+// is replenished as the receiving protocol reads its messages.
+//
+// Multiplexing is negotiated by advertising the mux capability in the protocol
+// handshake. It runs on top of the negotiated encryption transport, whose
+// messages carry the frames described below.
+//
+// Data frames contain the stream number (2 bytes) followed by a chunk of the
+// message. The first chunk of a message also contains the message code
+// (uvarint) and the message size (4 bytes). Window frames contain the stream
+// number and the number of bytes returned to the sender (4 bytes).
+const (
+	muxCapName    = "mux"
+	muxCapVersion = 1
+
+	muxDataFrame   = 0x10
+	muxWindowFrame = 0x11
+
+	muxChunkSize       = 16 * 1024
+	muxWindowSize      = 1024 * 1024
+	muxMsgOverhead     = 32 // charged in addition to the payload of each message
+	muxWindowFrameSize = 6
+)
+
+var (
+	errMuxFrame    = errors.New("invalid mux frame")
+	errMuxStream   = errors.New("unknown mux stream")
+	errMuxWindow   = errors.New("mux stream window exceeded")
+	errMuxTooLarge = errors.New("mux message too large")
+)
+
+// muxCap is the capability announcing support for multiplexed streams.
+var muxCap = Cap{Name: muxCapName, Version: muxCapVersion}
+
+// streamTransport is implemented by transports which carry every subprotocol
+// on its own stream. Messages of stream 0 belong to the base protocol, the
+// following streams belong to the subprotocols in the order of their message
+// code offsets.
+type streamTransport interface {
+	streams() int
+	readStream(id int) (Msg, error)
+}
+
+// muxSupported reports whether the capabilities include multiplexed streams.
+func muxSupported(caps []Cap) bool {
+	for _, cap := range caps {
+		if cap == muxCap {
+			return true
+		}
+	}
+	return false
+}
+
+// muxTransport multiplexes messages over an established transport.
+type muxTransport struct {
+	transport          // underlying encrypted transport
+	offsets   []uint64 // message code offsets of the subprotocol streams
+	streamSet []*muxStream
+
+	wtoken chan struct{} // passed between writers of frames in FIFO order
+
+	mu  sync.Mutex // protects the streams and err
+	err error      // set when the connection failed
+}
+
+type muxStream struct {
+	id int
+
+	// Receiving side.
+	queue     []Msg      // complete messages which haven't been read
+	buffered  int        // window used by queued messages
+	unacked   int        // window used by read messages not returned to the sender yet
+	receiving bool       // whether a message is partially received
+	cur       Msg        // the partially received message
+	curData   []byte     // payload of cur received so far
+	readable  *sync.Cond // signals new messages
+
+	// Sending side.
+	sendMu   sync.Mutex // held while a message is written
+	credit   int        // remaining window of the remote end
+	writable *sync.Cond // signals new credit
+}
+
+// newMuxTransport starts multiplexing on the given transport. The streams are
+// derived from the subprotocols shared with the remote end, which computes the
+// same set of streams.
+func newMuxTransport(t transport, protocols []Protocol, caps []Cap) *muxTransport {
+	var offsets []uint64
+	for _, proto := range matchProtocols(protocols, caps, nil) {
+		offsets = append(offsets, proto.offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	mt := &muxTransport{
+		transport: t,
+		offsets:   offsets,
+		wtoken:    make(chan struct{}, 1),
+	}
+	mt.wtoken <- struct{}{}
+	for id := 0; id <= len(offsets); id++ {
+		s := &muxStream{id: id, credit: muxWindowSize}
+		s.readable = sync.NewCond(&mt.mu)
+		s.writable = sync.NewCond(&mt.mu)
+		mt.streamSet = append(mt.streamSet, s)
+	}
+	go mt.readLoop()
+	return mt
+}
+
+// streams returns the number of streams.
+func (t *muxTransport) streams() int {
+	return len(t.streamSet)
+}
+
+// streamOf returns the stream carrying messages with the given code.
+func (t *muxTransport) streamOf(code uint64) *muxStream {
+	for i := len(t.offsets) - 1; i >= 0; i-- {
+		if code >= t.offsets[i] {
+			return t.streamSet[i+1]
+		}
+	}
+	return t.streamSet[0]
+}
+
+// muxCost returns the amount of window used by a message.
+func muxCost(size uint32) int {
+	return int(size) + muxMsgOverhead
+}
+
+// ReadMsg reads a message of the base protocol stream. Subprotocol messages are
+// read using readStream.
+func (t *muxTransport) ReadMsg() (Msg, error) {
+	return t.readStream(0)
+}
+
+// readStream reads the next message of a stream.
+func (t *muxTransport) readStream(id int) (Msg, error) {
+	s := t.streamSet[id]
+
+	t.mu.Lock()
+	for len(s.queue) == 0 && t.err == nil {
+		s.readable.Wait()
+	}
+	if len(s.queue) == 0 {
+		err := t.err
+		t.mu.Unlock()
+		return Msg{}, err
+	}
+	msg := s.queue[0]
+	s.queue[0] = Msg{}
+	s.queue = s.queue[1:]
+	cost := muxCost(msg.Size)
+	s.buffered -= cost
+	s.unacked += cost
+	// Return the window in batches. The remote end keeps at least half of
+	// the window while updates are held back.
+	var ack int
+	if s.unacked >= muxWindowSize/2 {
+		ack, s.unacked = s.unacked, 0
+	}
+	t.mu.Unlock()
+
+	if ack > 0 {
+		frame := make([]byte, muxWindowFrameSize)
+		binary.BigEndian.PutUint16(frame, uint16(s.id))
+		binary.BigEndian.PutUint32(frame[2:], uint32(ack))
+		if err := t.writeFrame(muxWindowFrame, frame); err != nil {
+			return Msg{}, err
+		}
+	}
+	return msg, nil
+}
+
+// WriteMsg sends a message on its stream. It waits until the remote end has
+// window left on the stream, but not for the messages of other streams.
+func (t *muxTransport) WriteMsg(msg Msg) error {
+	if msg.Size > maxUint24 {
+		return errMuxTooLarge
+	}
+	payload := make([]byte, msg.Size)
+	if _, err := io.ReadFull(msg.Payload, payload); err != nil {
+		return err
+	}
+	s := t.streamOf(msg.Code)
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	// Wait for window. A message may exceed the remaining window, but the
+	// next one can't be sent before the window is replenished.
+	t.mu.Lock()
+	for s.credit <= 0 && t.err == nil {
+		s.writable.Wait()
+	}
+	if err := t.err; err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	s.credit -= muxCost(msg.Size)
+	t.mu.Unlock()
+
+	// Send the chunks. Writers take turns for every frame.
+	header := make([]byte, 2+binary.MaxVarintLen64+4)
+	binary.BigEndian.PutUint16(header, uint16(s.id))
+	n := 2 + binary.PutUvarint(header[2:], msg.Code)
+	binary.BigEndian.PutUint32(header[n:], msg.Size)
+	header = header[:n+4]
+	for first := true; first || len(payload) > 0; first = false {
+		chunk := payload
+		if len(chunk) > muxChunkSize {
+			chunk = chunk[:muxChunkSize]
+		}
+		payload = payload[len(chunk):]
+
+		frame := append(header, chunk...)
+		if err := t.writeFrame(muxDataFrame, frame); err != nil {
+			return err
+		}
+		header = header[:2]
+	}
+	return nil
+}
+
+// writeFrame writes a frame to the underlying transport.
+func (t *muxTransport) writeFrame(code uint64, frame []byte) error {
+	<-t.wtoken
+	err := t.transport.WriteMsg(Msg{Code: code, Size: uint32(len(frame)), Payload: bytes.NewReader(frame)})
+	t.wtoken <- struct{}{}
+	if err != nil {
+		t.fail(err)
+	}
+	return err
+}
+
+// readLoop reads frames from the underlying transport.
+func (t *muxTransport) readLoop() {
+	for {
+		msg, err := t.transport.ReadMsg()
+		if err != nil {
+			t.fail(err)
+			return
+		}
+		if msg.Code == discMsg {
+			var reason [1]DiscReason
+			rlp.Decode(msg.Payload, &reason)
+			t.fail(reason[0])
+			return
+		}
+		frame, err := ioutil.ReadAll(msg.Payload)
+		if err != nil {
+			t.fail(err)
+			return
+		}
+		switch msg.Code {
+		case muxDataFrame:
+			err = t.handleData(frame)
+		case muxWindowFrame:
+			err = t.handleWindow(frame)
+		default:
+			err = errMuxFrame
+		}
+		if err != nil {
+			t.fail(err)
+			return
+		}
+	}
+}
+
+// stream returns the stream a frame belongs to.
+func (t *muxTransport) stream(frame []byte) (*muxStream, error) {
+	if len(frame) < 2 {
+		return nil, errMuxFrame
+	}
+	id := int(binary.BigEndian.Uint16(frame))
+	if id >= len(t.streamSet) {
+		return nil, errMuxStream
+	}
+	return t.streamSet[id], nil
+}
+
+func (t *muxTransport) handleData(frame []byte) error {
+	s, err := t.stream(frame)
+	if err != nil {
+		return err
+	}
+	chunk := frame[2:]
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !s.receiving {
+		code, n := binary.Uvarint(chunk)
+		if n <= 0 || len(chunk) < n+4 {
+			return errMuxFrame
+		}
+		size := binary.BigEndian.Uint32(chunk[n:])
+		chunk = chunk[n+4:]
+		// Messages must be sent on the stream of their protocol.
+		if t.streamOf(code) != s {
+			return errMuxStream
+		}
+		if size > maxUint24 {
+			return errMuxTooLarge
+		}
+		// The sender must not start a message without window left.
+		if s.buffered+s.unacked >= muxWindowSize {
+			return errMuxWindow
+		}
+		s.receiving = true
+		s.cur = Msg{Code: code, Size: size}
+		s.curData = make([]byte, 0, size)
+	}
+	if len(s.curData)+len(chunk) > int(s.cur.Size) {
+		return errMuxFrame
+	}
+	s.curData = append(s.curData, chunk...)
+	if len(s.curData) == int(s.cur.Size) {
+		msg := s.cur
+		msg.Payload = bytes.NewReader(s.curData)
+		s.queue = append(s.queue, msg)
+		s.buffered += muxCost(msg.Size)
+		s.receiving, s.cur, s.curData = false, Msg{}, nil
+		s.readable.Signal()
+	}
+	return nil
+}
+
+func (t *muxTransport) handleWindow(frame []byte) error {
+	s, err := t.stream(frame)
+	if err != nil {
+		return err
+	}
+	if len(frame) != muxWindowFrameSize {
+		return errMuxFrame
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.credit += int(binary.BigEndian.Uint32(frame[2:]))
+	if s.credit > muxWindowSize {
+		return errMuxWindow
+	}
+	s.writable.Broadcast()
+	return nil
+}
+
+// fail records the error of the connection and wakes up all readers and
+// writers. Queued messages can still be read.
+func (t *muxTransport) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = err
+	}
+	for _, s := range t.streamSet {
+		s.readable.Broadcast()
+		s.writable.Broadcast()
+	}
+}
+
+func (t *muxTransport) close(err error) {
+	t.fail(err)
+	t.transport.close(err)
+}
+
+// setRateLimits configures the bandwidth limits of the underlying transport.
+func (t *muxTransport) setRateLimits(ingress, egress []*rateLimiter) {
+	if mt, ok := t.transport.(meteredTransport); ok {
+		mt.setRateLimits(ingress, egress)
+	}
+}
+
+// traffic returns the traffic of the underlying transport.
+func (t *muxTransport) traffic() (ingress, egress uint64) {
+	if mt, ok := t.transport.(meteredTransport); ok {
+		return mt.traffic()
+	}
+	return 0, 0
+}
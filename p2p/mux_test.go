@@ -0,0 +1,215 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+var muxTestProtocols = []Protocol{
+	{Name: "a", Version: 1, Length: 5},
+	{Name: "b", Version: 1, Length: 5},
+}
+
+func newMuxTestPipe() (*muxTransport, *muxTransport) {
+	fd0, fd1 := net.Pipe()
+	caps := []Cap{muxTestProtocols[0].cap(), muxTestProtocols[1].cap()}
+	t0 := newMuxTransport(newTestRLPX(fd0), muxTestProtocols, caps)
+	t1 := newMuxTransport(newTestRLPX(fd1), muxTestProtocols, caps)
+	return t0, t1
+}
+
+// newTestRLPX creates an RLPx transport which is ready for use.
+func newTestRLPX(fd net.Conn) *rlpx {
+	return newTestTransport(&newkey().PublicKey, fd).(*testTransport).rlpx
+}
+
+func TestMuxTransportStreams(t *testing.T) {
+	t0, t1 := newMuxTestPipe()
+	defer t0.close(DiscQuitting)
+	defer t1.close(DiscQuitting)
+
+	if t0.streams() != 3 {
+		t.Fatalf("wrong number of streams %d, want 3", t0.streams())
+	}
+	// Send a message larger than the window on stream 1. Another message on
+	// the same stream must wait until the first one is read.
+	large := make([]byte, 3*muxWindowSize)
+	if err := t0.WriteMsg(Msg{Code: 16, Size: uint32(len(large)), Payload: bytes.NewReader(large)}); err != nil {
+		t.Fatal("write error:", err)
+	}
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- t0.WriteMsg(Msg{Code: 17, Size: 3, Payload: bytes.NewReader([]byte("foo"))})
+	}()
+	select {
+	case err := <-blocked:
+		t.Fatalf("write on exhausted stream returned: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The other streams are not affected.
+	if err := t0.WriteMsg(Msg{Code: 21, Size: 3, Payload: bytes.NewReader([]byte("bar"))}); err != nil {
+		t.Fatal("write error:", err)
+	}
+	checkMuxMsg(t, t1, 2, 21, []byte("bar"))
+	if err := t1.WriteMsg(Msg{Code: pingMsg, Size: 1, Payload: bytes.NewReader([]byte{0xC0})}); err != nil {
+		t.Fatal("write error:", err)
+	}
+	checkMuxMsg(t, t0, 0, pingMsg, []byte{0xC0})
+
+	// Reading the large message replenishes the window.
+	checkMuxMsg(t, t1, 1, 16, large)
+	select {
+	case err := <-blocked:
+		if err != nil {
+			t.Fatal("write error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write not unblocked after reading")
+	}
+	checkMuxMsg(t, t1, 1, 17, []byte("foo"))
+}
+
+func checkMuxMsg(t *testing.T, tr *muxTransport, stream int, code uint64, payload []byte) {
+	t.Helper()
+	msg, err := tr.readStream(stream)
+	if err != nil {
+		t.Fatalf("stream %d: read error: %v", stream, err)
+	}
+	data, _ := ioutil.ReadAll(msg.Payload)
+	if msg.Code != code || !bytes.Equal(data, payload) {
+		t.Fatalf("stream %d: wrong message code %d, size %d", stream, msg.Code, msg.Size)
+	}
+}
+
+func TestMuxTransportWindowViolation(t *testing.T) {
+	fd0, fd1 := net.Pipe()
+	raw := newTestRLPX(fd0)
+	defer raw.close(DiscQuitting)
+	mt := newMuxTransport(newTestRLPX(fd1), muxTestProtocols, nil)
+	defer mt.close(DiscQuitting)
+
+	// Send two messages filling the window without waiting for window updates.
+	go func() {
+		for {
+			if _, err := raw.ReadMsg(); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		frame := make([]byte, 2+1+4)
+		binary.BigEndian.PutUint16(frame, 0)
+		frame[2] = pingMsg
+		binary.BigEndian.PutUint32(frame[3:], muxWindowSize)
+		frame = append(frame, make([]byte, muxWindowSize)...)
+		if err := raw.WriteMsg(Msg{Code: muxDataFrame, Size: uint32(len(frame)), Payload: bytes.NewReader(frame)}); err != nil {
+			t.Fatal("write error:", err)
+		}
+	}
+	// Wait for the second message to be processed.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		mt.mu.Lock()
+		err := mt.err
+		mt.mu.Unlock()
+		if err == errMuxWindow {
+			break
+		}
+		if err != nil || time.Since(start) > time.Second {
+			t.Fatalf("wrong error %v, want %v", err, errMuxWindow)
+		}
+	}
+	// The first message is still readable.
+	if _, err := mt.readStream(0); err != nil {
+		t.Fatal("first message failed:", err)
+	}
+	if _, err := mt.readStream(0); err != errMuxWindow {
+		t.Fatalf("wrong error %v, want %v", err, errMuxWindow)
+	}
+}
+
+func TestMuxTransportWrongStream(t *testing.T) {
+	fd0, fd1 := net.Pipe()
+	raw := newTestRLPX(fd0)
+	defer raw.close(DiscQuitting)
+	caps := []Cap{muxTestProtocols[0].cap(), muxTestProtocols[1].cap()}
+	mt := newMuxTransport(newTestRLPX(fd1), muxTestProtocols, caps)
+	defer mt.close(DiscQuitting)
+
+	// Send a message of protocol "a" on the base protocol stream.
+	frame := make([]byte, 2+1+4)
+	binary.BigEndian.PutUint16(frame, 0)
+	frame[2] = 16
+	binary.BigEndian.PutUint32(frame[3:], 0)
+	if err := raw.WriteMsg(Msg{Code: muxDataFrame, Size: uint32(len(frame)), Payload: bytes.NewReader(frame)}); err != nil {
+		t.Fatal("write error:", err)
+	}
+	if _, err := mt.readStream(0); err != errMuxStream {
+		t.Fatalf("wrong error %v, want %v", err, errMuxStream)
+	}
+}
+
+func TestMuxTransportDisconnect(t *testing.T) {
+	t0, t1 := newMuxTestPipe()
+	go t0.close(DiscTooManyPeers)
+	for id := 0; id < t1.streams(); id++ {
+		if _, err := t1.readStream(id); err != DiscTooManyPeers {
+			t.Errorf("stream %d: wrong error %v, want %v", id, err, DiscTooManyPeers)
+		}
+	}
+	t1.close(DiscQuitting)
+}
+
+// This test checks that multiplexing is used when both servers enable it.
+func TestServerMuxInterop(t *testing.T) {
+	tests := []struct {
+		dialerMux, listenerMux bool
+	}{
+		{false, true},
+		{true, false},
+		{true, true},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("dialer=%v/listener=%v", test.dialerMux, test.listenerMux)
+		t.Run(name, func(t *testing.T) {
+			dialer, dialerPeers := startTransportTestServer(t, func(c *Config) { c.EnableMux = test.dialerMux })
+			defer dialer.Stop()
+			listener, listenerPeers := startTransportTestServer(t, func(c *Config) { c.EnableMux = test.listenerMux })
+			defer listener.Stop()
+
+			dialer.AddPeer(listener.Self())
+			want := test.dialerMux && test.listenerMux
+			for _, peers := range []chan *Peer{dialerPeers, listenerPeers} {
+				select {
+				case p := <-peers:
+					if muxed := p.Info().Network.Multiplexed; muxed != want {
+						t.Errorf("wrong multiplexing %v, want %v", muxed, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("peers did not exchange messages")
+				}
+			}
+		})
+	}
+}
@@ -59,6 +59,8 @@ type Interface interface {
 //     "upnp"               uses the Universal Plug and Play protocol
 //     "pmp"                uses NAT-PMP with an auto-detected gateway address
 //     "pmp:192.168.0.1"    uses NAT-PMP with the given gateway address
+//     "pcp"                uses the Port Control Protocol with an auto-detected gateway address
+//     "pcp:192.168.0.1"    uses the Port Control Protocol with the given gateway address
 func Parse(spec string) (Interface, error) {
 	var (
 		parts = strings.SplitN(spec, ":", 2)
@@ -85,6 +87,8 @@ func Parse(spec string) (Interface, error) {
 		return UPnP(), nil
 	case "pmp", "natpmp", "nat-pmp":
 		return PMP(ip), nil
+	case "pcp":
+		return PCP(ip), nil
 	default:
 		return nil, fmt.Errorf("unknown mechanism %q", parts[0])
 	}
@@ -93,23 +97,72 @@ func Parse(spec string) (Interface, error) {
 const (
 	mapTimeout        = 20 * time.Minute
 	mapUpdateInterval = 15 * time.Minute
+	mapMinRenewal     = 1 * time.Second
+	mapRetryInterval  = 30 * time.Second
 )
 
+// errNoLifetime is returned for mappings the gateway granted without lifetime.
+var errNoLifetime = errors.New("port mapping granted with zero lifetime")
+
+// Mapping is a port mapping created on the gateway.
+type Mapping struct {
+	Protocol     string
+	InternalPort int
+	ExternalPort int
+	ExternalIP   net.IP        // nil if the gateway doesn't report it
+	Lifetime     time.Duration // lifetime granted by the gateway
+}
+
+// MapStatus reports the result of creating or renewing a port mapping.
+type MapStatus struct {
+	Mapping
+	Err      error // non-nil if the mapping could not be created
+	Failures int   // number of consecutive failures
+}
+
+// mapper is implemented by mechanisms which report the mapping created by the
+// gateway. The external port and the lifetime may differ from the request.
+type mapper interface {
+	addMapping(protocol string, extport, intport int, name string, lifetime time.Duration) (Mapping, error)
+}
+
+// addMapping creates a port mapping and returns it. For mechanisms which don't
+// report the created mapping, the requested one is returned.
+func addMapping(m Interface, protocol string, extport, intport int, name string, lifetime time.Duration) (Mapping, error) {
+	if mm, ok := m.(mapper); ok {
+		return mm.addMapping(protocol, extport, intport, name, lifetime)
+	}
+	if err := m.AddMapping(protocol, extport, intport, name, lifetime); err != nil {
+		return Mapping{}, err
+	}
+	mapping := Mapping{Protocol: protocol, InternalPort: intport, ExternalPort: extport, Lifetime: lifetime}
+	mapping.ExternalIP, _ = m.ExternalIP()
+	return mapping, nil
+}
+
 // Map adds a port mapping on m and keeps it alive until c is closed.
 // This function is typically invoked in its own goroutine.
 func Map(m Interface, c chan struct{}, protocol string, extport, intport int, name string) {
+	MapWithStatus(m, c, protocol, extport, intport, name, nil)
+}
+
+// MapWithStatus is like Map, but also reports the result of every attempt to
+// create or renew the mapping to the status function. Mappings are renewed
+// before the lifetime granted by the gateway ends. Failed attempts are retried
+// with increasing delay.
+func MapWithStatus(m Interface, c chan struct{}, protocol string, extport, intport int, name string, status func(MapStatus)) {
 	log := log.New("proto", protocol, "extport", extport, "intport", intport, "interface", m)
-	refresh := time.NewTimer(mapUpdateInterval)
+	refresh := time.NewTimer(0)
 	defer func() {
 		refresh.Stop()
 		log.Debug("Deleting port mapping")
 		m.DeleteMapping(protocol, extport, intport)
 	}()
-	if err := m.AddMapping(protocol, extport, intport, name, mapTimeout); err != nil {
-		log.Debug("Couldn't add port mapping", "err", err)
-	} else {
-		log.Info("Mapped network port")
-	}
+
+	var (
+		failures int
+		retry    = mapRetryInterval
+	)
 	for {
 		select {
 		case _, ok := <-c:
@@ -117,15 +170,53 @@ func Map(m Interface, c chan struct{}, protocol string, extport, intport int, na
 				return
 			}
 		case <-refresh.C:
-			log.Trace("Refreshing port mapping")
-			if err := m.AddMapping(protocol, extport, intport, name, mapTimeout); err != nil {
-				log.Debug("Couldn't add port mapping", "err", err)
+			mapping, err := addMapping(m, protocol, extport, intport, name, mapTimeout)
+			if err == nil && mapping.Lifetime == 0 {
+				// Renewing such a mapping right away would flood the gateway.
+				err = errNoLifetime
+			}
+			if err != nil {
+				failures++
+				if failures == 1 {
+					log.Warn("Couldn't add port mapping", "err", err)
+				} else {
+					log.Debug("Couldn't add port mapping", "failures", failures, "err", err)
+				}
+				if status != nil {
+					status(MapStatus{Mapping: Mapping{Protocol: protocol, InternalPort: intport}, Err: err, Failures: failures})
+				}
+				refresh.Reset(retry)
+				if retry *= 2; retry > mapUpdateInterval {
+					retry = mapUpdateInterval
+				}
+				continue
+			}
+			if failures > 0 {
+				log.Info("Port mapping restored", "failures", failures)
+			} else {
+				log.Debug("Mapped network port", "extip", mapping.ExternalIP, "mapped", mapping.ExternalPort, "lifetime", mapping.Lifetime)
+			}
+			failures, retry = 0, mapRetryInterval
+			if status != nil {
+				status(MapStatus{Mapping: mapping})
 			}
-			refresh.Reset(mapUpdateInterval)
+			refresh.Reset(renewalInterval(mapping.Lifetime))
 		}
 	}
 }
 
+// renewalInterval returns the time after which a mapping with the given
+// lifetime should be renewed.
+func renewalInterval(lifetime time.Duration) time.Duration {
+	if lifetime >= mapTimeout {
+		return mapUpdateInterval
+	}
+	if lifetime/2 < mapMinRenewal {
+		return mapMinRenewal
+	}
+	return lifetime / 2
+}
+
 // ExtIP assumes that the local machine is reachable on the given
 // external IP address, and that any required ports were mapped manually.
 // Mapping operations will not return an error but won't actually do anything.
@@ -144,10 +235,11 @@ func (ExtIP) DeleteMapping(string, int, int) error                     { return
 func Any() Interface {
 	// TODO: attempt to discover whether the local machine has an
 	// Internet-class address. Return ExtIP in this case.
-	return startautodisc("UPnP or NAT-PMP", func() Interface {
-		found := make(chan Interface, 2)
+	return startautodisc("UPnP, NAT-PMP or PCP", func() Interface {
+		found := make(chan Interface, 3)
 		go func() { found <- discoverUPnP() }()
 		go func() { found <- discoverPMP() }()
+		go func() { found <- discoverPCP() }()
 		for i := 0; i < cap(found); i++ {
 			if c := <-found; c != nil {
 				return c
@@ -173,6 +265,17 @@ func PMP(gateway net.IP) Interface {
 	return startautodisc("NAT-PMP", discoverPMP)
 }
 
+// PCP returns a port mapper that uses the Port Control Protocol. The provided
+// gateway address should be the IP of your router or of the carrier-grade NAT.
+// If the given gateway address is nil, PCP will attempt to auto-discover the
+// router.
+func PCP(gateway net.IP) Interface {
+	if gateway != nil {
+		return newPCP(&net.UDPAddr{IP: gateway, Port: pcpPort})
+	}
+	return startautodisc("PCP", discoverPCP)
+}
+
 // autodisc represents a port mapping mechanism that is still being
 // auto-discovered. Calls to the Interface methods on this type will
 // wait until the discovery is done and then call the method on the
@@ -201,6 +304,13 @@ func (n *autodisc) AddMapping(protocol string, extport, intport int, name string
 	return n.found.AddMapping(protocol, extport, intport, name, lifetime)
 }
 
+func (n *autodisc) addMapping(protocol string, extport, intport int, name string, lifetime time.Duration) (Mapping, error) {
+	if err := n.wait(); err != nil {
+		return Mapping{}, err
+	}
+	return addMapping(n.found, protocol, extport, intport, name, lifetime)
+}
+
 func (n *autodisc) DeleteMapping(protocol string, extport, intport int) error {
 	if err := n.wait(); err != nil {
 		return err
@@ -0,0 +1,295 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package nat
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Port Control Protocol (RFC 6887) constants.
+const (
+	pcpPort    = 5351
+	pcpVersion = 2
+
+	pcpOpAnnounce = 0
+	pcpOpMap      = 1
+	pcpResponse   = 0x80
+
+	pcpHeaderSize   = 24
+	pcpMapSize      = 36
+	pcpMaxPacket    = 1100
+	pcpRetries      = 3
+	pcpInitialRetry = 500 * time.Millisecond
+
+	pcpProtoTCP = 6
+	pcpProtoUDP = 17
+)
+
+// pcpResultCodes are the names of the PCP result codes.
+var pcpResultCodes = []string{
+	"SUCCESS", "UNSUPP_VERSION", "NOT_AUTHORIZED", "MALFORMED_REQUEST",
+	"UNSUPP_OPCODE", "UNSUPP_OPTION", "MALFORMED_OPTION", "NETWORK_FAILURE",
+	"NO_RESOURCES", "UNSUPP_PROTOCOL", "USER_EX_QUOTA", "CANNOT_PROVIDE_EXTERNAL",
+	"ADDRESS_MISMATCH", "EXCESSIVE_REMOTE_PEERS",
+}
+
+var (
+	errPCPTimeout  = errors.New("PCP request timed out")
+	errPCPResponse = errors.New("invalid PCP response")
+	errPCPNoIP     = errors.New("external IP not known yet")
+)
+
+// pcpError is a PCP result code other than SUCCESS.
+type pcpError uint8
+
+func (e pcpError) Error() string {
+	if int(e) < len(pcpResultCodes) {
+		return "PCP error " + pcpResultCodes[e]
+	}
+	return fmt.Sprintf("PCP error %d", uint8(e))
+}
+
+// pcp implements the MAP operation of the Port Control Protocol. PCP is the
+// successor of NAT-PMP and is supported by carrier-grade NATs.
+type pcp struct {
+	gw *net.UDPAddr
+
+	mu     sync.Mutex
+	nonces map[string][12]byte // mapping nonces by protocol and internal port
+	extIP  net.IP              // external IP of the last mapping
+}
+
+func newPCP(gw *net.UDPAddr) *pcp {
+	return &pcp{gw: gw, nonces: make(map[string][12]byte)}
+}
+
+func (n *pcp) String() string {
+	return fmt.Sprintf("PCP(%v)", n.gw.IP)
+}
+
+// ExternalIP returns the external IP assigned to the last mapping. PCP has no
+// separate operation to query the external address.
+func (n *pcp) ExternalIP() (net.IP, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.extIP == nil {
+		return nil, errPCPNoIP
+	}
+	return n.extIP, nil
+}
+
+func (n *pcp) AddMapping(protocol string, extport, intport int, name string, lifetime time.Duration) error {
+	_, err := n.addMapping(protocol, extport, intport, name, lifetime)
+	return err
+}
+
+func (n *pcp) addMapping(protocol string, extport, intport int, name string, lifetime time.Duration) (Mapping, error) {
+	if lifetime <= 0 {
+		return Mapping{}, fmt.Errorf("lifetime must not be <= 0")
+	}
+	m, err := n.doMap(protocol, extport, intport, lifetime)
+	if err != nil {
+		return Mapping{}, err
+	}
+	n.mu.Lock()
+	n.extIP = m.ExternalIP
+	n.mu.Unlock()
+	return m, nil
+}
+
+func (n *pcp) DeleteMapping(protocol string, extport, intport int) error {
+	// Mappings are deleted by requesting a zero lifetime with the nonce used to
+	// create them.
+	_, err := n.doMap(protocol, 0, intport, 0)
+	return err
+}
+
+// doMap sends a MAP request and returns the mapping created by the gateway.
+func (n *pcp) doMap(protocol string, extport, intport int, lifetime time.Duration) (Mapping, error) {
+	var proto byte
+	switch strings.ToLower(protocol) {
+	case "tcp":
+		proto = pcpProtoTCP
+	case "udp":
+		proto = pcpProtoUDP
+	default:
+		return Mapping{}, fmt.Errorf("unsupported protocol %q", protocol)
+	}
+	nonce, err := n.nonce(proto, intport)
+	if err != nil {
+		return Mapping{}, err
+	}
+	conn, err := net.DialUDP("udp", nil, n.gw)
+	if err != nil {
+		return Mapping{}, err
+	}
+	defer conn.Close()
+	clientIP := conn.LocalAddr().(*net.UDPAddr).IP
+
+	req := make([]byte, pcpHeaderSize+pcpMapSize)
+	pcpEncodeHeader(req, pcpOpMap, uint32(lifetime/time.Second), clientIP)
+	body := req[pcpHeaderSize:]
+	copy(body, nonce[:])
+	body[12] = proto
+	binary.BigEndian.PutUint16(body[16:], uint16(intport))
+	binary.BigEndian.PutUint16(body[18:], uint16(extport))
+	if clientIP.To4() != nil {
+		copy(body[20:], net.IPv4zero.To16())
+	}
+
+	resp, err := pcpRequest(conn, req)
+	if err != nil {
+		return Mapping{}, err
+	}
+	if len(resp) < pcpHeaderSize+pcpMapSize {
+		return Mapping{}, errPCPResponse
+	}
+	body = resp[pcpHeaderSize:]
+	if string(body[:12]) != string(nonce[:]) || body[12] != proto || int(binary.BigEndian.Uint16(body[16:])) != intport {
+		return Mapping{}, errPCPResponse
+	}
+	extIP := make(net.IP, net.IPv6len)
+	copy(extIP, body[20:36])
+	if ip4 := extIP.To4(); ip4 != nil {
+		extIP = ip4
+	}
+	return Mapping{
+		Protocol:     strings.ToLower(protocol),
+		InternalPort: intport,
+		ExternalPort: int(binary.BigEndian.Uint16(body[18:])),
+		ExternalIP:   extIP,
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(resp[4:])) * time.Second,
+	}, nil
+}
+
+// nonce returns the nonce of a mapping, creating it if necessary.
+func (n *pcp) nonce(proto byte, intport int) ([12]byte, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	key := fmt.Sprintf("%d/%d", proto, intport)
+	nonce, ok := n.nonces[key]
+	if !ok {
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return nonce, err
+		}
+		n.nonces[key] = nonce
+	}
+	return nonce, nil
+}
+
+// pcpEncodeHeader writes the common request header.
+func pcpEncodeHeader(b []byte, op byte, lifetime uint32, clientIP net.IP) {
+	b[0] = pcpVersion
+	b[1] = op
+	binary.BigEndian.PutUint32(b[4:], lifetime)
+	copy(b[8:24], clientIP.To16())
+}
+
+// pcpRequest sends a request and waits for the matching response, retransmitting
+// the request with increasing timeouts.
+func pcpRequest(conn *net.UDPConn, req []byte) ([]byte, error) {
+	buf := make([]byte, pcpMaxPacket)
+	timeout := pcpInitialRetry
+	for i := 0; i < pcpRetries; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		conn.SetReadDeadline(deadline)
+		for {
+			nbytes, err := conn.Read(buf)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			resp := buf[:nbytes]
+			if nbytes < pcpHeaderSize || resp[1] != req[1]|pcpResponse {
+				continue // not a response to this request
+			}
+			if resp[0] != pcpVersion {
+				// NAT-PMP gateways answer with their own version number.
+				return nil, pcpError(1)
+			}
+			if resp[3] != 0 {
+				return nil, pcpError(resp[3])
+			}
+			return resp, nil
+		}
+		timeout *= 2
+	}
+	return nil, errPCPTimeout
+}
+
+// pcpAnnounce checks whether a PCP server is running at the given address.
+func pcpAnnounce(gw *net.UDPAddr, timeout time.Duration) error {
+	conn, err := net.DialUDP("udp", nil, gw)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := make([]byte, pcpHeaderSize)
+	pcpEncodeHeader(req, pcpOpAnnounce, 0, conn.LocalAddr().(*net.UDPAddr).IP)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, pcpMaxPacket)
+	for {
+		nbytes, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if nbytes >= pcpHeaderSize && buf[0] == pcpVersion && buf[1] == pcpOpAnnounce|pcpResponse {
+			if buf[3] != 0 {
+				return pcpError(buf[3])
+			}
+			return nil
+		}
+	}
+}
+
+func discoverPCP() Interface {
+	// Probe all potential gateways and use the first one that answers.
+	gws := potentialGateways()
+	found := make(chan *pcp, len(gws))
+	for i := range gws {
+		gw := &net.UDPAddr{IP: gws[i], Port: pcpPort}
+		go func() {
+			if err := pcpAnnounce(gw, 1*time.Second); err != nil {
+				found <- nil
+			} else {
+				found <- newPCP(gw)
+			}
+		}()
+	}
+	for range gws {
+		if c := <-found; c != nil {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package nat
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePCP is a PCP server listening on the loopback interface.
+type fakePCP struct {
+	conn     *net.UDPConn
+	extIP    net.IP
+	lifetime uint32 // maximum lifetime granted in seconds
+
+	mu       sync.Mutex
+	result   byte // result code of MAP responses
+	drop     int  // number of requests to ignore
+	requests []fakePCPRequest
+}
+
+type fakePCPRequest struct {
+	op       byte
+	lifetime uint32
+	nonce    [12]byte
+	proto    byte
+	intport  uint16
+	extport  uint16
+}
+
+func startFakePCP(t *testing.T, lifetime uint32) *fakePCP {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := &fakePCP{conn: conn, extIP: net.IP{203, 0, 113, 7}, lifetime: lifetime}
+	go gw.serve()
+	return gw
+}
+
+func (gw *fakePCP) addr() *net.UDPAddr {
+	return gw.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (gw *fakePCP) close() {
+	gw.conn.Close()
+}
+
+func (gw *fakePCP) setResult(code byte) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	gw.result = code
+}
+
+func (gw *fakePCP) received() []fakePCPRequest {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return append([]fakePCPRequest{}, gw.requests...)
+}
+
+func (gw *fakePCP) serve() {
+	buf := make([]byte, pcpMaxPacket)
+	for {
+		n, from, err := gw.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if resp := gw.handle(buf[:n]); resp != nil {
+			gw.conn.WriteToUDP(resp, from)
+		}
+	}
+}
+
+func (gw *fakePCP) handle(req []byte) []byte {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if len(req) < pcpHeaderSize {
+		return nil
+	}
+	if gw.drop > 0 {
+		gw.drop--
+		return nil
+	}
+	r := fakePCPRequest{op: req[1], lifetime: binary.BigEndian.Uint32(req[4:])}
+	resp := make([]byte, pcpHeaderSize, pcpHeaderSize+pcpMapSize)
+	resp[0] = pcpVersion
+	resp[1] = req[1] | pcpResponse
+	switch {
+	case req[0] != pcpVersion:
+		resp[3] = 1 // UNSUPP_VERSION
+	case r.op == pcpOpAnnounce:
+	case r.op == pcpOpMap && len(req) >= pcpHeaderSize+pcpMapSize:
+		body := req[pcpHeaderSize:]
+		copy(r.nonce[:], body)
+		r.proto = body[12]
+		r.intport = binary.BigEndian.Uint16(body[16:])
+		r.extport = binary.BigEndian.Uint16(body[18:])
+
+		resp[3] = gw.result
+		lifetime := r.lifetime
+		if lifetime > gw.lifetime {
+			lifetime = gw.lifetime
+		}
+		binary.BigEndian.PutUint32(resp[4:], lifetime)
+		// The gateway assigns a different external port.
+		resp = resp[:pcpHeaderSize+pcpMapSize]
+		copy(resp[pcpHeaderSize:], body[:pcpMapSize])
+		binary.BigEndian.PutUint16(resp[pcpHeaderSize+18:], r.intport+1)
+		copy(resp[pcpHeaderSize+20:], gw.extIP.To16())
+	default:
+		resp[3] = 4 // UNSUPP_OPCODE
+	}
+	gw.requests = append(gw.requests, r)
+	return resp
+}
+
+func TestPCPMapping(t *testing.T) {
+	gw := startFakePCP(t, 3600)
+	defer gw.close()
+	n := newPCP(gw.addr())
+
+	if _, err := n.ExternalIP(); err != errPCPNoIP {
+		t.Fatalf("wrong error %v before mapping, want %v", err, errPCPNoIP)
+	}
+	m, err := n.addMapping("TCP", 30303, 30303, "test", mapTimeout)
+	if err != nil {
+		t.Fatal("mapping failed:", err)
+	}
+	want := Mapping{Protocol: "tcp", InternalPort: 30303, ExternalPort: 30304, ExternalIP: gw.extIP, Lifetime: mapTimeout}
+	if m.Protocol != want.Protocol || m.InternalPort != want.InternalPort || m.ExternalPort != want.ExternalPort ||
+		!m.ExternalIP.Equal(want.ExternalIP) || m.Lifetime != want.Lifetime {
+		t.Fatalf("wrong mapping %+v, want %+v", m, want)
+	}
+	if ip, err := n.ExternalIP(); err != nil || !ip.Equal(gw.extIP) {
+		t.Fatalf("wrong external IP %v (err %v), want %v", ip, err, gw.extIP)
+	}
+	if err := n.DeleteMapping("TCP", 30303, 30303); err != nil {
+		t.Fatal("deleting mapping failed:", err)
+	}
+
+	reqs := gw.received()
+	if len(reqs) != 2 {
+		t.Fatalf("gateway received %d requests, want 2", len(reqs))
+	}
+	if reqs[0].proto != pcpProtoTCP || reqs[0].intport != 30303 || reqs[0].extport != 30303 || reqs[0].lifetime != uint32(mapTimeout/time.Second) {
+		t.Errorf("wrong MAP request %+v", reqs[0])
+	}
+	if reqs[1].lifetime != 0 || reqs[1].nonce != reqs[0].nonce {
+		t.Errorf("delete request %+v doesn't match mapping", reqs[1])
+	}
+}
+
+func TestPCPErrors(t *testing.T) {
+	gw := startFakePCP(t, 3600)
+	defer gw.close()
+	n := newPCP(gw.addr())
+
+	gw.setResult(8)
+	_, err := n.addMapping("UDP", 30303, 30303, "test", mapTimeout)
+	if err != pcpError(8) {
+		t.Fatalf("wrong error %v, want %v", err, pcpError(8))
+	}
+	if err.Error() != "PCP error NO_RESOURCES" {
+		t.Errorf("wrong error message %q", err)
+	}
+	if _, err := n.addMapping("SCTP", 30303, 30303, "test", mapTimeout); err == nil {
+		t.Error("mapping unsupported protocol succeeded")
+	}
+}
+
+// This test checks that requests are retransmitted when the gateway doesn't
+// respond.
+func TestPCPRetransmit(t *testing.T) {
+	gw := startFakePCP(t, 3600)
+	defer gw.close()
+	gw.mu.Lock()
+	gw.drop = 1
+	gw.mu.Unlock()
+
+	if _, err := newPCP(gw.addr()).addMapping("UDP", 30303, 30303, "test", mapTimeout); err != nil {
+		t.Fatal("mapping failed:", err)
+	}
+	if reqs := gw.received(); len(reqs) != 1 {
+		t.Fatalf("gateway answered %d requests, want 1", len(reqs))
+	}
+}
+
+func TestPCPAnnounce(t *testing.T) {
+	gw := startFakePCP(t, 3600)
+	defer gw.close()
+
+	if err := pcpAnnounce(gw.addr(), time.Second); err != nil {
+		t.Fatal("announce failed:", err)
+	}
+}
+
+// This test checks that Map renews mappings before the lifetime granted by the
+// gateway ends and deletes them when stopped.
+func TestMapRenewal(t *testing.T) {
+	gw := startFakePCP(t, 2)
+	defer gw.close()
+
+	var (
+		quit   = make(chan struct{})
+		done   = make(chan struct{})
+		status = make(chan MapStatus, 10)
+	)
+	go func() {
+		MapWithStatus(newPCP(gw.addr()), quit, "udp", 30303, 30303, "test", func(s MapStatus) { status <- s })
+		close(done)
+	}()
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-status:
+			if s.Err != nil {
+				t.Fatalf("mapping %d failed: %v", i, s.Err)
+			}
+			if s.ExternalPort != 30304 || s.Lifetime != 2*time.Second {
+				t.Fatalf("wrong mapping %+v", s.Mapping)
+			}
+		case <-timeout:
+			t.Fatalf("mapping %d not created", i)
+		}
+	}
+	close(quit)
+	<-done
+
+	reqs := gw.received()
+	if last := reqs[len(reqs)-1]; last.lifetime != 0 {
+		t.Errorf("mapping not deleted, last request %+v", last)
+	}
+}
+
+func TestMapFailure(t *testing.T) {
+	gw := startFakePCP(t, 3600)
+	defer gw.close()
+	gw.setResult(2)
+
+	var (
+		quit   = make(chan struct{})
+		status = make(chan MapStatus, 10)
+	)
+	defer close(quit)
+	go MapWithStatus(newPCP(gw.addr()), quit, "tcp", 30303, 30303, "test", func(s MapStatus) { status <- s })
+
+	select {
+	case s := <-status:
+		if s.Err != pcpError(2) || s.Failures != 1 {
+			t.Fatalf("wrong status %+v", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no status reported")
+	}
+}
+
+// This test checks that mappings granted without lifetime are retried with
+// backoff instead of being renewed immediately.
+func TestMapZeroLifetime(t *testing.T) {
+	gw := startFakePCP(t, 0)
+	defer gw.close()
+
+	var (
+		quit   = make(chan struct{})
+		status = make(chan MapStatus, 10)
+	)
+	defer close(quit)
+	go MapWithStatus(newPCP(gw.addr()), quit, "tcp", 30303, 30303, "test", func(s MapStatus) { status <- s })
+
+	select {
+	case s := <-status:
+		if s.Err != errNoLifetime || s.Failures != 1 {
+			t.Fatalf("wrong status %+v", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no status reported")
+	}
+	select {
+	case s := <-status:
+		t.Fatalf("mapping retried without backoff: %+v", s)
+	case <-time.After(2 * mapMinRenewal):
+	}
+}
+
+func TestRenewalInterval(t *testing.T) {
+	tests := []struct {
+		lifetime, want time.Duration
+	}{
+		{2 * time.Hour, mapUpdateInterval},
+		{mapTimeout, mapUpdateInterval},
+		{2 * time.Minute, time.Minute},
+		{0, mapMinRenewal},
+	}
+	for _, test := range tests {
+		if got := renewalInterval(test.lifetime); got != test.want {
+			t.Errorf("renewalInterval(%v) = %v, want %v", test.lifetime, got, test.want)
+		}
+	}
+}
+
+func TestParsePCP(t *testing.T) {
+	m, err := Parse("pcp:192.168.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, ok := m.(*pcp)
+	if !ok {
+		t.Fatalf("wrong type %T", m)
+	}
+	if want := (&net.UDPAddr{IP: net.IP{192, 168, 0, 1}, Port: pcpPort}); n.gw.String() != want.String() {
+		t.Fatalf("wrong gateway address %v, want %v", n.gw, want)
+	}
+}
@@ -45,13 +45,28 @@ func (n *pmp) ExternalIP() (net.IP, error) {
 }
 
 func (n *pmp) AddMapping(protocol string, extport, intport int, name string, lifetime time.Duration) error {
+	_, err := n.addMapping(protocol, extport, intport, name, lifetime)
+	return err
+}
+
+func (n *pmp) addMapping(protocol string, extport, intport int, name string, lifetime time.Duration) (Mapping, error) {
 	if lifetime <= 0 {
-		return fmt.Errorf("lifetime must not be <= 0")
+		return Mapping{}, fmt.Errorf("lifetime must not be <= 0")
 	}
 	// Note order of port arguments is switched between our
 	// AddMapping and the client's AddPortMapping.
-	_, err := n.c.AddPortMapping(strings.ToLower(protocol), intport, extport, int(lifetime/time.Second))
-	return err
+	res, err := n.c.AddPortMapping(strings.ToLower(protocol), intport, extport, int(lifetime/time.Second))
+	if err != nil {
+		return Mapping{}, err
+	}
+	ip, _ := n.ExternalIP()
+	return Mapping{
+		Protocol:     strings.ToLower(protocol),
+		InternalPort: intport,
+		ExternalPort: int(res.MappedExternalPort),
+		ExternalIP:   ip,
+		Lifetime:     time.Duration(res.PortMappingLifetimeInSeconds) * time.Second,
+	}, nil
 }
 
 func (n *pmp) DeleteMapping(protocol string, extport, intport int) (err error) {
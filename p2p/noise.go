@@ -0,0 +1,459 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+
+	"github.com/golang/snappy"
+	"github.com/severeum/go-severeum/common/math"
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+// The Noise transport is an alternative to the RLPx encryption handshake and
+// framing. It runs the Noise XK handshake pattern with the secp256k1 node keys,
+// which provides forward secrecy for all handshake messages, and encrypts the
+// devp2p messages with AES-GCM. Everything above the framing layer, including
+// the devp2p protocol handshake, is the same as in RLPx.
+//
+// The initiator sends noiseMagic before the first handshake message, which
+// allows the listener to accept both RLPx and Noise connections on the same
+// port. The magic can't be mistaken for the start of an RLPx handshake because
+// it would encode an impossible EIP-8 auth message size.
+
+const (
+	noiseProtocolName = "Noise_XK_secp256k1_AESGCM_SHA256"
+	noiseVersion      = 1
+
+	noiseKeyLen      = 33 // Compressed public key size
+	noiseTagLen      = 16 // AES-GCM tag size
+	noiseMaxFrameLen = 65535
+	noiseMaxChunkLen = noiseMaxFrameLen - noiseTagLen
+	noiseMsgHeadLen  = 4 // Size of a message's length prefix
+)
+
+var (
+	noiseMagic    = []byte("\x00\x00noise1")
+	noisePrologue = []byte("devp2p noise transport v1")
+
+	errNoiseBadMagic   = errors.New("noise: bad magic")
+	errNoiseBadMessage = errors.New("noise: invalid handshake message")
+	errNoiseDecrypt    = errors.New("noise: decryption failed")
+)
+
+// noiseEntry is the ENR entry which advertises support for the Noise transport.
+type noiseEntry struct {
+	Version uint
+	Rest    []rlp.RawValue `rlp:"tail"`
+}
+
+func (noiseEntry) ENRKey() string { return "noise" }
+
+// noiseTransport is the Noise variant of the RLPx transport. It reuses the
+// protocol handshake, message handling and traffic control of rlpx.
+type noiseTransport struct {
+	*rlpx
+}
+
+func newNoiseTransport(fd net.Conn) transport {
+	return &noiseTransport{newRLPX(fd).(*rlpx)}
+}
+
+// doEncHandshake runs the Noise XK handshake. The initiator (dial != nil) must
+// know the static key of the responder.
+func (t *noiseTransport) doEncHandshake(prv *ecdsa.PrivateKey, dial *ecdsa.PublicKey) (*ecdsa.PublicKey, error) {
+	var (
+		remote     *ecdsa.PublicKey
+		send, recv *noiseCipher
+		err        error
+	)
+	if dial == nil {
+		remote, send, recv, err = noiseRespond(t.fd, prv)
+	} else {
+		remote, send, recv, err = noiseInitiate(t.fd, prv, dial)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.wmu.Lock()
+	t.rw = &noiseFrameRW{conn: t.fd, enc: send, dec: recv}
+	t.wmu.Unlock()
+	return remote, nil
+}
+
+// noiseInitiate performs the initiator side of the handshake:
+//
+//	<- s
+//	-> e, es
+//	<- e, ee
+//	-> s, se
+func noiseInitiate(conn io.ReadWriter, prv *ecdsa.PrivateKey, remote *ecdsa.PublicKey) (*ecdsa.PublicKey, *noiseCipher, *noiseCipher, error) {
+	hs := newNoiseSymmetric()
+	hs.mixHash(crypto.CompressPubkey(remote))
+
+	// -> e, es
+	e, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	epub := crypto.CompressPubkey(&e.PublicKey)
+	hs.mixHash(epub)
+	hs.mixKey(noiseDH(e, remote))
+	msg := append(epub, hs.encryptAndHash(nil)...)
+	if _, err := conn.Write(noiseMagic); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := writeNoiseFrame(conn, msg); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// <- e, ee
+	if msg, err = readNoiseFrame(conn); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(msg) != noiseKeyLen+noiseTagLen {
+		return nil, nil, nil, errNoiseBadMessage
+	}
+	re, err := crypto.DecompressPubkey(msg[:noiseKeyLen])
+	if err != nil {
+		return nil, nil, nil, errNoiseBadMessage
+	}
+	hs.mixHash(msg[:noiseKeyLen])
+	hs.mixKey(noiseDH(e, re))
+	if _, err := hs.decryptAndHash(msg[noiseKeyLen:]); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// -> s, se
+	msg = hs.encryptAndHash(crypto.CompressPubkey(&prv.PublicKey))
+	hs.mixKey(noiseDH(prv, re))
+	msg = append(msg, hs.encryptAndHash(nil)...)
+	if err := writeNoiseFrame(conn, msg); err != nil {
+		return nil, nil, nil, err
+	}
+	send, recv := hs.split()
+	return remote, send, recv, nil
+}
+
+// noiseRespond performs the responder side of the handshake.
+func noiseRespond(conn io.ReadWriter, prv *ecdsa.PrivateKey) (*ecdsa.PublicKey, *noiseCipher, *noiseCipher, error) {
+	hs := newNoiseSymmetric()
+	hs.mixHash(crypto.CompressPubkey(&prv.PublicKey))
+
+	// -> e, es
+	magic := make([]byte, len(noiseMagic))
+	if _, err := io.ReadFull(conn, magic); err != nil {
+		return nil, nil, nil, err
+	}
+	if !bytes.Equal(magic, noiseMagic) {
+		return nil, nil, nil, errNoiseBadMagic
+	}
+	msg, err := readNoiseFrame(conn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(msg) != noiseKeyLen+noiseTagLen {
+		return nil, nil, nil, errNoiseBadMessage
+	}
+	re, err := crypto.DecompressPubkey(msg[:noiseKeyLen])
+	if err != nil {
+		return nil, nil, nil, errNoiseBadMessage
+	}
+	hs.mixHash(msg[:noiseKeyLen])
+	hs.mixKey(noiseDH(prv, re))
+	if _, err := hs.decryptAndHash(msg[noiseKeyLen:]); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// <- e, ee
+	e, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	epub := crypto.CompressPubkey(&e.PublicKey)
+	hs.mixHash(epub)
+	hs.mixKey(noiseDH(e, re))
+	if err := writeNoiseFrame(conn, append(epub, hs.encryptAndHash(nil)...)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// -> s, se
+	if msg, err = readNoiseFrame(conn); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(msg) != noiseKeyLen+2*noiseTagLen {
+		return nil, nil, nil, errNoiseBadMessage
+	}
+	spub, err := hs.decryptAndHash(msg[:noiseKeyLen+noiseTagLen])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	remote, err := crypto.DecompressPubkey(spub)
+	if err != nil {
+		return nil, nil, nil, errNoiseBadMessage
+	}
+	hs.mixKey(noiseDH(e, remote))
+	if _, err := hs.decryptAndHash(msg[noiseKeyLen+noiseTagLen:]); err != nil {
+		return nil, nil, nil, err
+	}
+	recv, send := hs.split()
+	return remote, send, recv, nil
+}
+
+// noiseDH computes the secp256k1 Diffie-Hellman function, i.e. the X coordinate
+// of the shared point.
+func noiseDH(prv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) []byte {
+	x, _ := crypto.S256().ScalarMult(pub.X, pub.Y, math.PaddedBigBytes(prv.D, 32))
+	return math.PaddedBigBytes(x, 32)
+}
+
+// noiseSymmetric is the SymmetricState object of the Noise specification.
+type noiseSymmetric struct {
+	ck, h []byte
+	c     *noiseCipher
+}
+
+func newNoiseSymmetric() *noiseSymmetric {
+	// The protocol name is exactly 32 bytes long, so it is used as the
+	// initial hash directly.
+	h := []byte(noiseProtocolName)
+	s := &noiseSymmetric{ck: h, h: h}
+	s.mixHash(noisePrologue)
+	return s
+}
+
+func (s *noiseSymmetric) mixHash(data []byte) {
+	d := sha256.New()
+	d.Write(s.h)
+	d.Write(data)
+	s.h = d.Sum(nil)
+}
+
+func (s *noiseSymmetric) mixKey(ikm []byte) {
+	var k []byte
+	s.ck, k = noiseHKDF(s.ck, ikm)
+	s.c = newNoiseCipher(k)
+}
+
+func (s *noiseSymmetric) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := s.c.encrypt(plaintext, s.h)
+	s.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (s *noiseSymmetric) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.c.decrypt(ciphertext, s.h)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split derives the transport ciphers of the initiator and the responder.
+func (s *noiseSymmetric) split() (initiator, responder *noiseCipher) {
+	k1, k2 := noiseHKDF(s.ck, nil)
+	return newNoiseCipher(k1), newNoiseCipher(k2)
+}
+
+// noiseHKDF is the HKDF function of the Noise specification with two outputs.
+func noiseHKDF(ck, ikm []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{1})
+	out1 := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1)
+	mac.Write([]byte{2})
+	return out1, mac.Sum(nil)
+}
+
+// noiseCipher is the CipherState object of the Noise specification.
+type noiseCipher struct {
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+func newNoiseCipher(key []byte) *noiseCipher {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic("noise: invalid key: " + err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic("noise: can't create GCM: " + err.Error())
+	}
+	return &noiseCipher{aead: aead}
+}
+
+func (c *noiseCipher) nextNonce() []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], c.nonce)
+	c.nonce++
+	return nonce
+}
+
+func (c *noiseCipher) encrypt(plaintext, ad []byte) []byte {
+	return c.aead.Seal(nil, c.nextNonce(), plaintext, ad)
+}
+
+func (c *noiseCipher) decrypt(ciphertext, ad []byte) ([]byte, error) {
+	plaintext, err := c.aead.Open(nil, c.nextNonce(), ciphertext, ad)
+	if err != nil {
+		return nil, errNoiseDecrypt
+	}
+	return plaintext, nil
+}
+
+func writeNoiseFrame(w io.Writer, data []byte) error {
+	buf := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(buf, uint16(len(data)))
+	copy(buf[2:], data)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readNoiseFrame(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// noiseFrameRW implements the message framing of the Noise transport. A message
+// consists of the 4 byte length of its content, the RLP encoded message code and
+// the payload. This byte stream is split into chunks, each of which is sent as a
+// separately encrypted, length-prefixed Noise transport message.
+type noiseFrameRW struct {
+	conn     io.ReadWriter
+	enc, dec *noiseCipher
+	snappy   bool
+}
+
+func (rw *noiseFrameRW) setSnappy(enabled bool) {
+	rw.snappy = enabled
+}
+
+func (rw *noiseFrameRW) WriteMsg(msg Msg) error {
+	ptype, _ := rlp.EncodeToBytes(msg.Code)
+	payload, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return err
+	}
+	if rw.snappy {
+		if len(payload) > int(maxUint24) {
+			return errPlainMessageTooLarge
+		}
+		payload = snappy.Encode(nil, payload)
+	}
+	size := len(ptype) + len(payload)
+	if size > int(maxUint24) {
+		return errors.New("message size overflows uint24")
+	}
+	content := make([]byte, noiseMsgHeadLen, noiseMsgHeadLen+size)
+	binary.BigEndian.PutUint32(content, uint32(size))
+	content = append(content, ptype...)
+	content = append(content, payload...)
+
+	for len(content) > 0 {
+		n := len(content)
+		if n > noiseMaxChunkLen {
+			n = noiseMaxChunkLen
+		}
+		if err := writeNoiseFrame(rw.conn, rw.enc.encrypt(content[:n], nil)); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	return nil
+}
+
+func (rw *noiseFrameRW) ReadMsg() (msg Msg, err error) {
+	chunk, err := rw.readChunk()
+	if err != nil {
+		return msg, err
+	}
+	if len(chunk) < noiseMsgHeadLen {
+		return msg, errors.New("noise: short message")
+	}
+	size := binary.BigEndian.Uint32(chunk)
+	if size > maxUint24 {
+		return msg, errors.New("noise: message too large")
+	}
+	content := make([]byte, 0, size)
+	content = append(content, chunk[noiseMsgHeadLen:]...)
+	for uint32(len(content)) < size {
+		if chunk, err = rw.readChunk(); err != nil {
+			return msg, err
+		}
+		content = append(content, chunk...)
+	}
+	if uint32(len(content)) != size {
+		return msg, fmt.Errorf("noise: message size mismatch: got %d, want %d", len(content), size)
+	}
+
+	// Decode the message code and decompress the payload.
+	r := bytes.NewReader(content)
+	if err := rlp.Decode(r, &msg.Code); err != nil {
+		return msg, err
+	}
+	msg.Size, msg.Payload = uint32(r.Len()), r
+	if rw.snappy {
+		payload := content[len(content)-r.Len():]
+		dsize, err := snappy.DecodedLen(payload)
+		if err != nil {
+			return msg, err
+		}
+		if dsize > int(maxUint24) {
+			return msg, errPlainMessageTooLarge
+		}
+		if payload, err = snappy.Decode(nil, payload); err != nil {
+			return msg, err
+		}
+		msg.Size, msg.Payload = uint32(dsize), bytes.NewReader(payload)
+	}
+	return msg, nil
+}
+
+func (rw *noiseFrameRW) readChunk() ([]byte, error) {
+	frame, err := readNoiseFrame(rw.conn)
+	if err != nil {
+		return nil, err
+	}
+	return rw.dec.decrypt(frame, nil)
+}
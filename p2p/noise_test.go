@@ -0,0 +1,179 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/crypto"
+)
+
+func TestNoiseHandshake(t *testing.T) {
+	var (
+		prv0, prv1 = newkey(), newkey()
+		fd0, fd1   = net.Pipe()
+		t0, t1     = newNoiseTransport(fd0), newNoiseTransport(fd1)
+		errc       = make(chan error, 1)
+	)
+	defer fd0.Close()
+	defer fd1.Close()
+
+	go func() {
+		remote, err := t1.doEncHandshake(prv1, nil)
+		if err == nil && !remote.Equal(&prv0.PublicKey) {
+			err = fmt.Errorf("responder: wrong remote key %x", crypto.FromECDSAPub(remote))
+		}
+		errc <- err
+	}()
+	remote, err := t0.doEncHandshake(prv0, &prv1.PublicKey)
+	if err != nil {
+		t.Fatal("initiator:", err)
+	}
+	if !remote.Equal(&prv1.PublicKey) {
+		t.Fatalf("initiator: wrong remote key %x", crypto.FromECDSAPub(remote))
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// Exchange messages of various sizes in both directions, both with and
+	// without snappy compression.
+	sizes := []int{0, 1, noiseMaxChunkLen - 10, noiseMaxChunkLen, 3*noiseMaxChunkLen + 1}
+	for _, snappy := range []bool{false, true} {
+		t0.(*noiseTransport).rw.setSnappy(snappy)
+		t1.(*noiseTransport).rw.setSnappy(snappy)
+		for _, size := range sizes {
+			payload := make([]byte, size)
+			for i := range payload {
+				payload[i] = byte(i)
+			}
+			checkNoiseMsg(t, t0, t1, payload)
+			checkNoiseMsg(t, t1, t0, payload)
+		}
+	}
+}
+
+func checkNoiseMsg(t *testing.T, from, to transport, payload []byte) {
+	go from.WriteMsg(Msg{Code: 7, Size: uint32(len(payload)), Payload: bytes.NewReader(payload)})
+	msg, err := to.ReadMsg()
+	if err != nil {
+		t.Fatalf("size %d: read error: %v", len(payload), err)
+	}
+	data, _ := ioutil.ReadAll(msg.Payload)
+	if msg.Code != 7 || msg.Size != uint32(len(payload)) || !bytes.Equal(data, payload) {
+		t.Fatalf("size %d: wrong message code %d, size %d", len(payload), msg.Code, msg.Size)
+	}
+}
+
+func TestNoiseHandshakeWrongKey(t *testing.T) {
+	var (
+		fd0, fd1 = net.Pipe()
+		errc     = make(chan error, 1)
+	)
+	defer fd0.Close()
+	defer fd1.Close()
+
+	go func() {
+		_, err := newNoiseTransport(fd1).doEncHandshake(newkey(), nil)
+		fd1.Close()
+		errc <- err
+	}()
+	// The initiator expects a different responder key.
+	if _, err := newNoiseTransport(fd0).doEncHandshake(newkey(), &newkey().PublicKey); err == nil {
+		t.Error("initiator: handshake succeeded")
+	}
+	if err := <-errc; err != errNoiseDecrypt {
+		t.Errorf("responder: got error %v, want %v", err, errNoiseDecrypt)
+	}
+}
+
+// This test checks that servers with and without Noise support can connect to
+// each other, and that Noise is used when both sides support it.
+func TestServerNoiseInterop(t *testing.T) {
+	tests := []struct {
+		dialerNoise, listenerNoise bool
+		wantNoise                  bool
+	}{
+		{false, false, false},
+		{true, false, false},
+		{false, true, false},
+		{true, true, true},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("dialer=%v/listener=%v", test.dialerNoise, test.listenerNoise)
+		t.Run(name, func(t *testing.T) {
+			dialer, dialerPeers := startTransportTestServer(t, func(c *Config) { c.EnableNoise = test.dialerNoise })
+			defer dialer.Stop()
+			listener, listenerPeers := startTransportTestServer(t, func(c *Config) { c.EnableNoise = test.listenerNoise })
+			defer listener.Stop()
+
+			dialer.AddPeer(listener.Self())
+			for _, peers := range []chan *Peer{dialerPeers, listenerPeers} {
+				select {
+				case p := <-peers:
+					_, isNoise := p.rw.transport.(*noiseTransport)
+					if isNoise != test.wantNoise {
+						t.Errorf("wrong transport %T", p.rw.transport)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("peers did not exchange messages")
+				}
+			}
+		})
+	}
+}
+
+// startTransportTestServer starts a server which reports peers after they have
+// exchanged a message.
+func startTransportTestServer(t *testing.T, config func(*Config)) (*Server, chan *Peer) {
+	peers := make(chan *Peer, 1)
+	srv := &Server{Config: Config{
+		PrivateKey:  newkey(),
+		MaxPeers:    10,
+		ListenAddr:  "127.0.0.1:0",
+		NoDiscovery: true,
+		Protocols: []Protocol{{
+			Name:    "test",
+			Version: 1,
+			Length:  1,
+			Run: func(p *Peer, rw MsgReadWriter) error {
+				if err := SendItems(rw, 0, "hello"); err != nil {
+					return err
+				}
+				if err := ExpectMsg(rw, 0, []string{"hello"}); err != nil {
+					return err
+				}
+				peers <- p
+				for {
+					if _, err := rw.ReadMsg(); err != nil {
+						return err
+					}
+				}
+			},
+		}},
+	}}
+	config(&srv.Config)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	return srv, peers
+}
@@ -103,6 +103,7 @@ type PeerEvent struct {
 type Peer struct {
 	rw      *conn
 	running map[string]*protoRW
+	idle    map[string]*protoRW // matched protocols not started due to their peer limits
 	log     log.Logger
 	created mclock.AbsTime
 
@@ -110,6 +111,8 @@ type Peer struct {
 	protoErr chan error
 	closed   chan struct{}
 	disc     chan DiscReason
+	score    peerScore
+	msgs     *msgTracker
 
 	// events receives message send / receive events if set
 	events *event.Feed
@@ -178,13 +181,20 @@ func (p *Peer) Inbound() bool {
 
 func newPeer(conn *conn, protocols []Protocol) *Peer {
 	protomap := matchProtocols(protocols, conn.caps, conn)
+	idle := make(map[string]*protoRW)
+	for name := range conn.full {
+		idle[name] = protomap[name]
+		delete(protomap, name)
+	}
 	p := &Peer{
 		rw:       conn,
 		running:  protomap,
+		idle:     idle,
 		created:  mclock.Now(),
 		disc:     make(chan DiscReason),
 		protoErr: make(chan error, len(protomap)+1), // protocols + pingLoop
 		closed:   make(chan struct{}),
+		msgs:     newMsgTracker(),
 		log:      log.New("id", conn.node.ID(), "conn", conn.flags),
 	}
 	return p
@@ -196,18 +206,26 @@ func (p *Peer) Log() log.Logger {
 
 func (p *Peer) run() (remoteRequested bool, err error) {
 	var (
+		readers    = p.readers()
 		writeStart = make(chan struct{}, 1)
 		writeErr   = make(chan error, 1)
-		readErr    = make(chan error, 1)
+		readErr    = make(chan error, len(readers))
 		reason     DiscReason // sent to the peer
 	)
-	p.wg.Add(2)
-	go p.readLoop(readErr)
+	p.wg.Add(len(readers) + 1)
+	for _, read := range readers {
+		go p.readLoop(read, readErr)
+	}
 	go p.pingLoop()
 
-	// Start all protocol handlers.
-	writeStart <- struct{}{}
-	p.startProtocols(writeStart, writeErr)
+	// Start all protocol handlers. Multiplexed transports interleave the
+	// messages of all protocols, their writes don't need to wait for each other.
+	if _, ok := p.rw.transport.(streamTransport); ok {
+		p.startProtocols(nil, writeErr)
+	} else {
+		writeStart <- struct{}{}
+		p.startProtocols(writeStart, writeErr)
+	}
 
 	// Wait for an error or disconnect.
 loop:
@@ -230,6 +248,9 @@ loop:
 			}
 			break loop
 		case err = <-p.protoErr:
+			if isProtocolViolation(err) {
+				p.score.add(scoreDelta(ScoreInvalid, 0, 0), time.Now())
+			}
 			reason = discReasonForError(err)
 			break loop
 		case err = <-p.disc:
@@ -262,10 +283,25 @@ func (p *Peer) pingLoop() {
 	}
 }
 
-func (p *Peer) readLoop(errc chan<- error) {
+// readers returns the message sources of the connection. Multiplexed transports
+// provide one per stream, all others a single one.
+func (p *Peer) readers() []func() (Msg, error) {
+	st, ok := p.rw.transport.(streamTransport)
+	if !ok {
+		return []func() (Msg, error){p.rw.ReadMsg}
+	}
+	readers := make([]func() (Msg, error), st.streams())
+	for i := range readers {
+		id := i
+		readers[i] = func() (Msg, error) { return st.readStream(id) }
+	}
+	return readers
+}
+
+func (p *Peer) readLoop(read func() (Msg, error), errc chan<- error) {
 	defer p.wg.Done()
 	for {
-		msg, err := p.rw.ReadMsg()
+		msg, err := read()
 		if err != nil {
 			errc <- err
 			return
@@ -296,8 +332,12 @@ func (p *Peer) handle(msg Msg) error {
 		// it's a subprotocol message
 		proto, err := p.getProto(msg.Code)
 		if err != nil {
+			if p.idleProto(msg.Code) {
+				return msg.Discard()
+			}
 			return fmt.Errorf("msg code out of range: %v", msg.Code)
 		}
+		p.msgs.received(proto.Name, msg.Code-proto.offset, msg.Size)
 		select {
 		case proto.in <- msg:
 			return nil
@@ -352,6 +392,7 @@ func (p *Peer) startProtocols(writeStart <-chan struct{}, writeErr chan<- error)
 		proto.closed = p.closed
 		proto.wstart = writeStart
 		proto.werr = writeErr
+		proto.msgs = p.msgs
 		var rw MsgReadWriter = proto
 		if p.events != nil {
 			rw = newMsgEventer(rw, p.events, p.ID(), proto.Name)
@@ -382,12 +423,24 @@ func (p *Peer) getProto(code uint64) (*protoRW, error) {
 	return nil, newPeerError(errInvalidMsgCode, "%d", code)
 }
 
+// idleProto reports whether the message code belongs to a protocol which was
+// matched, but not started.
+func (p *Peer) idleProto(code uint64) bool {
+	for _, proto := range p.idle {
+		if code >= proto.offset && code < proto.offset+proto.Length {
+			return true
+		}
+	}
+	return false
+}
+
 type protoRW struct {
 	Protocol
 	in     chan Msg        // receives read messages
 	closed <-chan struct{} // receives when peer is shutting down
 	wstart <-chan struct{} // receives when write may start
 	werr   chan<- error    // for write results
+	msgs   *msgTracker     // for traffic accounting
 	offset uint64
 	w      MsgWriter
 }
@@ -396,10 +449,26 @@ func (rw *protoRW) WriteMsg(msg Msg) (err error) {
 	if msg.Code >= rw.Length {
 		return newPeerError(errInvalidMsgCode, "not handled")
 	}
+	code, size := msg.Code, msg.Size
 	msg.Code += rw.offset
+	if rw.wstart == nil {
+		// The transport multiplexes protocols, only failures are reported.
+		select {
+		case <-rw.closed:
+			return ErrShuttingDown
+		default:
+		}
+		if err = rw.write(msg, code, size); err != nil {
+			select {
+			case rw.werr <- err:
+			case <-rw.closed:
+			}
+		}
+		return err
+	}
 	select {
 	case <-rw.wstart:
-		err = rw.w.WriteMsg(msg)
+		err = rw.write(msg, code, size)
 		// Report write status back to Peer.run. It will initiate
 		// shutdown if the error is non-nil and unblock the next write
 		// otherwise. The calling protocol code should exit for errors
@@ -411,6 +480,15 @@ func (rw *protoRW) WriteMsg(msg Msg) (err error) {
 	return err
 }
 
+// write sends a message and records its traffic.
+func (rw *protoRW) write(msg Msg, code uint64, size uint32) error {
+	err := rw.w.WriteMsg(msg)
+	if err == nil && rw.msgs != nil {
+		rw.msgs.sent(rw.Name, code, size)
+	}
+	return err
+}
+
 func (rw *protoRW) ReadMsg() (Msg, error) {
 	select {
 	case msg := <-rw.in:
@@ -429,14 +507,17 @@ type PeerInfo struct {
 	ID      string   `json:"id"`    // Unique node identifier
 	Name    string   `json:"name"`  // Name of the node, including client type, version, OS, custom data
 	Caps    []string `json:"caps"`  // Protocols advertised by this peer
+	Score   int64    `json:"score"` // Reputation of the peer based on its past behaviour
 	Network struct {
 		LocalAddress  string `json:"localAddress"`  // Local endpoint of the TCP data connection
 		RemoteAddress string `json:"remoteAddress"` // Remote endpoint of the TCP data connection
 		Inbound       bool   `json:"inbound"`
 		Trusted       bool   `json:"trusted"`
 		Static        bool   `json:"static"`
+		Multiplexed   bool   `json:"multiplexed"` // Whether protocols use separate streams
 	} `json:"network"`
 	Protocols map[string]interface{} `json:"protocols"` // Sub-protocol specific metadata fields
+	Traffic   PeerTraffic            `json:"traffic"`   // Traffic statistics of the connection
 }
 
 // Info gathers and returns a collection of metadata known about a peer.
@@ -452,6 +533,7 @@ func (p *Peer) Info() *PeerInfo {
 		ID:        p.ID().String(),
 		Name:      p.Name(),
 		Caps:      caps,
+		Score:     p.Score(),
 		Protocols: make(map[string]interface{}),
 	}
 	info.Network.LocalAddress = p.LocalAddr().String()
@@ -459,6 +541,12 @@ func (p *Peer) Info() *PeerInfo {
 	info.Network.Inbound = p.rw.is(inboundConn)
 	info.Network.Trusted = p.rw.is(trustedConn)
 	info.Network.Static = p.rw.is(staticDialedConn)
+	_, info.Network.Multiplexed = p.rw.transport.(streamTransport)
+
+	if t, ok := p.rw.transport.(meteredTransport); ok {
+		info.Traffic.Ingress, info.Traffic.Egress = t.traffic()
+	}
+	info.Traffic.Messages = p.msgs.snapshot()
 
 	// Gather all the running protocol infos
 	for _, proto := range p.running {
@@ -98,6 +98,95 @@ func TestPeerProtoReadMsg(t *testing.T) {
 	}
 }
 
+func TestPeerIdleProtocol(t *testing.T) {
+	idle := Protocol{
+		Name:   "a",
+		Length: 5,
+		Run: func(peer *Peer, rw MsgReadWriter) error {
+			t.Error("idle protocol started")
+			return nil
+		},
+	}
+	proto := Protocol{
+		Name:   "b",
+		Length: 5,
+		Run: func(peer *Peer, rw MsgReadWriter) error {
+			return ExpectMsg(rw, 1, []uint{2})
+		},
+	}
+	fd1, fd2 := net.Pipe()
+	c1 := &conn{fd: fd1, node: newNode(randomID(), nil), transport: newTestTransport(&newkey().PublicKey, fd1)}
+	c2 := &conn{fd: fd2, node: newNode(randomID(), nil), transport: newTestTransport(&newkey().PublicKey, fd2)}
+	c1.caps = []Cap{idle.cap(), proto.cap()}
+	c1.full = map[string]bool{idle.Name: true}
+	defer c2.close(errors.New("test done"))
+
+	peer := newPeer(c1, []Protocol{idle, proto})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := peer.run()
+		errc <- err
+	}()
+	// Messages of the idle protocol are discarded.
+	Send(c2, baseProtocolLength+1, []uint{1})
+	Send(c2, baseProtocolLength+idle.Length+1, []uint{2})
+
+	select {
+	case err := <-errc:
+		if err != errProtocolReturned {
+			t.Errorf("peer returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("receive timeout")
+	}
+}
+
+func TestPeerTraffic(t *testing.T) {
+	sent := make(chan struct{})
+	proto := Protocol{
+		Name:   "a",
+		Length: 5,
+		Run: func(peer *Peer, rw MsgReadWriter) error {
+			if err := ExpectMsg(rw, 2, []uint{1}); err != nil {
+				return err
+			}
+			if err := SendItems(rw, 3, "reply"); err != nil {
+				return err
+			}
+			close(sent)
+			<-peer.closed
+			return nil
+		},
+	}
+	closer, rw, peer, _ := testPeer([]Protocol{proto})
+	defer closer()
+
+	if err := Send(rw, baseProtocolLength+2, []uint{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExpectMsg(rw, baseProtocolLength+3, []string{"reply"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reply not sent")
+	}
+	info := peer.Info().Traffic
+	if info.Ingress == 0 || info.Egress == 0 {
+		t.Errorf("connection traffic not counted: ingress %d, egress %d", info.Ingress, info.Egress)
+	}
+	want := map[string]map[uint64]*MessageTraffic{
+		"a": {
+			2: {InCount: 1, InBytes: 2},
+			3: {OutCount: 1, OutBytes: 7},
+		},
+	}
+	if !reflect.DeepEqual(info.Messages, want) {
+		t.Errorf("wrong message traffic:\ngot  %v\nwant %v", info.Messages, want)
+	}
+}
+
 func TestPeerProtoEncodeMsg(t *testing.T) {
 	proto := Protocol{
 		Name:   "a",
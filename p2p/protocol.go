@@ -56,6 +56,20 @@ type Protocol struct {
 
 	// Attributes contains protocol specific information for the node record.
 	Attributes []enr.Entry
+
+	// DialFilter is an optional function which is consulted for dynamically
+	// discovered dial candidates. Candidates rejected by the filter of any
+	// protocol are not dialed. Static nodes are always dialed.
+	DialFilter func(*enode.Node) bool
+
+	// MaxPeers, MaxInboundPeers and MaxOutboundPeers optionally limit the number
+	// of all, inbound and dialed peers running this protocol. Connections which
+	// would exceed a limit don't run the protocol and are only rejected if all of
+	// their protocols are full. Zero means that only the limits of the server
+	// apply. Trusted and static nodes are exempt.
+	MaxPeers         int
+	MaxInboundPeers  int
+	MaxOutboundPeers int
 }
 
 func (p Protocol) cap() Cap {
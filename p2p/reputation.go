@@ -0,0 +1,196 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/severeum/go-severeum/p2p/enode"
+)
+
+// ScoreEvent is an observed peer behaviour that affects the peer's reputation.
+type ScoreEvent int
+
+const (
+	ScoreServed  ScoreEvent = iota // Peer delivered requested data
+	ScoreUseless                   // Peer delivered an empty or unusable response
+	ScoreTimeout                   // Peer failed to answer a request in time
+	ScoreInvalid                   // Peer delivered invalid data or violated the protocol
+)
+
+// String implements fmt.Stringer.
+func (ev ScoreEvent) String() string {
+	switch ev {
+	case ScoreServed:
+		return "served"
+	case ScoreUseless:
+		return "useless"
+	case ScoreTimeout:
+		return "timeout"
+	case ScoreInvalid:
+		return "invalid"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	maxPeerScore = 1000  // Upper bound of the reputation, limiting the credit of long lived peers
+	minPeerScore = -1000 // Lower bound of the reputation, limiting the time a peer stays banned
+
+	// ScoreBanThreshold is the reputation below which non-trusted and non-static
+	// peers are disconnected, rejected and not dialed any more.
+	ScoreBanThreshold = -100
+
+	scoreHalfLife   = time.Hour // Time after which the reputation of a peer is halved
+	scoreVolumeUnit = 256       // Number of served items earning an extra reputation point
+	scoreVolumeCap  = 4         // Maximum reputation bonus for the volume of a single response
+
+	scoreFastResponse = 500 * time.Millisecond // Latency below which responses earn a bonus
+	scoreSlowResponse = 5 * time.Second        // Latency above which responses are penalised
+)
+
+// scoreDelta calculates the reputation change caused by a single event.
+func scoreDelta(ev ScoreEvent, items int, latency time.Duration) float64 {
+	switch ev {
+	case ScoreServed:
+		delta := 1 + math.Min(float64(items/scoreVolumeUnit), scoreVolumeCap)
+		switch {
+		case latency > 0 && latency < scoreFastResponse:
+			delta++
+		case latency > scoreSlowResponse:
+			delta--
+		}
+		return delta
+	case ScoreUseless:
+		return -5
+	case ScoreTimeout:
+		return -10
+	case ScoreInvalid:
+		return -50
+	default:
+		return 0
+	}
+}
+
+// decayScore fades a reputation score towards zero based on the time passed
+// since it was last updated, so both good and bad behaviour are forgotten.
+func decayScore(score float64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return score
+	}
+	return score * math.Pow(0.5, float64(elapsed)/float64(scoreHalfLife))
+}
+
+// peerScore tracks the reputation of a single connected peer.
+type peerScore struct {
+	value   float64   // Reputation at the time of the last update
+	updated time.Time // Time of the last update, used for decaying
+	lock    sync.Mutex
+}
+
+// set initialises the reputation with a previously persisted value.
+func (s *peerScore) set(score int64, updated time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.value, s.updated = float64(score), updated
+}
+
+// current returns the decayed reputation at the given time.
+func (s *peerScore) current(now time.Time) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return int64(decayScore(s.value, now.Sub(s.updated)))
+}
+
+// add decays the reputation up to the given time and applies a delta to it,
+// returning the new score.
+func (s *peerScore) add(delta float64, now time.Time) int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.value = decayScore(s.value, now.Sub(s.updated)) + delta
+	s.value = math.Max(minPeerScore, math.Min(maxPeerScore, s.value))
+	s.updated = now
+	return int64(s.value)
+}
+
+// Score returns the current reputation of the peer.
+func (p *Peer) Score() int64 {
+	return p.score.current(time.Now())
+}
+
+// RecordScore adjusts the reputation of the peer based on an observed behaviour.
+// The number of delivered items and the response latency are only relevant for
+// served requests. Peers falling below the ban threshold are disconnected unless
+// they are trusted or static.
+func (p *Peer) RecordScore(ev ScoreEvent, items int, latency time.Duration) {
+	score := p.score.add(scoreDelta(ev, items, latency), time.Now())
+	if score < ScoreBanThreshold && !p.rw.is(trustedConn|staticDialedConn) {
+		p.log.Debug("Dropping peer with bad reputation", "score", score, "event", ev)
+		go p.Disconnect(DiscUselessPeer)
+	}
+}
+
+// isProtocolViolation reports whether an error returned by a protocol handler
+// is caused by the misbehaviour of the remote peer, rather than by a network
+// failure or a regular disconnect.
+func isProtocolViolation(err error) bool {
+	if _, ok := err.(DiscReason); ok {
+		return false
+	}
+	if _, ok := err.(net.Error); ok {
+		return false
+	}
+	return err != nil && err != errProtocolReturned && err != io.EOF
+}
+
+// nodeScore returns the current reputation of a node as persisted in the node
+// database, decayed to the present.
+func (srv *Server) nodeScore(id enode.ID) int64 {
+	if srv.nodedb == nil {
+		return 0
+	}
+	score, updated := srv.nodedb.PeerScore(id)
+	return int64(decayScore(float64(score), time.Since(updated)))
+}
+
+// loadScore initialises the reputation of a freshly connected peer from the
+// node database.
+func (srv *Server) loadScore(p *Peer) {
+	if srv.nodedb == nil {
+		return
+	}
+	p.score.set(srv.nodedb.PeerScore(p.ID()))
+}
+
+// storeScore persists the reputation of a peer into the node database.
+func (srv *Server) storeScore(p *Peer) {
+	if srv.nodedb == nil {
+		return
+	}
+	now := time.Now()
+	if err := srv.nodedb.UpdatePeerScore(p.ID(), p.score.current(now), now); err != nil {
+		p.log.Warn("Failed to store peer reputation", "err", err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/p2p/enode"
+)
+
+// Tests that reputation changes are weighted by the event type, the served
+// volume and the response latency.
+func TestScoreDelta(t *testing.T) {
+	tests := []struct {
+		event   ScoreEvent
+		items   int
+		latency time.Duration
+		want    float64
+	}{
+		{ScoreServed, 1, time.Second, 1},
+		{ScoreServed, 1, 100 * time.Millisecond, 2},
+		{ScoreServed, 1, 10 * time.Second, 0},
+		{ScoreServed, 2 * scoreVolumeUnit, time.Second, 3},
+		{ScoreServed, 100 * scoreVolumeUnit, time.Second, 1 + scoreVolumeCap},
+		{ScoreUseless, 0, 0, -5},
+		{ScoreTimeout, 0, 0, -10},
+		{ScoreInvalid, 0, 0, -50},
+	}
+	for i, tt := range tests {
+		if delta := scoreDelta(tt.event, tt.items, tt.latency); delta != tt.want {
+			t.Errorf("test %d: delta mismatch: have %v, want %v", i, delta, tt.want)
+		}
+	}
+}
+
+// Tests that peer scores decay over time and stay within bounds.
+func TestPeerScoreDecay(t *testing.T) {
+	var (
+		score peerScore
+		now   = time.Now()
+	)
+	score.set(0, now)
+	if have := score.add(-400, now); have != -400 {
+		t.Fatalf("score mismatch: have %d, want %d", have, -400)
+	}
+	if have := score.current(now.Add(scoreHalfLife)); have != -200 {
+		t.Fatalf("decayed score mismatch: have %d, want %d", have, -200)
+	}
+	if have := score.add(-5000, now); have != minPeerScore {
+		t.Fatalf("score not clamped: have %d, want %d", have, minPeerScore)
+	}
+	if have := score.add(5000, now); have != maxPeerScore {
+		t.Fatalf("score not clamped: have %d, want %d", have, maxPeerScore)
+	}
+}
+
+// Tests that peer reputations are persisted into the node database when peers
+// disconnect and restored when they reconnect.
+func TestServerPeerReputation(t *testing.T) {
+	db, _ := enode.OpenDB("")
+	defer db.Close()
+
+	srv := &Server{nodedb: db}
+	p := NewPeer(uintID(1), "test", nil)
+	srv.loadScore(p)
+	p.RecordScore(ScoreInvalid, 0, 0)
+	p.RecordScore(ScoreInvalid, 0, 0)
+	p.RecordScore(ScoreInvalid, 0, 0)
+	srv.storeScore(p)
+
+	if score := srv.nodeScore(p.ID()); score > ScoreBanThreshold {
+		t.Fatalf("stored score too high: have %d, want below %d", score, ScoreBanThreshold)
+	}
+	// A fresh peer with the same identity should inherit the reputation
+	q := NewPeer(uintID(1), "test", nil)
+	srv.loadScore(q)
+	if diff := q.Score() - p.Score(); diff < -1 || diff > 1 { // persisted with second precision
+		t.Fatalf("loaded score mismatch: have %d, want %d", q.Score(), p.Score())
+	}
+}
@@ -36,6 +36,7 @@ import (
 	"time"
 
 	"github.com/severeum/go-severeum/common/bitutil"
+	"github.com/severeum/go-severeum/common/mclock"
 	"github.com/severeum/go-severeum/crypto"
 	"github.com/severeum/go-severeum/crypto/ecies"
 	"github.com/severeum/go-severeum/crypto/secp256k1"
@@ -77,32 +78,76 @@ var errPlainMessageTooLarge = errors.New("message length >= 16MB")
 // rlpx is the transport protocol used by actual (non-test) connections.
 // It wraps the frame encoder with locks and read/write deadlines.
 type rlpx struct {
-	fd net.Conn
+	fd    net.Conn
+	count *countingConn
 
 	rmu, wmu sync.Mutex
-	rw       *rlpxFrameRW
+	rw       frameRW
+
+	// Bandwidth limits applied to the messages of the connection. They are
+	// configured before the handshakes and not modified afterwards.
+	ingressLimits []*rateLimiter
+	egressLimits  []*rateLimiter
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// frameRW is the encrypted message framing layer of a connection, which is
+// created by the encryption handshake.
+type frameRW interface {
+	MsgReadWriter
+	setSnappy(enabled bool)
 }
 
 func newRLPX(fd net.Conn) transport {
 	fd.SetDeadline(time.Now().Add(handshakeTimeout))
-	return &rlpx{fd: fd}
+	count := &countingConn{Conn: fd}
+	return &rlpx{fd: count, count: count, closed: make(chan struct{})}
 }
 
 func (t *rlpx) ReadMsg() (Msg, error) {
 	t.rmu.Lock()
-	defer t.rmu.Unlock()
 	t.fd.SetReadDeadline(time.Now().Add(frameReadTimeout))
-	return t.rw.ReadMsg()
+	before, _ := t.count.counts()
+	msg, err := t.rw.ReadMsg()
+	after, _ := t.count.counts()
+	t.rmu.Unlock()
+
+	if len(t.ingressLimits) > 0 {
+		throttle(t.ingressLimits, int(after-before), mclock.System{}, t.closed)
+	}
+	return msg, err
 }
 
 func (t *rlpx) WriteMsg(msg Msg) error {
 	t.wmu.Lock()
-	defer t.wmu.Unlock()
 	t.fd.SetWriteDeadline(time.Now().Add(frameWriteTimeout))
-	return t.rw.WriteMsg(msg)
+	_, before := t.count.counts()
+	err := t.rw.WriteMsg(msg)
+	_, after := t.count.counts()
+	t.wmu.Unlock()
+
+	// Throttling happens after the write lock is released, so the connection
+	// can still be closed while waiting.
+	if len(t.egressLimits) > 0 {
+		throttle(t.egressLimits, int(after-before), mclock.System{}, t.closed)
+	}
+	return err
+}
+
+// setRateLimits configures the bandwidth limits of the connection.
+func (t *rlpx) setRateLimits(ingress, egress []*rateLimiter) {
+	t.ingressLimits, t.egressLimits = ingress, egress
+}
+
+// traffic returns the number of bytes received and sent on the connection.
+func (t *rlpx) traffic() (ingress, egress uint64) {
+	return t.count.counts()
 }
 
 func (t *rlpx) close(err error) {
+	t.closeOnce.Do(func() { close(t.closed) })
 	t.wmu.Lock()
 	defer t.wmu.Unlock()
 	// Tell the remote end why we're disconnecting if possible.
@@ -136,7 +181,7 @@ func (t *rlpx) doProtoHandshake(our *protoHandshake) (their *protoHandshake, err
 		return nil, fmt.Errorf("write error: %v", err)
 	}
 	// If the protocol version supports Snappy encoding, upgrade immediately
-	t.rw.snappy = their.Version >= snappyProtocolVersion
+	t.rw.setSnappy(their.Version >= snappyProtocolVersion)
 
 	return their, nil
 }
@@ -589,6 +634,10 @@ func newRLPXFrameRW(conn io.ReadWriter, s secrets) *rlpxFrameRW {
 	}
 }
 
+func (rw *rlpxFrameRW) setSnappy(enabled bool) {
+	rw.snappy = enabled
+}
+
 func (rw *rlpxFrameRW) WriteMsg(msg Msg) error {
 	ptype, _ := rlp.EncodeToBytes(msg.Code)
 
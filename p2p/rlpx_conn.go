@@ -0,0 +1,135 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/severeum/go-severeum/crypto"
+	"github.com/severeum/go-severeum/p2p/enode"
+	"github.com/severeum/go-severeum/rlp"
+)
+
+// RLPXConn is an RLPx connection to a remote node on which the devp2p handshake
+// has been performed, but which doesn't run any protocols. It allows tools such
+// as network crawlers and conformance test suites to exchange messages with a
+// node directly. Protocol implementations should use Server instead.
+//
+// Message codes passed to ReadMsg and WriteMsg are relative to the start of the
+// sub-protocol range, i.e. the first message of the first shared capability has
+// code zero. Messages of the base protocol are handled by RLPXConn itself.
+type RLPXConn struct {
+	t        *rlpx
+	deadline time.Time // Read deadline set by SetDeadline
+
+	Name string // Client name announced by the remote node
+	Caps []Cap  // Capabilities announced by the remote node
+}
+
+// DialRLPX connects to n over TCP and performs the encryption and protocol
+// handshakes, announcing the given client name and capabilities. The timeout
+// applies to establishing the connection and both handshakes.
+func DialRLPX(n *enode.Node, prv *ecdsa.PrivateKey, name string, caps []Cap, timeout time.Duration) (*RLPXConn, error) {
+	if n.IP() == nil || n.TCP() == 0 {
+		return nil, errors.New("node has no TCP endpoint")
+	}
+	addr := &net.TCPAddr{IP: n.IP(), Port: n.TCP()}
+	fd, err := net.DialTimeout("tcp", addr.String(), timeout)
+	if err != nil {
+		return nil, err
+	}
+	t := newRLPX(fd).(*rlpx)
+	fd.SetDeadline(time.Now().Add(timeout))
+	if _, err := t.doEncHandshake(prv, n.Pubkey()); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	pubkey := crypto.FromECDSAPub(&prv.PublicKey)
+	our := &protoHandshake{Version: baseProtocolVersion, Name: name, ID: pubkey[1:]}
+	our.Caps = append(our.Caps, caps...)
+	sort.Sort(capsByNameAndVersion(our.Caps))
+	their, err := t.doProtoHandshake(our)
+	if err != nil {
+		t.close(err)
+		return nil, err
+	}
+	fd.SetDeadline(time.Time{})
+	return &RLPXConn{t: t, Name: their.Name, Caps: their.Caps}, nil
+}
+
+// ReadMsg reads the next sub-protocol message. Pings of the remote node are
+// answered automatically. If the remote node disconnects, the disconnect reason
+// is returned as the error.
+func (c *RLPXConn) ReadMsg() (Msg, error) {
+	for {
+		msg, err := c.readMsg()
+		if err != nil {
+			return msg, err
+		}
+		switch {
+		case msg.Code == pingMsg:
+			msg.Discard()
+			if err := SendItems(c.t, pongMsg); err != nil {
+				return msg, err
+			}
+		case msg.Code == discMsg:
+			var reason [1]DiscReason
+			rlp.Decode(msg.Payload, &reason)
+			return msg, reason[0]
+		case msg.Code < baseProtocolLength:
+			// Ignore all other base protocol messages.
+			msg.Discard()
+		default:
+			msg.Code -= baseProtocolLength
+			return msg, nil
+		}
+	}
+}
+
+// WriteMsg sends a sub-protocol message.
+func (c *RLPXConn) WriteMsg(msg Msg) error {
+	msg.Code += baseProtocolLength
+	return c.t.WriteMsg(msg)
+}
+
+// Close tells the remote node why the connection is closed and closes it.
+func (c *RLPXConn) Close(reason DiscReason) {
+	c.t.close(reason)
+}
+
+// readMsg reads a message from the connection, applying the deadline if one is
+// set. Otherwise the default frame read timeout applies.
+func (c *RLPXConn) readMsg() (Msg, error) {
+	if c.deadline.IsZero() {
+		return c.t.ReadMsg()
+	}
+	c.t.rmu.Lock()
+	defer c.t.rmu.Unlock()
+	c.t.fd.SetReadDeadline(c.deadline)
+	return c.t.rw.ReadMsg()
+}
+
+// SetDeadline sets a deadline for reading messages. Unlike the deadline of the
+// underlying connection, it is not extended by messages handled internally, such
+// as pings. A zero value restores the default frame read timeout.
+func (c *RLPXConn) SetDeadline(t time.Time) {
+	c.deadline = t
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/p2p/enode"
+)
+
+func TestDialRLPX(t *testing.T) {
+	received := make(chan string, 1)
+	srv := &Server{Config: Config{
+		Name:        "test-server",
+		MaxPeers:    10,
+		ListenAddr:  "127.0.0.1:0",
+		PrivateKey:  newkey(),
+		NoDiscovery: true,
+		Protocols: []Protocol{{
+			Name:    "test",
+			Version: 1,
+			Length:  2,
+			Run: func(p *Peer, rw MsgReadWriter) error {
+				if err := SendItems(rw, 0, "hello"); err != nil {
+					return err
+				}
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				var reply []string
+				msg.Decode(&reply)
+				received <- reply[0]
+				return nil
+			},
+		}},
+	}}
+	if err := srv.Start(); err != nil {
+		t.Fatal("can't start server:", err)
+	}
+	defer srv.Stop()
+
+	addr := srv.listener.Addr().(*net.TCPAddr)
+	dest := enode.NewV4(&srv.PrivateKey.PublicKey, addr.IP, addr.Port, 0)
+	conn, err := DialRLPX(dest, newkey(), "test-client", []Cap{{"test", 1}}, 5*time.Second)
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer conn.Close(DiscQuitting)
+
+	if conn.Name != "test-server" {
+		t.Errorf("wrong remote name %q", conn.Name)
+	}
+	if len(conn.Caps) != 1 || conn.Caps[0] != (Cap{"test", 1}) {
+		t.Errorf("wrong remote caps %v", conn.Caps)
+	}
+	if err := ExpectMsg(conn, 0, []string{"hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendItems(conn, 1, "world"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reply := <-received:
+		if reply != "world" {
+			t.Errorf("server received %q, want %q", reply, "world")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not receive the reply")
+	}
+}
@@ -18,6 +18,7 @@
 package p2p
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
@@ -35,6 +36,7 @@ import (
 	"github.com/severeum/go-severeum/log"
 	"github.com/severeum/go-severeum/p2p/discover"
 	"github.com/severeum/go-severeum/p2p/discv5"
+	"github.com/severeum/go-severeum/p2p/dnsdisc"
 	"github.com/severeum/go-severeum/p2p/enode"
 	"github.com/severeum/go-severeum/p2p/enr"
 	"github.com/severeum/go-severeum/p2p/nat"
@@ -79,6 +81,39 @@ type Config struct {
 	// Setting DialRatio to zero defaults it to 3.
 	DialRatio int `toml:",omitempty"`
 
+	// MaxInboundPeers and MaxOutboundPeers limit the number of inbound and
+	// dynamically dialed connections separately. If zero, the limits are derived
+	// from MaxPeers and DialRatio. Static and trusted nodes are exempt from the
+	// outbound limit.
+	MaxInboundPeers  int `toml:",omitempty"`
+	MaxOutboundPeers int `toml:",omitempty"`
+
+	// MaxInboundPerIP and MaxInboundPerSubnet limit the number of inbound
+	// connections from a single IP address and from a single /24 (IPv4) or
+	// /64 (IPv6) network. Zero means no limit. Trusted nodes are exempt.
+	MaxInboundPerIP     int `toml:",omitempty"`
+	MaxInboundPerSubnet int `toml:",omitempty"`
+
+	// MaxIngressRate and MaxEgressRate limit the download and upload bandwidth
+	// of all peers combined in bytes per second. Zero means no limit.
+	MaxIngressRate int `toml:",omitempty"`
+	MaxEgressRate  int `toml:",omitempty"`
+
+	// MaxPeerIngressRate and MaxPeerEgressRate limit the download and upload
+	// bandwidth of each peer in bytes per second. Zero means no limit.
+	MaxPeerIngressRate int `toml:",omitempty"`
+	MaxPeerEgressRate  int `toml:",omitempty"`
+
+	// EnableNoise enables the Noise transport as an alternative to RLPx. It is
+	// advertised in the node record and used to connect to nodes which advertise
+	// it as well. Inbound connections may use either transport.
+	EnableNoise bool `toml:",omitempty"`
+
+	// EnableMux enables multiplexed streams, which carry every subprotocol on its
+	// own stream with independent flow control. It is used with peers which
+	// enable it as well.
+	EnableMux bool `toml:",omitempty"`
+
 	// NoDiscovery can be used to disable the peer discovery mechanism.
 	// Disabling is useful for protocol debugging (manual topology).
 	NoDiscovery bool
@@ -100,6 +135,11 @@ type Config struct {
 	// protocol.
 	BootstrapNodesV5 []*discv5.Node `toml:",omitempty"`
 
+	// DiscoveryDNS is a list of enrtree:// URLs of node lists published in DNS
+	// (EIP-1459). The nodes of these lists are used as additional dial candidates,
+	// which helps in networks where UDP discovery is blocked.
+	DiscoveryDNS []string `toml:",omitempty"`
+
 	// Static nodes are used as pre-configured connections which are always
 	// maintained and re-connected on disconnects.
 	StaticNodes []*enode.Node
@@ -156,8 +196,9 @@ type Server struct {
 	Config
 
 	// Hooks for testing. These are useful because we can inhibit
-	// the whole protocol stack.
-	newTransport func(net.Conn) transport
+	// the whole protocol stack. newTransport creates the transport of
+	// a connection, dialDest is nil for inbound connections.
+	newTransport func(fd net.Conn, dialDest *enode.Node) transport
 	newPeerHook  func(*Peer)
 
 	lock    sync.Mutex // protects running
@@ -166,11 +207,16 @@ type Server struct {
 	nodedb       *enode.DB
 	localnode    *enode.LocalNode
 	ntab         discoverTable
+	dnsdisc      *dnsdisc.Client
 	listener     net.Listener
 	ourHandshake *protoHandshake
 	lastLookup   time.Time
 	DiscV5       *discv5.Network
 
+	// Global bandwidth limits, nil if unlimited.
+	ingressLimit *rateLimiter
+	egressLimit  *rateLimiter
+
 	// These are for Peers, PeerCount (and nothing else).
 	peerOp     chan peerOpFunc
 	peerOpDone chan struct{}
@@ -215,8 +261,13 @@ type conn struct {
 	cont  chan error // The run loop uses cont to signal errors to SetupConn.
 	caps  []Cap      // valid after the protocol handshake
 	name  string     // valid after the protocol handshake
+
+	full map[string]bool // matched protocols whose peer limits are reached, not started
 }
 
+// transport is the encryption and framing layer of a connection. Transports
+// are created by Server.newTransport, see selectTransport for the available
+// implementations.
 type transport interface {
 	// The two handshakes.
 	doEncHandshake(prv *ecdsa.PrivateKey, dialDest *ecdsa.PublicKey) (*ecdsa.PublicKey, error)
@@ -231,6 +282,13 @@ type transport interface {
 	close(err error)
 }
 
+// meteredTransport is implemented by transports which support traffic
+// accounting and bandwidth limits.
+type meteredTransport interface {
+	setRateLimits(ingress, egress []*rateLimiter)
+	traffic() (ingress, egress uint64)
+}
+
 func (c *conn) String() string {
 	s := c.flags.String()
 	if (c.node.ID() != enode.ID{}) {
@@ -349,6 +407,14 @@ func (srv *Server) SubscribeEvents(ch chan *PeerEvent) event.Subscription {
 	return srv.peerFeed.Subscribe(ch)
 }
 
+// LocalNode returns the local node record, which protocols may use to update
+// their attributes. It is nil before the server is started.
+func (srv *Server) LocalNode() *enode.LocalNode {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.localnode
+}
+
 // Self returns the local node's endpoint information.
 func (srv *Server) Self() *enode.Node {
 	srv.lock.Lock()
@@ -427,11 +493,17 @@ func (srv *Server) Start() (err error) {
 		return errors.New("Server.PrivateKey must be set to a non-nil key")
 	}
 	if srv.newTransport == nil {
-		srv.newTransport = newRLPX
+		srv.newTransport = srv.selectTransport
 	}
 	if srv.Dialer == nil {
 		srv.Dialer = TCPDialer{&net.Dialer{Timeout: defaultDialTimeout}}
 	}
+	if srv.MaxIngressRate > 0 {
+		srv.ingressLimit = newRateLimiter(srv.MaxIngressRate, mclock.System{})
+	}
+	if srv.MaxEgressRate > 0 {
+		srv.egressLimit = newRateLimiter(srv.MaxEgressRate, mclock.System{})
+	}
 	srv.quit = make(chan struct{})
 	srv.addpeer = make(chan *conn)
 	srv.delpeer = make(chan peerDrop)
@@ -457,11 +529,35 @@ func (srv *Server) Start() (err error) {
 
 	dynPeers := srv.maxDialedConns()
 	dialer := newDialState(srv.localnode.ID(), srv.StaticNodes, srv.BootstrapNodes, srv.ntab, dynPeers, srv.NetRestrict)
+	dialer.scores = srv.nodeScore
+	dialer.filter = srv.dialFilter()
 	srv.loopWG.Add(1)
 	go srv.run(dialer)
 	return nil
 }
 
+// dialFilter combines the dial candidate filters of all protocols. It returns
+// nil if no protocol declares a filter.
+func (srv *Server) dialFilter() func(*enode.Node) bool {
+	var filters []func(*enode.Node) bool
+	for _, p := range srv.Protocols {
+		if p.DialFilter != nil {
+			filters = append(filters, p.DialFilter)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return func(n *enode.Node) bool {
+		for _, f := range filters {
+			if !f(n) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 func (srv *Server) setupLocalNode() error {
 	// Create the devp2p handshake.
 	pubkey := crypto.FromECDSAPub(&srv.PrivateKey.PublicKey)
@@ -480,6 +576,14 @@ func (srv *Server) setupLocalNode() error {
 	srv.localnode = enode.NewLocalNode(db, srv.PrivateKey)
 	srv.localnode.SetFallbackIP(net.IP{127, 0, 0, 1})
 	srv.localnode.Set(capsByNameAndVersion(srv.ourHandshake.Caps))
+	if srv.EnableMux {
+		// Multiplexing is a property of the connection, it is announced in the
+		// handshake only.
+		srv.ourHandshake.Caps = append(srv.ourHandshake.Caps, muxCap)
+	}
+	if srv.EnableNoise {
+		srv.localnode.Set(noiseEntry{Version: noiseVersion})
+	}
 	// TODO: check conflicts
 	for _, p := range srv.Protocols {
 		for _, e := range p.Attributes {
@@ -495,12 +599,13 @@ func (srv *Server) setupLocalNode() error {
 		srv.localnode.SetStaticIP(ip)
 	default:
 		// Ask the router about the IP. This takes a while and blocks startup,
-		// do it in the background.
+		// do it in the background. The IP reported by the router is used until
+		// other nodes tell us about our endpoint.
 		srv.loopWG.Add(1)
 		go func() {
 			defer srv.loopWG.Done()
 			if ip, err := srv.NAT.ExternalIP(); err == nil {
-				srv.localnode.SetStaticIP(ip)
+				srv.localnode.SetNATIP(ip)
 			}
 		}()
 	}
@@ -508,6 +613,14 @@ func (srv *Server) setupLocalNode() error {
 }
 
 func (srv *Server) setupDiscovery() error {
+	// DNS node lists, these don't need the UDP listener.
+	if len(srv.DiscoveryDNS) > 0 {
+		client, err := dnsdisc.NewClient(dnsdisc.Config{Logger: srv.log}, srv.DiscoveryDNS...)
+		if err != nil {
+			return err
+		}
+		srv.dnsdisc = client
+	}
 	if srv.NoDiscovery && !srv.DiscoveryV5 {
 		return nil
 	}
@@ -524,7 +637,7 @@ func (srv *Server) setupDiscovery() error {
 	srv.log.Debug("UDP listener up", "addr", realaddr)
 	if srv.NAT != nil {
 		if !realaddr.IP.IsLoopback() {
-			go nat.Map(srv.NAT, srv.quit, "udp", realaddr.Port, realaddr.Port, "severeum discovery")
+			go srv.mapPort("udp", realaddr.Port, "severeum discovery", srv.localnode.SetFallbackUDP)
 		}
 	}
 	srv.localnode.SetFallbackUDP(realaddr.Port)
@@ -587,13 +700,29 @@ func (srv *Server) setupListening() error {
 	if !laddr.IP.IsLoopback() && srv.NAT != nil {
 		srv.loopWG.Add(1)
 		go func() {
-			nat.Map(srv.NAT, srv.quit, "tcp", laddr.Port, laddr.Port, "severeum p2p")
+			srv.mapPort("tcp", laddr.Port, "severeum p2p", func(port int) { srv.localnode.Set(enr.TCP(port)) })
 			srv.loopWG.Done()
 		}()
 	}
 	return nil
 }
 
+// mapPort keeps a port mapping alive until the server stops. The external endpoint
+// of the mapping is applied to the local node record using setPort. The local
+// port is advertised while the mapping fails.
+func (srv *Server) mapPort(protocol string, port int, name string, setPort func(int)) {
+	nat.MapWithStatus(srv.NAT, srv.quit, protocol, port, port, name, func(s nat.MapStatus) {
+		if s.Err != nil {
+			setPort(port)
+			return
+		}
+		setPort(s.ExternalPort)
+		if s.ExternalIP != nil {
+			srv.localnode.SetNATIP(s.ExternalIP)
+		}
+	})
+}
+
 type dialer interface {
 	newTasks(running int, peers map[enode.ID]*Peer, now time.Time) []task
 	taskDone(task, time.Time)
@@ -724,6 +853,7 @@ running:
 			if err == nil {
 				// The handshakes are done and it passed all checks.
 				p := newPeer(c, srv.Protocols)
+				srv.loadScore(p)
 				// If message events are enabled, pass the peerFeed
 				// to the peer
 				if srv.EnableMsgEvents {
@@ -749,6 +879,7 @@ running:
 			// A peer disconnected.
 			d := common.PrettyDuration(mclock.Now() - pd.created)
 			pd.log.Debug("Removing p2p peer", "duration", d, "peers", len(peers)-1, "req", pd.requested, "err", pd.err)
+			srv.storeScore(pd.Peer)
 			delete(peers, pd.ID())
 			if pd.Inbound() {
 				inboundCount--
@@ -775,6 +906,7 @@ running:
 	for len(peers) > 0 {
 		p := <-srv.delpeer
 		p.log.Trace("<-delpeer (spindown)", "remainingTasks", len(runningTasks))
+		srv.storeScore(p.Peer)
 		delete(peers, p.ID())
 	}
 }
@@ -782,35 +914,155 @@ running:
 func (srv *Server) protoHandshakeChecks(peers map[enode.ID]*Peer, inboundCount int, c *conn) error {
 	// Drop connections with no matching protocols.
 	if len(srv.Protocols) > 0 && countMatchingProtocols(srv.Protocols, c.caps) == 0 {
-		return DiscUselessPeer
+		return rejectConn("Useless", DiscUselessPeer)
 	}
 	// Repeat the encryption handshake checks because the
 	// peer set might have changed between the handshakes.
-	return srv.encHandshakeChecks(peers, inboundCount, c)
+	if err := srv.encHandshakeChecks(peers, inboundCount, c); err != nil {
+		return err
+	}
+	// Check the peer limits of the protocols the connection would run. Full
+	// protocols are not started, the connection is only rejected if all are.
+	if !c.is(trustedConn | staticDialedConn) {
+		matched := matchProtocols(srv.Protocols, c.caps, nil)
+		c.full = make(map[string]bool)
+		for name, proto := range matched {
+			if protocolPeersFull(peers, proto.Protocol, c) {
+				c.full[name] = true
+			}
+		}
+		if len(matched) > 0 && len(c.full) == len(matched) {
+			return rejectConn("ProtocolLimit", DiscTooManyPeers)
+		}
+	}
+	return nil
 }
 
 func (srv *Server) encHandshakeChecks(peers map[enode.ID]*Peer, inboundCount int, c *conn) error {
 	switch {
 	case !c.is(trustedConn|staticDialedConn) && len(peers) >= srv.MaxPeers:
-		return DiscTooManyPeers
+		return rejectConn("TooManyPeers", DiscTooManyPeers)
 	case !c.is(trustedConn) && c.is(inboundConn) && inboundCount >= srv.maxInboundConns():
-		return DiscTooManyPeers
+		return rejectConn("TooManyInbound", DiscTooManyPeers)
+	case !c.is(trustedConn|staticDialedConn) && c.is(dynDialedConn) && srv.MaxOutboundPeers > 0 && countDynDialed(peers) >= srv.MaxOutboundPeers:
+		return rejectConn("TooManyOutbound", DiscTooManyPeers)
 	case peers[c.node.ID()] != nil:
-		return DiscAlreadyConnected
+		return rejectConn("AlreadyConnected", DiscAlreadyConnected)
 	case c.node.ID() == srv.localnode.ID():
-		return DiscSelf
+		return rejectConn("Self", DiscSelf)
+	case !c.is(trustedConn|staticDialedConn) && srv.nodeScore(c.node.ID()) < ScoreBanThreshold:
+		return rejectConn("Banned", DiscUselessPeer)
+	case !c.is(trustedConn) && c.is(inboundConn):
+		return srv.inboundIPChecks(peers, c)
+	default:
+		return nil
+	}
+}
+
+// inboundIPChecks enforces the per-IP and per-subnet limits of inbound connections.
+func (srv *Server) inboundIPChecks(peers map[enode.ID]*Peer, c *conn) error {
+	ip := connIP(c.fd)
+	if ip == nil || (srv.MaxInboundPerIP == 0 && srv.MaxInboundPerSubnet == 0) {
+		return nil
+	}
+	var sameIP, sameSubnet int
+	for _, p := range peers {
+		if !p.Inbound() {
+			continue
+		}
+		pip := connIP(p.rw.fd)
+		if pip == nil {
+			continue
+		}
+		if pip.Equal(ip) {
+			sameIP++
+		}
+		if sameNetwork(pip, ip) {
+			sameSubnet++
+		}
+	}
+	switch {
+	case srv.MaxInboundPerIP > 0 && sameIP >= srv.MaxInboundPerIP:
+		return rejectConn("IPLimit", DiscTooManyPeers)
+	case srv.MaxInboundPerSubnet > 0 && sameSubnet >= srv.MaxInboundPerSubnet:
+		return rejectConn("SubnetLimit", DiscTooManyPeers)
 	default:
 		return nil
 	}
 }
 
+// protocolPeersFull reports whether the peer limits of proto prevent c from
+// running it.
+func protocolPeersFull(peers map[enode.ID]*Peer, proto Protocol, c *conn) bool {
+	if proto.MaxPeers == 0 && proto.MaxInboundPeers == 0 && proto.MaxOutboundPeers == 0 {
+		return false
+	}
+	var total, inbound int
+	for _, p := range peers {
+		if p.running[proto.Name] == nil {
+			continue
+		}
+		total++
+		if p.Inbound() {
+			inbound++
+		}
+	}
+	switch {
+	case proto.MaxPeers > 0 && total >= proto.MaxPeers:
+		return true
+	case proto.MaxInboundPeers > 0 && c.is(inboundConn) && inbound >= proto.MaxInboundPeers:
+		return true
+	case proto.MaxOutboundPeers > 0 && !c.is(inboundConn) && total-inbound >= proto.MaxOutboundPeers:
+		return true
+	default:
+		return false
+	}
+}
+
+// countDynDialed returns the number of dynamically dialed peers.
+func countDynDialed(peers map[enode.ID]*Peer) int {
+	n := 0
+	for _, p := range peers {
+		if p.rw.is(dynDialedConn) {
+			n++
+		}
+	}
+	return n
+}
+
+// connIP returns the remote IP address of a TCP connection, or nil for other
+// kinds of connections.
+func connIP(fd net.Conn) net.IP {
+	if tcp, ok := fd.RemoteAddr().(*net.TCPAddr); ok {
+		return tcp.IP
+	}
+	return nil
+}
+
+// sameNetwork reports whether a and b are in the same /24 (IPv4) or /64 (IPv6)
+// network.
+func sameNetwork(a, b net.IP) bool {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil || b4 != nil {
+		mask := net.CIDRMask(24, 32)
+		return a4 != nil && b4 != nil && a4.Mask(mask).Equal(b4.Mask(mask))
+	}
+	mask := net.CIDRMask(64, 128)
+	return a.Mask(mask).Equal(b.Mask(mask))
+}
+
 func (srv *Server) maxInboundConns() int {
+	if srv.MaxInboundPeers > 0 {
+		return srv.MaxInboundPeers
+	}
 	return srv.MaxPeers - srv.maxDialedConns()
 }
 func (srv *Server) maxDialedConns() int {
-	if srv.NoDiscovery || srv.NoDial {
+	if srv.NoDial || (srv.NoDiscovery && len(srv.DiscoveryDNS) == 0) {
 		return 0
 	}
+	if srv.MaxOutboundPeers > 0 {
+		return srv.MaxOutboundPeers
+	}
 	r := srv.DialRatio
 	if r == 0 {
 		r = defaultDialRatio
@@ -880,7 +1132,10 @@ func (srv *Server) listenLoop() {
 // as a peer. It returns when the connection has been added as a peer
 // or the handshakes have failed.
 func (srv *Server) SetupConn(fd net.Conn, flags connFlag, dialDest *enode.Node) error {
-	c := &conn{fd: fd, transport: srv.newTransport(fd), flags: flags, cont: make(chan error)}
+	c := &conn{fd: fd, transport: srv.newTransport(fd, dialDest), flags: flags, cont: make(chan error)}
+	if t, ok := c.transport.(meteredTransport); ok {
+		t.setRateLimits(srv.rateLimits())
+	}
 	err := srv.setupConn(c, flags, dialDest)
 	if err != nil {
 		c.close(err)
@@ -889,6 +1144,57 @@ func (srv *Server) SetupConn(fd net.Conn, flags connFlag, dialDest *enode.Node)
 	return err
 }
 
+// selectTransport creates the transport of a connection. RLPx is used unless
+// the Noise transport is enabled and either advertised by the dialed node or
+// announced by the remote end of an inbound connection.
+func (srv *Server) selectTransport(fd net.Conn, dialDest *enode.Node) transport {
+	if !srv.EnableNoise {
+		return newRLPX(fd)
+	}
+	if dialDest != nil {
+		var entry noiseEntry
+		if dialDest.Load(&entry) == nil && entry.Version == noiseVersion {
+			return newNoiseTransport(fd)
+		}
+		return newRLPX(fd)
+	}
+	// Inbound connections are recognized by the magic bytes sent by Noise
+	// initiators. The handshake fails later if nothing arrives.
+	pc := &peekConn{Conn: fd, r: bufio.NewReader(fd)}
+	pc.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	if magic, err := pc.r.Peek(len(noiseMagic)); err == nil && bytes.Equal(magic, noiseMagic) {
+		return newNoiseTransport(pc)
+	}
+	return newRLPX(pc)
+}
+
+// peekConn is a connection whose first bytes can be inspected before reading.
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// rateLimits returns the bandwidth limits of a new connection.
+func (srv *Server) rateLimits() (ingress, egress []*rateLimiter) {
+	if srv.ingressLimit != nil {
+		ingress = append(ingress, srv.ingressLimit)
+	}
+	if srv.MaxPeerIngressRate > 0 {
+		ingress = append(ingress, newRateLimiter(srv.MaxPeerIngressRate, mclock.System{}))
+	}
+	if srv.egressLimit != nil {
+		egress = append(egress, srv.egressLimit)
+	}
+	if srv.MaxPeerEgressRate > 0 {
+		egress = append(egress, newRateLimiter(srv.MaxPeerEgressRate, mclock.System{}))
+	}
+	return ingress, egress
+}
+
 func (srv *Server) setupConn(c *conn, flags connFlag, dialDest *enode.Node) error {
 	// Prevent leftover pending conns from entering the handshake.
 	srv.lock.Lock()
@@ -940,6 +1246,9 @@ func (srv *Server) setupConn(c *conn, flags connFlag, dialDest *enode.Node) erro
 		return DiscUnexpectedIdentity
 	}
 	c.caps, c.name = phs.Caps, phs.Name
+	if srv.EnableMux && muxSupported(phs.Caps) {
+		c.transport = newMuxTransport(c.transport, srv.Protocols, phs.Caps)
+	}
 	err = srv.checkpoint(c, srv.addpeer)
 	if err != nil {
 		clog.Trace("Rejected peer", "err", err)
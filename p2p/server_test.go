@@ -45,7 +45,7 @@ type testTransport struct {
 
 func newTestTransport(rpub *ecdsa.PublicKey, fd net.Conn) transport {
 	wrapped := newRLPX(fd).(*rlpx)
-	wrapped.rw = newRLPXFrameRW(fd, secrets{
+	wrapped.rw = newRLPXFrameRW(wrapped.fd, secrets{
 		MAC:        zero16,
 		AES:        zero16,
 		IngressMAC: sha3.NewLegacyKeccak256(),
@@ -78,7 +78,7 @@ func startTestServer(t *testing.T, remoteKey *ecdsa.PublicKey, pf func(*Peer)) *
 	server := &Server{
 		Config:       config,
 		newPeerHook:  pf,
-		newTransport: func(fd net.Conn) transport { return newTestTransport(remoteKey, fd) },
+		newTransport: func(fd net.Conn, dialDest *enode.Node) transport { return newTestTransport(remoteKey, fd) },
 	}
 	if err := server.Start(); err != nil {
 		t.Fatalf("Could not start server: %v", err)
@@ -414,6 +414,156 @@ func TestServerAtCap(t *testing.T) {
 	}
 }
 
+func TestServerInboundIPLimits(t *testing.T) {
+	remote := newkey()
+	srv := &Server{
+		Config: Config{
+			PrivateKey:          newkey(),
+			MaxPeers:            10,
+			NoDial:              true,
+			MaxInboundPerIP:     2,
+			MaxInboundPerSubnet: 3,
+		},
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	newconn := func(ip string, flags connFlag) *conn {
+		fd, _ := net.Pipe()
+		fd = &addrConn{fd, &net.TCPAddr{IP: net.ParseIP(ip), Port: 30303}}
+		tx := newTestTransport(&remote.PublicKey, fd)
+		node := enode.SignNull(new(enr.Record), randomID())
+		return &conn{fd: fd, transport: tx, flags: flags, node: node, cont: make(chan error)}
+	}
+	tests := []struct {
+		ip      string
+		flags   connFlag
+		wantErr error
+	}{
+		{"10.0.1.1", inboundConn, nil},
+		{"10.0.1.1", inboundConn, nil},
+		{"10.0.1.1", inboundConn, DiscTooManyPeers}, // IP limit
+		{"10.0.1.1", staticDialedConn, nil},         // dialed conns are exempt
+		{"10.0.1.2", inboundConn, nil},
+		{"10.0.1.3", inboundConn, DiscTooManyPeers}, // subnet limit
+		{"10.0.2.1", inboundConn, nil},
+		{"2001:db8::1", inboundConn, nil},
+		{"2001:db8::2", inboundConn, nil},
+		{"2001:db8::3", inboundConn, nil},
+		{"2001:db8::4", inboundConn, DiscTooManyPeers}, // IPv6 subnet limit
+	}
+	for i, test := range tests {
+		c := newconn(test.ip, test.flags)
+		if err := srv.checkpoint(c, srv.addpeer); err != test.wantErr {
+			t.Errorf("conn %d (%s): got error %v, want %v", i, test.ip, err, test.wantErr)
+		}
+	}
+}
+
+func TestServerProtocolLimits(t *testing.T) {
+	remote := newkey()
+	limited, other := discard, discard
+	limited.Name, limited.MaxPeers, limited.MaxInboundPeers = "limited", 3, 2
+	other.Name = "other"
+	srv := &Server{
+		Config: Config{
+			PrivateKey: newkey(),
+			MaxPeers:   10,
+			NoDial:     true,
+			Protocols:  []Protocol{limited, other},
+		},
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	newconn := func(flags connFlag, caps ...Cap) *conn {
+		fd, _ := net.Pipe()
+		tx := newTestTransport(&remote.PublicKey, fd)
+		node := enode.SignNull(new(enr.Record), randomID())
+		return &conn{fd: fd, transport: tx, flags: flags, node: node, caps: caps, cont: make(chan error)}
+	}
+	tests := []struct {
+		flags   connFlag
+		caps    []Cap
+		wantErr error
+	}{
+		{inboundConn, []Cap{limited.cap()}, nil},
+		{inboundConn, []Cap{limited.cap(), other.cap()}, nil},
+		{inboundConn, []Cap{limited.cap()}, DiscTooManyPeers}, // inbound limit
+		{inboundConn, []Cap{other.cap()}, nil},                // other protocol isn't limited
+		{dynDialedConn, []Cap{limited.cap()}, nil},
+		{dynDialedConn, []Cap{limited.cap()}, DiscTooManyPeers}, // total limit
+		{staticDialedConn, []Cap{limited.cap()}, nil},           // static nodes are exempt
+	}
+	for i, test := range tests {
+		c := newconn(test.flags, test.caps...)
+		if err := srv.checkpoint(c, srv.addpeer); err != test.wantErr {
+			t.Errorf("conn %d: got error %v, want %v", i, err, test.wantErr)
+		}
+	}
+	// A full protocol doesn't prevent running the others, it's just not started.
+	c := newconn(inboundConn, limited.cap(), other.cap())
+	if err := srv.checkpoint(c, srv.addpeer); err != nil {
+		t.Fatalf("conn with free protocol slots rejected: %v", err)
+	}
+	var found bool
+	for _, p := range srv.Peers() {
+		if p.ID() != c.node.ID() {
+			continue
+		}
+		found = true
+		if p.running[limited.Name] != nil || p.running[other.Name] == nil {
+			t.Errorf("wrong protocols started: limited %t, other %t", p.running[limited.Name] != nil, p.running[other.Name] != nil)
+		}
+	}
+	if !found {
+		t.Errorf("peer not added")
+	}
+}
+
+func TestServerRateLimits(t *testing.T) {
+	var tt *testTransport
+	srv := &Server{
+		Config: Config{
+			PrivateKey:         newkey(),
+			MaxPeers:           10,
+			NoDial:             true,
+			MaxIngressRate:     1000,
+			MaxPeerIngressRate: 100,
+			MaxPeerEgressRate:  100,
+		},
+		newTransport: func(fd net.Conn, dialDest *enode.Node) transport {
+			tt = newTestTransport(&newkey().PublicKey, fd).(*testTransport)
+			return tt
+		},
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start: %v", err)
+	}
+	defer srv.Stop()
+
+	fd, _ := net.Pipe()
+	srv.SetupConn(fd, inboundConn, nil)
+	if len(tt.ingressLimits) != 2 || tt.ingressLimits[0] != srv.ingressLimit {
+		t.Errorf("wrong ingress limits: %v", tt.ingressLimits)
+	}
+	if len(tt.egressLimits) != 1 || tt.egressLimits[0] == srv.egressLimit {
+		t.Errorf("wrong egress limits: %v", tt.egressLimits)
+	}
+}
+
+// addrConn overrides the remote address of a connection.
+type addrConn struct {
+	net.Conn
+	raddr net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.raddr }
+
 func TestServerPeerLimits(t *testing.T) {
 	srvkey := newkey()
 	clientkey := newkey()
@@ -435,7 +585,7 @@ func TestServerPeerLimits(t *testing.T) {
 			NoDial:     true,
 			Protocols:  []Protocol{discard},
 		},
-		newTransport: func(fd net.Conn) transport { return tp },
+		newTransport: func(fd net.Conn, dialDest *enode.Node) transport { return tp },
 		log:          log.New(),
 	}
 	if err := srv.Start(); err != nil {
@@ -548,7 +698,7 @@ func TestServerSetupConn(t *testing.T) {
 				NoDial:     true,
 				Protocols:  []Protocol{discard},
 			},
-			newTransport: func(fd net.Conn) transport { return test.tt },
+			newTransport: func(fd net.Conn, dialDest *enode.Node) transport { return test.tt },
 			log:          log.New(),
 		}
 		if !test.dontstart {
@@ -104,6 +104,7 @@ func (e *ExecAdapter) NewNode(config *NodeConfig) (Node, error) {
 	conf.Stack.WSExposeAll = true
 	conf.Stack.P2P.EnableMsgEvents = false
 	conf.Stack.P2P.NoDiscovery = true
+	conf.Stack.P2P.EnableMux = config.EnableMux
 	conf.Stack.P2P.NAT = nil
 	conf.Stack.NoUSB = true
 
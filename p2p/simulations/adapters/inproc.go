@@ -94,6 +94,7 @@ func (s *SimAdapter) NewNode(config *NodeConfig) (Node, error) {
 			NoDiscovery:     true,
 			Dialer:          s,
 			EnableMsgEvents: config.EnableMsgEvents,
+			EnableMux:       config.EnableMux,
 		},
 		NoUSB:  true,
 		Logger: log.New("node.id", id.String()),
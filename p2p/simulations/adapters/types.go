@@ -87,6 +87,9 @@ type NodeConfig struct {
 	// Enable peer events for Msgs
 	EnableMsgEvents bool
 
+	// EnableMux enables multiplexed streams on the node's connections
+	EnableMux bool
+
 	// Name is a human friendly name for the node like "node01"
 	Name string
 
@@ -110,6 +113,7 @@ type nodeConfigJSON struct {
 	Name            string   `json:"name"`
 	Services        []string `json:"services"`
 	EnableMsgEvents bool     `json:"enable_msg_events"`
+	EnableMux       bool     `json:"enable_mux"`
 	Port            uint16   `json:"port"`
 }
 
@@ -122,6 +126,7 @@ func (n *NodeConfig) MarshalJSON() ([]byte, error) {
 		Services:        n.Services,
 		Port:            n.Port,
 		EnableMsgEvents: n.EnableMsgEvents,
+		EnableMux:       n.EnableMux,
 	}
 	if n.PrivateKey != nil {
 		confJSON.PrivateKey = hex.EncodeToString(crypto.FromECDSA(n.PrivateKey))
@@ -159,6 +164,7 @@ func (n *NodeConfig) UnmarshalJSON(data []byte) error {
 	n.Services = confJSON.Services
 	n.Port = confJSON.Port
 	n.EnableMsgEvents = confJSON.EnableMsgEvents
+	n.EnableMux = confJSON.EnableMux
 
 	return nil
 }
@@ -0,0 +1,178 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package simulations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/node"
+	"github.com/severeum/go-severeum/p2p"
+	"github.com/severeum/go-severeum/p2p/simulations/adapters"
+	"github.com/severeum/go-severeum/rpc"
+)
+
+const (
+	muxTestBulkMsgs = 4
+	muxTestBulkSize = 2 * 1024 * 1024
+)
+
+// muxTestService runs two protocols: "bulk" sends large messages which are not
+// read until release is closed, "ping" exchanges a small message after the
+// first large message has been sent.
+type muxTestService struct {
+	release chan struct{}
+	sent    chan struct{}
+	pinged  chan struct{}
+	bulk    chan struct{}
+}
+
+func (s *muxTestService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{
+		{
+			Name:    "bulk",
+			Version: 1,
+			Length:  1,
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				errc := make(chan error, 1)
+				go func() {
+					payload := make([]byte, muxTestBulkSize)
+					for i := 0; i < muxTestBulkMsgs; i++ {
+						if err := p2p.Send(rw, 0, payload); err != nil {
+							errc <- err
+							return
+						}
+						if i == 0 {
+							close(s.sent)
+						}
+					}
+					errc <- nil
+				}()
+				<-s.release
+				for i := 0; i < muxTestBulkMsgs; i++ {
+					msg, err := rw.ReadMsg()
+					if err != nil {
+						return err
+					}
+					msg.Discard()
+				}
+				close(s.bulk)
+				if err := <-errc; err != nil {
+					return err
+				}
+				_, err := rw.ReadMsg()
+				return err
+			},
+		},
+		{
+			Name:    "ping",
+			Version: 1,
+			Length:  1,
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				<-s.sent
+				if err := p2p.SendItems(rw, 0, "ping"); err != nil {
+					return err
+				}
+				if err := p2p.ExpectMsg(rw, 0, []string{"ping"}); err != nil {
+					return err
+				}
+				close(s.pinged)
+				_, err := rw.ReadMsg()
+				return err
+			},
+		},
+	}
+}
+
+func (s *muxTestService) APIs() []rpc.API {
+	return nil
+}
+
+func (s *muxTestService) Start(server *p2p.Server) error {
+	return nil
+}
+
+func (s *muxTestService) Stop() error {
+	return nil
+}
+
+// This test checks that multiplexed streams prevent large messages of one
+// protocol from blocking the messages of another protocol.
+func TestMuxSimulation(t *testing.T) {
+	t.Run("pipe", func(t *testing.T) { testMuxSimulation(t, adapters.NewSimAdapter) })
+	t.Run("tcp", func(t *testing.T) { testMuxSimulation(t, adapters.NewTCPAdapter) })
+}
+
+func testMuxSimulation(t *testing.T, newAdapter func(map[string]adapters.ServiceFunc) *adapters.SimAdapter) {
+	release := make(chan struct{})
+	var services []*muxTestService
+	adapter := newAdapter(adapters.Services{
+		"mux": func(ctx *adapters.ServiceContext) (node.Service, error) {
+			s := &muxTestService{
+				release: release,
+				sent:    make(chan struct{}),
+				pinged:  make(chan struct{}),
+				bulk:    make(chan struct{}),
+			}
+			services = append(services, s)
+			return s, nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{DefaultService: "mux"})
+	defer network.Shutdown()
+
+	var nodes []*Node
+	for i := 0; i < 2; i++ {
+		conf := adapters.RandomNodeConfig()
+		conf.EnableMux = true
+		node, err := network.NewNodeWithConfig(conf)
+		if err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("error starting node: %s", err)
+		}
+		nodes = append(nodes, node)
+	}
+	if err := network.Connect(nodes[0].ID(), nodes[1].ID()); err != nil {
+		t.Fatalf("error connecting nodes: %s", err)
+	}
+
+	// The ping protocol completes while the bulk messages are not read.
+	timeout := time.After(10 * time.Second)
+	for i, s := range services {
+		select {
+		case <-s.pinged:
+		case <-timeout:
+			t.Fatalf("node %d: ping blocked by bulk transfer", i)
+		}
+	}
+	for i, n := range nodes {
+		peers := n.Node.(*adapters.SimNode).Server().PeersInfo()
+		if len(peers) != 1 || !peers[0].Network.Multiplexed {
+			t.Fatalf("node %d: connection is not multiplexed", i)
+		}
+	}
+	close(release)
+	for i, s := range services {
+		select {
+		case <-s.bulk:
+		case <-timeout:
+			t.Fatalf("node %d: bulk messages not received", i)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/severeum/go-severeum/common/mclock"
+	"github.com/severeum/go-severeum/metrics"
+)
+
+// PeerTraffic contains the traffic statistics of a peer connection.
+type PeerTraffic struct {
+	Ingress  uint64                                `json:"ingress"`  // Bytes received, including encryption and framing overhead
+	Egress   uint64                                `json:"egress"`   // Bytes sent, including encryption and framing overhead
+	Messages map[string]map[uint64]*MessageTraffic `json:"messages"` // Sub-protocol message statistics by protocol name and message code
+}
+
+// MessageTraffic contains the traffic statistics of a single message type.
+type MessageTraffic struct {
+	InCount  uint64 `json:"inCount"`  // Number of messages received
+	InBytes  uint64 `json:"inBytes"`  // Payload bytes received
+	OutCount uint64 `json:"outCount"` // Number of messages sent
+	OutBytes uint64 `json:"outBytes"` // Payload bytes sent
+}
+
+// msgTracker counts the sub-protocol messages exchanged with a peer.
+type msgTracker struct {
+	lock   sync.Mutex
+	stats  map[string]map[uint64]*MessageTraffic
+	meters map[string]map[uint64]*msgMeters // Cached metrics of the message types seen
+}
+
+// msgMeters are the registered meters of a single message type.
+type msgMeters struct {
+	ingress metrics.Meter // Meter for the received payload bytes
+	egress  metrics.Meter // Meter for the sent payload bytes
+}
+
+func newMsgTracker() *msgTracker {
+	return &msgTracker{
+		stats:  make(map[string]map[uint64]*MessageTraffic),
+		meters: make(map[string]map[uint64]*msgMeters),
+	}
+}
+
+// received records an incoming message of the given protocol. The code is
+// relative to the protocol's offset.
+func (t *msgTracker) received(proto string, code uint64, size uint32) {
+	t.lock.Lock()
+	s := t.get(proto, code)
+	s.InCount++
+	s.InBytes += uint64(size)
+	if metrics.Enabled {
+		t.meter(proto, code).ingress.Mark(int64(size))
+	}
+	t.lock.Unlock()
+}
+
+// sent records an outgoing message of the given protocol. The code is relative
+// to the protocol's offset.
+func (t *msgTracker) sent(proto string, code uint64, size uint32) {
+	t.lock.Lock()
+	s := t.get(proto, code)
+	s.OutCount++
+	s.OutBytes += uint64(size)
+	if metrics.Enabled {
+		t.meter(proto, code).egress.Mark(int64(size))
+	}
+	t.lock.Unlock()
+}
+
+func (t *msgTracker) get(proto string, code uint64) *MessageTraffic {
+	codes := t.stats[proto]
+	if codes == nil {
+		codes = make(map[uint64]*MessageTraffic)
+		t.stats[proto] = codes
+	}
+	s := codes[code]
+	if s == nil {
+		s = new(MessageTraffic)
+		codes[code] = s
+	}
+	return s
+}
+
+// meter returns the meters of the given message type, registering them on first
+// use. The caller must hold t.lock.
+func (t *msgTracker) meter(proto string, code uint64) *msgMeters {
+	codes := t.meters[proto]
+	if codes == nil {
+		codes = make(map[uint64]*msgMeters)
+		t.meters[proto] = codes
+	}
+	m := codes[code]
+	if m == nil {
+		m = &msgMeters{
+			ingress: metrics.GetOrRegisterMeter(fmt.Sprintf("%s/%s/%d", MetricsInboundMessages, proto, code), nil),
+			egress:  metrics.GetOrRegisterMeter(fmt.Sprintf("%s/%s/%d", MetricsOutboundMessages, proto, code), nil),
+		}
+		codes[code] = m
+	}
+	return m
+}
+
+// snapshot returns a copy of the statistics.
+func (t *msgTracker) snapshot() map[string]map[uint64]*MessageTraffic {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	cpy := make(map[string]map[uint64]*MessageTraffic, len(t.stats))
+	for proto, codes := range t.stats {
+		cpy[proto] = make(map[uint64]*MessageTraffic, len(codes))
+		for code, s := range codes {
+			c := *s
+			cpy[proto][code] = &c
+		}
+	}
+	return cpy
+}
+
+// countingConn counts the bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	read, written uint64 // accessed atomically
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.read, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.written, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) counts() (read, written uint64) {
+	return atomic.LoadUint64(&c.read), atomic.LoadUint64(&c.written)
+}
+
+// rateLimiter is a token bucket limiting the throughput of a byte stream. The
+// bucket holds one second worth of tokens. Transfers are charged after they have
+// happened and the bucket may go into debt, so that messages larger than the
+// bucket can pass. The caller is then delayed until the debt is repaid.
+type rateLimiter struct {
+	clock mclock.Clock
+	rate  float64 // Bytes per second
+
+	lock   sync.Mutex
+	tokens float64
+	last   mclock.AbsTime
+}
+
+func newRateLimiter(rate int, clock mclock.Clock) *rateLimiter {
+	return &rateLimiter{
+		clock:  clock,
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   clock.Now(),
+	}
+}
+
+// charge takes n bytes worth of tokens from the bucket and returns how long the
+// caller has to wait until the bucket is out of debt.
+func (l *rateLimiter) charge(n int) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.clock.Now()
+	l.tokens += l.rate * float64(now-l.last) / float64(time.Second)
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// throttle charges n bytes to all limiters and waits until all of them are out of
+// debt or the abort channel is closed.
+func throttle(limiters []*rateLimiter, n int, clock mclock.Clock, abort <-chan struct{}) {
+	var wait time.Duration
+	for _, l := range limiters {
+		if d := l.charge(n); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		select {
+		case <-clock.After(wait):
+		case <-abort:
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The go-severeum Authors
+// This file is part of the go-severeum library.
+//
+// The go-severeum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-severeum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-severeum library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/severeum/go-severeum/common/mclock"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := new(mclock.Simulated)
+	l := newRateLimiter(1000, clock)
+
+	if d := l.charge(600); d != 0 {
+		t.Fatalf("wait %v within bucket size, want zero", d)
+	}
+	// The bucket holds 400 tokens, charging 1000 puts it 600 into debt.
+	if d := l.charge(1000); d != 600*time.Millisecond {
+		t.Fatalf("wrong wait %v for debt, want 600ms", d)
+	}
+	clock.Run(600 * time.Millisecond)
+	if d := l.charge(0); d != 0 {
+		t.Fatalf("wait %v after repaying debt, want zero", d)
+	}
+	// The bucket doesn't hold more than one second worth of tokens.
+	clock.Run(10 * time.Second)
+	if d := l.charge(1500); d != 500*time.Millisecond {
+		t.Fatalf("wrong wait %v after refill, want 500ms", d)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	clock := new(mclock.Simulated)
+	slow, fast := newRateLimiter(100, clock), newRateLimiter(1000, clock)
+
+	done := make(chan struct{})
+	go func() {
+		throttle([]*rateLimiter{slow, fast}, 200, clock, nil)
+		close(done)
+	}()
+	// The slower limiter determines the wait.
+	clock.WaitForTimers(1)
+	clock.Run(999 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("throttle returned early")
+	default:
+	}
+	clock.Run(time.Millisecond)
+	<-done
+
+	// Throttling is aborted when the abort channel is closed.
+	abort := make(chan struct{})
+	close(abort)
+	throttle([]*rateLimiter{slow}, 1000, clock, abort)
+}
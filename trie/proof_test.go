@@ -20,6 +20,7 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	mrand "math/rand"
+	"sort"
 	"testing"
 	"time"
 
@@ -143,6 +144,267 @@ func TestMissingKeyProof(t *testing.T) {
 	}
 }
 
+type entrySlice []*kv
+
+func (p entrySlice) Len() int           { return len(p) }
+func (p entrySlice) Less(i, j int) bool { return bytes.Compare(p[i].k, p[j].k) < 0 }
+func (p entrySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// sortedEntries returns the entries of a random trie in key order.
+func sortedEntries(vals map[string]*kv) entrySlice {
+	var entries entrySlice
+	for _, kv := range vals {
+		entries = append(entries, kv)
+	}
+	sort.Sort(entries)
+	return entries
+}
+
+// rangeData splits a range of entries into key and value lists.
+func rangeData(entries entrySlice) ([][]byte, [][]byte) {
+	var keys, vals [][]byte
+	for _, kv := range entries {
+		keys = append(keys, kv.k)
+		vals = append(vals, kv.v)
+	}
+	return keys, vals
+}
+
+// Tests that random ranges of a trie can be proven with the proofs of their
+// first and last keys.
+func TestRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		proof := ethdb.NewMemDatabase()
+		if err := trie.ProveRange(entries[start].k, entries[end-1].k, proof); err != nil {
+			t.Fatalf("failed to prove range: %v", err)
+		}
+		keys, values := rangeData(entries[start:end])
+		more, err := VerifyRangeProof(trie.Hash(), keys[0], keys[len(keys)-1], keys, values, proof)
+		if err != nil {
+			t.Fatalf("case %d(%d->%d): failed to verify range: %v", i, start, end-1, err)
+		}
+		if more != (end < len(entries)) {
+			t.Fatalf("case %d(%d->%d): continuation mismatch: have %v, want %v", i, start, end-1, more, end < len(entries))
+		}
+	}
+}
+
+// Tests that ranges can be proven with edge keys which are not present in the
+// trie, as long as no leaves exist between the edges and the range.
+func TestRangeProofNonExistentEdges(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries)-1) + 1
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		first := decreaseKey(common.CopyBytes(entries[start].k))
+		if bytes.Compare(first, entries[start-1].k) <= 0 {
+			continue
+		}
+		last := increaseKey(common.CopyBytes(entries[end-1].k))
+		if end < len(entries) && bytes.Compare(last, entries[end].k) >= 0 {
+			continue
+		}
+		proof := ethdb.NewMemDatabase()
+		if err := trie.ProveRange(first, last, proof); err != nil {
+			t.Fatalf("failed to prove range: %v", err)
+		}
+		keys, values := rangeData(entries[start:end])
+		if _, err := VerifyRangeProof(trie.Hash(), first, last, keys, values, proof); err != nil {
+			t.Fatalf("case %d(%d->%d): failed to verify range: %v", i, start, end-1, err)
+		}
+	}
+	// Special case, the whole trie is proven with a zero origin and a max limit
+	proof := ethdb.NewMemDatabase()
+	first, last := common.Hash{}.Bytes(), common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff").Bytes()
+	if err := trie.ProveRange(first, last, proof); err != nil {
+		t.Fatalf("failed to prove range: %v", err)
+	}
+	keys, values := rangeData(entries)
+	more, err := VerifyRangeProof(trie.Hash(), first, last, keys, values, proof)
+	if err != nil {
+		t.Fatalf("failed to verify whole range: %v", err)
+	}
+	if more {
+		t.Fatalf("continuation reported after the whole range")
+	}
+}
+
+// Tests that a single element can be proven with equal or non-existent edges.
+func TestOneElementRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	start := mrand.Intn(len(entries)-1) + 1
+	keys, values := rangeData(entries[start : start+1])
+
+	proof := ethdb.NewMemDatabase()
+	if err := trie.ProveRange(keys[0], keys[0], proof); err != nil {
+		t.Fatalf("failed to prove range: %v", err)
+	}
+	if _, err := VerifyRangeProof(trie.Hash(), keys[0], keys[0], keys, values, proof); err != nil {
+		t.Fatalf("failed to verify single element: %v", err)
+	}
+	first := decreaseKey(common.CopyBytes(keys[0]))
+	if bytes.Compare(first, entries[start-1].k) > 0 {
+		proof = ethdb.NewMemDatabase()
+		if err := trie.ProveRange(first, keys[0], proof); err != nil {
+			t.Fatalf("failed to prove range: %v", err)
+		}
+		if _, err := VerifyRangeProof(trie.Hash(), first, keys[0], keys, values, proof); err != nil {
+			t.Fatalf("failed to verify single element with non-existent left edge: %v", err)
+		}
+	}
+	// Single entry trie, proven with both edges non-existent
+	tinyTrie := new(Trie)
+	tinyTrie.Update(common.HexToHash("0x02").Bytes(), []byte{0x01})
+
+	first, last := common.HexToHash("0x01").Bytes(), common.HexToHash("0x03").Bytes()
+	proof = ethdb.NewMemDatabase()
+	if err := tinyTrie.ProveRange(first, last, proof); err != nil {
+		t.Fatalf("failed to prove range: %v", err)
+	}
+	keys, values = [][]byte{common.HexToHash("0x02").Bytes()}, [][]byte{{0x01}}
+	if _, err := VerifyRangeProof(tinyTrie.Hash(), first, last, keys, values, proof); err != nil {
+		t.Fatalf("failed to verify single element trie: %v", err)
+	}
+}
+
+// Tests that an empty range can only be proven beyond the last leaf of a trie.
+func TestEmptyRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	tests := []struct {
+		key []byte
+		err bool
+	}{
+		{increaseKey(common.CopyBytes(entries[len(entries)-1].k)), false},
+		{common.CopyBytes(entries[len(entries)-1].k), true},
+		{common.CopyBytes(entries[len(entries)/2].k), true},
+		{common.Hash{}.Bytes(), true},
+	}
+	for i, tt := range tests {
+		proof := ethdb.NewMemDatabase()
+		if err := trie.Prove(tt.key, 0, proof); err != nil {
+			t.Fatalf("test %d: failed to prove key: %v", i, err)
+		}
+		_, err := VerifyRangeProof(trie.Hash(), tt.key, nil, nil, nil, proof)
+		if tt.err && err == nil {
+			t.Errorf("test %d: empty range accepted with entries after it", i)
+		}
+		if !tt.err && err != nil {
+			t.Errorf("test %d: valid empty range rejected: %v", i, err)
+		}
+	}
+}
+
+// Tests that the whole trie can be verified from its leaves without any proof.
+func TestAllElementsRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	keys, values := rangeData(sortedEntries(vals))
+
+	if _, err := VerifyRangeProof(trie.Hash(), nil, nil, keys, values, nil); err != nil {
+		t.Fatalf("failed to verify whole trie: %v", err)
+	}
+	if _, err := VerifyRangeProof(trie.Hash(), nil, nil, keys[1:], values[1:], nil); err == nil {
+		t.Fatalf("incomplete trie accepted")
+	}
+	// The same must hold with edge proofs of the first and last leaves
+	proof := ethdb.NewMemDatabase()
+	if err := trie.ProveRange(keys[0], keys[len(keys)-1], proof); err != nil {
+		t.Fatalf("failed to prove range: %v", err)
+	}
+	more, err := VerifyRangeProof(trie.Hash(), keys[0], keys[len(keys)-1], keys, values, proof)
+	if err != nil {
+		t.Fatalf("failed to verify whole range: %v", err)
+	}
+	if more {
+		t.Fatalf("continuation reported after the whole range")
+	}
+}
+
+// Tests that tampered ranges are rejected: missing, modified or reordered leaves
+// and gaps between the edges and the range.
+func TestBadRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+		if end-start < 3 {
+			continue
+		}
+		proof := ethdb.NewMemDatabase()
+		if err := trie.ProveRange(entries[start].k, entries[end-1].k, proof); err != nil {
+			t.Fatalf("failed to prove range: %v", err)
+		}
+		keys, values := rangeData(entries[start:end])
+		first, last := keys[0], keys[len(keys)-1]
+
+		var testcase int
+		switch testcase = mrand.Intn(5); testcase {
+		case 0:
+			// Modified value
+			index := mrand.Intn(end - start)
+			values[index] = randBytes(20)
+		case 1:
+			// Gap inside the range
+			index := mrand.Intn(end-start-2) + 1
+			keys = append(keys[:index], keys[index+1:]...)
+			values = append(values[:index], values[index+1:]...)
+		case 2:
+			// Gap at the left edge
+			keys, values = keys[1:], values[1:]
+		case 3:
+			// Out of order
+			index1, index2 := mrand.Intn(end-start), mrand.Intn(end-start)
+			if index1 == index2 {
+				continue
+			}
+			keys[index1], keys[index2] = keys[index2], keys[index1]
+			values[index1], values[index2] = values[index2], values[index1]
+		case 4:
+			// Deleted value
+			values[mrand.Intn(end-start)] = nil
+		}
+		if _, err := VerifyRangeProof(trie.Hash(), first, last, keys, values, proof); err == nil {
+			t.Fatalf("case %d(%d->%d): expected error for testcase %d", i, start, end-1, testcase)
+		}
+	}
+}
+
+// increaseKey returns the key incremented by one, treated as a big-endian number.
+func increaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]++
+		if key[i] != 0x0 {
+			break
+		}
+	}
+	return key
+}
+
+// decreaseKey returns the key decremented by one, treated as a big-endian number.
+func decreaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]--
+		if key[i] != 0xff {
+			break
+		}
+	}
+	return key
+}
+
 // mutateByte changes one byte in b.
 func mutateByte(b []byte) {
 	for r := mrand.Intn(len(b)); ; {